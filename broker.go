@@ -1,160 +1,811 @@
 package ultrabus
 
 import (
+	"fmt"
 	"io"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"code.google.com/p/go-uuid/uuid"
 	"github.com/emef/ultrabus/pb"
+	"github.com/golang/protobuf/proto"
 	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 )
 
 type TopicBroker struct {
-	topic                *pb.TopicMeta
-	clientID             *pb.ClientID
+	lock              sync.RWMutex
+	topic             *pb.TopicMeta
+	clientID          *pb.ClientID
 	connectionManager ConnectionManager
+	options           *clientOptions
+	subscriptions     []*BrokeredSubscription
+
+	// Partition the last keyless message went to
+	keylessPartition uint32
+
+	// Set when publishing idempotently, along with the next sequence of
+	// each partition
+	producerID    string
+	sequencesLock sync.Mutex
+	sequences     map[int32]*partitionSequence
+
+	// Each partition's publishes, sent one at a time in the order they
+	// were made so that they're appended in that order, and the
+	// partitions with a sender sending them
+	sendersLock sync.Mutex
+	queued      map[int32][]*queuedPublish
+	sending     map[int32]bool
+	stopped     bool
+}
+
+// An idempotent producer's position in one partition, locked while a
+// request to the partition is in flight.
+type partitionSequence struct {
+	lock  sync.Mutex
+	epoch int32
+	next  int64
+
+	// The messages of the last request if it failed without saying
+	// whether it was appended, which only a resend of them may reuse
+	// the sequence of
+	failed []*pb.Message
 }
 
+// Errors a subscription queues for its reader before dropping them.
+const subscriptionErrors = 64
+
 type BrokeredSubscription struct {
-	messages chan *pb.MessageWithOffset
-	done     chan interface{}
+	lock       sync.Mutex
+	messages   chan *pb.MessageWithOffset
+	done       chan interface{}
+	wg         sync.WaitGroup
+	partitions int32
+	filter     *pb.SubscriptionFilter
+	errors     chan error
+	broker     *TopicBroker
 }
 
 func NewTopicBroker(
 	topic *pb.TopicMeta,
 	clientID *pb.ClientID,
 	connectionManager ConnectionManager) *TopicBroker {
-	return &TopicBroker{topic, clientID, connectionManager}
+	options, _ := newClientOptions(nil)
+	return newTopicBroker(topic, clientID, connectionManager, options)
+}
+
+func newTopicBroker(
+	topic *pb.TopicMeta,
+	clientID *pb.ClientID,
+	connectionManager ConnectionManager,
+	options *clientOptions) *TopicBroker {
+	broker := &TopicBroker{
+		topic:             topic,
+		clientID:          clientID,
+		connectionManager: connectionManager,
+		options:           options,
+		sequences:         make(map[int32]*partitionSequence),
+		queued:            make(map[int32][]*queuedPublish),
+		sending:           make(map[int32]bool)}
+
+	if options.idempotent {
+		broker.producerID = uuid.New()
+	}
+
+	return broker
+}
+
+func (broker *TopicBroker) meta() *pb.TopicMeta {
+	broker.lock.RLock()
+	defer broker.lock.RUnlock()
+
+	return broker.topic
+}
+
+// Moves the broker to newer metadata of its topic. Partitions added
+// since are subscribed to by running subscriptions and published to by
+// later calls to Publish.
+func (broker *TopicBroker) UpdateMeta(topic *pb.TopicMeta) {
+	broker.lock.Lock()
+	defer broker.lock.Unlock()
+
+	if topic.Partitions < broker.topic.Partitions {
+		return
+	}
+
+	broker.topic = topic
+
+	running := broker.subscriptions[:0]
+	for _, subscription := range broker.subscriptions {
+		if subscription.subscribe(broker, topic.Partitions) {
+			running = append(running, subscription)
+		}
+	}
+
+	broker.subscriptions = running
+}
+
+// Stops the broker's subscriptions and senders. Publishes already queued
+// are still sent; later ones fail.
+func (broker *TopicBroker) stop() {
+	broker.lock.Lock()
+	subscriptions := broker.subscriptions
+	broker.subscriptions = nil
+	broker.lock.Unlock()
+
+	for _, subscription := range subscriptions {
+		subscription.Stop()
+	}
+
+	broker.sendersLock.Lock()
+	broker.stopped = true
+	broker.sendersLock.Unlock()
 }
 
 func (broker *TopicBroker) Subscribe() (Subscription, error) {
+	return broker.SubscribeFiltered(nil)
+}
+
+// Subscribes to the messages filter lets through, which may be nil.
+// Nodes leave out the rest rather than send them.
+func (broker *TopicBroker) SubscribeFiltered(
+	filter *pb.SubscriptionFilter) (Subscription, error) {
+
+	// Nodes decode values by the topic's schema, which doesn't change
+	// whether the filter is valid
+	if _, err := NewMessageFilter(filter, ValueSchemaNone); err != nil {
+		return nil, err
+	} else if filter != nil && broker.options.queue != nil {
+		return nil, &InvalidFilterError{"queue subscriptions can't be filtered"}
+	}
+
 	// TODO queue size?
-	messages := make(chan *pb.MessageWithOffset, 1)
-	done := make(chan interface{}, 1)
+	subscription := &BrokeredSubscription{
+		messages: make(chan *pb.MessageWithOffset, 1),
+		done:     make(chan interface{}),
+		filter:   filter,
+		errors:   make(chan error, subscriptionErrors),
+		broker:   broker}
+
+	broker.lock.Lock()
+	subscription.subscribe(broker, broker.topic.Partitions)
+	broker.subscriptions = append(broker.subscriptions, subscription)
+	broker.lock.Unlock()
+
+	go func() {
+		<-subscription.done
+		subscription.wg.Wait()
+		close(subscription.messages)
+	}()
+
+	return subscription, nil
+}
+
+func (broker *TopicBroker) consume(
+	subscription *BrokeredSubscription, partition int32) {
+
+	defer subscription.wg.Done()
+
+	partitionId := &pb.PartitionID{
+		Topic:     broker.meta().Topic,
+		Partition: partition}
+	request := &pb.SubscribeRequest{
+		ClientID:    broker.clientID,
+		PartitionID: partitionId,
+		Isolation:   broker.options.isolation,
+		Filter:      subscription.filter,
+		Credits:     broker.options.credits,
+		Batch:       broker.options.batch,
+		Queue:       broker.options.queue}
 
-	var wg sync.WaitGroup
-	wg.Add(int(broker.topic.Partitions))
+	var stream pb.UltrabusNode_SubscribeClient = nil
+	var client pb.UltrabusNodeClient
 
-	for partition := int32(0); partition < broker.topic.Partitions; partition++ {
-		go func(partition int32) {
-			partitionId := &pb.PartitionID{
-				Topic: broker.topic.Topic,
-				Partition: partition}
-			request := &pb.SubscribeRequest{
-				ClientID:    broker.clientID,
-				PartitionID: partitionId}
+	// Ends the current stream, which is given up on before subscribing
+	// again so that the node stops sending on it
+	cancel := func() {}
+	defer func() { cancel() }()
 
-			var stream pb.UltrabusNode_SubscribeClient = nil
+	// Read since credits were last granted, given back once they reach
+	// half of the window
+	var read pb.Credits
 
-			for {
+	for {
+		select {
+		case <-subscription.done:
+			return
+
+		default:
+			if stream == nil {
+				var err error
+				client, err = broker.connectionManager.GetReadClient(partitionId)
+				read = pb.Credits{}
+
+				if err == nil {
+					var ctx context.Context
+					ctx, cancel = context.WithCancel(context.Background())
+					stream, err = client.Subscribe(ctx, request)
+				}
+
+				if err != nil {
+					// TODO LOG?
+					cancel()
+					time.Sleep(time.Second)
+					break
+				}
+			}
+
+			// NOTE: This can block us forever and halt cleanup
+			in, err := stream.Recv()
+			if err == io.EOF || err != nil {
+				// TODO: differentiate between EOF and other error?
+				cancel()
+				stream = nil
+				break
+			}
+
+			for _, filterError := range in.FilterErrors {
+				subscription.report(&FilterEvaluationError{
+					partitionId, filterError.Offset, filterError.Error})
+			}
+
+			for _, msg := range in.Messages {
+				msg.Partition = partition
 				select {
-				case <-done:
-					wg.Done()
+				case subscription.messages <- msg:
+				case <-subscription.done:
 					return
+				}
 
-				default:
-					if stream == nil {
-						client, err := broker.connectionManager.GetReadClient(partitionId)
-
-						if err == nil {
-							stream, err = client.Subscribe(context.Background(), request)
-						}
-
-						if err != nil {
-							// TODO LOG?
-							time.Sleep(time.Second)
-							break
-						}
-					}
-
-					// NOTE: This can block us forever and halt cleanup
-					in, err := stream.Recv()
-					if err == io.EOF || err != nil {
-						// TODO: differentiate between EOF and other error?
-						stream = nil
-						break
-					}
-
-					for _, msg := range in.Messages {
-						select {
-						case messages <- msg:
-						case <-done:
-							wg.Done()
-							return
-						}
-					}
+				read.Messages++
+				read.Bytes += messageSize(msg.Message)
+			}
+
+			window := broker.options.credits
+			if read.Messages > 0 && (read.Messages >= window.Messages/2 ||
+				read.Bytes >= window.Bytes/2) {
+				_, err := client.GrantCredits(context.Background(),
+					&pb.GrantCreditsRequest{
+						ClientID:    broker.clientID,
+						PartitionID: partitionId,
+						Credits:     &read})
+				if err != nil {
+					// Without credits the node would stall; start over
+					cancel()
+					stream = nil
 				}
+
+				read = pb.Credits{}
 			}
-		}(partition)
+		}
 	}
+}
 
-	go func() {
-		wg.Wait()
-		close(done)
-		close(messages)
-	}()
+// Called once per message of PublishAsync when it's written, with the
+// offset it was given, or fails.
+type PublishCallback func(message *pb.Message, offset int64, err error)
 
-	return &BrokeredSubscription{messages, done}, nil
+// Publishes messages and waits for all of them. If any fail the error
+// is a *PublishError reporting each failed partition.
+func (broker *TopicBroker) Publish(messages []*pb.Message) error {
+	receipts, partitions := broker.publishAsync(messages, nil)
+	return publishReport(broker.meta().Topic, receipts, partitions)
 }
 
-// TODO: make async
-func (broker *TopicBroker) Publish(messages []*pb.Message) error {
-	partitionIDToMessages := make(map[pb.PartitionID][]*pb.Message)
-	partitions := broker.topic.Partitions
+// Starts publishing messages without waiting for them, returning a
+// receipt per message that resolves to its offset. Messages are sent in
+// one request per partition; callback, if not nil, is called as each
+// message resolves. Each partition's requests are sent one at a time in
+// the order of the calls, so a call's messages are appended after those
+// of earlier calls even when their requests are retried.
+func (broker *TopicBroker) PublishAsync(
+	messages []*pb.Message, callback PublishCallback) []WriteReceipt {
+
+	receipts, _ := broker.publishAsync(messages, callback)
+	return receipts
+}
+
+// PublishAsync, also returning the partition of each message or -1 for
+// those that failed before getting one.
+func (broker *TopicBroker) publishAsync(
+	messages []*pb.Message,
+	callback PublishCallback) ([]WriteReceipt, []int32) {
+
+	sent := time.Now()
+	batches, receipts, partitions := broker.batch(messages, callback)
+
+	for partitionID, batch := range batches {
+		request := batch.request(partitionID)
+		if !broker.queue(partitionID.Partition,
+			&queuedPublish{request, batch.receipts, callback, sent}) {
+			for i, message := range request.Messages {
+				resolvePublish(batch.receipts[i], callback, message, -1,
+					&ClientClosedError{})
+			}
+		}
+	}
+
+	return receipts, partitions
+}
+
+// Queues a publish for its partition without waiting, so that neither a
+// slow partition nor a callback publishing again holds up the rest, or
+// returns false if the broker has stopped.
+func (broker *TopicBroker) queue(
+	partition int32, publish *queuedPublish) bool {
+
+	broker.sendersLock.Lock()
+	defer broker.sendersLock.Unlock()
+
+	if broker.stopped {
+		return false
+	}
+
+	broker.queued[partition] = append(broker.queued[partition], publish)
+	if !broker.sending[partition] {
+		broker.sending[partition] = true
+		go broker.sendQueued(partition)
+	}
+
+	return true
+}
+
+// A request of publishAsync waiting for those before it to be sent.
+type queuedPublish struct {
+	request  *pb.PublishRequest
+	receipts []*receiptImpl
+	callback PublishCallback
+	sent     time.Time
+}
 
-	for _, message := range messages {
-		partition, err := HashToPartition(message, partitions)
+// Sends a partition's queued publishes one at a time, keeping them in
+// order, until none are left.
+func (broker *TopicBroker) sendQueued(partition int32) {
+	for {
+		broker.sendersLock.Lock()
+		queued := broker.queued[partition]
+		if len(queued) == 0 {
+			delete(broker.queued, partition)
+			delete(broker.sending, partition)
+			broker.sendersLock.Unlock()
+			return
+		}
+
+		broker.queued[partition] = queued[1:]
+		broker.sendersLock.Unlock()
+
+		broker.send(queued[0].request,
+			queued[0].receipts, queued[0].callback, queued[0].sent)
+	}
+}
+
+// Groups messages by the partition each is headed for. Messages that
+// can't be placed have their receipts failed straight away; the rest
+// are resolved by sending their batch. Also returns the partition of
+// each message, -1 for those failed.
+func (broker *TopicBroker) batch(
+	messages []*pb.Message,
+	callback PublishCallback) (
+	map[pb.PartitionID]*publishBatch, []WriteReceipt, []int32) {
+
+	receipts := make([]WriteReceipt, len(messages))
+	partitions := make([]int32, len(messages))
+	batches := make(map[pb.PartitionID]*publishBatch)
+	topic, config, strategy, configErr := broker.placement()
+
+	// Sticky publishes move on to the next partition once per call
+	var sticky int32
+	if strategy == KeylessSticky {
+		sticky = broker.nextKeylessPartition(topic.Partitions)
+	}
+
+	for i, message := range messages {
+		receipt := newReceipt()
+		receipts[i] = receipt
+		partitions[i] = -1
+
+		if configErr != nil {
+			resolvePublish(receipt, callback, message, -1, configErr)
+			continue
+		}
+
+		partition, err := broker.partition(
+			topic, config, strategy, sticky, message)
 		if err != nil {
-			return err
+			resolvePublish(receipt, callback, message, -1, err)
+			continue
 		}
 
+		partitions[i] = partition
 		partitionID := pb.PartitionID{
-			Topic:     broker.topic.Topic,
+			Topic:     topic.Topic,
 			Partition: partition}
 
-		partitionMessages, _ := partitionIDToMessages[partitionID]
-		partitionIDToMessages[partitionID] = append(partitionMessages, message)
-	}
-
-	var wg sync.WaitGroup
-	wg.Add(len(partitionIDToMessages))
+		batch, ok := batches[partitionID]
+		if !ok {
+			batch = &publishBatch{}
+			batches[partitionID] = batch
+		}
 
-	for partitionID, partitionMessages := range partitionIDToMessages {
-		request := &pb.PublishRequest{
-			PartitionID: &pb.PartitionID{
-				Topic:     partitionID.Topic,
-				Partition: partitionID.Partition},
-			Messages: partitionMessages}
+		batch.messages = append(batch.messages, message)
+		batch.receipts = append(batch.receipts, receipt)
+	}
 
-		go func(request *pb.PublishRequest) {
-			// TODO: no more infinite retries...
-			for {
-				client, err := broker.connectionManager.GetWriteClient(request.PartitionID)
+	return batches, receipts, partitions
+}
 
-				if err == nil {
-					_, err = client.Publish(context.Background(), request)
-				}
+// Waits for receipts, reporting those that failed in a *PublishError by
+// the partition in partitions at the same index.
+func publishReport(
+	topic string, receipts []WriteReceipt, partitions []int32) error {
 
-				if err != nil {
-					time.Sleep(time.Second)
-				} else {
-					wg.Done()
-					return
-				}
+	var failed map[int32]error
+	for i, receipt := range receipts {
+		<-receipt.Done()
+		if _, err := receipt.Read(); err != nil {
+			if failed == nil {
+				failed = make(map[int32]error)
 			}
-		}(request)
+
+			failed[partitions[i]] = err
+		}
 	}
 
-	wg.Wait()
+	if failed != nil {
+		return &PublishError{topic, failed}
+	}
 
-	// TODO: propagate errors
 	return nil
 }
 
+// Messages of a PublishAsync call headed to one partition.
+type publishBatch struct {
+	messages []*pb.Message
+	receipts []*receiptImpl
+}
+
+func (batch *publishBatch) request(
+	partitionID pb.PartitionID) *pb.PublishRequest {
+
+	return &pb.PublishRequest{
+		PartitionID: &pb.PartitionID{
+			Topic:     partitionID.Topic,
+			Partition: partitionID.Partition},
+		Messages: batch.messages}
+}
+
+// The topic, its config and the keyless strategy to place messages by.
+func (broker *TopicBroker) placement() (
+	*pb.TopicMeta, *TopicConfig, string, error) {
+
+	topic := broker.meta()
+	config, err := ParseTopicConfig(topic.Config)
+	if err != nil {
+		return topic, nil, "", err
+	}
+
+	strategy := config.KeylessPartitioning
+	if broker.options.keylessPartitioning != "" {
+		strategy = broker.options.keylessPartitioning
+	}
+
+	return topic, config, strategy, nil
+}
+
+// Chooses message's partition, validating it on the way.
+func (broker *TopicBroker) partition(
+	topic *pb.TopicMeta,
+	config *TopicConfig,
+	strategy string,
+	sticky int32,
+	message *pb.Message) (int32, error) {
+
+	partitions := topic.Partitions
+
+	if size := messageSize(message); size > config.MaxMessageBytes {
+		return -1, &MessageTooLargeError{size, config.MaxMessageBytes}
+	}
+
+	if !config.Keyed || len(message.Key) == 0 {
+		if strategy == KeylessSticky {
+			return sticky, nil
+		}
+
+		return broker.nextKeylessPartition(partitions), nil
+	}
+
+	var partition int32
+	var err error
+	if broker.options.partitioner != nil {
+		partition, err = broker.options.partitioner.Partition(
+			topic.Topic, message, partitions)
+	} else {
+		partition, err = HashToPartitionScheme(
+			message, partitions, config.KeyHash)
+	}
+
+	if err != nil {
+		return -1, err
+	} else if partition < 0 || partition >= partitions {
+		return -1, &PartitionNotFoundError{
+			&pb.PartitionID{Topic: topic.Topic, Partition: partition}}
+	}
+
+	return partition, nil
+}
+
+// Writes request to its partition, retrying by the client's policy, and
+// resolves its messages' receipts. The delivery timeout counts from
+// sent, when the messages were handed to the broker.
+func (broker *TopicBroker) send(
+	request *pb.PublishRequest,
+	receipts []*receiptImpl,
+	callback PublishCallback,
+	sent time.Time) {
+
+	policy := broker.options.retry
+	ctx := context.Background()
+	if policy.DeliveryTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, sent.Add(policy.DeliveryTimeout))
+		defer cancel()
+	}
+
+	sequence := broker.sequence(request)
+
+	var response *pb.PublishResponse
+	var err error
+
+	for attempt := 0; ; attempt++ {
+		var client pb.UltrabusNodeClient
+		client, err = broker.connectionManager.GetWriteClient(request.PartitionID)
+
+		if err == nil {
+			response, err = client.Publish(ctx, request)
+		}
+
+		if err == nil || !IsRetriable(err) || attempt >= policy.MaxRetries ||
+			!sleepContext(ctx, policy.backoff(attempt)) {
+			break
+		}
+	}
+
+	if sequence != nil && isDuplicateSequence(err) {
+		// Appended by an earlier attempt too long ago for the partition
+		// to remember the offsets
+		offsets := make([]int64, len(request.Messages))
+		for i := range offsets {
+			offsets[i] = -1
+		}
+
+		response, err = &pb.PublishResponse{Offsets: offsets}, nil
+	}
+
+	if err != nil && ctx.Err() == context.DeadlineExceeded {
+		err = &DeliveryTimeoutError{
+			request.PartitionID, policy.DeliveryTimeout, err}
+	}
+
+	if sequence != nil {
+		sequence.finish(request.Messages, err)
+	}
+
+	if err != nil {
+		for i, message := range request.Messages {
+			resolvePublish(receipts[i], callback, message, -1, err)
+		}
+
+		return
+	}
+
+	for i, message := range request.Messages {
+		if i < len(response.Offsets) {
+			resolvePublish(
+				receipts[i], callback, message, response.Offsets[i], nil)
+		} else {
+			resolvePublish(receipts[i], callback, message, -1,
+				fmt.Errorf("No offset returned for message %v", i))
+		}
+	}
+}
+
+func resolvePublish(
+	receipt *receiptImpl,
+	callback PublishCallback,
+	message *pb.Message,
+	offset int64,
+	err error) {
+
+	receipt.resolve(offset, err)
+	if callback != nil {
+		callback(message, offset, err)
+	}
+}
+
+// Stamps request with the producer's next sequence of its partition,
+// holding the partition until finish. Nil unless publishing
+// idempotently, or if the request was stamped by a transaction.
+func (broker *TopicBroker) sequence(
+	request *pb.PublishRequest) *partitionSequence {
+
+	if broker.producerID == "" || request.Producer != nil {
+		return nil
+	}
+
+	broker.sequencesLock.Lock()
+	sequence, ok := broker.sequences[request.PartitionID.Partition]
+	if !ok {
+		sequence = &partitionSequence{}
+		broker.sequences[request.PartitionID.Partition] = sequence
+	}
+	broker.sequencesLock.Unlock()
+
+	sequence.lock.Lock()
+	if sequence.failed != nil && !sameMessages(sequence.failed, request.Messages) {
+		// The failed request may have been appended, so new messages
+		// can't take its sequence without risk of being dropped as it
+		sequence.epoch++
+		sequence.next = 0
+	}
+	sequence.failed = nil
+
+	request.Producer = &pb.ProducerSequence{
+		ProducerID: broker.producerID,
+		Epoch:      sequence.epoch,
+		Sequence:   sequence.next}
+
+	return sequence
+}
+
+// Moves past a request of messages. A request the partition rejected
+// for its epoch or sequence starts a new epoch; one that failed otherwise
+// may or may not have been appended, so its sequence is kept for the
+// caller to resend the same messages, which the partition dedupes.
+func (sequence *partitionSequence) finish(messages []*pb.Message, err error) {
+	switch {
+	case err == nil:
+		sequence.next += int64(len(messages))
+	case isSequenceRejected(err):
+		sequence.epoch++
+		sequence.next = 0
+	default:
+		sequence.failed = messages
+	}
+
+	sequence.lock.Unlock()
+}
+
+func sameMessages(a, b []*pb.Message) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if !proto.Equal(a[i], b[i]) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Whether err says a resent request was appended before.
+func isDuplicateSequence(err error) bool {
+	_, ok := err.(*DuplicateSequenceError)
+	return ok || grpc.Code(err) == codes.AlreadyExists
+}
+
+// Whether err says the partition refused a request's epoch or sequence.
+func isSequenceRejected(err error) bool {
+	switch err.(type) {
+	case *ProducerFencedError, *OutOfOrderSequenceError:
+		return true
+	}
+
+	return grpc.Code(err) == codes.FailedPrecondition
+}
+
+// Sleeps for duration, false if ctx is done first.
+func sleepContext(ctx context.Context, duration time.Duration) bool {
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func (broker *TopicBroker) nextKeylessPartition(partitions int32) int32 {
+	next := atomic.AddUint32(&broker.keylessPartition, 1)
+	return int32(next % uint32(partitions))
+}
+
 func (subscription *BrokeredSubscription) Messages() chan *pb.MessageWithOffset {
 	return subscription.messages
 }
 
+func (subscription *BrokeredSubscription) Errors() chan error {
+	return subscription.errors
+}
+
+// Queues err for Errors, dropping it if the queue is full.
+func (subscription *BrokeredSubscription) report(err error) {
+	select {
+	case subscription.errors <- err:
+	default:
+	}
+}
+
+func (subscription *BrokeredSubscription) Ack(
+	message *pb.MessageWithOffset) error {
+
+	return subscription.settle(&pb.AckRequest{Acks: []int64{message.Offset}},
+		message.Partition)
+}
+
+func (subscription *BrokeredSubscription) Nack(
+	message *pb.MessageWithOffset, cause error) error {
+
+	request := &pb.AckRequest{Nacks: []int64{message.Offset}}
+	if cause != nil {
+		request.Errors = map[int64]string{message.Offset: cause.Error()}
+	}
+
+	return subscription.settle(request, message.Partition)
+}
+
+// Sends request to the node partition is subscribed to.
+func (subscription *BrokeredSubscription) settle(
+	request *pb.AckRequest, partition int32) error {
+
+	broker := subscription.broker
+	request.ClientID = broker.clientID
+	request.PartitionID = &pb.PartitionID{
+		Topic: broker.meta().Topic, Partition: partition}
+
+	client, err := broker.connectionManager.GetReadClient(request.PartitionID)
+	if err != nil {
+		return err
+	}
+
+	_, err = client.Ack(context.Background(), request)
+	return err
+}
+
 func (subscription *BrokeredSubscription) Stop() {
-	close(subscription.done)
+	subscription.lock.Lock()
+	defer subscription.lock.Unlock()
+
+	select {
+	case <-subscription.done:
+	default:
+		close(subscription.done)
+	}
+}
+
+// Starts consuming any of the topic's first partitions not yet being
+// consumed, false once the subscription has stopped.
+func (subscription *BrokeredSubscription) subscribe(
+	broker *TopicBroker, partitions int32) bool {
+
+	subscription.lock.Lock()
+	defer subscription.lock.Unlock()
+
+	select {
+	case <-subscription.done:
+		return false
+	default:
+	}
+
+	for ; subscription.partitions < partitions; subscription.partitions++ {
+		subscription.wg.Add(1)
+		go broker.consume(subscription, subscription.partitions)
+	}
+
+	return true
 }
@@ -0,0 +1,579 @@
+package ultrabus
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/emef/ultrabus/pb"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+// Records what is published to it instead of talking to a node, and
+// hands itself out as the connection to every partition.
+type recordingNodeClient struct {
+	pb.UltrabusNodeClient
+	lock      sync.Mutex
+	published map[int32][]*pb.Message
+	requests  int
+}
+
+func newRecordingNodeClient() *recordingNodeClient {
+	return &recordingNodeClient{published: make(map[int32][]*pb.Message)}
+}
+
+func (client *recordingNodeClient) Publish(
+	ctx context.Context,
+	request *pb.PublishRequest,
+	opts ...grpc.CallOption) (*pb.PublishResponse, error) {
+
+	client.lock.Lock()
+	defer client.lock.Unlock()
+
+	client.requests++
+	partition := request.PartitionID.Partition
+	offsets := make([]int64, len(request.Messages))
+	for i := range offsets {
+		offsets[i] = int64(len(client.published[partition]) + i)
+	}
+
+	client.published[partition] = append(
+		client.published[partition], request.Messages...)
+
+	return &pb.PublishResponse{Offsets: offsets}, nil
+}
+
+func (client *recordingNodeClient) GetReadClient(
+	partitionID *pb.PartitionID) (pb.UltrabusNodeClient, error) {
+
+	return client, nil
+}
+
+func (client *recordingNodeClient) GetWriteClient(
+	partitionID *pb.PartitionID) (pb.UltrabusNodeClient, error) {
+
+	return client, nil
+}
+
+func (client *recordingNodeClient) GetAdminClient() (
+	pb.UltrabusNodeClient, error) {
+
+	return client, nil
+}
+
+func (client *recordingNodeClient) GetCoordinatorClient(
+	transactionalID string) (pb.UltrabusNodeClient, error) {
+
+	return client, nil
+}
+
+func TestKeylessPartitioning(t *testing.T) {
+	assert := assert.New(t)
+
+	messages := make([]*pb.Message, 4)
+	for i := range messages {
+		messages[i] = &pb.Message{Value: []byte("value")}
+	}
+
+	// Round-robin, the topic's default
+	client := newRecordingNodeClient()
+	broker := NewTopicBroker(
+		&pb.TopicMeta{Topic: "orders", Partitions: 4}, nil, client)
+	assert.Nil(broker.Publish(messages))
+	assert.Equal(4, len(client.published))
+
+	// Sticky from the topic's config
+	client = newRecordingNodeClient()
+	broker = NewTopicBroker(&pb.TopicMeta{
+		Topic:      "orders",
+		Partitions: 4,
+		Config: map[string]string{
+			ConfigKeylessPartitioning: KeylessSticky}}, nil, client)
+	assert.Nil(broker.Publish(messages))
+	assert.Equal(1, len(client.published))
+	assert.Nil(broker.Publish(messages))
+	assert.Equal(2, len(client.published))
+
+	// Round-robin again, the client overriding the topic
+	options, err := newClientOptions(
+		[]ClientOption{WithKeylessPartitioning(KeylessRoundRobin)})
+	assert.Nil(err)
+
+	client = newRecordingNodeClient()
+	broker = newTopicBroker(broker.meta(), nil, client, options)
+	assert.Nil(broker.Publish(messages))
+	assert.Equal(4, len(client.published))
+
+	_, err = newClientOptions(
+		[]ClientOption{WithKeylessPartitioning("random")})
+	assert.IsType(&InvalidConfigError{}, err)
+}
+
+func TestPartitioners(t *testing.T) {
+	assert := assert.New(t)
+
+	// Kafka's own murmur2 test vectors
+	vectors := map[string]int32{
+		"21":                         -973932308,
+		"foobar":                     -790332482,
+		"a-little-bit-long-string":   -985981536,
+		"a-little-bit-longer-string": -1486304829,
+		"lkjh234lh9fiuh90y23oiuhsafujhadof229phr9h19h89h8": -58897971,
+		"abc": 479470107}
+
+	for key, expected := range vectors {
+		assert.Equal(expected, int32(murmur2([]byte(key))), key)
+	}
+
+	message := &pb.Message{Key: []byte("foobar")}
+	partition, err := (&Murmur2Partitioner{}).Partition("t", message, 10)
+	assert.Nil(err)
+	assert.Equal(int32((-790332482&0x7fffffff)%10), partition)
+
+	explicit := &ExplicitPartitioner{Partitions: map[string]int32{"foobar": 3}}
+	partition, err = explicit.Partition("t", message, 10)
+	assert.Nil(err)
+	assert.Equal(int32(3), partition)
+
+	_, err = explicit.Partition("t", &pb.Message{Key: []byte("other")}, 10)
+	assert.NotNil(err)
+
+	explicit.Fallback = &MD5Partitioner{}
+	_, err = explicit.Partition("t", &pb.Message{Key: []byte("other")}, 10)
+	assert.Nil(err)
+
+	// A custom partitioner choosing a partition the topic doesn't have
+	options, err := newClientOptions([]ClientOption{WithPartitioner(
+		PartitionerFunc(func(string, *pb.Message, int32) (int32, error) {
+			return 4, nil
+		}))})
+	assert.Nil(err)
+
+	broker := newTopicBroker(&pb.TopicMeta{Topic: "orders", Partitions: 4},
+		nil, newRecordingNodeClient(), options)
+	err = broker.Publish([]*pb.Message{message})
+	assert.IsType(&PublishError{}, err)
+	assert.IsType(&PartitionNotFoundError{}, err.(*PublishError).Partitions[-1])
+}
+
+func TestPublishAsync(t *testing.T) {
+	assert := assert.New(t)
+
+	broker := NewTopicBroker(&pb.TopicMeta{
+		Topic:      "orders",
+		Partitions: 2,
+		Config: map[string]string{
+			ConfigKeylessPartitioning: KeylessSticky,
+			ConfigMaxMessageBytes:     "10"}}, nil, newRecordingNodeClient())
+
+	var lock sync.Mutex
+	called := make(map[string]int64)
+	callback := func(message *pb.Message, offset int64, err error) {
+		lock.Lock()
+		defer lock.Unlock()
+
+		if err == nil {
+			called[string(message.Value)] = offset
+		} else {
+			called[string(message.Value)] = -1
+		}
+	}
+
+	receipts := broker.PublishAsync([]*pb.Message{
+		{Value: []byte("a")},
+		{Value: []byte("too large a message")},
+		{Value: []byte("b")}}, callback)
+
+	assert.Equal(3, len(receipts))
+	for i, expected := range []int64{0, -1, 1} {
+		<-receipts[i].Done()
+		offset, _ := receipts[i].Read()
+		assert.Equal(expected, offset)
+	}
+
+	_, err := receipts[1].Read()
+	assert.IsType(&MessageTooLargeError{}, err)
+
+	lock.Lock()
+	assert.Equal(map[string]int64{
+		"a": 0, "too large a message": -1, "b": 1}, called)
+	lock.Unlock()
+}
+
+// Fails its first publishes with err before recording the rest.
+type flakyNodeClient struct {
+	*recordingNodeClient
+	failures int
+	err      error
+}
+
+func (client *flakyNodeClient) Publish(
+	ctx context.Context,
+	request *pb.PublishRequest,
+	opts ...grpc.CallOption) (*pb.PublishResponse, error) {
+
+	client.lock.Lock()
+	if client.failures > 0 {
+		client.failures--
+		client.requests++
+		client.lock.Unlock()
+		return nil, client.err
+	}
+	client.lock.Unlock()
+
+	return client.recordingNodeClient.Publish(ctx, request, opts...)
+}
+
+func (client *flakyNodeClient) GetWriteClient(
+	partitionID *pb.PartitionID) (pb.UltrabusNodeClient, error) {
+
+	return client, nil
+}
+
+func TestPublishRetries(t *testing.T) {
+	assert := assert.New(t)
+
+	topic := &pb.TopicMeta{Topic: "orders", Partitions: 1}
+	messages := []*pb.Message{{Value: []byte("value")}}
+	unavailable := grpc.Errorf(codes.Unavailable, "node down")
+
+	publish := func(
+		policy *RetryPolicy, failures int, err error) (*flakyNodeClient, error) {
+
+		options, optionsErr := newClientOptions(
+			[]ClientOption{WithRetryPolicy(policy)})
+		assert.Nil(optionsErr)
+
+		client := &flakyNodeClient{newRecordingNodeClient(), failures, err}
+		broker := newTopicBroker(topic, nil, client, options)
+		return client, broker.Publish(messages)
+	}
+
+	policy := &RetryPolicy{
+		MaxRetries: 2,
+		Backoff:    time.Millisecond,
+		MaxBackoff: 2 * time.Millisecond,
+		Jitter:     0.5}
+
+	// Retriable errors are retried until the retries run out
+	client, err := publish(policy, 2, unavailable)
+	assert.Nil(err)
+	assert.Equal(3, client.requests)
+
+	client, err = publish(policy, 3, unavailable)
+	assert.IsType(&PublishError{}, err)
+	assert.Equal(codes.Unavailable, grpc.Code(err.(*PublishError).Partitions[0]))
+	assert.Equal(3, client.requests)
+
+	// Fatal ones aren't
+	client, err = publish(
+		policy, 1, grpc.Errorf(codes.InvalidArgument, "too large"))
+	assert.IsType(&PublishError{}, err)
+	assert.Equal(1, client.requests)
+
+	// Nor are retries made past the delivery timeout
+	policy.MaxRetries = 1000
+	policy.DeliveryTimeout = 20 * time.Millisecond
+	_, err = publish(policy, 1000, unavailable)
+	assert.IsType(&DeliveryTimeoutError{}, err.(*PublishError).Partitions[0])
+
+	_, err = newClientOptions(
+		[]ClientOption{WithRetryPolicy(&RetryPolicy{Jitter: 2})})
+	assert.NotNil(err)
+}
+
+// Overlapping publishes to a partition are appended in the order they
+// were made, even when the first is retried.
+func TestPublishAsyncOrder(t *testing.T) {
+	assert := assert.New(t)
+
+	options, err := newClientOptions([]ClientOption{
+		WithRetryPolicy(&RetryPolicy{
+			MaxRetries: 1,
+			Backoff:    20 * time.Millisecond,
+			MaxBackoff: 20 * time.Millisecond})})
+	assert.Nil(err)
+
+	client := &flakyNodeClient{newRecordingNodeClient(), 1,
+		grpc.Errorf(codes.Unavailable, "node down")}
+	broker := newTopicBroker(
+		&pb.TopicMeta{Topic: "orders", Partitions: 1}, nil, client, options)
+
+	first := broker.PublishAsync([]*pb.Message{{Value: []byte("first")}}, nil)
+	second := broker.PublishAsync([]*pb.Message{{Value: []byte("second")}}, nil)
+	<-first[0].Done()
+	<-second[0].Done()
+
+	client.lock.Lock()
+	var values []string
+	for _, message := range client.published[0] {
+		values = append(values, string(message.Value))
+	}
+	client.lock.Unlock()
+
+	assert.Equal([]string{"first", "second"}, values)
+
+	// A callback may publish again without holding up the partition
+	again := make(chan WriteReceipt, 1)
+	broker.PublishAsync([]*pb.Message{{Value: []byte("third")}},
+		func(message *pb.Message, offset int64, err error) {
+			again <- broker.PublishAsync(
+				[]*pb.Message{{Value: []byte("fourth")}}, nil)[0]
+		})
+	fourth := <-again
+	<-fourth.Done()
+	offset, err := fourth.Read()
+	assert.Nil(err)
+	assert.Equal(int64(3), offset)
+
+	// Nothing is sent once the broker stops
+	broker.stop()
+	receipts := broker.PublishAsync([]*pb.Message{{Value: []byte("late")}}, nil)
+	_, err = receipts[0].Read()
+	assert.IsType(&ClientClosedError{}, err)
+}
+
+func TestNodeErrorInterceptor(t *testing.T) {
+	assert := assert.New(t)
+
+	intercept := func(err error) error {
+		_, err = NodeErrorInterceptor(context.Background(), nil, nil,
+			func(context.Context, interface{}) (interface{}, error) {
+				return nil, err
+			})
+		return err
+	}
+
+	err := intercept(&MessageTooLargeError{10, 5})
+	assert.Equal(codes.InvalidArgument, grpc.Code(err))
+	assert.False(IsRetriable(err))
+
+	err = intercept(&NotEnoughReplicasError{nil, 1, 2})
+	assert.Equal(codes.Unavailable, grpc.Code(err))
+	assert.True(IsRetriable(err))
+
+	assert.Nil(intercept(nil))
+}
+
+// Loses the responses of its first publishes after appending them.
+type lossyNodeClient struct {
+	*recordingNodeClient
+	partition *Partition
+	losses    int
+}
+
+func (client *lossyNodeClient) Publish(
+	ctx context.Context,
+	request *pb.PublishRequest,
+	opts ...grpc.CallOption) (*pb.PublishResponse, error) {
+
+	offsets, err := client.partition.AppendFrom(
+		request.Producer, request.Messages)
+	if err != nil {
+		return nil, err
+	}
+
+	client.lock.Lock()
+	defer client.lock.Unlock()
+
+	if client.losses > 0 {
+		client.losses--
+		return nil, grpc.Errorf(codes.Unavailable, "response lost")
+	}
+
+	return &pb.PublishResponse{Offsets: offsets}, nil
+}
+
+func (client *lossyNodeClient) GetWriteClient(
+	partitionID *pb.PartitionID) (pb.UltrabusNodeClient, error) {
+
+	return client, nil
+}
+
+func TestIdempotentPublish(t *testing.T) {
+	assert := assert.New(t)
+
+	options, err := newClientOptions([]ClientOption{
+		WithIdempotence(),
+		WithRetryPolicy(&RetryPolicy{MaxRetries: 1})})
+	assert.Nil(err)
+
+	partition := NewInMemoryPartition()
+	defer partition.Stop()
+
+	client := &lossyNodeClient{newRecordingNodeClient(), partition, 1}
+	broker := newTopicBroker(
+		&pb.TopicMeta{Topic: "orders", Partitions: 1}, nil, client, options)
+
+	messages := []*pb.Message{{Value: []byte("a")}, {Value: []byte("b")}}
+	assert.Nil(broker.Publish(messages))
+	assert.Nil(broker.Publish(messages))
+
+	lastOffset, err := partition.log.LastOffset()
+	assert.Nil(err)
+	assert.Equal(int64(3), lastOffset)
+
+	// Callers retrying a failed publish don't append it twice
+	client.losses = 2
+	assert.IsType(&PublishError{}, broker.Publish(messages))
+	assert.Nil(broker.Publish(messages))
+
+	lastOffset, err = partition.log.LastOffset()
+	assert.Nil(err)
+	assert.Equal(int64(5), lastOffset)
+
+	// Nor do they have new messages taken for the failed ones
+	client.losses = 2
+	assert.IsType(&PublishError{}, broker.Publish(messages))
+	assert.Nil(broker.Publish([]*pb.Message{{Value: []byte("c")}}))
+
+	lastOffset, err = partition.log.LastOffset()
+	assert.Nil(err)
+	assert.Equal(int64(8), lastOffset)
+
+	// Resends appended too long ago to tell where succeed all the same
+	duplicated := &flakyNodeClient{newRecordingNodeClient(), 1,
+		grpc.Errorf(codes.AlreadyExists, "duplicate sequence")}
+	broker = newTopicBroker(&pb.TopicMeta{Topic: "orders", Partitions: 1},
+		nil, duplicated, options)
+	receipts := broker.PublishAsync(messages, nil)
+	<-receipts[0].Done()
+	offset, err := receipts[0].Read()
+	assert.Nil(err)
+	assert.Equal(int64(-1), offset)
+}
+
+// Sends a message on each subscription it opens, then fails to take the
+// credits given back for it.
+type stingyNodeClient struct {
+	*recordingNodeClient
+	streams []context.Context
+}
+
+type stingyStream struct {
+	grpc.ClientStream
+	ctx  context.Context
+	sent bool
+}
+
+func (stream *stingyStream) Recv() (*pb.Messages, error) {
+	if !stream.sent {
+		stream.sent = true
+		return &pb.Messages{Messages: []*pb.MessageWithOffset{
+			{Message: &pb.Message{Value: []byte("a")}}}}, nil
+	}
+
+	<-stream.ctx.Done()
+	return nil, stream.ctx.Err()
+}
+
+func (client *stingyNodeClient) Subscribe(
+	ctx context.Context,
+	request *pb.SubscribeRequest,
+	opts ...grpc.CallOption) (pb.UltrabusNode_SubscribeClient, error) {
+
+	client.lock.Lock()
+	defer client.lock.Unlock()
+
+	client.streams = append(client.streams, ctx)
+	return &stingyStream{ctx: ctx}, nil
+}
+
+func (client *stingyNodeClient) GrantCredits(
+	ctx context.Context,
+	request *pb.GrantCreditsRequest,
+	opts ...grpc.CallOption) (*pb.GrantCreditsResponse, error) {
+
+	return nil, grpc.Errorf(codes.Unavailable, "credits lost")
+}
+
+func (client *stingyNodeClient) GetReadClient(
+	partitionID *pb.PartitionID) (pb.UltrabusNodeClient, error) {
+
+	return client, nil
+}
+
+func TestSubscriptionResubscribes(t *testing.T) {
+	assert := assert.New(t)
+
+	options, err := newClientOptions([]ClientOption{
+		WithSubscriptionCredits(2, 1024)})
+	assert.Nil(err)
+
+	client := &stingyNodeClient{recordingNodeClient: newRecordingNodeClient()}
+	broker := newTopicBroker(
+		&pb.TopicMeta{Topic: "orders", Partitions: 1}, nil, client, options)
+
+	subscription, err := broker.Subscribe()
+	assert.Nil(err)
+	defer subscription.Stop()
+
+	for i := 0; i < 3; i++ {
+		<-subscription.Messages()
+	}
+
+	// Streams given up on are cancelled before subscribing again
+	client.lock.Lock()
+	streams := client.streams[:len(client.streams)-1]
+	client.lock.Unlock()
+
+	assert.True(len(streams) >= 2)
+	for _, ctx := range streams {
+		select {
+		case <-ctx.Done():
+		default:
+			t.Error("Stream given up on wasn't cancelled")
+		}
+	}
+}
+
+// Queue members are sent little ahead of what they've read, as what
+// they're sent is kept from the group's other members.
+func TestQueueSubscriptionCredits(t *testing.T) {
+	assert := assert.New(t)
+
+	_, discovery, stop := serveTestNode(t)
+	defer stop()
+
+	client := func() UltrabusClient {
+		client, err := NewSingleAddrBrokeredClient("group", discovery,
+			WithQueueSubscriptions(time.Minute, 0))
+		assert.Nil(err)
+		return client
+	}
+
+	idle := client()
+	defer idle.Close()
+
+	eventually(t, func() error {
+		return idle.Create("orders", 1, 1)
+	})
+
+	_, err := idle.Subscribe("orders")
+	assert.Nil(err)
+
+	for i := 0; i < 30; i++ {
+		assert.Nil(idle.Publish(
+			"orders", []*pb.Message{{Value: []byte("value")}}))
+	}
+
+	busy := client()
+	defer busy.Close()
+
+	subscription, err := busy.Subscribe("orders")
+	assert.Nil(err)
+
+	received := 0
+	for received < 19 {
+		select {
+		case <-subscription.Messages():
+			received++
+		case <-time.After(time.Second):
+			t.Fatalf("Received %v messages, the idle member leasing the rest",
+				received)
+		}
+	}
+}
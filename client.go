@@ -1,31 +1,373 @@
 package ultrabus
 
 import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
 	"code.google.com/p/go-uuid/uuid"
 	"github.com/emef/ultrabus/pb"
+	"golang.org/x/net/context"
 )
 
 type UltrabusClient interface {
 	Subscribe(topic string) (Subscription, error)
+
+	// Subscribes to only the messages of topic that filter lets through;
+	// nodes drop the rest before sending
+	SubscribeFiltered(
+		topic string, filter *pb.SubscriptionFilter) (Subscription, error)
+
+	// Records that the client's consumer group has read topic's partition
+	// up to offset, the next its subscriptions start from
+	CommitOffset(topic string, partition int32, offset int64) error
+
+	// Reads topic's partition from offset, waiting up to maxWait for
+	// messages to arrive, see pb.FetchRequest
+	Fetch(
+		topic string,
+		partition int32,
+		offset int64,
+		maxMessages int32,
+		maxBytes int64,
+		maxWait time.Duration) (*pb.FetchResponse, error)
+
+	// Reads topic with Fetch rather than over streams
+	NewPullConsumer(topic string) (PullConsumer, error)
+
 	Publish(topic string, messages []*pb.Message) error
+	PublishAsync(
+		topic string,
+		messages []*pb.Message,
+		callback PublishCallback) []WriteReceipt
+
+	// A producer batching messages by config, or DefaultProducerConfig
+	// when nil
+	NewProducer(config *ProducerConfig) (Producer, error)
+
+	// Starts a transaction, aborting any transactionalID left open, such
+	// as that of a previous run of the same job. The first transaction a
+	// client begins fences off other clients of transactionalID, whose
+	// calls fail with ProducerFencedError from then on.
+	BeginTransaction(transactionalID string) (Transaction, error)
+
+	// Publishes what transform makes of each message of source to sink
+	// exactly once, see TransformFunc
+	Transform(
+		transactionalID, source, sink string,
+		transform TransformFunc,
+		done chan interface{}) error
+
   Create(topic string, partitions int32, replicas int32) error
+
+	// Topic administration
+	Alter(topic string, partitions int32) error
+	Delete(topic string) error
+	GetConfig(topic string) (*pb.GetTopicConfigResponse, error)
+	SetConfig(topic string, set map[string]string, unset []string) error
+	List() ([]*pb.TopicMeta, error)
+	Describe(topic string) (*pb.DescribeTopicResponse, error)
+
+	// Stops the client's subscriptions and background refreshes. The
+	// client can't be used afterwards.
+	Close() error
 }
 
 type Subscription interface {
 	Messages() chan *pb.MessageWithOffset
+
+	// Errors the subscription's filter failed with on messages it left
+	// out, such as FilterEvaluationError. Errors not read in time are
+	// dropped.
+	Errors() chan error
+
+	// Settles a message leased in queue mode, see WithQueueSubscriptions:
+	// Ack when done with it, Nack to have it delivered again, with the
+	// error it failed with, if any, for its dead-letter topic
+	Ack(message *pb.MessageWithOffset) error
+	Nack(message *pb.MessageWithOffset, cause error) error
+
 	Stop()
 }
 
+// Changes how a client publishes, see NewSingleAddrBrokeredClient.
+type ClientOption func(options *clientOptions)
+
+type clientOptions struct {
+	// Overrides the keyless.partitioning of every topic when set
+	keylessPartitioning string
+
+	// Places keyed messages in place of the topic's key.hash when set
+	partitioner Partitioner
+
+	retry *RetryPolicy
+
+	// Whether brokers publish as idempotent producers
+	idempotent bool
+
+	// Which messages subscriptions receive
+	isolation pb.IsolationLevel
+
+	// The most messages and bytes each partition of a subscription has
+	// in flight
+	credits *pb.Credits
+
+	// Set for subscriptions in batch mode
+	batch *pb.BatchOptions
+
+	// Set for subscriptions in queue mode, along with where they send
+	// messages they give up on
+	queue           *pb.QueueOptions
+	deadLetterTopic string
+
+	// Whether pull consumers of a group without committed offsets start
+	// from the beginning of each partition
+	earliest bool
+}
+
+// How publish requests are retried, see DefaultRetryPolicy.
+type RetryPolicy struct {
+	// Retries after the first attempt before a request fails
+	MaxRetries int
+
+	// Wait before the first retry, doubled each retry up to MaxBackoff
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+
+	// Fraction of each wait, in [0, 1], randomly added or taken away so
+	// that clients don't retry in lockstep
+	Jitter float64
+
+	// How long a message may take to be written, retries included, or 0
+	// for no limit
+	DeliveryTimeout time.Duration
+}
+
+func DefaultRetryPolicy() *RetryPolicy {
+	return &RetryPolicy{
+		MaxRetries:      10,
+		Backoff:         100 * time.Millisecond,
+		MaxBackoff:      10 * time.Second,
+		Jitter:          0.2,
+		DeliveryTimeout: 2 * time.Minute}
+}
+
+// How long to wait before retry number attempt, counting from 0.
+func (policy *RetryPolicy) backoff(attempt int) time.Duration {
+	backoff := policy.Backoff
+	for i := 0; i < attempt && backoff < policy.MaxBackoff; i++ {
+		backoff *= 2
+	}
+
+	if backoff > policy.MaxBackoff {
+		backoff = policy.MaxBackoff
+	}
+
+	jitter := policy.Jitter * (2*rand.Float64() - 1)
+	return backoff + time.Duration(jitter*float64(backoff))
+}
+
+func (policy *RetryPolicy) validate() error {
+	switch {
+	case policy.MaxRetries < 0:
+		return fmt.Errorf("Retries must not be negative")
+	case policy.Backoff < 0 || policy.MaxBackoff < policy.Backoff:
+		return fmt.Errorf("Backoff must be between 0 and the max backoff")
+	case policy.Jitter < 0 || policy.Jitter > 1:
+		return fmt.Errorf("Jitter must be between 0 and 1")
+	case policy.DeliveryTimeout < 0:
+		return fmt.Errorf("Delivery timeout must not be negative")
+	}
+
+	return nil
+}
+
+// Spreads keyless messages with strategy (KeylessRoundRobin or
+// KeylessSticky) whatever the topic's keyless.partitioning says.
+func WithKeylessPartitioning(strategy string) ClientOption {
+	return func(options *clientOptions) {
+		options.keylessPartitioning = strategy
+	}
+}
+
+// Places keyed messages with partitioner rather than by the topic's
+// key.hash.
+func WithPartitioner(partitioner Partitioner) ClientOption {
+	return func(options *clientOptions) {
+		options.partitioner = partitioner
+	}
+}
+
+// Retries failed publishes by policy rather than DefaultRetryPolicy.
+func WithRetryPolicy(policy *RetryPolicy) ClientOption {
+	return func(options *clientOptions) {
+		options.retry = policy
+	}
+}
+
+// Publishes as an idempotent producer, so that partitions append a
+// request retried after its response was lost only once, including when
+// the caller retries a Publish that failed with the same messages.
+// Messages appended too long before their retry resolve with offset -1.
+// Requests to a partition are sent one at a time.
+func WithIdempotence() ClientOption {
+	return func(options *clientOptions) {
+		options.idempotent = true
+	}
+}
+
+// Subscribes with isolation, READ_COMMITTED to only receive messages of
+// committed transactions. Defaults to READ_UNCOMMITTED.
+func WithIsolation(isolation pb.IsolationLevel) ClientOption {
+	return func(options *clientOptions) {
+		options.isolation = isolation
+	}
+}
+
+// Lets each partition of a subscription have at most messages, and
+// bytes of messages, sent ahead of what's been read from Messages. The
+// defaults are 1000 messages and 4MiB, or 10 messages in queue mode,
+// where messages sent ahead are leased and kept from other members.
+func WithSubscriptionCredits(messages, bytes int64) ClientOption {
+	return func(options *clientOptions) {
+		options.credits = &pb.Credits{Messages: messages, Bytes: bytes}
+	}
+}
+
+// Subscribes in batch mode: nodes hold messages until they have
+// minMessages of them, maxBytes of them or the first has waited maxWait,
+// and send them together. Zero leaves a threshold out. A partition out of
+// credits sends what it holds.
+func WithSubscriptionBatch(
+	minMessages int32, maxWait time.Duration, maxBytes int64) ClientOption {
+
+	return func(options *clientOptions) {
+		options.batch = &pb.BatchOptions{
+			MinMessages: minMessages,
+			MaxWaitMs:   int64(maxWait / time.Millisecond),
+			MaxBytes:    maxBytes}
+	}
+}
+
+// Subscribes in queue mode: the consumer group's subscribers share out
+// its messages, each acking those it's given within visibilityTimeout or
+// having them delivered again, up to maxDeliveries times. Zero leaves the
+// defaults of 30s and no limit.
+func WithQueueSubscriptions(
+	visibilityTimeout time.Duration, maxDeliveries int32) ClientOption {
+
+	return func(options *clientOptions) {
+		options.queue = &pb.QueueOptions{
+			VisibilityTimeoutMs: int64(visibilityTimeout / time.Millisecond),
+			MaxDeliveries:       maxDeliveries}
+	}
+}
+
+// Has queue subscriptions send messages delivered maxDeliveries times to
+// topic, see HeaderDeadLetterTopic and ReplayDeadLetters.
+func WithDeadLetterTopic(topic string) ClientOption {
+	return func(options *clientOptions) {
+		options.deadLetterTopic = topic
+	}
+}
+
+// Starts pull consumers of a consumer group that hasn't committed offsets
+// from the beginning of each partition, rather than its end.
+func WithEarliestOffsets() ClientOption {
+	return func(options *clientOptions) {
+		options.earliest = true
+	}
+}
+
+// The credits subscriptions have by default, see WithSubscriptionCredits.
+const (
+	defaultCredits      = 1000
+	defaultQueueCredits = 10
+	defaultCreditBytes  = 4 * 1024 * 1024
+)
+
+func newClientOptions(options []ClientOption) (*clientOptions, error) {
+	parsed := &clientOptions{retry: DefaultRetryPolicy()}
+	for _, option := range options {
+		option(parsed)
+	}
+
+	if parsed.credits == nil && parsed.queue != nil {
+		parsed.credits = &pb.Credits{
+			Messages: defaultQueueCredits, Bytes: defaultCreditBytes}
+	} else if parsed.credits == nil {
+		parsed.credits = &pb.Credits{
+			Messages: defaultCredits, Bytes: defaultCreditBytes}
+	}
+
+	if err := parsed.retry.validate(); err != nil {
+		return nil, err
+	}
+
+	if parsed.credits.Messages < 1 || parsed.credits.Bytes < 1 {
+		return nil, fmt.Errorf("Subscriptions need credits for a message")
+	}
+
+	if batch := parsed.batch; batch != nil && (batch.MinMessages < 0 ||
+		batch.MaxWaitMs < 0 || batch.MaxBytes < 0) {
+		return nil, fmt.Errorf("Batch thresholds must not be negative")
+	}
+
+	if queue := parsed.queue; queue != nil &&
+		(queue.VisibilityTimeoutMs < 0 || queue.MaxDeliveries < 0) {
+		return nil, fmt.Errorf("Queue options must not be negative")
+	}
+
+	if parsed.deadLetterTopic != "" {
+		if parsed.queue == nil || parsed.queue.MaxDeliveries == 0 {
+			return nil, fmt.Errorf(
+				"Dead-letter topics need queue subscriptions with max deliveries")
+		}
+
+		parsed.queue.DeadLetterTopic = parsed.deadLetterTopic
+	}
+
+	if parsed.keylessPartitioning != "" {
+		_, err := parseKeylessPartitioning(parsed.keylessPartitioning)
+		if err != nil {
+			return nil, &InvalidConfigError{
+				ConfigKeylessPartitioning, parsed.keylessPartitioning, err.Error()}
+		}
+	}
+
+	return parsed, nil
+}
+
+// How often brokers pick up partitions added to their topic elsewhere.
+const topicRefreshInterval = 10 * time.Second
+
 type singleAddrBrokeredClient struct {
 	clientID *pb.ClientID
 	discovery Discovery
 	connectionManager ConnectionManager
+	options  *clientOptions
+	lock     sync.Mutex
 	brokers  map[string]*TopicBroker
+
+	// The producer each transactional ID's last transaction was given,
+	// which fences the client off once another takes the ID over
+	producers map[string]*pb.ProducerSequence
+
+	// Closed by Close
+	done      chan interface{}
+	closeOnce sync.Once
 }
 
 func NewSingleAddrBrokeredClient(
 	consumerGroup string,
-	discovery Discovery) (UltrabusClient, error) {
+	discovery Discovery,
+	options ...ClientOption) (UltrabusClient, error) {
+
+	parsedOptions, err := newClientOptions(options)
+	if err != nil {
+		return nil, err
+	}
 
 	connectionManager := NewDiscoveryConnectionManager(discovery)
 
@@ -33,20 +375,84 @@ func NewSingleAddrBrokeredClient(
 		ConsumerGroup: consumerGroup,
 		ConsumerID:    uuid.New()}
 
-	brokers := make(map[string]*TopicBroker)
+	client := &singleAddrBrokeredClient{
+		clientID:          clientID,
+		discovery:         discovery,
+		connectionManager: connectionManager,
+		options:           parsedOptions,
+		brokers:           make(map[string]*TopicBroker),
+		producers:         make(map[string]*pb.ProducerSequence),
+		done:              make(chan interface{})}
+
+	go client.refresh()
+
+	return client, nil
+}
+
+// Keeps brokers up to date with their topics' partition counts until the
+// client is closed.
+func (client *singleAddrBrokeredClient) refresh() {
+	ticker := time.NewTicker(topicRefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-client.done:
+			return
+		}
+
+		client.lock.Lock()
+		brokers := make([]*TopicBroker, 0, len(client.brokers))
+		for _, broker := range client.brokers {
+			brokers = append(brokers, broker)
+		}
+		client.lock.Unlock()
+
+		for _, broker := range brokers {
+			meta, err := client.discovery.GetTopic(broker.meta().Topic)
+			if err == nil {
+				broker.UpdateMeta(meta)
+			}
+		}
+	}
+}
+
+func (client *singleAddrBrokeredClient) Close() error {
+	client.closeOnce.Do(func() {
+		close(client.done)
+
+		client.lock.Lock()
+		brokers := client.brokers
+		client.brokers = make(map[string]*TopicBroker)
+		client.lock.Unlock()
+
+		for _, broker := range brokers {
+			broker.stop()
+		}
+	})
 
-	return &singleAddrBrokeredClient{
-		clientID, discovery, connectionManager, brokers}, nil
+	return nil
 }
 
 func (client *singleAddrBrokeredClient) broker(topic string) (*TopicBroker, error) {
+	client.lock.Lock()
+	defer client.lock.Unlock()
+
+	select {
+	case <-client.done:
+		return nil, &ClientClosedError{}
+	default:
+	}
+
 	if _, ok := client.brokers[topic]; !ok {
 		meta, err := client.discovery.GetTopic(topic)
 		if err != nil {
 			return nil, err
 		}
 
-		broker := NewTopicBroker(meta, client.clientID, client.connectionManager)
+		broker := newTopicBroker(
+			meta, client.clientID, client.connectionManager, client.options)
 		client.brokers[meta.Topic] = broker
 	}
 
@@ -64,6 +470,49 @@ func (client *singleAddrBrokeredClient) Subscribe(
 	return broker.Subscribe()
 }
 
+func (client *singleAddrBrokeredClient) SubscribeFiltered(
+	topic string, filter *pb.SubscriptionFilter) (Subscription, error) {
+
+	broker, err := client.broker(topic)
+	if err != nil {
+		return nil, err
+	}
+
+	return broker.SubscribeFiltered(filter)
+}
+
+func (client *singleAddrBrokeredClient) CommitOffset(
+	topic string, partition int32, offset int64) error {
+
+	return client.commitOffset(topic, partition, offset, nil)
+}
+
+// Commits offset, as part of producer's transaction when set.
+func (client *singleAddrBrokeredClient) commitOffset(
+	topic string,
+	partition int32,
+	offset int64,
+	producer *pb.ProducerSequence) error {
+
+	partitionID := &pb.PartitionID{Topic: topic, Partition: partition}
+	node, err := client.connectionManager.GetWriteClient(partitionID)
+	if err != nil {
+		return err
+	}
+
+	request := &pb.CommitOffsetRequest{
+		ConsumerGroup: client.clientID.ConsumerGroup,
+		PartitionID:   partitionID,
+		Offset:        offset}
+	if producer != nil {
+		request.ProducerID = producer.ProducerID
+		request.Epoch = producer.Epoch
+	}
+
+	_, err = node.CommitOffset(context.Background(), request)
+	return err
+}
+
 func (client *singleAddrBrokeredClient) Publish(
 	topic string, messages []*pb.Message) error {
 
@@ -75,9 +524,195 @@ func (client *singleAddrBrokeredClient) Publish(
 	return broker.Publish(messages)
 }
 
+// Publishes without waiting, see TopicBroker.PublishAsync. If the topic
+// can't be found every receipt fails.
+func (client *singleAddrBrokeredClient) PublishAsync(
+	topic string,
+	messages []*pb.Message,
+	callback PublishCallback) []WriteReceipt {
+
+	broker, err := client.broker(topic)
+	if err != nil {
+		receipts := make([]WriteReceipt, len(messages))
+		for i, message := range messages {
+			receipt := newReceipt()
+			resolvePublish(receipt, callback, message, -1, err)
+			receipts[i] = receipt
+		}
+
+		return receipts
+	}
+
+	return broker.PublishAsync(messages, callback)
+}
+
+func (client *singleAddrBrokeredClient) NewProducer(
+	config *ProducerConfig) (Producer, error) {
+
+	return newBatchingProducer(client.broker, config)
+}
+
+func (client *singleAddrBrokeredClient) BeginTransaction(
+	transactionalID string) (Transaction, error) {
+
+	coordinator, err := client.connectionManager.GetCoordinatorClient(
+		transactionalID)
+	if err != nil {
+		return nil, err
+	}
+
+	client.lock.Lock()
+	producer := client.producers[transactionalID]
+	client.lock.Unlock()
+
+	response, err := coordinator.BeginTransaction(context.Background(),
+		&pb.BeginTransactionRequest{
+			TransactionalID: transactionalID, Producer: producer})
+	if err != nil {
+		return nil, err
+	}
+
+	client.lock.Lock()
+	client.producers[transactionalID] = response.Producer
+	client.lock.Unlock()
+
+	return &clientTransaction{
+		client:          client,
+		transactionalID: transactionalID,
+		producerID:      response.Producer.ProducerID,
+		epoch:           response.Producer.Epoch,
+		sequences:       make(map[pb.PartitionID]int64)}, nil
+}
+
 func (client *singleAddrBrokeredClient) Create(
 	topic string, partitions int32, replicas int32) error {
 
-	return client.discovery.CreateTopic(&pb.TopicMeta{
-		Topic: topic, Partitions: partitions, Replicas: replicas})
+	admin, err := client.connectionManager.GetAdminClient()
+	if err != nil {
+		return err
+	}
+
+	_, err = admin.CreateTopic(context.Background(), &pb.CreateTopicRequest{
+		Meta: &pb.TopicMeta{
+			Topic: topic, Partitions: partitions, Replicas: replicas}})
+	return topicError(topic, err)
+}
+
+// Grows topic to the given number of partitions. Keyed messages
+// published afterwards are hashed over the new count, so most keys move
+// to a different partition unless the topic's key.hash is ring; see
+// HashToPartitionScheme.
+func (client *singleAddrBrokeredClient) Alter(
+	topic string, partitions int32) error {
+
+	admin, err := client.connectionManager.GetAdminClient()
+	if err != nil {
+		return err
+	}
+
+	response, err := admin.AlterTopic(context.Background(),
+		&pb.AlterTopicRequest{Topic: topic, Partitions: partitions})
+	if err != nil {
+		return topicError(topic, err)
+	}
+
+	client.lock.Lock()
+	broker, ok := client.brokers[topic]
+	client.lock.Unlock()
+
+	if ok {
+		broker.UpdateMeta(response.Meta)
+	}
+
+	return nil
+}
+
+func (client *singleAddrBrokeredClient) Delete(topic string) error {
+	admin, err := client.connectionManager.GetAdminClient()
+	if err != nil {
+		return err
+	}
+
+	_, err = admin.DeleteTopic(
+		context.Background(), &pb.DeleteTopicRequest{Topic: topic})
+	if err != nil {
+		return topicError(topic, err)
+	}
+
+	client.lock.Lock()
+	broker, ok := client.brokers[topic]
+	delete(client.brokers, topic)
+	client.lock.Unlock()
+
+	if ok {
+		broker.stop()
+	}
+
+	return nil
+}
+
+func (client *singleAddrBrokeredClient) GetConfig(
+	topic string) (*pb.GetTopicConfigResponse, error) {
+
+	admin, err := client.connectionManager.GetAdminClient()
+	if err != nil {
+		return nil, err
+	}
+
+	return admin.GetTopicConfig(
+		context.Background(), &pb.GetTopicConfigRequest{Topic: topic})
+}
+
+// Sets and removes overrides of topic's config, see TopicConfig for the
+// keys.
+func (client *singleAddrBrokeredClient) SetConfig(
+	topic string, set map[string]string, unset []string) error {
+
+	admin, err := client.connectionManager.GetAdminClient()
+	if err != nil {
+		return err
+	}
+
+	response, err := admin.SetTopicConfig(context.Background(),
+		&pb.SetTopicConfigRequest{Topic: topic, Set: set, Unset: unset})
+	if err != nil {
+		return err
+	}
+
+	client.lock.Lock()
+	broker, ok := client.brokers[topic]
+	client.lock.Unlock()
+
+	if ok {
+		broker.UpdateMeta(response.Meta)
+	}
+
+	return nil
+}
+
+func (client *singleAddrBrokeredClient) List() ([]*pb.TopicMeta, error) {
+	admin, err := client.connectionManager.GetAdminClient()
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := admin.ListTopics(
+		context.Background(), &pb.ListTopicsRequest{})
+	if err != nil {
+		return nil, err
+	}
+
+	return response.Topics, nil
+}
+
+func (client *singleAddrBrokeredClient) Describe(
+	topic string) (*pb.DescribeTopicResponse, error) {
+
+	admin, err := client.connectionManager.GetAdminClient()
+	if err != nil {
+		return nil, err
+	}
+
+	return admin.DescribeTopic(
+		context.Background(), &pb.DescribeTopicRequest{Topic: topic})
 }
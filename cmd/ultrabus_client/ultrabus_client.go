@@ -4,22 +4,34 @@ import (
 	"flag"
 
 	"github.com/emef/ultrabus"
+	"github.com/emef/ultrabus/pb"
 	"google.golang.org/grpc/grpclog"
 )
 
 var (
 	serverAddr = flag.String("server_addr", "127.0.0.1:10000",
 		"The server address in the format of host:port")
-	topic         = flag.String("topic", "topic", "Topic to subscribe to")
-	partitions    = flag.Int("partitions", 10, "Number of partitions (temporary)")
+	discoveryURI = flag.String("discovery", "",
+		"file:///path, srv://name or host:port (default server_addr)")
+	topic      = flag.String("topic", "topic", "Topic to subscribe to")
+	partitions = flag.Int("partitions", 10,
+		"Partitions to create the topic with if it doesn't exist")
 	numMessages   = flag.Int("n", 10, "Number of messages to read")
 	consumerGroup = flag.String("consumer_group", "grp", "Consumer group name")
+	keyPrefix     = flag.String("key_prefix", "", "Only read keys with this prefix")
+	keyRegex      = flag.String("key_regex", "", "Only read keys matching this regex")
+	expression    = flag.String("filter", "",
+		"Only read messages this expression holds of, see ultrabus.Expression")
 )
 
 func main() {
 	flag.Parse()
 
-	discovery, err := ultrabus.NewSingleAddrDiscovery(*serverAddr)
+	if *discoveryURI == "" {
+		*discoveryURI = *serverAddr
+	}
+
+	discovery, err := ultrabus.OpenDiscovery(*discoveryURI)
 	if err != nil {
 		grpclog.Fatalf("Failed to create discovery: %v", err)
 	}
@@ -29,8 +41,17 @@ func main() {
 	if err != nil {
 		grpclog.Fatalf("Failed to create brokered client: %v", err)
 	}
+	defer client.Close()
+
+	if err := client.Create(*topic, int32(*partitions), 1); err != nil {
+		grpclog.Printf("Not creating topic %v: %v", *topic, err)
+	}
 
-	subscription, err := client.Subscribe(*topic)
+	subscription, err := client.SubscribeFiltered(*topic,
+		&pb.SubscriptionFilter{
+			KeyPrefix:  []byte(*keyPrefix),
+			KeyRegex:   *keyRegex,
+			Expression: *expression})
 	if err != nil {
 		grpclog.Fatalf("Failed to subscribe to topic %v: %v", *topic, err)
 	}
@@ -4,6 +4,7 @@ import (
 	"flag"
 	"fmt"
 	"net"
+	"strings"
 
 	"github.com/emef/ultrabus"
 	"github.com/emef/ultrabus/pb"
@@ -12,7 +13,22 @@ import (
 )
 
 var (
-	port = flag.Int("port", 10000, "The server port")
+	port          = flag.Int("port", 10000, "The server port")
+	advertiseAddr = flag.String("advertise_addr", "",
+		"Address clients reach this node at (default 127.0.0.1:port)")
+	discoveryURI = flag.String("discovery", "",
+		"file:///path, srv://name or raft://members "+
+			"(default: this node alone, keeping metadata in memory)")
+	raftAddr = flag.String("raft_addr", "",
+		"Join the metadata quorum, running raft on this address")
+	raftPeers = flag.String("raft_peers", "",
+		"Voters to bootstrap the quorum with: advertise_addr=raft_addr,...")
+	raftDir = flag.String("raft_dir", "",
+		"Directory for raft state (kept in memory if empty)")
+	gossipAddr = flag.String("gossip_addr", "",
+		"Detect failed nodes by gossiping over UDP on this address")
+	gossipSeeds = flag.String("gossip_seeds", "",
+		"Gossip addresses of existing nodes to join through: host:port,...")
 )
 
 func main() {
@@ -23,9 +39,72 @@ func main() {
 		grpclog.Fatalf("failed to listen: %v", err)
 	}
 
-	server := ultrabus.NewNodeService()
+	if *advertiseAddr == "" {
+		*advertiseAddr = fmt.Sprintf("127.0.0.1:%d", *port)
+	}
+
+	var discovery ultrabus.Discovery
+	switch {
+	case *raftAddr != "":
+		discovery, err = newRaftDiscovery()
+	case *discoveryURI != "":
+		discovery, err = ultrabus.OpenDiscovery(*discoveryURI)
+	default:
+		discovery, err = ultrabus.NewLocalDiscovery()
+	}
+
+	if err != nil {
+		grpclog.Fatalf("Failed to create discovery: %v", err)
+	}
 
-	grpcServer := grpc.NewServer()
+	if *gossipAddr != "" {
+		discovery, err = newGossipDiscovery(discovery)
+		if err != nil {
+			grpclog.Fatalf("Failed to join gossip: %v", err)
+		}
+	}
+
+	server, err := ultrabus.NewNodeService(*advertiseAddr, discovery)
+	if err != nil {
+		grpclog.Fatalf("Failed to create node: %v", err)
+	}
+
+	grpcServer := grpc.NewServer(
+		grpc.UnaryInterceptor(ultrabus.NodeErrorInterceptor))
 	pb.RegisterUltrabusNodeServer(grpcServer, server)
 	grpcServer.Serve(lis)
 }
+
+func newRaftDiscovery() (ultrabus.Discovery, error) {
+	peers := make(map[string]string)
+	for _, peer := range strings.Split(*raftPeers, ",") {
+		if parts := strings.SplitN(peer, "=", 2); len(parts) == 2 {
+			peers[parts[0]] = parts[1]
+		}
+	}
+
+	return ultrabus.NewRaftDiscovery(&ultrabus.RaftDiscoveryConfig{
+		ServerAddr: *advertiseAddr,
+		RaftAddr:   *raftAddr,
+		DataDir:    *raftDir,
+		Peers:      peers})
+}
+
+func newGossipDiscovery(
+	discovery ultrabus.Discovery) (ultrabus.Discovery, error) {
+
+	var seeds []string
+	if *gossipSeeds != "" {
+		seeds = strings.Split(*gossipSeeds, ",")
+	}
+
+	membership, err := ultrabus.NewMembership(&ultrabus.GossipConfig{
+		ServerAddr: *advertiseAddr,
+		BindAddr:   *gossipAddr,
+		Seeds:      seeds})
+	if err != nil {
+		return nil, err
+	}
+
+	return ultrabus.NewGossipDiscovery(discovery, membership)
+}
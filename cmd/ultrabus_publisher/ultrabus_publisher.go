@@ -13,27 +13,73 @@ import (
 var (
 	serverAddr = flag.String("server_addr", "127.0.0.1:10000",
 		"The server address in the format of host:port")
-	topic              = flag.String("topic", "topic", "Topic to subscribe to")
+	discoveryURI = flag.String("discovery", "",
+		"file:///path, srv://name or host:port (default server_addr)")
+	topic      = flag.String("topic", "topic", "Topic to publish to")
+	partitions = flag.Int("partitions", 10,
+		"Partitions to create the topic with if it doesn't exist")
 	numMessages        = flag.Int("n", 10, "Number of messages to publish")
 	messagesPerRequest = flag.Int("messages_per_request", 1,
 		"Messages to batch in each request")
-	intervalSeconds = flag.Int("interval_seconds", 1, "Seconds between publishing")
-	consumerGroup   = flag.String("consumer_group", "", "Consumer group name")
+	intervalSeconds     = flag.Int("interval_seconds", 1, "Seconds between publishing")
+	consumerGroup       = flag.String("consumer_group", "", "Consumer group name")
+	keyless             = flag.Bool("keyless", false, "Publish messages without keys")
+	keylessPartitioning = flag.String("keyless_partitioning", "",
+		"round-robin or sticky (default the topic's keyless.partitioning)")
+	partitioner = flag.String("partitioner", "",
+		"md5, ring, legacy or murmur2 (default the topic's key.hash)")
+	idempotent = flag.Bool("idempotent", false,
+		"Publish as an idempotent producer")
+	delay = flag.Duration("delay", 0,
+		"Have subscribers receive each message this long after it's published")
+	ttl = flag.Duration("ttl", 0,
+		"Have subscribers skip each message this long after it's published")
 )
 
 func main() {
 	flag.Parse()
 
-	discovery, err := ultrabus.NewSingleAddrDiscovery(*serverAddr)
+	if *discoveryURI == "" {
+		*discoveryURI = *serverAddr
+	}
+
+	discovery, err := ultrabus.OpenDiscovery(*discoveryURI)
 	if err != nil {
 		grpclog.Fatalf("Failed to create discovery: %v", err)
 	}
 
+	var options []ultrabus.ClientOption
+	if *keylessPartitioning != "" {
+		options = append(options,
+			ultrabus.WithKeylessPartitioning(*keylessPartitioning))
+	}
+
+	if *idempotent {
+		options = append(options, ultrabus.WithIdempotence())
+	}
+
+	switch *partitioner {
+	case "":
+	case ultrabus.KeyHashMD5, ultrabus.KeyHashRing, ultrabus.KeyHashLegacy:
+		options = append(options, ultrabus.WithPartitioner(
+			&ultrabus.MD5Partitioner{Scheme: *partitioner}))
+	case "murmur2":
+		options = append(options,
+			ultrabus.WithPartitioner(&ultrabus.Murmur2Partitioner{}))
+	default:
+		grpclog.Fatalf("Unknown partitioner: %v", *partitioner)
+	}
+
 	client, err := ultrabus.NewSingleAddrBrokeredClient(
-		*consumerGroup, discovery)
+		*consumerGroup, discovery, options...)
 	if err != nil {
 		grpclog.Fatalf("Failed to create brokered client: %v", err)
 	}
+	defer client.Close()
+
+	if err := client.Create(*topic, int32(*partitions), 1); err != nil {
+		grpclog.Printf("Not creating topic %v: %v", *topic, err)
+	}
 
 	for i := 0; i < *numMessages; i++ {
 		messages := make([]*pb.Message, 0)
@@ -42,6 +88,17 @@ func main() {
 			message := &pb.Message{
 				Key:   []byte(fmt.Sprintf("key-%v", id)),
 				Value: []byte(fmt.Sprintf("value-%v", id))}
+			if *keyless {
+				message.Key = nil
+			}
+			if *delay > 0 {
+				message.DeliverAt =
+					time.Now().Add(*delay).UnixNano() / int64(time.Millisecond)
+			}
+			if *ttl > 0 {
+				message.ExpiresAt =
+					time.Now().Add(*ttl).UnixNano() / int64(time.Millisecond)
+			}
 			messages = append(messages, message)
 		}
 
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"flag"
+	"time"
+
+	"github.com/emef/ultrabus"
+	"google.golang.org/grpc/grpclog"
+)
+
+var (
+	serverAddr = flag.String("server_addr", "127.0.0.1:10000",
+		"The server address in the format of host:port")
+	discoveryURI = flag.String("discovery", "",
+		"file:///path, srv://name or host:port (default server_addr)")
+	topic         = flag.String("topic", "", "Dead-letter topic to replay")
+	consumerGroup = flag.String("consumer_group", "ultrabus_replay",
+		"Consumer group recording what's been replayed")
+	idle = flag.Duration("idle", 5*time.Second,
+		"Stop once no dead letters arrive for this long")
+)
+
+func main() {
+	flag.Parse()
+
+	if *topic == "" {
+		grpclog.Fatalf("No dead-letter topic given")
+	}
+
+	if *discoveryURI == "" {
+		*discoveryURI = *serverAddr
+	}
+
+	discovery, err := ultrabus.OpenDiscovery(*discoveryURI)
+	if err != nil {
+		grpclog.Fatalf("Failed to create discovery: %v", err)
+	}
+
+	client, err := ultrabus.NewSingleAddrBrokeredClient(
+		*consumerGroup, discovery, ultrabus.WithEarliestOffsets())
+	if err != nil {
+		grpclog.Fatalf("Failed to create brokered client: %v", err)
+	}
+	defer client.Close()
+
+	replayed, err := ultrabus.ReplayDeadLetters(client, *topic, *idle)
+	if err != nil {
+		grpclog.Fatalf("Failed after replaying %v messages: %v", replayed, err)
+	}
+
+	grpclog.Printf("Replayed %v messages from %v", replayed, *topic)
+}
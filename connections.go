@@ -2,6 +2,7 @@ package ultrabus
 
 import (
 	"math/rand"
+	"sync"
 
 	"github.com/emef/ultrabus/pb"
 	"google.golang.org/grpc"
@@ -10,6 +11,8 @@ import (
 type ConnectionManager interface {
 	GetReadClient(partitionId *pb.PartitionID) (pb.UltrabusNodeClient, error)
 	GetWriteClient(partitionId *pb.PartitionID) (pb.UltrabusNodeClient, error)
+	GetAdminClient() (pb.UltrabusNodeClient, error)
+	GetCoordinatorClient(transactionalID string) (pb.UltrabusNodeClient, error)
 }
 
 type connectedClient struct {
@@ -19,8 +22,11 @@ type connectedClient struct {
 }
 
 type discoveryConnectionManager struct {
+	lock sync.Mutex
 	readClients map[pb.PartitionID]*connectedClient
 	writeClients map[pb.PartitionID]*connectedClient
+	adminClient *connectedClient
+	coordinatorClients map[string]*connectedClient
 	discovery Discovery
 }
 
@@ -28,12 +34,16 @@ func NewDiscoveryConnectionManager(discovery Discovery) ConnectionManager {
 	return &discoveryConnectionManager{
 		readClients: make(map[pb.PartitionID]*connectedClient),
 		writeClients: make(map[pb.PartitionID]*connectedClient),
+		coordinatorClients: make(map[string]*connectedClient),
 		discovery: discovery}
 }
 
 func (manager *discoveryConnectionManager) GetReadClient(
 	partitionId *pb.PartitionID) (pb.UltrabusNodeClient, error) {
 
+	manager.lock.Lock()
+	defer manager.lock.Unlock()
+
 	connClient, exists := manager.readClients[*partitionId]
 	if exists && connClient.conn.GetState() != grpc.Shutdown {
 		return connClient.client, nil
@@ -59,6 +69,9 @@ func (manager *discoveryConnectionManager) GetReadClient(
 func (manager *discoveryConnectionManager) GetWriteClient(
 	partitionId *pb.PartitionID) (pb.UltrabusNodeClient, error) {
 
+	manager.lock.Lock()
+	defer manager.lock.Unlock()
+
 	serverAddr, err := manager.discovery.GetLeaderAddr(partitionId)
 	if err != nil {
 		return nil, err
@@ -83,3 +96,69 @@ func (manager *discoveryConnectionManager) GetWriteClient(
 
 	return client, nil
 }
+
+// A client for any node, used for requests not tied to a partition.
+func (manager *discoveryConnectionManager) GetAdminClient() (
+	pb.UltrabusNodeClient, error) {
+
+	manager.lock.Lock()
+	defer manager.lock.Unlock()
+
+	connClient := manager.adminClient
+	if connClient != nil && connClient.conn.GetState() != grpc.Shutdown {
+		return connClient.client, nil
+	}
+
+	addrs, err := manager.discovery.GetAllNodeAddrs()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(addrs) == 0 {
+		return nil, &NoNodesError{}
+	}
+
+	serverAddr := addrs[rand.Intn(len(addrs))]
+	conn, err := grpc.Dial(serverAddr, grpc.WithInsecure())
+	if err != nil {
+		return nil, err
+	}
+
+	client := pb.NewUltrabusNodeClient(conn)
+	manager.adminClient = &connectedClient{serverAddr, conn, client}
+
+	return client, nil
+}
+
+// A client for the node coordinating transactionalID's transactions.
+func (manager *discoveryConnectionManager) GetCoordinatorClient(
+	transactionalID string) (pb.UltrabusNodeClient, error) {
+
+	manager.lock.Lock()
+	defer manager.lock.Unlock()
+
+	addrs, err := manager.discovery.GetAllNodeAddrs()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(addrs) == 0 {
+		return nil, &NoNodesError{}
+	}
+
+	serverAddr := transactionCoordinator(addrs, transactionalID)
+	connClient, exists := manager.coordinatorClients[serverAddr]
+	if exists && connClient.conn.GetState() != grpc.Shutdown {
+		return connClient.client, nil
+	}
+
+	conn, err := grpc.Dial(serverAddr, grpc.WithInsecure())
+	if err != nil {
+		return nil, err
+	}
+
+	client := pb.NewUltrabusNodeClient(conn)
+	manager.coordinatorClients[serverAddr] = &connectedClient{serverAddr, conn, client}
+
+	return client, nil
+}
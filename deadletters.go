@@ -0,0 +1,235 @@
+package ultrabus
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/emef/ultrabus/pb"
+	"google.golang.org/grpc/grpclog"
+)
+
+// Headers of messages on a dead-letter topic, saying where they were
+// consumed from and why they were given up on. Replaying them takes the
+// headers off again.
+const (
+	HeaderDeadLetterTopic      = deadLetterHeaderPrefix + "topic"
+	HeaderDeadLetterPartition  = deadLetterHeaderPrefix + "partition"
+	HeaderDeadLetterOffset     = deadLetterHeaderPrefix + "offset"
+	HeaderDeadLetterGroup      = deadLetterHeaderPrefix + "group"
+	HeaderDeadLetterDeliveries = deadLetterHeaderPrefix + "deliveries"
+	HeaderDeadLetterError      = deadLetterHeaderPrefix + "error"
+)
+
+const deadLetterHeaderPrefix = "ultrabus.dlq."
+
+// How often nodes forward the messages queues gave up on to their
+// dead-letter topics.
+const deadLetterInterval = time.Second
+
+// A message a queue gave up on after deliveries, the last of them
+// failing for reason.
+type deadLetter struct {
+	topic      string
+	message    *pb.MessageWithOffset
+	deliveries int32
+	reason     string
+}
+
+// The message as published to the dead-letter topic, consumed from
+// partitionID by group.
+func (letter *deadLetter) publishable(
+	partitionID *pb.PartitionID, group string) *pb.Message {
+
+	msg := letter.message.Message
+	headers := make(map[string][]byte, len(msg.Headers)+6)
+	for name, value := range msg.Headers {
+		headers[name] = value
+	}
+
+	headers[HeaderDeadLetterTopic] = []byte(partitionID.Topic)
+	headers[HeaderDeadLetterPartition] =
+		[]byte(strconv.Itoa(int(partitionID.Partition)))
+	headers[HeaderDeadLetterOffset] =
+		[]byte(strconv.FormatInt(letter.message.Offset, 10))
+	headers[HeaderDeadLetterGroup] = []byte(group)
+	headers[HeaderDeadLetterDeliveries] =
+		[]byte(strconv.Itoa(int(letter.deliveries)))
+	headers[HeaderDeadLetterError] = []byte(letter.reason)
+
+	return &pb.Message{Key: msg.Key, Value: msg.Value, Headers: headers}
+}
+
+// Publishes the queue's dead letters with publish, keeping those it
+// fails to publish for next time. The group's committed offset moves past
+// them once they're published.
+func (queue *consumerQueue) forwardDeadLetters(
+	partition *Partition,
+	partitionID *pb.PartitionID,
+	publish func(topic string, messages []*pb.Message) error) error {
+
+	queue.lock.Lock()
+	letters := append([]*deadLetter(nil), queue.deadLetters...)
+	queue.lock.Unlock()
+
+	// In order within each topic
+	var topics []string
+	messages := make(map[string][]*pb.Message)
+	for _, letter := range letters {
+		if _, ok := messages[letter.topic]; !ok {
+			topics = append(topics, letter.topic)
+		}
+
+		messages[letter.topic] = append(messages[letter.topic],
+			letter.publishable(partitionID, queue.group))
+	}
+
+	var firstErr error
+	published := make(map[string]bool)
+	for _, topic := range topics {
+		if err := publish(topic, messages[topic]); err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+
+			continue
+		}
+
+		published[topic] = true
+	}
+
+	queue.lock.Lock()
+	defer queue.lock.Unlock()
+	defer queue.commit(partition)
+
+	forwarded := make(map[*deadLetter]bool)
+	for _, letter := range letters {
+		forwarded[letter] = published[letter.topic]
+	}
+
+	kept := queue.deadLetters[:0]
+	for _, letter := range queue.deadLetters {
+		if !forwarded[letter] {
+			kept = append(kept, letter)
+		}
+	}
+	queue.deadLetters = kept
+
+	return firstErr
+}
+
+// Publishes the messages the partition's queues gave up on to their
+// dead-letter topics, see pb.QueueOptions.
+func (partition *Partition) ForwardDeadLetters(
+	partitionID *pb.PartitionID,
+	publish func(topic string, messages []*pb.Message) error) error {
+
+	var firstErr error
+	for _, queue := range partition.consumerQueues() {
+		err := queue.forwardDeadLetters(partition, partitionID, publish)
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	return firstErr
+}
+
+// Forwards what the queues of the node's partitions give up on.
+func (node *NodeService) forwardDeadLetters() {
+	for range time.Tick(deadLetterInterval) {
+		node.lock.RLock()
+		partitions := make(map[pb.PartitionID]*Partition, len(node.partitions))
+		for partitionID, partition := range node.partitions {
+			partitions[partitionID] = partition
+		}
+		node.lock.RUnlock()
+
+		for partitionID, partition := range partitions {
+			partitionID := partitionID
+			err := partition.ForwardDeadLetters(
+				&partitionID, node.deadLetters.Publish)
+			if err != nil {
+				grpclog.Printf("Error forwarding dead letters of %v: %v",
+					partitionID, err)
+			}
+		}
+	}
+}
+
+// Publishes the messages on deadLetterTopic back to the topics they were
+// given up on from, without the dead-letter headers, until none arrive
+// for idle. The client's consumer group picks up from the offsets it
+// committed, so each message is replayed once; a client made
+// WithEarliestOffsets starts a group new to the topic from its
+// beginning. Returns how many messages were replayed.
+func ReplayDeadLetters(
+	client UltrabusClient,
+	deadLetterTopic string,
+	idle time.Duration) (int, error) {
+
+	consumer, err := client.NewPullConsumer(deadLetterTopic)
+	if err != nil {
+		return 0, err
+	}
+
+	replayed := 0
+	for {
+		letters, err := consumer.Poll(0, 0, idle)
+		if err != nil {
+			return replayed, err
+		} else if len(letters) == 0 {
+			return replayed, nil
+		}
+
+		// In order within each topic
+		var topics []string
+		messages := make(map[string][]*pb.Message)
+		for _, letter := range letters {
+			topic, msg := replayable(letter.Message)
+			if topic == "" {
+				grpclog.Printf("Not replaying offset %v of partition %v: no %v",
+					letter.Offset, letter.Partition, HeaderDeadLetterTopic)
+				continue
+			}
+
+			if _, ok := messages[topic]; !ok {
+				topics = append(topics, topic)
+			}
+
+			messages[topic] = append(messages[topic], msg)
+		}
+
+		for _, topic := range topics {
+			if err := client.Publish(topic, messages[topic]); err != nil {
+				return replayed, err
+			}
+
+			replayed += len(messages[topic])
+		}
+
+		if err := consumer.Commit(); err != nil {
+			return replayed, err
+		}
+	}
+}
+
+// The topic a dead letter came from and the message as it was there, or
+// "" if it doesn't say.
+func replayable(letter *pb.Message) (string, *pb.Message) {
+	topic := string(letter.Headers[HeaderDeadLetterTopic])
+
+	var headers map[string][]byte
+	for name, value := range letter.Headers {
+		if strings.HasPrefix(name, deadLetterHeaderPrefix) {
+			continue
+		} else if headers == nil {
+			headers = make(map[string][]byte)
+		}
+
+		headers[name] = value
+	}
+
+	return topic, &pb.Message{
+		Key: letter.Key, Value: letter.Value, Headers: headers}
+}
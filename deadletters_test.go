@@ -0,0 +1,79 @@
+package ultrabus
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/emef/ultrabus/pb"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeadLetters(t *testing.T) {
+	assert := assert.New(t)
+
+	partition := NewInMemoryPartition()
+	defer partition.Stop()
+
+	clientID := &pb.ClientID{ConsumerGroup: "group", ConsumerID: "a"}
+	stream := newRecordingStream()
+	_, err := partition.RegisterConsumer(&pb.SubscribeRequest{
+		ClientID: clientID,
+		Queue: &pb.QueueOptions{
+			MaxDeliveries: 2, DeadLetterTopic: "orders.dlq"}}, stream)
+	assert.Nil(err)
+
+	original := &pb.Message{
+		Key:     []byte("key"),
+		Value:   []byte("value"),
+		Headers: map[string][]byte{"source": []byte("web")}}
+	_, err = partition.Append(original)
+	assert.Nil(err)
+
+	assert.Equal([]string{"value"}, stream.received())
+	assert.Nil(partition.Ack(clientID, nil, []int64{0}, nil))
+	assert.Equal([]string{"value"}, stream.received())
+	assert.Nil(partition.Ack(clientID, nil, []int64{0},
+		map[int64]string{0: "invalid order"}))
+	assert.Nil(stream.received())
+
+	// Held until published, and not committed past until then
+	partitionID := &pb.PartitionID{Topic: "orders", Partition: 3}
+	err = partition.ForwardDeadLetters(partitionID,
+		func(topic string, messages []*pb.Message) error {
+			return fmt.Errorf("unavailable")
+		})
+	assert.NotNil(err)
+
+	offset, _ := partition.CommittedOffset("group")
+	assert.Equal(int64(0), offset)
+
+	published := make(map[string][]*pb.Message)
+	publish := func(topic string, messages []*pb.Message) error {
+		published[topic] = append(published[topic], messages...)
+		return nil
+	}
+
+	assert.Nil(partition.ForwardDeadLetters(partitionID, publish))
+	assert.Nil(partition.ForwardDeadLetters(partitionID, publish))
+	if !assert.Len(published["orders.dlq"], 1) {
+		return
+	}
+
+	offset, _ = partition.CommittedOffset("group")
+	assert.Equal(int64(1), offset)
+
+	letter := published["orders.dlq"][0]
+	assert.Equal(map[string][]byte{
+		"source":                   []byte("web"),
+		HeaderDeadLetterTopic:      []byte("orders"),
+		HeaderDeadLetterPartition:  []byte("3"),
+		HeaderDeadLetterOffset:     []byte("0"),
+		HeaderDeadLetterGroup:      []byte("group"),
+		HeaderDeadLetterDeliveries: []byte("2"),
+		HeaderDeadLetterError:      []byte("invalid order")}, letter.Headers)
+
+	// Replaying restores the message as it was
+	topic, replayed := replayable(letter)
+	assert.Equal("orders", topic)
+	assert.Equal(original, replayed)
+}
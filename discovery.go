@@ -1,6 +1,10 @@
 package ultrabus
 
 import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/emef/ultrabus/pb"
@@ -23,17 +27,61 @@ type Discovery interface {
   // Topics
   CreateTopic(topicMeta *pb.TopicMeta) error
 	GetTopic(topic string) (*pb.TopicMeta, error)
+	AddPartitions(topic string, partitions int32) error
+	UpdateTopicConfig(topic string, set map[string]string, unset []string) error
+	DeleteTopic(topic string) error
+	ListTopics() ([]*pb.TopicMeta, error)
 }
 
+// Implemented by discovery backends that hold the cluster metadata
+// themselves, letting their node serve it to peers and clients.
+type MetadataServer interface {
+	// Applies an encoded metadata command
+	ApplyMetadata(command []byte) error
+
+	// Returns the encoded metadata
+	GetMetadata() ([]byte, error)
+}
+
+// Opens a Discovery backend described by uri:
+//
+//	file:///path/to/cluster.yaml   static cluster file, see NewFileDiscovery
+//	srv://_ultrabus._tcp.example   DNS SRV records, see NewDNSSRVDiscovery
+//	raft://host:port,host:port     raft members, see NewRaftClientDiscovery
+//	host:port                      a single node, see NewSingleAddrDiscovery
+func OpenDiscovery(uri string) (Discovery, error) {
+	switch {
+	case strings.HasPrefix(uri, "file://"):
+		return NewFileDiscovery(
+			strings.TrimPrefix(uri, "file://"), DefaultFileReloadInterval)
+
+	case strings.HasPrefix(uri, "srv://"):
+		return NewDNSSRVDiscovery(
+			strings.TrimPrefix(uri, "srv://"), DefaultDNSRefreshInterval)
+
+	case strings.HasPrefix(uri, "raft://"):
+		return NewRaftClientDiscovery(
+			strings.Split(strings.TrimPrefix(uri, "raft://"), ","))
+
+	case strings.Contains(uri, "://"):
+		return nil, fmt.Errorf("Unsupported discovery uri: %v", uri)
+
+	default:
+		return NewSingleAddrDiscovery(uri)
+	}
+}
+
+// Discovery through a single node, which every partition is read from
+// and written to. Topics and consumers are kept by the node itself.
 type singleAddrDiscovery struct {
+	*metadataDiscovery
 	serverAddr string
-	consumers map[string](map[string][]string)
 }
 
 func NewSingleAddrDiscovery(serverAddr string) (Discovery, error) {
 	return &singleAddrDiscovery{
-		serverAddr,
-		make(map[string](map[string][]string))}, nil
+		&metadataDiscovery{newRemoteMetadata([]string{serverAddr})},
+		serverAddr}, nil
 }
 
 func (discovery *singleAddrDiscovery) AdvertiseNodeAddr(
@@ -49,45 +97,123 @@ func (discovery *singleAddrDiscovery) GetAllNodeAddrs() ([]string, error) {
 func (discovery *singleAddrDiscovery) GetLeaderAddr(
 	partitionID *pb.PartitionID) (string, error) {
 
+	if _, err := discovery.metadataDiscovery.GetPartitionAddrs(
+		partitionID); err != nil {
+		return "", err
+	}
+
 	return discovery.serverAddr, nil
 }
 
 func (discovery *singleAddrDiscovery) GetPartitionAddrs(
 	partitionID *pb.PartitionID) ([]string, error) {
 
+	if _, err := discovery.metadataDiscovery.GetPartitionAddrs(
+		partitionID); err != nil {
+		return nil, err
+	}
+
 	return []string{discovery.serverAddr}, nil
 }
 
-func (discovery *singleAddrDiscovery) AdvertiseConsumer(
-	topic, consumerGroup, consumerID string, ttl time.Duration) error {
+// In-process record of advertised consumers, shared by the discovery
+// backends that have nowhere else to keep them.
+type consumerRegistry struct {
+	lock      sync.RWMutex
+	consumers map[string](map[string][]string)
+}
+
+func newConsumerRegistry() *consumerRegistry {
+	return &consumerRegistry{
+		consumers: make(map[string](map[string][]string))}
+}
 
-	_, ok := discovery.consumers[topic]
+func (registry *consumerRegistry) add(
+	topic, consumerGroup, consumerID string) {
+
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+
+	_, ok := registry.consumers[topic]
 	if !ok {
-		discovery.consumers[topic] = make(map[string][]string)
+		registry.consumers[topic] = make(map[string][]string)
 	}
 
-	discovery.consumers[topic][consumerGroup] = append(
-		discovery.consumers[topic][consumerGroup], consumerID)
+	for _, existing := range registry.consumers[topic][consumerGroup] {
+		if existing == consumerID {
+			return
+		}
+	}
 
-	return nil
+	registry.consumers[topic][consumerGroup] = append(
+		registry.consumers[topic][consumerGroup], consumerID)
 }
 
-func (discovery *singleAddrDiscovery) GetConsumers(
-	topic string, consumerGroup string) ([]string, error) {
+func (registry *consumerRegistry) get(
+	topic, consumerGroup string) []string {
 
-	topicGroups, ok := discovery.consumers[topic]
+	registry.lock.RLock()
+	defer registry.lock.RUnlock()
+
+	topicGroups, ok := registry.consumers[topic]
 	if !ok {
-		return nil, nil
+		return nil
 	}
 
 	consumers, _ := topicGroups[consumerGroup]
-	return consumers, nil
+	return append([]string(nil), consumers...)
 }
 
-func (discovery *singleAddrDiscovery) CreateTopic(topicMeta *pb.TopicMeta) error {
-	return nil
+func sortTopics(topics []*pb.TopicMeta) {
+	sort.Slice(topics, func(i, j int) bool {
+		return topics[i].Topic < topics[j].Topic
+	})
+}
+
+func copyTopicMeta(topicMeta *pb.TopicMeta) *pb.TopicMeta {
+	return &pb.TopicMeta{
+		Topic:      topicMeta.Topic,
+		Partitions: topicMeta.Partitions,
+		Replicas:   topicMeta.Replicas,
+		Config:     copyConfig(topicMeta.Config)}
+}
+
+func copyConfig(config map[string]string) map[string]string {
+	if config == nil {
+		return nil
+	}
+
+	copied := make(map[string]string, len(config))
+	for key, value := range config {
+		copied[key] = value
+	}
+
+	return copied
 }
 
-func (discovery *singleAddrDiscovery) GetTopic(topic string) (*pb.TopicMeta, error) {
-	return &pb.TopicMeta{Topic: topic, Partitions: 10}, nil
+// Deterministically places a partition's replicas on nodes: the nodes
+// are sorted and the replicas are taken in order starting from the
+// partition's index. The first address returned is the leader.
+func assignReplicas(
+	nodeAddrs []string, partition int32, replicas int32) []string {
+
+	if len(nodeAddrs) == 0 {
+		return nil
+	}
+
+	sorted := append([]string(nil), nodeAddrs...)
+	sort.Strings(sorted)
+
+	if replicas < 1 {
+		replicas = 1
+	} else if int(replicas) > len(sorted) {
+		replicas = int32(len(sorted))
+	}
+
+	addrs := make([]string, replicas)
+	for i := range addrs {
+		addrs[i] = sorted[(int(partition)+i)%len(sorted)]
+	}
+
+	return addrs
 }
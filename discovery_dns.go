@@ -0,0 +1,217 @@
+package ultrabus
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/emef/ultrabus/pb"
+	"google.golang.org/grpc/grpclog"
+)
+
+// Default period between DNS SRV lookups.
+const DefaultDNSRefreshInterval = 30 * time.Second
+
+type dnsSRVDiscovery struct {
+	*metadataDiscovery
+	srvName    string
+	lock       sync.RWMutex
+	nodeAddrs  []string
+	serverAddr string
+	done       chan interface{}
+
+	// The node holding the topic and consumer metadata, the one this
+	// process reaches it through, and its own copy should it be that node
+	holderAddr string
+	remote     *remoteMetadata
+	local      *localMetadata
+}
+
+// Resolves node addresses from the SRV records at srvName (for
+// example _ultrabus._tcp.example.com), refreshing them every
+// refreshInterval until closed. DNS only describes the nodes: partitions are placed
+// on them with assignReplicas, and topics and consumers are kept by the
+// node that sorts first, which every process reaches through its
+// metadata RPCs. They're lost when that node restarts or another sorts
+// before it.
+func NewDNSSRVDiscovery(
+	srvName string, refreshInterval time.Duration) (Discovery, error) {
+
+	discovery := newDNSSRVDiscovery(srvName)
+	if err := discovery.refresh(); err != nil {
+		return nil, err
+	}
+
+	go discovery.loop(refreshInterval)
+
+	return discovery, nil
+}
+
+func newDNSSRVDiscovery(srvName string) *dnsSRVDiscovery {
+	discovery := &dnsSRVDiscovery{
+		srvName: srvName,
+		done:    make(chan interface{}),
+		local:   &localMetadata{metadata: newClusterMetadata()}}
+	discovery.metadataDiscovery = &metadataDiscovery{discovery}
+
+	return discovery
+}
+
+// Stops resolving srvName.
+func (discovery *dnsSRVDiscovery) Close() error {
+	close(discovery.done)
+	return nil
+}
+
+func (discovery *dnsSRVDiscovery) loop(refreshInterval time.Duration) {
+	ticker := time.NewTicker(refreshInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := discovery.refresh(); err != nil {
+				grpclog.Printf(
+					"Error resolving %v: %v", discovery.srvName, err)
+			}
+
+		case <-discovery.done:
+			return
+		}
+	}
+}
+
+func (discovery *dnsSRVDiscovery) refresh() error {
+	_, records, err := net.LookupSRV("", "", discovery.srvName)
+	if err != nil {
+		return err
+	}
+
+	if len(records) == 0 {
+		return &NoNodesError{}
+	}
+
+	nodeAddrs := make([]string, len(records))
+	for i, record := range records {
+		nodeAddrs[i] = net.JoinHostPort(
+			strings.TrimSuffix(record.Target, "."),
+			fmt.Sprintf("%d", record.Port))
+	}
+
+	discovery.setNodeAddrs(nodeAddrs)
+	return nil
+}
+
+func (discovery *dnsSRVDiscovery) setNodeAddrs(nodeAddrs []string) {
+	sorted := append([]string(nil), nodeAddrs...)
+	sort.Strings(sorted)
+
+	discovery.lock.Lock()
+	defer discovery.lock.Unlock()
+
+	discovery.nodeAddrs = nodeAddrs
+	if sorted[0] != discovery.holderAddr {
+		discovery.holderAddr = sorted[0]
+		discovery.remote = newRemoteMetadata([]string{sorted[0]})
+	}
+}
+
+// The metadata of the holding node, and whether this process is it.
+func (discovery *dnsSRVDiscovery) store() (metadataStore, bool) {
+	discovery.lock.RLock()
+	defer discovery.lock.RUnlock()
+
+	if discovery.holderAddr == discovery.serverAddr {
+		return discovery.local, true
+	}
+
+	return discovery.remote, false
+}
+
+func (discovery *dnsSRVDiscovery) view(
+	fn func(metadata *clusterMetadata) error) error {
+
+	store, _ := discovery.store()
+	return store.view(fn)
+}
+
+func (discovery *dnsSRVDiscovery) propose(command *metadataCommand) error {
+	store, _ := discovery.store()
+	return store.propose(command)
+}
+
+// Only the holding node serves the metadata.
+func (discovery *dnsSRVDiscovery) ApplyMetadata(command []byte) error {
+	if _, holder := discovery.store(); !holder {
+		return &MetadataNotServedError{}
+	}
+
+	return discovery.local.ApplyMetadata(command)
+}
+
+func (discovery *dnsSRVDiscovery) GetMetadata() ([]byte, error) {
+	if _, holder := discovery.store(); !holder {
+		return nil, &MetadataNotServedError{}
+	}
+
+	return discovery.local.GetMetadata()
+}
+
+// Nodes come from DNS, but are also advertised to the holding node for
+// it to create topics on. A node's own address tells it whether it is
+// that node.
+func (discovery *dnsSRVDiscovery) AdvertiseNodeAddr(
+	serverAddr string, ttl time.Duration) error {
+
+	discovery.lock.Lock()
+	discovery.serverAddr = serverAddr
+	discovery.lock.Unlock()
+
+	return discovery.metadataDiscovery.AdvertiseNodeAddr(serverAddr, ttl)
+}
+
+func (discovery *dnsSRVDiscovery) GetAllNodeAddrs() ([]string, error) {
+	discovery.lock.RLock()
+	defer discovery.lock.RUnlock()
+
+	return append([]string(nil), discovery.nodeAddrs...), nil
+}
+
+func (discovery *dnsSRVDiscovery) GetLeaderAddr(
+	partitionID *pb.PartitionID) (string, error) {
+
+	addrs, err := discovery.GetPartitionAddrs(partitionID)
+	if err != nil {
+		return "", err
+	}
+
+	return addrs[0], nil
+}
+
+func (discovery *dnsSRVDiscovery) GetPartitionAddrs(
+	partitionID *pb.PartitionID) ([]string, error) {
+
+	topic, err := discovery.GetTopic(partitionID.Topic)
+	if err != nil {
+		return nil, err
+	}
+
+	if partitionID.Partition < 0 ||
+		partitionID.Partition >= topic.Partitions {
+		return nil, &PartitionNotFoundError{partitionID}
+	}
+
+	discovery.lock.RLock()
+	defer discovery.lock.RUnlock()
+
+	addrs := assignReplicas(
+		discovery.nodeAddrs, partitionID.Partition, topic.Replicas)
+	if len(addrs) == 0 {
+		return nil, &NoNodesError{}
+	}
+
+	return addrs, nil
+}
@@ -0,0 +1,44 @@
+package ultrabus
+
+import (
+	"testing"
+	"time"
+
+	"github.com/emef/ultrabus/pb"
+	"github.com/stretchr/testify/assert"
+)
+
+// Processes share the topics kept by the node that sorts first.
+func TestDNSSRVDiscoveryMetadata(t *testing.T) {
+	assert := assert.New(t)
+
+	node, _, stop := serveTestNode(t)
+	defer stop()
+
+	// The holder sorts before the unreachable node
+	nodeAddrs := []string{"zz.invalid:1", node.serverAddr}
+	discoveries := make([]*dnsSRVDiscovery, 2)
+	for i := range discoveries {
+		discoveries[i] = newDNSSRVDiscovery("_ultrabus._tcp.test")
+		discoveries[i].setNodeAddrs(nodeAddrs)
+		defer discoveries[i].Close()
+	}
+
+	meta := &pb.TopicMeta{Topic: "orders", Partitions: 2, Replicas: 2}
+	assert.Nil(discoveries[0].CreateTopic(meta))
+	assert.IsType(&TopicExistsError{}, discoveries[1].CreateTopic(meta))
+
+	addrs, err := discoveries[1].GetPartitionAddrs(
+		&pb.PartitionID{Topic: "orders", Partition: 1})
+	assert.Nil(err)
+	assert.Equal(2, len(addrs))
+
+	// Only the holder serves the metadata
+	_, err = discoveries[0].GetMetadata()
+	assert.IsType(&MetadataNotServedError{}, err)
+
+	assert.Nil(discoveries[1].AdvertiseNodeAddr(node.serverAddr, time.Minute))
+	assert.Nil(discoveries[1].CreateTopic(meta))
+	_, err = discoveries[1].GetMetadata()
+	assert.Nil(err)
+}
@@ -0,0 +1,357 @@
+package ultrabus
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/emef/ultrabus/pb"
+	"google.golang.org/grpc/grpclog"
+	"gopkg.in/yaml.v2"
+)
+
+// Default period between checks of a discovery file for changes.
+const DefaultFileReloadInterval = 5 * time.Second
+
+// Layout of a static cluster file. JSON files use the same keys:
+//
+//	nodes:
+//	  - 10.0.0.1:10000
+//	  - 10.0.0.2:10000
+//	topics:
+//	  - topic: orders
+//	    partitions: 8
+//	    replicas: 2
+//	    config:               # optional, see TopicMeta.config
+//	      retention.ms: "86400000"
+//	    assignments:          # optional, first address is the leader
+//	      0: [10.0.0.2:10000, 10.0.0.1:10000]
+type clusterFile struct {
+	Nodes  []string           `yaml:"nodes" json:"nodes"`
+	Topics []clusterFileTopic `yaml:"topics" json:"topics"`
+}
+
+type clusterFileTopic struct {
+	Topic       string             `yaml:"topic" json:"topic"`
+	Partitions  int32              `yaml:"partitions" json:"partitions"`
+	Replicas    int32              `yaml:"replicas" json:"replicas"`
+	Config      map[string]string  `yaml:"config" json:"config"`
+	Assignments map[int32][]string `yaml:"assignments" json:"assignments"`
+}
+
+type fileDiscovery struct {
+	path      string
+	lock      sync.RWMutex
+	cluster   *clusterFile
+	topics    map[string]*clusterFileTopic
+	modTime   time.Time
+	size      int64
+	consumers *consumerRegistry
+	done      chan interface{}
+}
+
+// Reads cluster topology and topic metadata from a YAML file (JSON if
+// the path ends in .json) and checks it for changes every
+// reloadInterval until closed. A file that fails to load after a change
+// is logged and the previous contents are kept.
+func NewFileDiscovery(
+	path string, reloadInterval time.Duration) (Discovery, error) {
+
+	discovery := &fileDiscovery{
+		path:      path,
+		topics:    make(map[string]*clusterFileTopic),
+		consumers: newConsumerRegistry(),
+		done:      make(chan interface{})}
+
+	if err := discovery.reload(); err != nil {
+		return nil, err
+	}
+
+	go discovery.loop(reloadInterval)
+
+	return discovery, nil
+}
+
+// Stops checking the file for changes.
+func (discovery *fileDiscovery) Close() error {
+	close(discovery.done)
+	return nil
+}
+
+func (discovery *fileDiscovery) loop(reloadInterval time.Duration) {
+	ticker := time.NewTicker(reloadInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			discovery.check()
+
+		case <-discovery.done:
+			return
+		}
+	}
+}
+
+// Reloads the file if it changed.
+func (discovery *fileDiscovery) check() {
+	info, err := os.Stat(discovery.path)
+	if err != nil {
+		grpclog.Printf("Error checking discovery file: %v", err)
+		return
+	}
+
+	discovery.lock.RLock()
+	changed := !info.ModTime().Equal(discovery.modTime) ||
+		info.Size() != discovery.size
+	discovery.lock.RUnlock()
+
+	if changed {
+		if err := discovery.reload(); err != nil {
+			grpclog.Printf("Error reloading discovery file: %v", err)
+		}
+	}
+}
+
+func (discovery *fileDiscovery) reload() error {
+	info, err := os.Stat(discovery.path)
+	if err != nil {
+		return err
+	}
+
+	contents, err := ioutil.ReadFile(discovery.path)
+	if err != nil {
+		return err
+	}
+
+	cluster := &clusterFile{}
+	if filepath.Ext(discovery.path) == ".json" {
+		err = json.Unmarshal(contents, cluster)
+	} else {
+		err = yaml.Unmarshal(contents, cluster)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	discovery.lock.Lock()
+	defer discovery.lock.Unlock()
+
+	discovery.cluster = cluster
+	discovery.modTime = info.ModTime()
+	discovery.size = info.Size()
+
+	return nil
+}
+
+// Must be called with the lock held.
+func (discovery *fileDiscovery) topic(topic string) *clusterFileTopic {
+	for i := range discovery.cluster.Topics {
+		if discovery.cluster.Topics[i].Topic == topic {
+			return &discovery.cluster.Topics[i]
+		}
+	}
+
+	return discovery.topics[topic]
+}
+
+func (discovery *fileDiscovery) AdvertiseNodeAddr(
+	serverAddr string, ttl time.Duration) error {
+
+	return nil
+}
+
+func (discovery *fileDiscovery) GetAllNodeAddrs() ([]string, error) {
+	discovery.lock.RLock()
+	defer discovery.lock.RUnlock()
+
+	return append([]string(nil), discovery.cluster.Nodes...), nil
+}
+
+func (discovery *fileDiscovery) GetLeaderAddr(
+	partitionID *pb.PartitionID) (string, error) {
+
+	addrs, err := discovery.GetPartitionAddrs(partitionID)
+	if err != nil {
+		return "", err
+	}
+
+	return addrs[0], nil
+}
+
+func (discovery *fileDiscovery) GetPartitionAddrs(
+	partitionID *pb.PartitionID) ([]string, error) {
+
+	discovery.lock.RLock()
+	defer discovery.lock.RUnlock()
+
+	topic := discovery.topic(partitionID.Topic)
+	if topic == nil {
+		return nil, &TopicNotFoundError{partitionID.Topic}
+	}
+
+	if partitionID.Partition < 0 ||
+		partitionID.Partition >= topic.Partitions {
+		return nil, &PartitionNotFoundError{partitionID}
+	}
+
+	if addrs, ok := topic.Assignments[partitionID.Partition]; ok &&
+		len(addrs) > 0 {
+		return append([]string(nil), addrs...), nil
+	}
+
+	addrs := assignReplicas(
+		discovery.cluster.Nodes, partitionID.Partition, topic.Replicas)
+	if len(addrs) == 0 {
+		return nil, &NoNodesError{}
+	}
+
+	return addrs, nil
+}
+
+func (discovery *fileDiscovery) AdvertiseConsumer(
+	topic, consumerGroup, consumerID string, ttl time.Duration) error {
+
+	discovery.consumers.add(topic, consumerGroup, consumerID)
+	return nil
+}
+
+func (discovery *fileDiscovery) GetConsumers(
+	topic string, consumerGroup string) ([]string, error) {
+
+	return discovery.consumers.get(topic, consumerGroup), nil
+}
+
+// Topics created at runtime are only known to this process; anything
+// that should survive a restart belongs in the file.
+func (discovery *fileDiscovery) CreateTopic(topicMeta *pb.TopicMeta) error {
+	if err := checkTopicMeta(topicMeta); err != nil {
+		return err
+	}
+
+	discovery.lock.Lock()
+	defer discovery.lock.Unlock()
+
+	if discovery.topic(topicMeta.Topic) != nil {
+		return &TopicExistsError{topicMeta.Topic}
+	}
+
+	discovery.topics[topicMeta.Topic] = &clusterFileTopic{
+		Topic:      topicMeta.Topic,
+		Partitions: topicMeta.Partitions,
+		Replicas:   topicMeta.Replicas,
+		Config:     copyConfig(topicMeta.Config)}
+
+	return nil
+}
+
+func (discovery *fileDiscovery) GetTopic(topic string) (*pb.TopicMeta, error) {
+	discovery.lock.RLock()
+	defer discovery.lock.RUnlock()
+
+	fileTopic := discovery.topic(topic)
+	if fileTopic == nil {
+		return nil, &TopicNotFoundError{topic}
+	}
+
+	return fileTopic.meta(), nil
+}
+
+// Only topics created at runtime can be altered.
+func (discovery *fileDiscovery) AddPartitions(
+	topic string, partitions int32) error {
+
+	discovery.lock.Lock()
+	defer discovery.lock.Unlock()
+
+	fileTopic := discovery.topic(topic)
+	if fileTopic == nil {
+		return &TopicNotFoundError{topic}
+	} else if fileTopic != discovery.topics[topic] {
+		return &StaticTopicError{topic}
+	}
+
+	if err := checkAddPartitions(fileTopic.meta(), partitions); err != nil {
+		return err
+	}
+
+	altered := *fileTopic
+	altered.Partitions = partitions
+	discovery.topics[topic] = &altered
+
+	return nil
+}
+
+// Only topics created at runtime can be reconfigured.
+func (discovery *fileDiscovery) UpdateTopicConfig(
+	topic string, set map[string]string, unset []string) error {
+
+	discovery.lock.Lock()
+	defer discovery.lock.Unlock()
+
+	fileTopic := discovery.topic(topic)
+	if fileTopic == nil {
+		return &TopicNotFoundError{topic}
+	} else if fileTopic != discovery.topics[topic] {
+		return &StaticTopicError{topic}
+	}
+
+	config := mergeConfig(fileTopic.Config, set, unset)
+	if _, err := ParseTopicConfig(config); err != nil {
+		return err
+	}
+
+	altered := *fileTopic
+	altered.Config = config
+	discovery.topics[topic] = &altered
+
+	return nil
+}
+
+// Only topics created at runtime can be deleted.
+func (discovery *fileDiscovery) DeleteTopic(topic string) error {
+	discovery.lock.Lock()
+	defer discovery.lock.Unlock()
+
+	if _, ok := discovery.topics[topic]; !ok {
+		if discovery.topic(topic) != nil {
+			return &StaticTopicError{topic}
+		}
+
+		return &TopicNotFoundError{topic}
+	}
+
+	delete(discovery.topics, topic)
+	return nil
+}
+
+func (discovery *fileDiscovery) ListTopics() ([]*pb.TopicMeta, error) {
+	discovery.lock.RLock()
+	defer discovery.lock.RUnlock()
+
+	var topics []*pb.TopicMeta
+	for _, fileTopic := range discovery.cluster.Topics {
+		topics = append(topics, fileTopic.meta())
+	}
+
+	for name, fileTopic := range discovery.topics {
+		if discovery.topic(name) == fileTopic {
+			topics = append(topics, fileTopic.meta())
+		}
+	}
+
+	sortTopics(topics)
+	return topics, nil
+}
+
+func (fileTopic *clusterFileTopic) meta() *pb.TopicMeta {
+	return &pb.TopicMeta{
+		Topic:      fileTopic.Topic,
+		Partitions: fileTopic.Partitions,
+		Replicas:   fileTopic.Replicas,
+		Config:     copyConfig(fileTopic.Config)}
+}
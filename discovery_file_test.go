@@ -0,0 +1,81 @@
+package ultrabus
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/emef/ultrabus/pb"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileDiscovery(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "ultrabus")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "cluster.yaml")
+	assert.Nil(ioutil.WriteFile(path, []byte(`
+nodes: [a:1, b:1, c:1]
+topics:
+  - topic: orders
+    partitions: 4
+    replicas: 2
+    assignments:
+      3: [c:1]
+`), 0644))
+
+	discovery, err := NewFileDiscovery(path, 10*time.Millisecond)
+	assert.Nil(err)
+	defer discovery.(io.Closer).Close()
+
+	nodes, err := discovery.GetAllNodeAddrs()
+	assert.Nil(err)
+	assert.Equal([]string{"a:1", "b:1", "c:1"}, nodes)
+
+	meta, err := discovery.GetTopic("orders")
+	assert.Nil(err)
+	assert.Equal(int32(4), meta.Partitions)
+
+	addrs, err := discovery.GetPartitionAddrs(
+		&pb.PartitionID{Topic: "orders", Partition: 1})
+	assert.Nil(err)
+	assert.Equal([]string{"b:1", "c:1"}, addrs)
+
+	leader, err := discovery.GetLeaderAddr(
+		&pb.PartitionID{Topic: "orders", Partition: 3})
+	assert.Nil(err)
+	assert.Equal("c:1", leader)
+
+	_, err = discovery.GetPartitionAddrs(
+		&pb.PartitionID{Topic: "orders", Partition: 4})
+	assert.IsType(&PartitionNotFoundError{}, err)
+
+	_, err = discovery.GetTopic("missing")
+	assert.IsType(&TopicNotFoundError{}, err)
+
+	// Rewrite the file as JSON-compatible YAML and wait for the reload
+	assert.Nil(ioutil.WriteFile(path, []byte(
+		`{"nodes": ["d:1"], "topics": [{"topic": "orders", "partitions": 8}]}`),
+		0644))
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if meta, _ = discovery.GetTopic("orders"); meta.Partitions == 8 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	assert.Equal(int32(8), meta.Partitions)
+
+	leader, err = discovery.GetLeaderAddr(
+		&pb.PartitionID{Topic: "orders", Partition: 3})
+	assert.Nil(err)
+	assert.Equal("d:1", leader)
+}
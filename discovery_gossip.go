@@ -0,0 +1,100 @@
+package ultrabus
+
+import (
+	"github.com/emef/ultrabus/pb"
+)
+
+type gossipDiscovery struct {
+	Discovery
+	membership *Membership
+}
+
+// Layers gossip failure detection over another Discovery. Node listings
+// come from the membership, dead replicas are dropped from partition
+// addresses and a partition whose leader is dead is led by its first
+// alive (or failing that, suspect) replica. Everything else, topics and
+// consumers included, is answered by the wrapped backend.
+func NewGossipDiscovery(
+	discovery Discovery, membership *Membership) (Discovery, error) {
+
+	return &gossipDiscovery{discovery, membership}, nil
+}
+
+func (discovery *gossipDiscovery) GetAllNodeAddrs() ([]string, error) {
+	var nodeAddrs []string
+	for _, member := range discovery.membership.Members() {
+		if member.State != MemberDead {
+			nodeAddrs = append(nodeAddrs, member.ServerAddr)
+		}
+	}
+
+	return nodeAddrs, nil
+}
+
+func (discovery *gossipDiscovery) GetLeaderAddr(
+	partitionID *pb.PartitionID) (string, error) {
+
+	leaderAddr, err := discovery.Discovery.GetLeaderAddr(partitionID)
+	if err != nil {
+		return "", err
+	}
+
+	if discovery.membership.State(leaderAddr) == MemberAlive {
+		return leaderAddr, nil
+	}
+
+	addrs, err := discovery.Discovery.GetPartitionAddrs(partitionID)
+	if err != nil {
+		return "", err
+	}
+
+	for _, state := range []MemberState{MemberAlive, MemberSuspect} {
+		for _, addr := range addrs {
+			if discovery.membership.State(addr) == state {
+				return addr, nil
+			}
+		}
+	}
+
+	return leaderAddr, nil
+}
+
+func (discovery *gossipDiscovery) GetPartitionAddrs(
+	partitionID *pb.PartitionID) ([]string, error) {
+
+	addrs, err := discovery.Discovery.GetPartitionAddrs(partitionID)
+	if err != nil {
+		return nil, err
+	}
+
+	var available []string
+	for _, addr := range addrs {
+		if discovery.membership.State(addr) != MemberDead {
+			available = append(available, addr)
+		}
+	}
+
+	if len(available) == 0 {
+		return addrs, nil
+	}
+
+	return available, nil
+}
+
+func (discovery *gossipDiscovery) ApplyMetadata(command []byte) error {
+	server, ok := discovery.Discovery.(MetadataServer)
+	if !ok {
+		return &MetadataNotServedError{}
+	}
+
+	return server.ApplyMetadata(command)
+}
+
+func (discovery *gossipDiscovery) GetMetadata() ([]byte, error) {
+	server, ok := discovery.Discovery.(MetadataServer)
+	if !ok {
+		return nil, &MetadataNotServedError{}
+	}
+
+	return server.GetMetadata()
+}
@@ -0,0 +1,306 @@
+package ultrabus
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/raft"
+	"github.com/hashicorp/raft-boltdb"
+)
+
+const (
+	raftApplyTimeout      = 5 * time.Second
+	raftLeaderCheckPeriod = time.Second
+)
+
+type RaftDiscoveryConfig struct {
+	// Address clients reach this node at, also its raft server ID
+	ServerAddr string
+
+	// Address the raft TCP transport binds to and advertises
+	RaftAddr string
+
+	// Where the raft log and snapshots are kept. When empty they only
+	// live in memory and a restarted member catches up from its peers.
+	DataDir string
+
+	// Voters a brand new cluster is bootstrapped with, keyed by server
+	// address with raft addresses as values. Ignored once state exists.
+	Peers map[string]string
+
+	// Replaces the TCP transport, e.g. with raft.NewInmemTransport
+	Transport raft.Transport
+
+	// Replaces raft.DefaultConfig; LocalID is always set to ServerAddr
+	Raft *raft.Config
+
+	// Hands a command to the raft leader when this member isn't it.
+	// Defaults to the leader's ApplyMetadata RPC.
+	Forward func(leaderAddr string, command []byte) error
+}
+
+// Discovery backed by a raft group run by (a subset of) the ultrabus
+// nodes themselves. Every member serves reads from its own replica of
+// the metadata; writes go through the raft leader.
+type RaftDiscovery struct {
+	*metadataDiscovery
+	serverAddr string
+	raft       *raft.Raft
+	fsm        *metadataFSM
+	forward    func(leaderAddr string, command []byte) error
+	done       chan interface{}
+}
+
+type metadataFSM struct {
+	lock     sync.RWMutex
+	metadata *clusterMetadata
+}
+
+type metadataSnapshot struct {
+	encoded []byte
+}
+
+func NewRaftDiscovery(config *RaftDiscoveryConfig) (*RaftDiscovery, error) {
+	raftConfig := raft.DefaultConfig()
+	if config.Raft != nil {
+		copied := *config.Raft
+		raftConfig = &copied
+	}
+
+	raftConfig.LocalID = raft.ServerID(config.ServerAddr)
+
+	var logStore raft.LogStore
+	var stableStore raft.StableStore
+	var snapshotStore raft.SnapshotStore
+
+	if config.DataDir == "" {
+		inmemStore := raft.NewInmemStore()
+		logStore, stableStore = inmemStore, inmemStore
+		snapshotStore = raft.NewInmemSnapshotStore()
+	} else {
+		if err := os.MkdirAll(config.DataDir, 0755); err != nil {
+			return nil, err
+		}
+
+		boltStore, err := raftboltdb.NewBoltStore(
+			filepath.Join(config.DataDir, "raft.db"))
+		if err != nil {
+			return nil, err
+		}
+
+		logStore, stableStore = boltStore, boltStore
+		snapshotStore, err = raft.NewFileSnapshotStore(
+			config.DataDir, 2, os.Stderr)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	transport := config.Transport
+	if transport == nil {
+		tcpTransport, err := raft.NewTCPTransport(
+			config.RaftAddr, nil, 3, 10*time.Second, os.Stderr)
+		if err != nil {
+			return nil, err
+		}
+
+		transport = tcpTransport
+	}
+
+	fsm := &metadataFSM{metadata: newClusterMetadata()}
+	r, err := raft.NewRaft(
+		raftConfig, fsm, logStore, stableStore, snapshotStore, transport)
+	if err != nil {
+		return nil, err
+	}
+
+	hasState, err := raft.HasExistingState(logStore, stableStore, snapshotStore)
+	if err != nil {
+		return nil, err
+	}
+
+	if !hasState && len(config.Peers) > 0 {
+		var servers []raft.Server
+		for serverAddr, raftAddr := range config.Peers {
+			servers = append(servers, raft.Server{
+				ID:      raft.ServerID(serverAddr),
+				Address: raft.ServerAddress(raftAddr)})
+		}
+
+		err := r.BootstrapCluster(raft.Configuration{Servers: servers}).Error()
+		if err != nil && err != raft.ErrCantBootstrap {
+			return nil, err
+		}
+	}
+
+	forward := config.Forward
+	if forward == nil {
+		forward = newNodePeers().applyMetadata
+	}
+
+	discovery := &RaftDiscovery{
+		serverAddr: config.ServerAddr,
+		raft:       r,
+		fsm:        fsm,
+		forward:    forward,
+		done:       make(chan interface{})}
+	discovery.metadataDiscovery = &metadataDiscovery{discovery}
+
+	go discovery.loop()
+
+	return discovery, nil
+}
+
+// Leaves the raft group running without this member.
+func (discovery *RaftDiscovery) Close() error {
+	close(discovery.done)
+	return discovery.raft.Shutdown().Error()
+}
+
+// True while this member is the raft leader.
+func (discovery *RaftDiscovery) IsLeader() bool {
+	return discovery.raft.State() == raft.Leader
+}
+
+// The leader moves partitions off expired nodes.
+func (discovery *RaftDiscovery) loop() {
+	ticker := time.NewTicker(raftLeaderCheckPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if !discovery.IsLeader() {
+				break
+			}
+
+			discovery.fsm.lock.RLock()
+			stale := discovery.fsm.metadata.staleLeaders(time.Now())
+			discovery.fsm.lock.RUnlock()
+
+			for partitionID, leaderAddr := range stale {
+				partitionID := partitionID
+				discovery.propose(&metadataCommand{
+					Op:          opSetLeader,
+					Time:        time.Now(),
+					PartitionID: &partitionID,
+					Addr:        leaderAddr})
+			}
+
+		case <-discovery.done:
+			return
+		}
+	}
+}
+
+func (discovery *RaftDiscovery) propose(command *metadataCommand) error {
+	encoded, err := json.Marshal(command)
+	if err != nil {
+		return err
+	}
+
+	return discovery.ApplyMetadata(encoded)
+}
+
+func (discovery *RaftDiscovery) ApplyMetadata(command []byte) error {
+	if !discovery.IsLeader() {
+		_, leaderID := discovery.raft.LeaderWithID()
+		leaderAddr := string(leaderID)
+		if leaderAddr == "" || leaderAddr == discovery.serverAddr {
+			return &NotLeaderError{leaderAddr}
+		}
+
+		return discovery.forward(leaderAddr, command)
+	}
+
+	future := discovery.raft.Apply(command, raftApplyTimeout)
+	if err := future.Error(); err != nil {
+		return err
+	}
+
+	if err, ok := future.Response().(error); ok {
+		return err
+	}
+
+	return nil
+}
+
+func (discovery *RaftDiscovery) GetMetadata() ([]byte, error) {
+	discovery.fsm.lock.RLock()
+	defer discovery.fsm.lock.RUnlock()
+
+	return json.Marshal(discovery.fsm.metadata)
+}
+
+func (discovery *RaftDiscovery) view(
+	fn func(metadata *clusterMetadata) error) error {
+
+	discovery.fsm.lock.RLock()
+	defer discovery.fsm.lock.RUnlock()
+
+	return fn(discovery.fsm.metadata)
+}
+
+func (fsm *metadataFSM) Apply(log *raft.Log) interface{} {
+	command := &metadataCommand{}
+	if err := json.Unmarshal(log.Data, command); err != nil {
+		return err
+	}
+
+	fsm.lock.Lock()
+	defer fsm.lock.Unlock()
+
+	return fsm.metadata.apply(command)
+}
+
+func (fsm *metadataFSM) Snapshot() (raft.FSMSnapshot, error) {
+	fsm.lock.RLock()
+	defer fsm.lock.RUnlock()
+
+	encoded, err := json.Marshal(fsm.metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	return &metadataSnapshot{encoded}, nil
+}
+
+func (fsm *metadataFSM) Restore(snapshot io.ReadCloser) error {
+	defer snapshot.Close()
+
+	metadata := newClusterMetadata()
+	if err := json.NewDecoder(snapshot).Decode(metadata); err != nil {
+		return err
+	}
+
+	fsm.lock.Lock()
+	fsm.metadata = metadata
+	fsm.lock.Unlock()
+
+	return nil
+}
+
+func (snapshot *metadataSnapshot) Persist(sink raft.SnapshotSink) error {
+	if _, err := sink.Write(snapshot.encoded); err != nil {
+		sink.Cancel()
+		return err
+	}
+
+	return sink.Close()
+}
+
+func (snapshot *metadataSnapshot) Release() {}
+
+// Discovery for nodes and clients outside the raft group, reading and
+// writing the metadata through any of its members.
+func NewRaftClientDiscovery(memberAddrs []string) (Discovery, error) {
+	if len(memberAddrs) == 0 {
+		return nil, &NoNodesError{}
+	}
+
+	return &metadataDiscovery{newRemoteMetadata(memberAddrs)}, nil
+}
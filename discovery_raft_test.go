@@ -0,0 +1,157 @@
+package ultrabus
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/emef/ultrabus/pb"
+	"github.com/hashicorp/raft"
+	"github.com/stretchr/testify/assert"
+)
+
+// Starts an in-process raft quorum whose members forward writes to each
+// other directly instead of over gRPC.
+func newTestRaftCluster(t *testing.T, size int) []*RaftDiscovery {
+	addrs := make([]string, size)
+	transports := make([]*raft.InmemTransport, size)
+	peers := make(map[string]string)
+	for i := range addrs {
+		addrs[i] = fmt.Sprintf("node-%d:10000", i)
+		_, transports[i] = raft.NewInmemTransport(
+			raft.ServerAddress(fmt.Sprintf("raft-%d", i)))
+		peers[addrs[i]] = string(transports[i].LocalAddr())
+	}
+
+	for i := range transports {
+		for j := range transports {
+			if i != j {
+				transports[i].Connect(transports[j].LocalAddr(), transports[j])
+			}
+		}
+	}
+
+	members := make(map[string]*RaftDiscovery)
+	forward := func(leaderAddr string, command []byte) error {
+		return members[leaderAddr].ApplyMetadata(command)
+	}
+
+	raftConfig := raft.DefaultConfig()
+	raftConfig.HeartbeatTimeout = 50 * time.Millisecond
+	raftConfig.ElectionTimeout = 50 * time.Millisecond
+	raftConfig.LeaderLeaseTimeout = 50 * time.Millisecond
+	raftConfig.CommitTimeout = 5 * time.Millisecond
+	raftConfig.LogLevel = "ERROR"
+
+	cluster := make([]*RaftDiscovery, size)
+	for i, addr := range addrs {
+		discovery, err := NewRaftDiscovery(&RaftDiscoveryConfig{
+			ServerAddr: addr,
+			Peers:      peers,
+			Transport:  transports[i],
+			Raft:       raftConfig,
+			Forward:    forward})
+		if err != nil {
+			t.Fatalf("Failed to start raft member: %v", err)
+		}
+
+		members[addr] = discovery
+		cluster[i] = discovery
+	}
+
+	return cluster
+}
+
+// Retries fn until it succeeds or a few seconds pass.
+func eventually(t *testing.T, fn func() error) {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		err := fn()
+		if err == nil {
+			return
+		} else if time.Now().After(deadline) {
+			t.Fatalf("Condition not met: %v", err)
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestRaftDiscovery(t *testing.T) {
+	assert := assert.New(t)
+
+	cluster := newTestRaftCluster(t, 3)
+	defer func() {
+		for _, member := range cluster {
+			member.Close()
+		}
+	}()
+
+	// Writes are accepted by any member, leader or not
+	for i, member := range cluster {
+		addr := fmt.Sprintf("node-%d:10000", i)
+		eventually(t, func() error {
+			return member.AdvertiseNodeAddr(addr, time.Minute)
+		})
+	}
+
+	follower := cluster[0]
+	for _, member := range cluster {
+		if !member.IsLeader() {
+			follower = member
+		}
+	}
+
+	eventually(t, func() error {
+		return follower.CreateTopic(
+			&pb.TopicMeta{Topic: "orders", Partitions: 3, Replicas: 2})
+	})
+	assert.Nil(follower.AdvertiseConsumer("orders", "grp", "c1", time.Minute))
+
+	// And every member converges on the same metadata
+	partitionID := &pb.PartitionID{Topic: "orders", Partition: 1}
+	for _, member := range cluster {
+		eventually(t, func() error {
+			_, err := member.GetTopic("orders")
+			return err
+		})
+
+		nodes, err := member.GetAllNodeAddrs()
+		assert.Nil(err)
+		assert.Len(nodes, 3)
+
+		addrs, err := member.GetPartitionAddrs(partitionID)
+		assert.Nil(err)
+		assert.Equal([]string{"node-1:10000", "node-2:10000"}, addrs)
+
+		leader, err := member.GetLeaderAddr(partitionID)
+		assert.Nil(err)
+		assert.Equal("node-1:10000", leader)
+
+		eventually(t, func() error {
+			consumers, _ := member.GetConsumers("orders", "grp")
+			if len(consumers) != 1 {
+				return fmt.Errorf("consumers: %v", consumers)
+			}
+			return nil
+		})
+	}
+
+	_, err := follower.GetTopic("missing")
+	assert.IsType(&TopicNotFoundError{}, err)
+
+	// Leadership of a partition moves off a node whose advertisement expires
+	eventually(t, func() error {
+		return follower.AdvertiseNodeAddr("node-1:10000", time.Millisecond)
+	})
+
+	for _, member := range cluster {
+		eventually(t, func() error {
+			leader, _ := member.GetLeaderAddr(partitionID)
+			if leader != "node-2:10000" {
+				return fmt.Errorf("leader is %v", leader)
+			}
+			return nil
+		})
+	}
+}
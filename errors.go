@@ -2,8 +2,13 @@ package ultrabus
 
 import (
 	"fmt"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/emef/ultrabus/pb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 )
 
 type OffsetOutOfBoundsError struct {
@@ -27,6 +32,14 @@ func (e *DuplicateClientIDError) Error() string {
 	return fmt.Sprintf("Duplicate ClientID %v", e.ClientID)
 }
 
+type SubscriptionNotFoundError struct {
+	ClientID *pb.ClientID
+}
+
+func (e *SubscriptionNotFoundError) Error() string {
+	return fmt.Sprintf("No subscription of %v", e.ClientID)
+}
+
 type PartitionStoppedError struct{}
 
 func (e *PartitionStoppedError) Error() string { return "Partition stopped" }
@@ -44,3 +57,252 @@ type ReceiptNotWrittenError struct{}
 func (e *ReceiptNotWrittenError) Error() string {
 	return "Receipt has not yet been written"
 }
+
+type TopicNotFoundError struct {
+	Topic string
+}
+
+func (e *TopicNotFoundError) Error() string {
+	return fmt.Sprintf("Topic not found: %v", e.Topic)
+}
+
+type NoNodesError struct{}
+
+func (e *NoNodesError) Error() string { return "No nodes available" }
+
+type MetadataNotServedError struct{}
+
+func (e *MetadataNotServedError) Error() string {
+	return "Node does not serve cluster metadata"
+}
+
+type NotLeaderError struct {
+	LeaderAddr string
+}
+
+func (e *NotLeaderError) Error() string {
+	return fmt.Sprintf("Not the leader (leader is %q)", e.LeaderAddr)
+}
+
+type TopicExistsError struct {
+	Topic string
+}
+
+func (e *TopicExistsError) Error() string {
+	return fmt.Sprintf("Topic already exists: %v", e.Topic)
+}
+
+type InvalidTopicError struct {
+	Topic  string
+	Reason string
+}
+
+func (e *InvalidTopicError) Error() string {
+	return fmt.Sprintf("Invalid topic %q: %v", e.Topic, e.Reason)
+}
+
+type StaticTopicError struct {
+	Topic string
+}
+
+func (e *StaticTopicError) Error() string {
+	return fmt.Sprintf("Topic is defined by static configuration: %v", e.Topic)
+}
+
+type InvalidConfigError struct {
+	Key, Value, Reason string
+}
+
+func (e *InvalidConfigError) Error() string {
+	return fmt.Sprintf("Invalid config %v=%q: %v", e.Key, e.Value, e.Reason)
+}
+
+type InvalidFilterError struct {
+	Reason string
+}
+
+func (e *InvalidFilterError) Error() string {
+	return fmt.Sprintf("Invalid subscription filter: %v", e.Reason)
+}
+
+// A subscription filter failed on the message at Offset, which was left
+// out.
+type FilterEvaluationError struct {
+	PartitionID *pb.PartitionID
+	Offset      int64
+	Reason      string
+}
+
+func (e *FilterEvaluationError) Error() string {
+	return fmt.Sprintf("Filter failed on offset %v of %v: %v",
+		e.Offset, e.PartitionID, e.Reason)
+}
+
+type MessageTooLargeError struct {
+	Size, MaxSize int64
+}
+
+func (e *MessageTooLargeError) Error() string {
+	return fmt.Sprintf("Message of %v bytes exceeds the limit of %v bytes",
+		e.Size, e.MaxSize)
+}
+
+type NotEnoughReplicasError struct {
+	PartitionID      *pb.PartitionID
+	Replicas, MinISR int32
+}
+
+func (e *NotEnoughReplicasError) Error() string {
+	return fmt.Sprintf("Partition %v has %v available replicas, needs %v",
+		e.PartitionID, e.Replicas, e.MinISR)
+}
+
+type ProducerClosedError struct{}
+
+func (e *ProducerClosedError) Error() string { return "Producer closed" }
+
+type ClientClosedError struct{}
+
+func (e *ClientClosedError) Error() string { return "Client closed" }
+
+type OutOfOrderSequenceError struct {
+	ProducerID         string
+	Expected, Sequence int64
+}
+
+func (e *OutOfOrderSequenceError) Error() string {
+	return fmt.Sprintf("Producer %v sent sequence %v, expected %v",
+		e.ProducerID, e.Sequence, e.Expected)
+}
+
+type DuplicateSequenceError struct {
+	ProducerID string
+	Sequence   int64
+}
+
+func (e *DuplicateSequenceError) Error() string {
+	return fmt.Sprintf(
+		"Producer %v resent sequence %v too long after it was appended",
+		e.ProducerID, e.Sequence)
+}
+
+type ProducerFencedError struct {
+	ProducerID          string
+	Epoch, CurrentEpoch int32
+}
+
+func (e *ProducerFencedError) Error() string {
+	return fmt.Sprintf("Producer %v epoch %v is fenced by epoch %v",
+		e.ProducerID, e.Epoch, e.CurrentEpoch)
+}
+
+type InvalidTransactionError struct {
+	TransactionalID string
+	Reason          string
+}
+
+func (e *InvalidTransactionError) Error() string {
+	return fmt.Sprintf("Invalid transaction %q: %v", e.TransactionalID, e.Reason)
+}
+
+type NotCoordinatorError struct {
+	TransactionalID string
+	Coordinator     string
+}
+
+func (e *NotCoordinatorError) Error() string {
+	return fmt.Sprintf("Transactions of %q are coordinated by %v",
+		e.TransactionalID, e.Coordinator)
+}
+
+type DeliveryTimeoutError struct {
+	PartitionID *pb.PartitionID
+	Timeout     time.Duration
+	Err         error
+}
+
+func (e *DeliveryTimeoutError) Error() string {
+	return fmt.Sprintf("Delivery to %v timed out after %v: %v",
+		e.PartitionID, e.Timeout, e.Err)
+}
+
+// The messages of a publish that failed, by the partition they were
+// headed for. Messages that failed before a partition was chosen for
+// them, such as oversized ones, are under partition -1.
+type PublishError struct {
+	Topic      string
+	Partitions map[int32]error
+}
+
+func (e *PublishError) Error() string {
+	partitions := make([]int, 0, len(e.Partitions))
+	for partition := range e.Partitions {
+		partitions = append(partitions, int(partition))
+	}
+	sort.Ints(partitions)
+
+	failures := make([]string, len(partitions))
+	for i, partition := range partitions {
+		failures[i] = fmt.Sprintf(
+			"partition %v: %v", partition, e.Partitions[int32(partition)])
+	}
+
+	return fmt.Sprintf("Publishing to %v failed: %v",
+		e.Topic, strings.Join(failures, "; "))
+}
+
+// Whether an operation that failed with err may succeed if retried.
+// Errors from nodes are told apart by their gRPC code, see errorCode.
+func IsRetriable(err error) bool {
+	switch err.(type) {
+	case *NotEnoughReplicasError, *NotLeaderError, *PartitionStoppedError,
+		*NoNodesError, *NotCoordinatorError:
+		return true
+	}
+
+	switch grpc.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded, codes.ResourceExhausted,
+		codes.Aborted:
+		return true
+	}
+
+	return false
+}
+
+// The gRPC code a node answers with err, Unknown for errors without one.
+func errorCode(err error) codes.Code {
+	switch err.(type) {
+	case *PartitionNotFoundError, *TopicNotFoundError,
+		*SubscriptionNotFoundError:
+		return codes.NotFound
+	case *OffsetOutOfBoundsError:
+		return codes.OutOfRange
+	case *MessageTooLargeError, *InvalidTopicError, *InvalidConfigError,
+		*InvalidFilterError:
+		return codes.InvalidArgument
+	case *TopicExistsError, *DuplicateSequenceError:
+		return codes.AlreadyExists
+	case *StaticTopicError, *MetadataNotServedError,
+		*OutOfOrderSequenceError, *ProducerFencedError,
+		*InvalidTransactionError:
+		return codes.FailedPrecondition
+	case *NotEnoughReplicasError, *NotLeaderError, *PartitionStoppedError,
+		*NoNodesError, *NotCoordinatorError:
+		return codes.Unavailable
+	}
+
+	return grpc.Code(err)
+}
+
+// The typed error about topic that a node answered with err's gRPC code,
+// reversing errorCode, or err itself when the code is another error's.
+func topicError(topic string, err error) error {
+	for _, typed := range []error{
+		&TopicExistsError{topic}, &TopicNotFoundError{topic}} {
+		if grpc.Code(err) == errorCode(typed) {
+			return typed
+		}
+	}
+
+	return err
+}
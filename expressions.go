@@ -0,0 +1,873 @@
+package ultrabus
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// A predicate in a small expression language modelled on CEL, compiled
+// once and evaluated against a set of variables. Expressions can't loop
+// or call out, so evaluation always ends in time linear in their size
+// and the values they look at.
+//
+// Literals are numbers, 'strings' or "strings" with backslash escapes,
+// true, false, null and lists [a, b]. Values are those literals, or maps
+// of strings to values. All numbers are float64.
+//
+// From loosest to tightest binding, the operators are
+//
+//	||
+//	&&
+//	== != < <= > >= in
+//	+ -
+//	* / %
+//	! - (unary)
+//	a.field a["field"] a[index] calls
+//
+// && and || short-circuit from left to right. == and != compare any two
+// values, different types being unequal; the orderings need two numbers
+// or two strings. + also joins strings and lists. x in list is whether
+// list holds x, key in map whether map has key.
+//
+// Functions are size(x) of a string, list or map, has(a.field), whether
+// the map a has the field, and the string methods s.startsWith(t),
+// s.endsWith(t), s.contains(t) and s.matches(regex), RE2 syntax.
+//
+// Looking up a missing field or index is an error, as is an expression
+// that doesn't evaluate to a bool.
+type Expression struct {
+	source string
+	root   expressionNode
+}
+
+// Nesting beyond this fails to compile, bounding the parser's stack.
+const maxExpressionDepth = 64
+
+type ExpressionError struct {
+	Source   string
+	Position int
+	Reason   string
+}
+
+func (e *ExpressionError) Error() string {
+	return fmt.Sprintf("%v at position %v of %q", e.Reason, e.Position, e.Source)
+}
+
+func CompileExpression(source string) (*Expression, error) {
+	tokens, err := lexExpression(source)
+	if err != nil {
+		return nil, err
+	}
+
+	parser := &expressionParser{source: source, tokens: tokens}
+	root, err := parser.parseOr(0)
+	if err != nil {
+		return nil, err
+	}
+
+	if next := parser.peek(); next.kind != tokenEnd {
+		return nil, parser.errorf(next, "unexpected %q", next.text)
+	}
+
+	return &Expression{source, root}, nil
+}
+
+func (expression *Expression) String() string {
+	return expression.source
+}
+
+// Evaluates the expression with vars, which must hold values as
+// described on Expression.
+func (expression *Expression) Eval(vars map[string]interface{}) (bool, error) {
+	result, err := expression.root.eval(vars)
+	if err != nil {
+		return false, err
+	}
+
+	matched, ok := result.(bool)
+	if !ok {
+		return false, fmt.Errorf(
+			"expression is a %v, not a bool", typeName(result))
+	}
+
+	return matched, nil
+}
+
+type tokenKind int
+
+const (
+	tokenEnd tokenKind = iota
+	tokenNumber
+	tokenString
+	tokenIdent
+	tokenOperator
+)
+
+type token struct {
+	kind     tokenKind
+	text     string
+	value    interface{}
+	position int
+}
+
+// Longest first, so that "<=" isn't read as "<" then "=".
+var expressionOperators = []string{
+	"&&", "||", "==", "!=", "<=", ">=",
+	"<", ">", "!", "+", "-", "*", "/", "%", ".", ",", "(", ")", "[", "]"}
+
+func lexExpression(source string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(source); {
+		c := source[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+
+		case c >= '0' && c <= '9':
+			start := i
+			for i < len(source) && (isDigit(source[i]) || source[i] == '.' ||
+				source[i] == 'e' || source[i] == 'E' ||
+				((source[i] == '+' || source[i] == '-') &&
+					(source[i-1] == 'e' || source[i-1] == 'E'))) {
+				i++
+			}
+
+			number, err := strconv.ParseFloat(source[start:i], 64)
+			if err != nil {
+				return nil, &ExpressionError{source, start, "malformed number"}
+			}
+
+			tokens = append(tokens,
+				token{tokenNumber, source[start:i], number, start})
+
+		case c == '"' || c == '\'':
+			start := i
+			var text strings.Builder
+			for i++; i < len(source) && source[i] != c; i++ {
+				if source[i] == '\\' && i+1 < len(source) {
+					i++
+					switch source[i] {
+					case 'n':
+						text.WriteByte('\n')
+					case 't':
+						text.WriteByte('\t')
+					case 'r':
+						text.WriteByte('\r')
+					default:
+						text.WriteByte(source[i])
+					}
+				} else {
+					text.WriteByte(source[i])
+				}
+			}
+
+			if i == len(source) {
+				return nil, &ExpressionError{source, start, "unterminated string"}
+			}
+
+			i++
+			tokens = append(tokens,
+				token{tokenString, source[start:i], text.String(), start})
+
+		case isIdentStart(c):
+			start := i
+			for i < len(source) &&
+				(isIdentStart(source[i]) || isDigit(source[i])) {
+				i++
+			}
+
+			tokens = append(tokens,
+				token{tokenIdent, source[start:i], nil, start})
+
+		default:
+			operator := ""
+			for _, candidate := range expressionOperators {
+				if strings.HasPrefix(source[i:], candidate) {
+					operator = candidate
+					break
+				}
+			}
+
+			if operator == "" {
+				return nil, &ExpressionError{
+					source, i, fmt.Sprintf("unexpected %q", c)}
+			}
+
+			tokens = append(tokens, token{tokenOperator, operator, nil, i})
+			i += len(operator)
+		}
+	}
+
+	return append(tokens, token{tokenEnd, "end", nil, len(source)}), nil
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+type expressionParser struct {
+	source string
+	tokens []token
+	next   int
+}
+
+func (parser *expressionParser) peek() token {
+	return parser.tokens[parser.next]
+}
+
+func (parser *expressionParser) take() token {
+	next := parser.tokens[parser.next]
+	if next.kind != tokenEnd {
+		parser.next++
+	}
+
+	return next
+}
+
+// Takes the next token if it's the operator or keyword text.
+func (parser *expressionParser) accept(text string) bool {
+	next := parser.peek()
+	if (next.kind == tokenOperator || next.kind == tokenIdent) &&
+		next.text == text {
+		parser.next++
+		return true
+	}
+
+	return false
+}
+
+func (parser *expressionParser) expect(text string) error {
+	if !parser.accept(text) {
+		next := parser.peek()
+		return parser.errorf(next, "expected %q, found %q", text, next.text)
+	}
+
+	return nil
+}
+
+func (parser *expressionParser) errorf(
+	at token, format string, args ...interface{}) error {
+
+	return &ExpressionError{
+		parser.source, at.position, fmt.Sprintf(format, args...)}
+}
+
+func (parser *expressionParser) parseOr(depth int) (expressionNode, error) {
+	return parser.parseBinary(depth, 0)
+}
+
+// Operators of each precedence level, loosest first.
+var binaryPrecedence = [][]string{
+	{"||"},
+	{"&&"},
+	{"==", "!=", "<", "<=", ">", ">=", "in"},
+	{"+", "-"},
+	{"*", "/", "%"},
+}
+
+func (parser *expressionParser) parseBinary(
+	depth, level int) (expressionNode, error) {
+
+	if level == len(binaryPrecedence) {
+		return parser.parseUnary(depth)
+	}
+
+	left, err := parser.parseBinary(depth, level+1)
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		operator := ""
+		for _, candidate := range binaryPrecedence[level] {
+			if parser.accept(candidate) {
+				operator = candidate
+				break
+			}
+		}
+
+		if operator == "" {
+			return left, nil
+		}
+
+		right, err := parser.parseBinary(depth, level+1)
+		if err != nil {
+			return nil, err
+		}
+
+		left = &binaryNode{operator, left, right}
+	}
+}
+
+func (parser *expressionParser) parseUnary(depth int) (expressionNode, error) {
+	if depth > maxExpressionDepth {
+		return nil, parser.errorf(parser.peek(), "expression nested too deeply")
+	}
+
+	for _, operator := range []string{"!", "-"} {
+		if parser.accept(operator) {
+			operand, err := parser.parseUnary(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+
+			return &unaryNode{operator, operand}, nil
+		}
+	}
+
+	return parser.parsePostfix(depth)
+}
+
+func (parser *expressionParser) parsePostfix(depth int) (expressionNode, error) {
+	node, err := parser.parsePrimary(depth)
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		switch {
+		case parser.accept("."):
+			name := parser.take()
+			if name.kind != tokenIdent {
+				return nil, parser.errorf(name, "expected a field name")
+			}
+
+			if parser.accept("(") {
+				args, err := parser.parseList(depth, ")")
+				if err != nil {
+					return nil, err
+				}
+
+				node, err = newCallNode(parser, name, node, args)
+				if err != nil {
+					return nil, err
+				}
+			} else {
+				node = &indexNode{node, &literalNode{name.text}}
+			}
+
+		case parser.accept("["):
+			index, err := parser.parseOr(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+
+			if err := parser.expect("]"); err != nil {
+				return nil, err
+			}
+
+			node = &indexNode{node, index}
+
+		default:
+			return node, nil
+		}
+	}
+}
+
+func (parser *expressionParser) parsePrimary(depth int) (expressionNode, error) {
+	next := parser.take()
+	switch next.kind {
+	case tokenNumber, tokenString:
+		return &literalNode{next.value}, nil
+
+	case tokenIdent:
+		switch next.text {
+		case "true":
+			return &literalNode{true}, nil
+		case "false":
+			return &literalNode{false}, nil
+		case "null":
+			return &literalNode{nil}, nil
+		}
+
+		if parser.accept("(") {
+			args, err := parser.parseList(depth, ")")
+			if err != nil {
+				return nil, err
+			}
+
+			return newCallNode(parser, next, nil, args)
+		}
+
+		return &variableNode{next.text}, nil
+
+	case tokenOperator:
+		switch next.text {
+		case "(":
+			node, err := parser.parseOr(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+
+			return node, parser.expect(")")
+
+		case "[":
+			items, err := parser.parseList(depth, "]")
+			if err != nil {
+				return nil, err
+			}
+
+			return &listNode{items}, nil
+		}
+	}
+
+	return nil, parser.errorf(next, "unexpected %q", next.text)
+}
+
+// Comma separated expressions up to the closing end.
+func (parser *expressionParser) parseList(
+	depth int, end string) ([]expressionNode, error) {
+
+	var items []expressionNode
+	if parser.accept(end) {
+		return items, nil
+	}
+
+	for {
+		item, err := parser.parseOr(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+
+		items = append(items, item)
+		if parser.accept(end) {
+			return items, nil
+		} else if err := parser.expect(","); err != nil {
+			return nil, err
+		}
+	}
+}
+
+type expressionNode interface {
+	eval(vars map[string]interface{}) (interface{}, error)
+}
+
+type literalNode struct {
+	value interface{}
+}
+
+func (node *literalNode) eval(_ map[string]interface{}) (interface{}, error) {
+	return node.value, nil
+}
+
+type variableNode struct {
+	name string
+}
+
+func (node *variableNode) eval(vars map[string]interface{}) (interface{}, error) {
+	value, ok := vars[node.name]
+	if !ok {
+		return nil, fmt.Errorf("undeclared variable %v", node.name)
+	}
+
+	return value, nil
+}
+
+type listNode struct {
+	items []expressionNode
+}
+
+func (node *listNode) eval(vars map[string]interface{}) (interface{}, error) {
+	list := make([]interface{}, len(node.items))
+	for i, item := range node.items {
+		value, err := item.eval(vars)
+		if err != nil {
+			return nil, err
+		}
+
+		list[i] = value
+	}
+
+	return list, nil
+}
+
+type indexNode struct {
+	operand, index expressionNode
+}
+
+func (node *indexNode) eval(vars map[string]interface{}) (interface{}, error) {
+	operand, err := node.operand.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+
+	index, err := node.index.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+
+	switch operand := operand.(type) {
+	case map[string]interface{}:
+		key, ok := index.(string)
+		if !ok {
+			return nil, fmt.Errorf(
+				"can't index a map with a %v", typeName(index))
+		}
+
+		value, ok := operand[key]
+		if !ok {
+			return nil, fmt.Errorf("no such field %q", key)
+		}
+
+		return value, nil
+
+	case []interface{}:
+		i, ok := index.(float64)
+		if !ok || i != math.Trunc(i) {
+			return nil, fmt.Errorf("can't index a list with %v", index)
+		} else if i < 0 || i >= float64(len(operand)) {
+			return nil, fmt.Errorf("index %v out of range", i)
+		}
+
+		return operand[int(i)], nil
+	}
+
+	return nil, fmt.Errorf("can't index a %v", typeName(operand))
+}
+
+type unaryNode struct {
+	operator string
+	operand  expressionNode
+}
+
+func (node *unaryNode) eval(vars map[string]interface{}) (interface{}, error) {
+	operand, err := node.operand.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+
+	switch operand := operand.(type) {
+	case bool:
+		if node.operator == "!" {
+			return !operand, nil
+		}
+	case float64:
+		if node.operator == "-" {
+			return -operand, nil
+		}
+	}
+
+	return nil, fmt.Errorf(
+		"can't apply %v to a %v", node.operator, typeName(operand))
+}
+
+type binaryNode struct {
+	operator    string
+	left, right expressionNode
+}
+
+func (node *binaryNode) eval(vars map[string]interface{}) (interface{}, error) {
+	left, err := node.left.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+
+	if node.operator == "&&" || node.operator == "||" {
+		value, ok := left.(bool)
+		if !ok {
+			return nil, fmt.Errorf(
+				"can't apply %v to a %v", node.operator, typeName(left))
+		} else if value == (node.operator == "||") {
+			return value, nil
+		}
+
+		right, err := node.right.eval(vars)
+		if err != nil {
+			return nil, err
+		} else if _, ok := right.(bool); !ok {
+			return nil, fmt.Errorf(
+				"can't apply %v to a %v", node.operator, typeName(right))
+		}
+
+		return right, nil
+	}
+
+	right, err := node.right.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+
+	switch node.operator {
+	case "==":
+		return reflect.DeepEqual(left, right), nil
+	case "!=":
+		return !reflect.DeepEqual(left, right), nil
+	case "in":
+		return evalIn(left, right)
+	case "<", "<=", ">", ">=":
+		return evalOrder(node.operator, left, right)
+	}
+
+	return evalArithmetic(node.operator, left, right)
+}
+
+func evalIn(item, container interface{}) (interface{}, error) {
+	switch container := container.(type) {
+	case []interface{}:
+		for _, element := range container {
+			if reflect.DeepEqual(item, element) {
+				return true, nil
+			}
+		}
+
+		return false, nil
+
+	case map[string]interface{}:
+		key, ok := item.(string)
+		if !ok {
+			return nil, fmt.Errorf("maps only have string keys, not %v",
+				typeName(item))
+		}
+
+		_, ok = container[key]
+		return ok, nil
+	}
+
+	return nil, fmt.Errorf("can't look in a %v", typeName(container))
+}
+
+func evalOrder(operator string, left, right interface{}) (interface{}, error) {
+	var compared int
+	switch left := left.(type) {
+	case float64:
+		right, ok := right.(float64)
+		if !ok {
+			break
+		}
+
+		switch {
+		case left < right:
+			compared = -1
+		case left > right:
+			compared = 1
+		}
+
+		return ordered(operator, compared), nil
+
+	case string:
+		right, ok := right.(string)
+		if !ok {
+			break
+		}
+
+		return ordered(operator, strings.Compare(left, right)), nil
+	}
+
+	return nil, fmt.Errorf("can't compare a %v with a %v",
+		typeName(left), typeName(right))
+}
+
+func ordered(operator string, compared int) bool {
+	switch operator {
+	case "<":
+		return compared < 0
+	case "<=":
+		return compared <= 0
+	case ">":
+		return compared > 0
+	default:
+		return compared >= 0
+	}
+}
+
+func evalArithmetic(
+	operator string, left, right interface{}) (interface{}, error) {
+
+	if operator == "+" {
+		switch left := left.(type) {
+		case string:
+			if right, ok := right.(string); ok {
+				return left + right, nil
+			}
+		case []interface{}:
+			if right, ok := right.([]interface{}); ok {
+				joined := append([]interface{}(nil), left...)
+				return append(joined, right...), nil
+			}
+		}
+	}
+
+	x, leftOk := left.(float64)
+	y, rightOk := right.(float64)
+	if !leftOk || !rightOk {
+		return nil, fmt.Errorf("can't apply %v to a %v and a %v",
+			operator, typeName(left), typeName(right))
+	}
+
+	switch operator {
+	case "+":
+		return x + y, nil
+	case "-":
+		return x - y, nil
+	case "*":
+		return x * y, nil
+	}
+
+	if y == 0 {
+		return nil, fmt.Errorf("division by zero")
+	} else if operator == "/" {
+		return x / y, nil
+	}
+
+	return math.Mod(x, y), nil
+}
+
+// A function, or a method of target when set.
+type callNode struct {
+	function string
+	target   expressionNode
+	args     []expressionNode
+
+	// The pattern of matches, when it's a literal
+	regex *regexp.Regexp
+}
+
+// Arguments each function and method takes.
+var expressionFunctions = map[string]int{
+	"size": 1, "has": 1}
+
+var expressionMethods = map[string]int{
+	"startsWith": 1, "endsWith": 1, "contains": 1, "matches": 1}
+
+func newCallNode(
+	parser *expressionParser,
+	name token,
+	target expressionNode,
+	args []expressionNode) (expressionNode, error) {
+
+	functions := expressionFunctions
+	if target != nil {
+		functions = expressionMethods
+	}
+
+	arity, ok := functions[name.text]
+	if !ok {
+		return nil, parser.errorf(name, "unknown function %v", name.text)
+	} else if len(args) != arity {
+		return nil, parser.errorf(name, "%v takes %v arguments, not %v",
+			name.text, arity, len(args))
+	}
+
+	node := &callNode{function: name.text, target: target, args: args}
+	if name.text == "has" {
+		if _, ok := args[0].(*indexNode); !ok {
+			return nil, parser.errorf(name, "has needs a field, as in has(a.b)")
+		}
+	}
+
+	if literal, ok := args[0].(*literalNode); ok && name.text == "matches" {
+		pattern, ok := literal.value.(string)
+		if !ok {
+			return nil, parser.errorf(name, "matches needs a string pattern")
+		}
+
+		regex, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, parser.errorf(name, "bad pattern: %v", err)
+		}
+
+		node.regex = regex
+	}
+
+	return node, nil
+}
+
+func (node *callNode) eval(vars map[string]interface{}) (interface{}, error) {
+	if node.function == "has" {
+		field := node.args[0].(*indexNode)
+		operand, err := field.operand.eval(vars)
+		if err != nil {
+			return nil, err
+		}
+
+		key, err := field.index.eval(vars)
+		if err != nil {
+			return nil, err
+		}
+
+		fields, ok := operand.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("a %v has no fields", typeName(operand))
+		}
+
+		_, ok = fields[fmt.Sprint(key)]
+		return ok, nil
+	}
+
+	arg, err := node.args[0].eval(vars)
+	if err != nil {
+		return nil, err
+	}
+
+	if node.function == "size" {
+		switch arg := arg.(type) {
+		case string:
+			return float64(utf8.RuneCountInString(arg)), nil
+		case []interface{}:
+			return float64(len(arg)), nil
+		case map[string]interface{}:
+			return float64(len(arg)), nil
+		}
+
+		return nil, fmt.Errorf("a %v has no size", typeName(arg))
+	}
+
+	target, err := node.target.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+
+	s, ok := target.(string)
+	argument, argOk := arg.(string)
+	if !ok || !argOk {
+		return nil, fmt.Errorf("%v needs strings, not a %v and a %v",
+			node.function, typeName(target), typeName(arg))
+	}
+
+	switch node.function {
+	case "startsWith":
+		return strings.HasPrefix(s, argument), nil
+	case "endsWith":
+		return strings.HasSuffix(s, argument), nil
+	case "contains":
+		return strings.Contains(s, argument), nil
+	}
+
+	regex := node.regex
+	if regex == nil {
+		regex, err = regexp.Compile(argument)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return regex.MatchString(s), nil
+}
+
+func typeName(value interface{}) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "bool"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "list"
+	case map[string]interface{}:
+		return "map"
+	}
+
+	return fmt.Sprintf("%T", value)
+}
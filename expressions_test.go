@@ -0,0 +1,92 @@
+package ultrabus
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpressions(t *testing.T) {
+	assert := assert.New(t)
+
+	vars := map[string]interface{}{
+		"key": "order-17",
+		"value": map[string]interface{}{
+			"status": "FAILED",
+			"amount": 250.0,
+			"tags":   []interface{}{"eu", "priority"},
+			"buyer":  map[string]interface{}{"name": "Ada"},
+			"note":   nil},
+		"headers": map[string]interface{}{"source": "web"},
+		"offset":  3.0}
+
+	for _, test := range []struct {
+		source   string
+		expected bool
+	}{
+		{`value.status == "FAILED" && value.amount > 100`, true},
+		{`value.status == 'FAILED' && value.amount > 1000`, false},
+		{`value.amount >= 250 && value.amount <= 250.0`, true},
+		{`value.amount * 2 - 100 == 400 && value.amount % 100 == 50`, true},
+		{`-value.amount < 0 && !(value.amount < 0)`, true},
+		{`value.status != "OK" || value.missing`, true},
+		{`"eu" in value.tags && !("us" in value.tags)`, true},
+		{`"buyer" in value && value["buyer"].name == "Ada"`, true},
+		{`value.tags[1] == "priority" && size(value.tags) == 2`, true},
+		{`value.tags + ["x"] == ["eu", "priority", "x"]`, true},
+		{`has(value.note) && value.note == null && !has(value.missing)`, true},
+		{`key.startsWith("order-") && key.endsWith("17")`, true},
+		{`key.contains("der") && key.matches("^order-[0-9]+$")`, true},
+		{`headers.source + "/" + key == "web/order-17"`, true},
+		{`value.amount == "250"`, false},
+		{`offset in [1, 2, 3]`, true},
+	} {
+		expression, err := CompileExpression(test.source)
+		if !assert.Nil(err, test.source) {
+			continue
+		}
+
+		matched, err := expression.Eval(vars)
+		assert.Nil(err, test.source)
+		assert.Equal(test.expected, matched, test.source)
+	}
+
+	// Failing at runtime
+	for _, source := range []string{
+		`value.missing == 1`,
+		`value.status > 1`,
+		`value.amount / 0 > 1`,
+		`value.tags[2] == "x"`,
+		`value.tags[1e300] == "x"`,
+		`value.tags[-1] == "x"`,
+		`[1][1e300] == 1`,
+		`value.amount`,
+		`value.status && true`,
+		`unknown == 1`,
+	} {
+		expression, err := CompileExpression(source)
+		if !assert.Nil(err, source) {
+			continue
+		}
+
+		_, err = expression.Eval(vars)
+		assert.NotNil(err, source)
+	}
+
+	// Failing to compile
+	for _, source := range []string{
+		``,
+		`value.status ==`,
+		`"unterminated`,
+		`value.status = "x"`,
+		`size(value, key)`,
+		`exec("rm")`,
+		`key.matches("(")`,
+		`has(value)`,
+		`(((((((((((((((((((((((((((((((((((((((((((((((((((((((((((((((((((1` +
+			`)))))))))))))))))))))))))))))))))))))))))))))))))))))))))))))))))))`,
+	} {
+		_, err := CompileExpression(source)
+		assert.IsType(&ExpressionError{}, err, source)
+	}
+}
@@ -1,13 +1,283 @@
 package ultrabus
 
 import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+
 	"github.com/emef/ultrabus/pb"
+	"github.com/golang/protobuf/proto"
 )
 
+// Whether a subscriber receives a message. Filters that fail on a
+// message, such as one whose value doesn't decode, leave it out and
+// report the error to the subscriber.
 type MessageFilter interface {
-	Applies(message *pb.MessageWithOffset) bool
+	Applies(message *pb.MessageWithOffset) (bool, error)
 }
 
 type YesFilter struct{}
 
-func (f *YesFilter) Applies(_ *pb.MessageWithOffset) bool { return true }
+func (f *YesFilter) Applies(_ *pb.MessageWithOffset) (bool, error) {
+	return true, nil
+}
+
+// The filter a subscriber asked for with spec, which may be nil.
+// Expressions see message values decoded by schema, the topic's
+// value.schema.
+func NewMessageFilter(
+	spec *pb.SubscriptionFilter, schema string) (MessageFilter, error) {
+
+	if spec == nil {
+		return &YesFilter{}, nil
+	}
+
+	var filters AllFilter
+	if len(spec.KeyPrefix) > 0 {
+		filters = append(filters, &KeyPrefixFilter{spec.KeyPrefix})
+	}
+
+	if spec.KeyRegex != "" {
+		regex, err := regexp.Compile(spec.KeyRegex)
+		if err != nil {
+			return nil, &InvalidFilterError{err.Error()}
+		}
+
+		filters = append(filters, &KeyRegexFilter{regex})
+	}
+
+	for name, value := range spec.Headers {
+		filters = append(filters, &HeaderFilter{name, value})
+	}
+
+	if spec.Offsets != nil {
+		if spec.Offsets.Start < 0 ||
+			(spec.Offsets.End != 0 && spec.Offsets.End <= spec.Offsets.Start) {
+			return nil, &InvalidFilterError{"offset range is negative or empty"}
+		}
+
+		filters = append(filters,
+			&OffsetRangeFilter{spec.Offsets.Start, spec.Offsets.End})
+	}
+
+	if spec.Expression != "" {
+		expression, err := CompileExpression(spec.Expression)
+		if err != nil {
+			return nil, &InvalidFilterError{err.Error()}
+		}
+
+		decode, err := valueDecoder(schema)
+		if err != nil {
+			return nil, &InvalidFilterError{err.Error()}
+		}
+
+		filters = append(filters, &ExpressionFilter{expression, decode})
+	}
+
+	if len(filters) == 0 {
+		return &YesFilter{}, nil
+	}
+
+	return filters, nil
+}
+
+// Applies when every one of its filters does.
+type AllFilter []MessageFilter
+
+func (f AllFilter) Applies(message *pb.MessageWithOffset) (bool, error) {
+	for _, filter := range f {
+		if applies, err := filter.Applies(message); !applies || err != nil {
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+type KeyPrefixFilter struct {
+	Prefix []byte
+}
+
+func (f *KeyPrefixFilter) Applies(message *pb.MessageWithOffset) (bool, error) {
+	return bytes.HasPrefix(message.Message.Key, f.Prefix), nil
+}
+
+type KeyRegexFilter struct {
+	Regex *regexp.Regexp
+}
+
+func (f *KeyRegexFilter) Applies(message *pb.MessageWithOffset) (bool, error) {
+	return f.Regex.Match(message.Message.Key), nil
+}
+
+// Applies to messages with the header Name set to Value.
+type HeaderFilter struct {
+	Name  string
+	Value []byte
+}
+
+func (f *HeaderFilter) Applies(message *pb.MessageWithOffset) (bool, error) {
+	value, ok := message.Message.Headers[f.Name]
+	return ok && bytes.Equal(value, f.Value), nil
+}
+
+// Applies to offsets from Start up to but not including End, or with no
+// end when End is 0.
+type OffsetRangeFilter struct {
+	Start, End int64
+}
+
+func (f *OffsetRangeFilter) Applies(
+	message *pb.MessageWithOffset) (bool, error) {
+
+	return message.Offset >= f.Start &&
+		(f.End == 0 || message.Offset < f.End), nil
+}
+
+// Applies when Expression holds of the message. Expressions see its key
+// and headers as strings, its offset and timestamp, and its value as
+// Decode makes of it.
+type ExpressionFilter struct {
+	Expression *Expression
+	Decode     func(value []byte) (interface{}, error)
+}
+
+func (f *ExpressionFilter) Applies(
+	message *pb.MessageWithOffset) (bool, error) {
+
+	value, err := f.Decode(message.Message.Value)
+	if err != nil {
+		return false, err
+	}
+
+	headers := make(map[string]interface{}, len(message.Message.Headers))
+	for name, value := range message.Message.Headers {
+		headers[name] = string(value)
+	}
+
+	return f.Expression.Eval(map[string]interface{}{
+		"key":       string(message.Message.Key),
+		"value":     value,
+		"headers":   headers,
+		"offset":    float64(message.Offset),
+		"timestamp": float64(message.Timestamp)})
+}
+
+// How values of the value.schema schema decode into expression values.
+func valueDecoder(schema string) (func([]byte) (interface{}, error), error) {
+	switch {
+	case schema == ValueSchemaNone:
+		return func(value []byte) (interface{}, error) {
+			return string(value), nil
+		}, nil
+
+	case schema == ValueSchemaJSON:
+		return func(value []byte) (interface{}, error) {
+			var decoded interface{}
+			if err := json.Unmarshal(value, &decoded); err != nil {
+				return nil, fmt.Errorf("value isn't JSON: %v", err)
+			}
+
+			return decoded, nil
+		}, nil
+
+	case strings.HasPrefix(schema, ValueSchemaProto):
+		name := strings.TrimPrefix(schema, ValueSchemaProto)
+		messageType := proto.MessageType(name)
+		if messageType == nil {
+			return nil, fmt.Errorf("unregistered protobuf type %v", name)
+		}
+
+		return func(value []byte) (interface{}, error) {
+			decoded := reflect.New(messageType.Elem()).Interface().(proto.Message)
+			if err := proto.Unmarshal(value, decoded); err != nil {
+				return nil, fmt.Errorf("value isn't a %v: %v", name, err)
+			}
+
+			return protoValue(reflect.ValueOf(decoded)), nil
+		}, nil
+	}
+
+	return nil, fmt.Errorf("unknown value schema %q", schema)
+}
+
+// A protobuf message's fields as an expression value, by their names in
+// the .proto file. Integers become numbers, enums their numbers and bytes
+// strings.
+func protoValue(value reflect.Value) interface{} {
+	switch value.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if value.IsNil() {
+			return nil
+		}
+
+		return protoValue(value.Elem())
+
+	case reflect.Struct:
+		fields := make(map[string]interface{})
+		for i := 0; i < value.NumField(); i++ {
+			field := value.Type().Field(i)
+			if field.Tag.Get("protobuf_oneof") != "" {
+				// The set field of a oneof, wrapped in a struct of its own
+				if set := value.Field(i); !set.IsNil() {
+					for name, field := range protoValue(set).(map[string]interface{}) {
+						fields[name] = field
+					}
+				}
+
+				continue
+			}
+
+			if name := protoFieldName(field.Tag.Get("protobuf")); name != "" {
+				fields[name] = protoValue(value.Field(i))
+			}
+		}
+
+		return fields
+
+	case reflect.Slice:
+		if value.Type().Elem().Kind() == reflect.Uint8 {
+			return string(value.Bytes())
+		}
+
+		list := make([]interface{}, value.Len())
+		for i := range list {
+			list[i] = protoValue(value.Index(i))
+		}
+
+		return list
+
+	case reflect.Map:
+		entries := make(map[string]interface{}, value.Len())
+		for _, key := range value.MapKeys() {
+			entries[fmt.Sprint(key.Interface())] = protoValue(value.MapIndex(key))
+		}
+
+		return entries
+
+	case reflect.Int32, reflect.Int64:
+		return float64(value.Int())
+
+	case reflect.Uint32, reflect.Uint64:
+		return float64(value.Uint())
+
+	case reflect.Float32, reflect.Float64:
+		return value.Float()
+	}
+
+	return value.Interface()
+}
+
+// The name= part of a protobuf struct tag.
+func protoFieldName(tag string) string {
+	for _, part := range strings.Split(tag, ",") {
+		if strings.HasPrefix(part, "name=") {
+			return strings.TrimPrefix(part, "name=")
+		}
+	}
+
+	return ""
+}
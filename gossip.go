@@ -0,0 +1,554 @@
+package ultrabus
+
+import (
+	"encoding/json"
+	"math"
+	"math/rand"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/grpclog"
+)
+
+type MemberState int
+
+const (
+	MemberAlive MemberState = iota
+	MemberSuspect
+	MemberDead
+)
+
+func (state MemberState) String() string {
+	switch state {
+	case MemberAlive:
+		return "alive"
+	case MemberSuspect:
+		return "suspect"
+	case MemberDead:
+		return "dead"
+	default:
+		return "unknown"
+	}
+}
+
+// A node as seen by the gossip layer. Members are identified by the
+// address their node serves clients on; the incarnation orders
+// conflicting reports about the same member.
+type Member struct {
+	ServerAddr  string      `json:"serverAddr"`
+	GossipAddr  string      `json:"gossipAddr"`
+	State       MemberState `json:"state"`
+	Incarnation uint64      `json:"incarnation"`
+}
+
+type GossipConfig struct {
+	// Address this member's node serves clients on
+	ServerAddr string
+
+	// UDP address to gossip on, which peers must be able to reach
+	BindAddr string
+
+	// Gossip addresses of existing members to join through
+	Seeds []string
+
+	// How often a random member is probed (default 1s)
+	ProbeInterval time.Duration
+
+	// How long a direct probe waits for an ack (default 300ms)
+	ProbeTimeout time.Duration
+
+	// Members asked to probe on our behalf after a failed probe
+	// (default 3)
+	IndirectProbes int
+
+	// How long a suspect has to refute before it is declared dead
+	// (default 5s)
+	SuspicionTimeout time.Duration
+}
+
+const (
+	gossipPing    = "ping"
+	gossipPingReq = "ping-req"
+	gossipAck     = "ack"
+	gossipJoin    = "join"
+
+	maxGossipPacket      = 65507
+	maxGossipPiggyback   = 16
+	gossipRetransmitMult = 4
+)
+
+type gossipMessage struct {
+	Type    string    `json:"type"`
+	SeqNo   uint64    `json:"seq"`
+	Target  string    `json:"target,omitempty"`
+	Updates []*Member `json:"updates,omitempty"`
+}
+
+type gossipBroadcast struct {
+	member    Member
+	transmits int
+}
+
+type pendingAck struct {
+	acked chan interface{}
+
+	// Set when probing for another member, whose ack is relayed back
+	relayTo    string
+	relaySeqNo uint64
+}
+
+// SWIM-style membership: every probe interval a member pings one peer,
+// asks a few others to ping it if that fails and then suspects it.
+// Suspects that don't refute within the suspicion timeout are declared
+// dead. Membership changes piggyback on the probe traffic.
+type Membership struct {
+	config     GossipConfig
+	conn       net.PacketConn
+	lock       sync.Mutex
+	members    map[string]*Member
+	suspicions map[string]*time.Timer
+	broadcasts []*gossipBroadcast
+	acks       map[uint64]*pendingAck
+	seqNo      uint64
+	probeOrder []string
+	closeOnce  sync.Once
+	done       chan interface{}
+}
+
+func NewMembership(config *GossipConfig) (*Membership, error) {
+	conn, err := net.ListenPacket("udp", config.BindAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	membership := &Membership{
+		config:     *config,
+		conn:       conn,
+		members:    make(map[string]*Member),
+		suspicions: make(map[string]*time.Timer),
+		acks:       make(map[uint64]*pendingAck),
+		done:       make(chan interface{})}
+
+	if membership.config.ProbeInterval == 0 {
+		membership.config.ProbeInterval = time.Second
+	}
+	if membership.config.ProbeTimeout == 0 {
+		membership.config.ProbeTimeout = 300 * time.Millisecond
+	}
+	if membership.config.IndirectProbes == 0 {
+		membership.config.IndirectProbes = 3
+	}
+	if membership.config.SuspicionTimeout == 0 {
+		membership.config.SuspicionTimeout = 5 * time.Second
+	}
+
+	membership.members[config.ServerAddr] = &Member{
+		ServerAddr: config.ServerAddr,
+		GossipAddr: conn.LocalAddr().String(),
+		State:      MemberAlive}
+
+	go membership.receiveLoop()
+	go membership.probeLoop()
+
+	for _, seed := range config.Seeds {
+		membership.send(seed, &gossipMessage{
+			Type: gossipJoin, Updates: []*Member{membership.Self()}})
+	}
+
+	return membership, nil
+}
+
+// Stops gossiping without telling anyone; peers will detect the failure.
+func (membership *Membership) Close() error {
+	var err error
+	membership.closeOnce.Do(func() {
+		close(membership.done)
+		err = membership.conn.Close()
+	})
+
+	return err
+}
+
+// Declares this member dead to a few peers before closing.
+func (membership *Membership) Leave() error {
+	membership.lock.Lock()
+	self := membership.members[membership.config.ServerAddr]
+	self.State = MemberDead
+	self.Incarnation++
+	left := *self
+	targets := membership.randomMembers(membership.config.IndirectProbes, "")
+	membership.lock.Unlock()
+
+	for _, target := range targets {
+		membership.send(target.GossipAddr, &gossipMessage{
+			Type: gossipPing, Updates: []*Member{&left}})
+	}
+
+	return membership.Close()
+}
+
+func (membership *Membership) GossipAddr() string {
+	return membership.conn.LocalAddr().String()
+}
+
+func (membership *Membership) Self() *Member {
+	membership.lock.Lock()
+	defer membership.lock.Unlock()
+
+	self := *membership.members[membership.config.ServerAddr]
+	return &self
+}
+
+// Every member ever heard of, including this one, by server address.
+func (membership *Membership) Members() []Member {
+	membership.lock.Lock()
+	defer membership.lock.Unlock()
+
+	members := make([]Member, 0, len(membership.members))
+	for _, member := range membership.members {
+		members = append(members, *member)
+	}
+
+	sort.Slice(members, func(i, j int) bool {
+		return members[i].ServerAddr < members[j].ServerAddr
+	})
+
+	return members
+}
+
+// State of the member with the given server address; unknown members
+// are reported dead.
+func (membership *Membership) State(serverAddr string) MemberState {
+	membership.lock.Lock()
+	defer membership.lock.Unlock()
+
+	member, ok := membership.members[serverAddr]
+	if !ok {
+		return MemberDead
+	}
+
+	return member.State
+}
+
+func (membership *Membership) receiveLoop() {
+	buf := make([]byte, maxGossipPacket)
+	for {
+		n, from, err := membership.conn.ReadFrom(buf)
+		if err != nil {
+			select {
+			case <-membership.done:
+				return
+			default:
+				grpclog.Printf("Error reading gossip: %v", err)
+				continue
+			}
+		}
+
+		msg := &gossipMessage{}
+		if err := json.Unmarshal(buf[:n], msg); err != nil {
+			grpclog.Printf("Bad gossip from %v: %v", from, err)
+			continue
+		}
+
+		membership.handle(msg, from.String())
+	}
+}
+
+func (membership *Membership) handle(msg *gossipMessage, from string) {
+	membership.lock.Lock()
+	for _, update := range msg.Updates {
+		membership.merge(update)
+	}
+	membership.lock.Unlock()
+
+	switch msg.Type {
+	case gossipPing:
+		membership.send(from, &gossipMessage{Type: gossipAck, SeqNo: msg.SeqNo})
+
+	case gossipJoin:
+		var updates []*Member
+		for _, member := range membership.Members() {
+			member := member
+			updates = append(updates, &member)
+		}
+
+		membership.send(from, &gossipMessage{
+			Type: gossipAck, SeqNo: msg.SeqNo, Updates: updates})
+
+	case gossipPingReq:
+		seqNo, _ := membership.expectAck(from, msg.SeqNo)
+		membership.send(msg.Target, &gossipMessage{
+			Type: gossipPing, SeqNo: seqNo})
+
+	case gossipAck:
+		membership.lock.Lock()
+		pending, ok := membership.acks[msg.SeqNo]
+		delete(membership.acks, msg.SeqNo)
+		membership.lock.Unlock()
+
+		if !ok {
+			break
+		} else if pending.relayTo != "" {
+			membership.send(pending.relayTo, &gossipMessage{
+				Type: gossipAck, SeqNo: pending.relaySeqNo})
+		} else {
+			close(pending.acked)
+		}
+	}
+}
+
+// Registers an ack we are waiting on, relaying it to relayTo if set.
+func (membership *Membership) expectAck(
+	relayTo string, relaySeqNo uint64) (uint64, chan interface{}) {
+
+	membership.lock.Lock()
+	defer membership.lock.Unlock()
+
+	membership.seqNo++
+	seqNo := membership.seqNo
+	acked := make(chan interface{})
+	membership.acks[seqNo] = &pendingAck{acked, relayTo, relaySeqNo}
+
+	time.AfterFunc(membership.config.ProbeInterval, func() {
+		membership.lock.Lock()
+		delete(membership.acks, seqNo)
+		membership.lock.Unlock()
+	})
+
+	return seqNo, acked
+}
+
+func (membership *Membership) probeLoop() {
+	ticker := time.NewTicker(membership.config.ProbeInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			membership.probe()
+
+		case <-membership.done:
+			return
+		}
+	}
+}
+
+func (membership *Membership) probe() {
+	target := membership.nextProbeTarget()
+	if target == nil {
+		return
+	}
+
+	seqNo, acked := membership.expectAck("", 0)
+	membership.send(target.GossipAddr, &gossipMessage{
+		Type: gossipPing, SeqNo: seqNo})
+
+	select {
+	case <-acked:
+		return
+	case <-membership.done:
+		return
+	case <-time.After(membership.config.ProbeTimeout):
+	}
+
+	membership.lock.Lock()
+	helpers := membership.randomMembers(
+		membership.config.IndirectProbes, target.ServerAddr)
+	membership.lock.Unlock()
+
+	for _, helper := range helpers {
+		membership.send(helper.GossipAddr, &gossipMessage{
+			Type: gossipPingReq, SeqNo: seqNo, Target: target.GossipAddr})
+	}
+
+	select {
+	case <-acked:
+		return
+	case <-membership.done:
+		return
+	case <-time.After(
+		membership.config.ProbeInterval - membership.config.ProbeTimeout):
+	}
+
+	membership.lock.Lock()
+	defer membership.lock.Unlock()
+
+	if current, ok := membership.members[target.ServerAddr]; ok &&
+		current.State == MemberAlive {
+		suspect := *current
+		suspect.State = MemberSuspect
+		membership.merge(&suspect)
+	}
+}
+
+// Members are probed in a random order, each once per round.
+func (membership *Membership) nextProbeTarget() *Member {
+	membership.lock.Lock()
+	defer membership.lock.Unlock()
+
+	for attempt := 0; attempt < 2; attempt++ {
+		for len(membership.probeOrder) > 0 {
+			serverAddr := membership.probeOrder[0]
+			membership.probeOrder = membership.probeOrder[1:]
+
+			member, ok := membership.members[serverAddr]
+			if ok && member.State != MemberDead {
+				target := *member
+				return &target
+			}
+		}
+
+		for serverAddr := range membership.members {
+			if serverAddr != membership.config.ServerAddr {
+				membership.probeOrder = append(membership.probeOrder, serverAddr)
+			}
+		}
+
+		rand.Shuffle(len(membership.probeOrder), func(i, j int) {
+			membership.probeOrder[i], membership.probeOrder[j] =
+				membership.probeOrder[j], membership.probeOrder[i]
+		})
+	}
+
+	return nil
+}
+
+// Must be called with the lock held.
+func (membership *Membership) randomMembers(n int, exclude string) []Member {
+	var candidates []Member
+	for serverAddr, member := range membership.members {
+		if serverAddr != membership.config.ServerAddr &&
+			serverAddr != exclude && member.State != MemberDead {
+			candidates = append(candidates, *member)
+		}
+	}
+
+	rand.Shuffle(len(candidates), func(i, j int) {
+		candidates[i], candidates[j] = candidates[j], candidates[i]
+	})
+
+	if len(candidates) > n {
+		candidates = candidates[:n]
+	}
+
+	return candidates
+}
+
+// Applies a report about a member if it is newer than what we know.
+// Must be called with the lock held.
+func (membership *Membership) merge(update *Member) {
+	self := membership.members[membership.config.ServerAddr]
+	if update.ServerAddr == self.ServerAddr {
+		// Refute rumors of our death with a newer incarnation
+		if update.State != MemberAlive &&
+			update.Incarnation >= self.Incarnation && self.State == MemberAlive {
+			self.Incarnation = update.Incarnation + 1
+			membership.queueBroadcast(self)
+		}
+		return
+	}
+
+	current, ok := membership.members[update.ServerAddr]
+	if ok && !supersedes(update, current) {
+		return
+	}
+
+	if !ok {
+		current = &Member{}
+		membership.members[update.ServerAddr] = current
+	}
+
+	*current = *update
+	membership.queueBroadcast(current)
+
+	if timer, ok := membership.suspicions[current.ServerAddr]; ok {
+		timer.Stop()
+		delete(membership.suspicions, current.ServerAddr)
+	}
+
+	if current.State == MemberSuspect {
+		suspect := *current
+		membership.suspicions[current.ServerAddr] = time.AfterFunc(
+			membership.config.SuspicionTimeout, func() {
+				membership.lock.Lock()
+				defer membership.lock.Unlock()
+
+				member := membership.members[suspect.ServerAddr]
+				if member.State == MemberSuspect &&
+					member.Incarnation == suspect.Incarnation {
+					dead := *member
+					dead.State = MemberDead
+					membership.merge(&dead)
+				}
+			})
+	}
+}
+
+// SWIM's precedence rules: a newer incarnation always wins, and for the
+// same incarnation suspect beats alive and dead beats both.
+func supersedes(update, current *Member) bool {
+	if update.Incarnation != current.Incarnation {
+		return update.Incarnation > current.Incarnation
+	}
+
+	return update.State > current.State
+}
+
+// Must be called with the lock held.
+func (membership *Membership) queueBroadcast(member *Member) {
+	for _, broadcast := range membership.broadcasts {
+		if broadcast.member.ServerAddr == member.ServerAddr {
+			broadcast.member = *member
+			broadcast.transmits = 0
+			return
+		}
+	}
+
+	membership.broadcasts = append(
+		membership.broadcasts, &gossipBroadcast{*member, 0})
+}
+
+// Must be called with the lock held.
+func (membership *Membership) piggyback() []*Member {
+	limit := gossipRetransmitMult *
+		int(math.Ceil(math.Log10(float64(len(membership.members)+1))))
+
+	var updates []*Member
+	remaining := membership.broadcasts[:0]
+	for _, broadcast := range membership.broadcasts {
+		if len(updates) < maxGossipPiggyback {
+			member := broadcast.member
+			updates = append(updates, &member)
+			broadcast.transmits++
+		}
+
+		if broadcast.transmits < limit {
+			remaining = append(remaining, broadcast)
+		}
+	}
+
+	membership.broadcasts = remaining
+	return updates
+}
+
+func (membership *Membership) send(addr string, msg *gossipMessage) {
+	membership.lock.Lock()
+	msg.Updates = append(msg.Updates, membership.piggyback()...)
+	membership.lock.Unlock()
+
+	encoded, err := json.Marshal(msg)
+	if err != nil {
+		grpclog.Printf("Error encoding gossip: %v", err)
+		return
+	}
+
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		grpclog.Printf("Bad gossip address %v: %v", addr, err)
+		return
+	}
+
+	membership.conn.WriteTo(encoded, udpAddr)
+}
@@ -0,0 +1,82 @@
+package ultrabus
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMembership(t *testing.T) {
+	assert := assert.New(t)
+
+	config := GossipConfig{
+		BindAddr:         "127.0.0.1:0",
+		ProbeInterval:    20 * time.Millisecond,
+		ProbeTimeout:     5 * time.Millisecond,
+		SuspicionTimeout: 100 * time.Millisecond}
+
+	var members []*Membership
+	for i := 0; i < 3; i++ {
+		memberConfig := config
+		memberConfig.ServerAddr = fmt.Sprintf("node-%d:10000", i)
+		if i > 0 {
+			memberConfig.Seeds = []string{members[0].GossipAddr()}
+		}
+
+		member, err := NewMembership(&memberConfig)
+		assert.Nil(err)
+		defer member.Close()
+
+		members = append(members, member)
+	}
+
+	states := func(member *Membership) string {
+		var states string
+		for _, m := range member.Members() {
+			states += fmt.Sprintf("%v=%v ", m.ServerAddr, m.State)
+		}
+		return states
+	}
+
+	// Everyone learns about everyone
+	for _, member := range members {
+		eventually(t, func() error {
+			if states := states(member); states !=
+				"node-0:10000=alive node-1:10000=alive node-2:10000=alive " {
+				return fmt.Errorf("states: %v", states)
+			}
+			return nil
+		})
+	}
+
+	discovery, err := NewSingleAddrDiscovery("node-0:10000")
+	assert.Nil(err)
+	gossip, err := NewGossipDiscovery(discovery, members[0])
+	assert.Nil(err)
+
+	nodes, err := gossip.GetAllNodeAddrs()
+	assert.Nil(err)
+	assert.Len(nodes, 3)
+
+	// A member that stops responding is suspected and then declared dead
+	members[2].Close()
+
+	for _, member := range members[:2] {
+		eventually(t, func() error {
+			if state := member.State("node-2:10000"); state != MemberDead {
+				return fmt.Errorf("state: %v", state)
+			}
+			return nil
+		})
+	}
+
+	nodes, err = gossip.GetAllNodeAddrs()
+	assert.Nil(err)
+	assert.Equal([]string{"node-0:10000", "node-1:10000"}, nodes)
+
+	// The survivors still consider each other alive
+	assert.Equal(MemberAlive, members[0].State("node-1:10000"))
+	assert.Equal(MemberAlive, members[1].State("node-0:10000"))
+}
@@ -4,17 +4,78 @@ import (
 	"crypto/md5"
 	"encoding/binary"
 	"errors"
+	"sort"
+	"strconv"
+	"sync"
 
 	"github.com/emef/ultrabus/pb"
 )
 
+// Schemes mapping keys to partitions, chosen per topic by key.hash.
+// Every scheme is specified in README.md so that producers in other
+// languages can place keys exactly as ultrabus does.
+const (
+	// KeyHash(key) modulo the partition count.
+	KeyHashMD5 = "md5"
+
+	// KeyHash(key) placed on a ring of ringPointsPerPartition points per
+	// partition. Growing a topic from n to m partitions only moves the
+	// keys the new partitions' points take over, about (m-n)/m of them.
+	KeyHashRing = "ring"
+
+	// The scheme before md5, kept for topics whose keys were placed by
+	// it and so the default of topics that don't set key.hash:
+	// binary.Varint over the raw md5 bytes, masked to 31 bits.
+	KeyHashLegacy = "legacy"
+)
+
+const ringPointsPerPartition = 64
+
+// The documented key hash: the first 8 bytes of the key's md5 read as a
+// big-endian unsigned integer.
+func KeyHash(key []byte) uint64 {
+	sum := md5.Sum(key)
+	return binary.BigEndian.Uint64(sum[:8])
+}
+
+// Maps a keyed message to one of partitions by the legacy scheme, as it
+// always has; new callers should use HashToPartitionScheme with the
+// topic's key.hash. Messages with equal keys land on the same partition,
+// and so stay ordered, only while the count stays the same. Growing a
+// topic with AlterTopic moves every key whose hash modulo the new count
+// differs from its hash modulo the old one, which is most keys, so
+// per-key ordering (and any state consumers keep per partition) only
+// holds among messages published on the same side of the change.
+// Existing messages are not moved. Topics set to the ring scheme move
+// far fewer keys.
 func HashToPartition(message *pb.Message, partitions int32) (int32, error) {
+	return HashToPartitionScheme(message, partitions, KeyHashLegacy)
+}
+
+// Maps a keyed message to one of partitions by scheme, one of the
+// KeyHash constants.
+func HashToPartitionScheme(
+	message *pb.Message, partitions int32, scheme string) (int32, error) {
+
 	if len(message.Key) == 0 {
 		return -1, errors.New("Cannot hash message with no key")
+	} else if partitions < 1 {
+		return -1, errors.New("Cannot hash message to no partitions")
 	}
 
-	partition := int32(hashToRange(message.Key, 0, int64(partitions)-1))
-	return partition, nil
+	switch scheme {
+	case KeyHashMD5:
+		return int32(KeyHash(message.Key) % uint64(partitions)), nil
+
+	case KeyHashRing:
+		return ringFor(partitions).partition(KeyHash(message.Key)), nil
+
+	case KeyHashLegacy:
+		return int32(hashToRange(message.Key, 0, int64(partitions)-1)), nil
+
+	default:
+		return -1, errors.New("Unknown key hash scheme: " + scheme)
+	}
 }
 
 func hashCode(source []byte) int64 {
@@ -28,3 +89,78 @@ func hashToRange(source []byte, minValue int64, maxValue int64) int64 {
 	delta := hash % (1 + maxValue - minValue)
 	return minValue + delta
 }
+
+// Partition p owns the points KeyHash("<p>-<i>") for i in
+// [0, ringPointsPerPartition), in ascending order of point then
+// partition. A key belongs to the owner of the first point at or after
+// its hash, wrapping around to the first point.
+type hashRing struct {
+	points     []uint64
+	partitions []int32
+}
+
+var (
+	ringsLock sync.Mutex
+	rings     = make(map[int32]*hashRing)
+)
+
+func ringFor(partitions int32) *hashRing {
+	ringsLock.Lock()
+	defer ringsLock.Unlock()
+
+	if ring, ok := rings[partitions]; ok {
+		return ring
+	}
+
+	ring := newHashRing(partitions)
+	rings[partitions] = ring
+	return ring
+}
+
+func newHashRing(partitions int32) *hashRing {
+	size := int(partitions) * ringPointsPerPartition
+	ring := &hashRing{
+		points:     make([]uint64, 0, size),
+		partitions: make([]int32, 0, size)}
+
+	for partition := int32(0); partition < partitions; partition++ {
+		for i := 0; i < ringPointsPerPartition; i++ {
+			name := strconv.Itoa(int(partition)) + "-" + strconv.Itoa(i)
+			ring.points = append(ring.points, KeyHash([]byte(name)))
+			ring.partitions = append(ring.partitions, partition)
+		}
+	}
+
+	sort.Sort(ring)
+	return ring
+}
+
+func (ring *hashRing) partition(hash uint64) int32 {
+	i := sort.Search(len(ring.points), func(i int) bool {
+		return ring.points[i] >= hash
+	})
+
+	if i == len(ring.points) {
+		i = 0
+	}
+
+	return ring.partitions[i]
+}
+
+func (ring *hashRing) Len() int {
+	return len(ring.points)
+}
+
+func (ring *hashRing) Less(i, j int) bool {
+	if ring.points[i] != ring.points[j] {
+		return ring.points[i] < ring.points[j]
+	}
+
+	return ring.partitions[i] < ring.partitions[j]
+}
+
+func (ring *hashRing) Swap(i, j int) {
+	ring.points[i], ring.points[j] = ring.points[j], ring.points[i]
+	ring.partitions[i], ring.partitions[j] =
+		ring.partitions[j], ring.partitions[i]
+}
@@ -0,0 +1,71 @@
+package ultrabus
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/emef/ultrabus/pb"
+	"github.com/stretchr/testify/assert"
+)
+
+// The test vectors published in README.md, for 10 partitions.
+func TestKeyHashVectors(t *testing.T) {
+	assert := assert.New(t)
+
+	vectors := []struct {
+		key               string
+		hash              uint64
+		md5, ring, legacy int32
+	}{
+		{"a", 919145239626757800, 0, 3, 6},
+		{"abc", 10376663631224000432, 2, 3, 2},
+		{"foobar", 4060265690780417169, 9, 1, 8},
+		{"user-42", 8516795111299649485, 5, 5, 9},
+		{"ultrabus", 10834507882084141750, 0, 3, 5}}
+
+	for _, vector := range vectors {
+		message := &pb.Message{Key: []byte(vector.key)}
+		assert.Equal(vector.hash, KeyHash(message.Key), vector.key)
+
+		for scheme, expected := range map[string]int32{
+			KeyHashMD5:    vector.md5,
+			KeyHashRing:   vector.ring,
+			KeyHashLegacy: vector.legacy} {
+
+			partition, err := HashToPartitionScheme(message, 10, scheme)
+			assert.Nil(err)
+			assert.Equal(expected, partition, vector.key+" "+scheme)
+		}
+	}
+
+	_, err := HashToPartition(&pb.Message{}, 10)
+	assert.NotNil(err)
+
+	// Topics that don't choose keep the scheme from before key.hash
+	config, err := ParseTopicConfig(nil)
+	assert.Nil(err)
+	assert.Equal(KeyHashLegacy, config.KeyHash)
+
+	partition, err := HashToPartition(&pb.Message{Key: []byte("a")}, 10)
+	assert.Nil(err)
+	assert.Equal(int32(6), partition)
+
+	_, err = HashToPartitionScheme(&pb.Message{Key: []byte("a")}, 10, "sha1")
+	assert.NotNil(err)
+}
+
+// Growing a ring topic only moves the keys the new partition takes.
+func TestHashRingRemapping(t *testing.T) {
+	moved := 0
+	for i := 0; i < 10000; i++ {
+		message := &pb.Message{Key: []byte(fmt.Sprint(i))}
+		before, _ := HashToPartitionScheme(message, 10, KeyHashRing)
+		after, _ := HashToPartitionScheme(message, 11, KeyHashRing)
+		if before != after {
+			assert.Equal(t, int32(10), after)
+			moved++
+		}
+	}
+
+	assert.InDelta(t, 10000/11, moved, 300)
+}
@@ -0,0 +1,661 @@
+package ultrabus
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/emef/ultrabus/pb"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+// How long a remote copy of the metadata is read from before refetching.
+const remoteMetadataCacheTimeout = time.Second
+
+const (
+	opAdvertiseNode     = "advertise_node"
+	opAdvertiseConsumer = "advertise_consumer"
+	opCreateTopic       = "create_topic"
+	opDeleteTopic       = "delete_topic"
+	opAddPartitions     = "add_partitions"
+	opUpdateConfig      = "update_config"
+	opSetLeader         = "set_leader"
+)
+
+// A change to the cluster metadata. Commands carry the proposer's clock
+// so that every replica applying them reaches the same state.
+type metadataCommand struct {
+	Op          string          `json:"op"`
+	Time        time.Time       `json:"time"`
+	Addr        string          `json:"addr,omitempty"`
+	TTL         time.Duration   `json:"ttl,omitempty"`
+	Topic       *pb.TopicMeta   `json:"topic,omitempty"`
+	PartitionID *pb.PartitionID `json:"partitionID,omitempty"`
+	Group       string          `json:"group,omitempty"`
+	ConsumerID  string          `json:"consumerID,omitempty"`
+	Unset       []string        `json:"unset,omitempty"`
+}
+
+type metadataTopic struct {
+	Meta *pb.TopicMeta `json:"meta"`
+
+	// Replica addresses of each partition, preferred leader first
+	Replicas [][]string `json:"replicas"`
+
+	// Current leader of each partition
+	Leaders []string `json:"leaders"`
+}
+
+// Cluster metadata replicated by the raft quorum: which nodes are alive,
+// where each topic's partitions live and who is consuming them. Node and
+// consumer entries hold the time they expire at.
+type clusterMetadata struct {
+	Nodes     map[string]time.Time                           `json:"nodes"`
+	Topics    map[string]*metadataTopic                      `json:"topics"`
+	Consumers map[string](map[string](map[string]time.Time)) `json:"consumers"`
+}
+
+func newClusterMetadata() *clusterMetadata {
+	return &clusterMetadata{
+		Nodes:     make(map[string]time.Time),
+		Topics:    make(map[string]*metadataTopic),
+		Consumers: make(map[string](map[string](map[string]time.Time)))}
+}
+
+func (metadata *clusterMetadata) apply(command *metadataCommand) error {
+	switch command.Op {
+	case opAdvertiseNode:
+		metadata.Nodes[command.Addr] = command.Time.Add(command.TTL)
+
+	case opAdvertiseConsumer:
+		groups, ok := metadata.Consumers[command.Topic.Topic]
+		if !ok {
+			groups = make(map[string](map[string]time.Time))
+			metadata.Consumers[command.Topic.Topic] = groups
+		}
+
+		consumers, ok := groups[command.Group]
+		if !ok {
+			consumers = make(map[string]time.Time)
+			groups[command.Group] = consumers
+		}
+
+		consumers[command.ConsumerID] = command.Time.Add(command.TTL)
+
+	case opCreateTopic:
+		if err := checkTopicMeta(command.Topic); err != nil {
+			return err
+		}
+
+		if _, exists := metadata.Topics[command.Topic.Topic]; exists {
+			return &TopicExistsError{command.Topic.Topic}
+		}
+
+		nodeAddrs := metadata.liveNodes(command.Time)
+		if len(nodeAddrs) == 0 {
+			return &NoNodesError{}
+		}
+
+		topic := &metadataTopic{
+			Meta:     command.Topic,
+			Replicas: make([][]string, command.Topic.Partitions),
+			Leaders:  make([]string, command.Topic.Partitions)}
+
+		for i := range topic.Replicas {
+			topic.Replicas[i] = assignReplicas(
+				nodeAddrs, int32(i), command.Topic.Replicas)
+			topic.Leaders[i] = topic.Replicas[i][0]
+		}
+
+		metadata.Topics[command.Topic.Topic] = topic
+
+	case opAddPartitions:
+		topic, ok := metadata.Topics[command.Topic.Topic]
+		if !ok {
+			return &TopicNotFoundError{command.Topic.Topic}
+		}
+
+		if err := checkAddPartitions(
+			topic.Meta, command.Topic.Partitions); err != nil {
+			return err
+		}
+
+		nodeAddrs := metadata.liveNodes(command.Time)
+		if len(nodeAddrs) == 0 {
+			return &NoNodesError{}
+		}
+
+		// Replaced rather than modified, readers may still hold the old one
+		meta := copyTopicMeta(topic.Meta)
+		meta.Partitions = command.Topic.Partitions
+		topic.Meta = meta
+
+		for i := int32(len(topic.Replicas)); i < meta.Partitions; i++ {
+			replicas := assignReplicas(nodeAddrs, i, meta.Replicas)
+			topic.Replicas = append(topic.Replicas, replicas)
+			topic.Leaders = append(topic.Leaders, replicas[0])
+		}
+
+	case opUpdateConfig:
+		topic, ok := metadata.Topics[command.Topic.Topic]
+		if !ok {
+			return &TopicNotFoundError{command.Topic.Topic}
+		}
+
+		meta := copyTopicMeta(topic.Meta)
+		meta.Config = mergeConfig(
+			meta.Config, command.Topic.Config, command.Unset)
+		if _, err := ParseTopicConfig(meta.Config); err != nil {
+			return err
+		}
+
+		topic.Meta = meta
+
+	case opDeleteTopic:
+		if _, exists := metadata.Topics[command.Topic.Topic]; !exists {
+			return &TopicNotFoundError{command.Topic.Topic}
+		}
+
+		delete(metadata.Topics, command.Topic.Topic)
+		delete(metadata.Consumers, command.Topic.Topic)
+
+	case opSetLeader:
+		topic, err := metadata.partition(command.PartitionID)
+		if err != nil {
+			return err
+		}
+
+		topic.Leaders[command.PartitionID.Partition] = command.Addr
+
+	default:
+		return fmt.Errorf("Unknown metadata command: %v", command.Op)
+	}
+
+	return nil
+}
+
+// Rejects topic metadata no backend can create.
+func checkTopicMeta(topicMeta *pb.TopicMeta) error {
+	switch {
+	case topicMeta == nil:
+		return &InvalidTopicError{"", "missing metadata"}
+	case topicMeta.Topic == "":
+		return &InvalidTopicError{topicMeta.Topic, "empty name"}
+	case topicMeta.Partitions < 1:
+		return &InvalidTopicError{topicMeta.Topic, "needs at least one partition"}
+	case topicMeta.Replicas < 0:
+		return &InvalidTopicError{topicMeta.Topic, "negative replicas"}
+	}
+
+	_, err := ParseTopicConfig(topicMeta.Config)
+	return err
+}
+
+// Rejects growing topicMeta to fewer partitions than it has.
+func checkAddPartitions(topicMeta *pb.TopicMeta, partitions int32) error {
+	if partitions <= topicMeta.Partitions {
+		return &InvalidTopicError{topicMeta.Topic, fmt.Sprintf(
+			"can't go from %v to %v partitions",
+			topicMeta.Partitions, partitions)}
+	}
+
+	return nil
+}
+
+func (metadata *clusterMetadata) liveNodes(now time.Time) []string {
+	var nodeAddrs []string
+	for addr, expires := range metadata.Nodes {
+		if expires.After(now) {
+			nodeAddrs = append(nodeAddrs, addr)
+		}
+	}
+
+	sort.Strings(nodeAddrs)
+	return nodeAddrs
+}
+
+func (metadata *clusterMetadata) isLive(addr string, now time.Time) bool {
+	expires, ok := metadata.Nodes[addr]
+	return ok && expires.After(now)
+}
+
+func (metadata *clusterMetadata) topic(topic string) (*pb.TopicMeta, error) {
+	metaTopic, ok := metadata.Topics[topic]
+	if !ok {
+		return nil, &TopicNotFoundError{topic}
+	}
+
+	return metaTopic.Meta, nil
+}
+
+func (metadata *clusterMetadata) topics() []*pb.TopicMeta {
+	var topics []*pb.TopicMeta
+	for _, topic := range metadata.Topics {
+		topics = append(topics, topic.Meta)
+	}
+
+	sortTopics(topics)
+	return topics
+}
+
+func (metadata *clusterMetadata) partition(
+	partitionID *pb.PartitionID) (*metadataTopic, error) {
+
+	topic, ok := metadata.Topics[partitionID.Topic]
+	if !ok {
+		return nil, &TopicNotFoundError{partitionID.Topic}
+	}
+
+	if partitionID.Partition < 0 ||
+		int(partitionID.Partition) >= len(topic.Replicas) {
+		return nil, &PartitionNotFoundError{partitionID}
+	}
+
+	return topic, nil
+}
+
+func (metadata *clusterMetadata) partitionAddrs(
+	partitionID *pb.PartitionID) ([]string, error) {
+
+	topic, err := metadata.partition(partitionID)
+	if err != nil {
+		return nil, err
+	}
+
+	return append([]string(nil), topic.Replicas[partitionID.Partition]...), nil
+}
+
+// The recorded leader while it is alive, otherwise the first live
+// replica. Falls back to the recorded leader when no replica is alive.
+func (metadata *clusterMetadata) leaderAddr(
+	partitionID *pb.PartitionID, now time.Time) (string, error) {
+
+	topic, err := metadata.partition(partitionID)
+	if err != nil {
+		return "", err
+	}
+
+	leader := topic.Leaders[partitionID.Partition]
+	if metadata.isLive(leader, now) {
+		return leader, nil
+	}
+
+	for _, addr := range topic.Replicas[partitionID.Partition] {
+		if metadata.isLive(addr, now) {
+			return addr, nil
+		}
+	}
+
+	return leader, nil
+}
+
+func (metadata *clusterMetadata) consumers(
+	topic, consumerGroup string, now time.Time) []string {
+
+	var consumerIDs []string
+	for consumerID, expires := range metadata.Consumers[topic][consumerGroup] {
+		if expires.After(now) {
+			consumerIDs = append(consumerIDs, consumerID)
+		}
+	}
+
+	sort.Strings(consumerIDs)
+	return consumerIDs
+}
+
+// Partitions whose recorded leader has expired while another replica
+// is alive, mapped to the replica that should take over.
+func (metadata *clusterMetadata) staleLeaders(
+	now time.Time) map[pb.PartitionID]string {
+
+	stale := make(map[pb.PartitionID]string)
+	for name, topic := range metadata.Topics {
+		for i, leader := range topic.Leaders {
+			partitionID := &pb.PartitionID{Topic: name, Partition: int32(i)}
+			if next, _ := metadata.leaderAddr(partitionID, now); next != leader {
+				stale[*partitionID] = next
+			}
+		}
+	}
+
+	return stale
+}
+
+// Where a metadataDiscovery reads and writes cluster metadata.
+type metadataStore interface {
+	// Runs fn against the current metadata, which it must not modify
+	view(fn func(metadata *clusterMetadata) error) error
+
+	// Applies command to the metadata
+	propose(command *metadataCommand) error
+}
+
+// Implements Discovery over a metadataStore.
+type metadataDiscovery struct {
+	store metadataStore
+}
+
+func (discovery *metadataDiscovery) AdvertiseNodeAddr(
+	serverAddr string, ttl time.Duration) error {
+
+	return discovery.store.propose(&metadataCommand{
+		Op: opAdvertiseNode, Time: time.Now(), Addr: serverAddr, TTL: ttl})
+}
+
+func (discovery *metadataDiscovery) GetAllNodeAddrs() ([]string, error) {
+	var nodeAddrs []string
+	err := discovery.store.view(func(metadata *clusterMetadata) error {
+		nodeAddrs = metadata.liveNodes(time.Now())
+		return nil
+	})
+
+	return nodeAddrs, err
+}
+
+func (discovery *metadataDiscovery) GetLeaderAddr(
+	partitionID *pb.PartitionID) (string, error) {
+
+	var leaderAddr string
+	err := discovery.store.view(func(metadata *clusterMetadata) error {
+		var err error
+		leaderAddr, err = metadata.leaderAddr(partitionID, time.Now())
+		return err
+	})
+
+	return leaderAddr, err
+}
+
+func (discovery *metadataDiscovery) GetPartitionAddrs(
+	partitionID *pb.PartitionID) ([]string, error) {
+
+	var addrs []string
+	err := discovery.store.view(func(metadata *clusterMetadata) error {
+		var err error
+		addrs, err = metadata.partitionAddrs(partitionID)
+		return err
+	})
+
+	return addrs, err
+}
+
+func (discovery *metadataDiscovery) AdvertiseConsumer(
+	topic, consumerGroup, consumerID string, ttl time.Duration) error {
+
+	return discovery.store.propose(&metadataCommand{
+		Op:         opAdvertiseConsumer,
+		Time:       time.Now(),
+		Topic:      &pb.TopicMeta{Topic: topic},
+		Group:      consumerGroup,
+		ConsumerID: consumerID,
+		TTL:        ttl})
+}
+
+func (discovery *metadataDiscovery) GetConsumers(
+	topic string, consumerGroup string) ([]string, error) {
+
+	var consumerIDs []string
+	err := discovery.store.view(func(metadata *clusterMetadata) error {
+		consumerIDs = metadata.consumers(topic, consumerGroup, time.Now())
+		return nil
+	})
+
+	return consumerIDs, err
+}
+
+func (discovery *metadataDiscovery) CreateTopic(topicMeta *pb.TopicMeta) error {
+	return discovery.store.propose(&metadataCommand{
+		Op: opCreateTopic, Time: time.Now(), Topic: topicMeta})
+}
+
+func (discovery *metadataDiscovery) GetTopic(
+	topic string) (*pb.TopicMeta, error) {
+
+	var topicMeta *pb.TopicMeta
+	err := discovery.store.view(func(metadata *clusterMetadata) error {
+		var err error
+		topicMeta, err = metadata.topic(topic)
+		return err
+	})
+
+	return topicMeta, err
+}
+
+func (discovery *metadataDiscovery) AddPartitions(
+	topic string, partitions int32) error {
+
+	return discovery.store.propose(&metadataCommand{
+		Op:    opAddPartitions,
+		Time:  time.Now(),
+		Topic: &pb.TopicMeta{Topic: topic, Partitions: partitions}})
+}
+
+func (discovery *metadataDiscovery) UpdateTopicConfig(
+	topic string, set map[string]string, unset []string) error {
+
+	return discovery.store.propose(&metadataCommand{
+		Op:    opUpdateConfig,
+		Time:  time.Now(),
+		Topic: &pb.TopicMeta{Topic: topic, Config: set},
+		Unset: unset})
+}
+
+func (discovery *metadataDiscovery) DeleteTopic(topic string) error {
+	return discovery.store.propose(&metadataCommand{
+		Op: opDeleteTopic, Time: time.Now(), Topic: &pb.TopicMeta{Topic: topic}})
+}
+
+func (discovery *metadataDiscovery) ListTopics() ([]*pb.TopicMeta, error) {
+	var topics []*pb.TopicMeta
+	err := discovery.store.view(func(metadata *clusterMetadata) error {
+		topics = metadata.topics()
+		return nil
+	})
+
+	return topics, err
+}
+
+// Metadata kept in the memory of a single node.
+type localMetadata struct {
+	lock     sync.RWMutex
+	metadata *clusterMetadata
+}
+
+type localDiscovery struct {
+	*metadataDiscovery
+	*localMetadata
+}
+
+// Discovery for a node that is the whole cluster. The node serves the
+// metadata to clients (see NewSingleAddrDiscovery) but loses it when
+// restarted.
+func NewLocalDiscovery() (Discovery, error) {
+	local := &localMetadata{metadata: newClusterMetadata()}
+	return &localDiscovery{&metadataDiscovery{local}, local}, nil
+}
+
+func (local *localMetadata) view(
+	fn func(metadata *clusterMetadata) error) error {
+
+	local.lock.RLock()
+	defer local.lock.RUnlock()
+
+	return fn(local.metadata)
+}
+
+func (local *localMetadata) propose(command *metadataCommand) error {
+	local.lock.Lock()
+	defer local.lock.Unlock()
+
+	return local.metadata.apply(command)
+}
+
+func (local *localMetadata) ApplyMetadata(command []byte) error {
+	decoded := &metadataCommand{}
+	if err := json.Unmarshal(command, decoded); err != nil {
+		return err
+	}
+
+	return local.propose(decoded)
+}
+
+func (local *localMetadata) GetMetadata() ([]byte, error) {
+	local.lock.RLock()
+	defer local.lock.RUnlock()
+
+	return json.Marshal(local.metadata)
+}
+
+// Connections to other nodes, by server address.
+type nodePeers struct {
+	lock    sync.Mutex
+	clients map[string]pb.UltrabusNodeClient
+}
+
+func newNodePeers() *nodePeers {
+	return &nodePeers{
+		clients: make(map[string]pb.UltrabusNodeClient)}
+}
+
+func (peers *nodePeers) client(
+	serverAddr string) (pb.UltrabusNodeClient, error) {
+
+	peers.lock.Lock()
+	defer peers.lock.Unlock()
+
+	if client, ok := peers.clients[serverAddr]; ok {
+		return client, nil
+	}
+
+	conn, err := grpc.Dial(serverAddr, grpc.WithInsecure())
+	if err != nil {
+		return nil, err
+	}
+
+	client := pb.NewUltrabusNodeClient(conn)
+	peers.clients[serverAddr] = client
+
+	return client, nil
+}
+
+func (peers *nodePeers) applyMetadata(serverAddr string, command []byte) error {
+	client, err := peers.client(serverAddr)
+	if err != nil {
+		return err
+	}
+
+	_, err = client.ApplyMetadata(
+		context.Background(), &pb.ApplyMetadataRequest{Command: command})
+	return err
+}
+
+func (peers *nodePeers) getMetadata(
+	serverAddr string) (*clusterMetadata, error) {
+
+	client, err := peers.client(serverAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := client.GetMetadata(
+		context.Background(), &pb.GetMetadataRequest{})
+	if err != nil {
+		return nil, err
+	}
+
+	metadata := newClusterMetadata()
+	if err := json.Unmarshal(response.Metadata, metadata); err != nil {
+		return nil, err
+	}
+
+	return metadata, nil
+}
+
+// Metadata held by other nodes. Reads come from a briefly cached copy
+// of a member's metadata, writes are sent to any member (and forwarded
+// to the raft leader from there, if the members run raft).
+type remoteMetadata struct {
+	memberAddrs []string
+	peers       *nodePeers
+	lock        sync.Mutex
+	metadata    *clusterMetadata
+	fetchedAt   time.Time
+}
+
+func newRemoteMetadata(memberAddrs []string) *remoteMetadata {
+	return &remoteMetadata{
+		memberAddrs: memberAddrs,
+		peers:       newNodePeers()}
+}
+
+func (remote *remoteMetadata) shuffledMembers() []string {
+	members := append([]string(nil), remote.memberAddrs...)
+	for i := range members {
+		j := rand.Intn(i + 1)
+		members[i], members[j] = members[j], members[i]
+	}
+
+	return members
+}
+
+func (remote *remoteMetadata) view(
+	fn func(metadata *clusterMetadata) error) error {
+
+	metadata, err := remote.read()
+	if err != nil {
+		return err
+	}
+
+	return fn(metadata)
+}
+
+func (remote *remoteMetadata) read() (*clusterMetadata, error) {
+	remote.lock.Lock()
+	defer remote.lock.Unlock()
+
+	if remote.metadata != nil &&
+		time.Since(remote.fetchedAt) < remoteMetadataCacheTimeout {
+		return remote.metadata, nil
+	}
+
+	var err error
+	for _, memberAddr := range remote.shuffledMembers() {
+		var metadata *clusterMetadata
+		if metadata, err = remote.peers.getMetadata(memberAddr); err == nil {
+			remote.metadata = metadata
+			remote.fetchedAt = time.Now()
+			return metadata, nil
+		}
+	}
+
+	return nil, err
+}
+
+func (remote *remoteMetadata) propose(command *metadataCommand) error {
+	encoded, err := json.Marshal(command)
+	if err != nil {
+		return err
+	}
+
+	for _, memberAddr := range remote.shuffledMembers() {
+		err = remote.peers.applyMetadata(memberAddr, encoded)
+		if err == nil || grpc.Code(err) != codes.Unavailable {
+			break
+		}
+	}
+
+	// Make the write visible to our next read
+	remote.lock.Lock()
+	remote.metadata = nil
+	remote.lock.Unlock()
+
+	if command.Topic != nil {
+		return topicError(command.Topic.Topic, err)
+	}
+
+	return err
+}
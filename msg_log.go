@@ -1,7 +1,12 @@
 package ultrabus
 
 import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"sort"
 	"sync"
+	"time"
 
 	"github.com/emef/ultrabus/pb"
 )
@@ -24,6 +29,14 @@ type MessageLog interface {
 	// Append the message to the log, returning the offset of this message
 	Append(message *pb.Message) WriteReceipt
 
+	// Append the message on behalf of an idempotent producer, keeping
+	// the producer's sequence with it
+	AppendFrom(message *pb.Message, producer *pb.ProducerSequence) WriteReceipt
+
+	// Append a control record ending producer's transaction
+	AppendMarker(
+		producer *pb.ProducerSequence, marker pb.TransactionMarker) WriteReceipt
+
 	// Create a cursor at the start of the log
 	CursorStart() (MessageLogCursor, error)
 
@@ -38,6 +51,19 @@ type MessageLog interface {
 
 	// Current largest offset in the log
 	LastOffset() (int64, error)
+
+	// Bytes of message keys and values held, as stored
+	Size() int64
+
+	// Messages cleanup has dropped because they'd expired
+	Expired() int64
+
+	// Apply a topic's config to later appends and cleanups
+	Configure(config *TopicConfig)
+
+	// Drop the messages the configured retention or cleanup policy no
+	// longer keeps. Offsets of the remaining messages don't change.
+	Clean() error
 }
 
 // When a message is being written a receipt is returned. This
@@ -53,47 +79,103 @@ type WriteReceipt interface {
 }
 
 type inMemoryMessageLog struct {
-	lock     sync.RWMutex
-	messages []*pb.MessageWithOffset
+	lock       sync.RWMutex
+	messages   []*pb.MessageWithOffset
+	compressed []bool
+	nextOffset int64
+	size       int64
+	config     *TopicConfig
+
+	// Messages Clean dropped because they'd expired
+	expired int64
 }
 
 func NewInMemoryMessageLog() MessageLog {
 	return &inMemoryMessageLog{
 		lock:     sync.RWMutex{},
-		messages: nil}
+		messages: nil,
+		config:   DefaultTopicConfig()}
 }
 
 func (log *inMemoryMessageLog) Append(message *pb.Message) WriteReceipt {
+	return log.AppendFrom(message, nil)
+}
+
+func (log *inMemoryMessageLog) AppendFrom(
+	message *pb.Message, producer *pb.ProducerSequence) WriteReceipt {
+
+	return log.append(message, producer, pb.TransactionMarker_NO_MARKER)
+}
+
+func (log *inMemoryMessageLog) AppendMarker(
+	producer *pb.ProducerSequence, marker pb.TransactionMarker) WriteReceipt {
+
+	return log.append(&pb.Message{}, producer, marker)
+}
+
+func (log *inMemoryMessageLog) append(
+	message *pb.Message,
+	producer *pb.ProducerSequence,
+	marker pb.TransactionMarker) WriteReceipt {
+
 	receipt := newReceipt()
 
 	log.lock.Lock()
 	defer log.lock.Unlock()
 
-	offset := int64(len(log.messages))
+	offset := log.nextOffset
 
-	msgWithOffset := &pb.MessageWithOffset{Message: message, Offset: offset}
+	compressed := false
+	if log.config.CompressionType == CompressionGzip {
+		value, err := gzipValue(message.Value)
+		if err != nil {
+			receipt.fail(err)
+			return receipt
+		}
+
+		message = &pb.Message{
+			Key:       message.Key,
+			Value:     value,
+			Headers:   message.Headers,
+			DeliverAt: message.DeliverAt,
+			ExpiresAt: message.ExpiresAt}
+		compressed = true
+	}
+
+	msgWithOffset := &pb.MessageWithOffset{
+		Message:   message,
+		Offset:    offset,
+		Timestamp: time.Now().UnixNano() / int64(time.Millisecond),
+		Producer:  producer,
+		Marker:    marker}
 	log.messages = append(log.messages, msgWithOffset)
+	log.compressed = append(log.compressed, compressed)
+	log.nextOffset++
+	log.size += messageSize(message)
 	receipt.succeed(offset)
 
 	return receipt
 }
 
 func (log *inMemoryMessageLog) CursorStart() (MessageLogCursor, error) {
-	return &inMemoryMessageLogCursor{0, log}, nil
-}
-
-func (log *inMemoryMessageLog) CursorEnd() (MessageLogCursor, error) {
-	lastOffset, err := log.LastOffset()
+	firstOffset, err := log.FirstOffset()
 	if err != nil {
 		switch err.(type) {
 		case *EmptyLogError:
-			return log.CursorStart()
+			return log.CursorEnd()
 		default:
 			return nil, err
 		}
 	}
 
-	return &inMemoryMessageLogCursor{lastOffset + 1, log}, nil
+	return &inMemoryMessageLogCursor{firstOffset, log}, nil
+}
+
+func (log *inMemoryMessageLog) CursorEnd() (MessageLogCursor, error) {
+	log.lock.RLock()
+	defer log.lock.RUnlock()
+
+	return &inMemoryMessageLogCursor{log.nextOffset, log}, nil
 }
 
 func (log *inMemoryMessageLog) CursorAt(offset int64) (MessageLogCursor, error) {
@@ -114,7 +196,7 @@ func (log *inMemoryMessageLog) FirstOffset() (int64, error) {
 	if len(log.messages) == 0 {
 		return -1, &EmptyLogError{}
 	} else {
-		return 0, nil
+		return log.messages[0].Offset, nil
 	}
 }
 
@@ -125,20 +207,170 @@ func (log *inMemoryMessageLog) LastOffset() (int64, error) {
 	if len(log.messages) == 0 {
 		return -1, &EmptyLogError{}
 	} else {
-		return int64(len(log.messages) - 1), nil
+		return log.messages[len(log.messages)-1].Offset, nil
+	}
+}
+
+func (log *inMemoryMessageLog) Size() int64 {
+	log.lock.RLock()
+	defer log.lock.RUnlock()
+
+	return log.size
+}
+
+func (log *inMemoryMessageLog) Expired() int64 {
+	log.lock.RLock()
+	defer log.lock.RUnlock()
+
+	return log.expired
+}
+
+func (log *inMemoryMessageLog) Configure(config *TopicConfig) {
+	log.lock.Lock()
+	defer log.lock.Unlock()
+
+	log.config = config
+}
+
+func (log *inMemoryMessageLog) Clean() error {
+	log.lock.Lock()
+	defer log.lock.Unlock()
+
+	keep := make([]bool, len(log.messages))
+	for i := range keep {
+		keep[i] = true
+	}
+
+	switch log.config.CleanupPolicy {
+	case CleanupCompact:
+		// Keyless messages can't be superseded and are kept
+		latest := make(map[string]int)
+		for i, msg := range log.messages {
+			if len(msg.Message.Key) > 0 {
+				if previous, ok := latest[string(msg.Message.Key)]; ok {
+					keep[previous] = false
+				}
+
+				latest[string(msg.Message.Key)] = i
+			}
+		}
+
+	default:
+		size := log.size
+		expired := time.Now().Add(-log.config.Retention)
+		for i, msg := range log.messages {
+			tooOld := log.config.Retention >= 0 &&
+				msg.Timestamp < expired.UnixNano()/int64(time.Millisecond)
+			tooBig := log.config.RetentionBytes >= 0 &&
+				size > log.config.RetentionBytes
+			if !tooOld && !tooBig {
+				break
+			}
+
+			keep[i] = false
+			size -= messageSize(msg.Message)
+		}
+	}
+
+	// Counted as expired only if nothing else drops them
+	if log.config.RetentionExpired {
+		now := time.Now().UnixNano() / int64(time.Millisecond)
+		for i, msg := range log.messages {
+			if keep[i] && isExpired(msg.Message, now) {
+				keep[i] = false
+				log.expired++
+			}
+		}
 	}
+
+	var messages []*pb.MessageWithOffset
+	var compressed []bool
+	for i, msg := range log.messages {
+		if keep[i] {
+			messages = append(messages, msg)
+			compressed = append(compressed, log.compressed[i])
+		} else {
+			log.size -= messageSize(msg.Message)
+		}
+	}
+
+	log.messages = messages
+	log.compressed = compressed
+
+	return nil
 }
 
-func (log *inMemoryMessageLog) read(pos int64) (*pb.MessageWithOffset, error) {
+// The first message at or after offset.
+func (log *inMemoryMessageLog) read(offset int64) (*pb.MessageWithOffset, error) {
 	log.lock.RLock()
 	defer log.lock.RUnlock()
 
-	if pos < 0 || pos >= int64(len(log.messages)) {
-		return nil, &OffsetOutOfBoundsError{
-			pos, int64(len(log.messages) - 1)}
+	i := sort.Search(len(log.messages), func(i int) bool {
+		return log.messages[i].Offset >= offset
+	})
+
+	if offset < 0 || i == len(log.messages) {
+		return nil, &OffsetOutOfBoundsError{offset, log.nextOffset - 1}
+	}
+
+	if !log.compressed[i] {
+		return log.messages[i], nil
+	}
+
+	value, err := gunzipValue(log.messages[i].Message.Value)
+	if err != nil {
+		return nil, err
+	}
+
+	return &pb.MessageWithOffset{
+		Offset:    log.messages[i].Offset,
+		Timestamp: log.messages[i].Timestamp,
+		Producer:  log.messages[i].Producer,
+		Marker:    log.messages[i].Marker,
+		Message: &pb.Message{
+			Key:       log.messages[i].Message.Key,
+			Value:     value,
+			Headers:   log.messages[i].Message.Headers,
+			DeliverAt: log.messages[i].Message.DeliverAt,
+			ExpiresAt: log.messages[i].Message.ExpiresAt}}, nil
+}
+
+func messageSize(message *pb.Message) int64 {
+	size := len(message.Key) + len(message.Value)
+	for name, value := range message.Headers {
+		size += len(name) + len(value)
 	}
 
-	return log.messages[int(pos)], nil
+	return int64(size)
+}
+
+// Whether msg had expired at now, in ms since the epoch.
+func isExpired(msg *pb.Message, now int64) bool {
+	return msg.ExpiresAt > 0 && msg.ExpiresAt <= now
+}
+
+func gzipValue(value []byte) ([]byte, error) {
+	var compressed bytes.Buffer
+	writer := gzip.NewWriter(&compressed)
+	if _, err := writer.Write(value); err != nil {
+		return nil, err
+	}
+
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+
+	return compressed.Bytes(), nil
+}
+
+func gunzipValue(value []byte) ([]byte, error) {
+	reader, err := gzip.NewReader(bytes.NewReader(value))
+	if err != nil {
+		return nil, err
+	}
+
+	defer reader.Close()
+	return ioutil.ReadAll(reader)
 }
 
 type inMemoryMessageLogCursor struct {
@@ -151,13 +383,14 @@ func (cursor *inMemoryMessageLogCursor) HasNext() bool {
 	return err == nil && cursor.pos <= lastOffset
 }
 
+// Messages dropped by cleanup are skipped over.
 func (cursor *inMemoryMessageLogCursor) Next() (*pb.MessageWithOffset, error) {
 	msg, error := cursor.log.read(cursor.pos)
 	if error != nil {
 		return nil, error
 	}
 
-	cursor.pos++
+	cursor.pos = msg.Offset + 1
 
 	return msg, nil
 }
@@ -194,11 +427,12 @@ func (r *receiptImpl) Done() chan interface{} {
 }
 
 func (r *receiptImpl) Read() (int64, error) {
-	if !r.resolved {
+	select {
+	case <-r.done:
+		return r.offset, r.err
+	default:
 		return -1, &ReceiptNotWrittenError{}
 	}
-
-	return r.offset, r.err
 }
 
 func (r *receiptImpl) resolve(offset int64, err error) {
@@ -3,6 +3,7 @@ package ultrabus
 import (
 	"errors"
 	"testing"
+	"time"
 
 	"github.com/emef/ultrabus/pb"
 	"github.com/stretchr/testify/assert"
@@ -152,3 +153,82 @@ func basicLogTests(t *testing.T, log MessageLog) {
 	assert.Nil(msg)
 	assert.NotNil(err)
 }
+
+func TestInMemoryLogClean(t *testing.T) {
+	assert := assert.New(t)
+
+	config, err := ParseTopicConfig(map[string]string{
+		ConfigRetentionBytes:  "70",
+		ConfigCompressionType: CompressionGzip})
+	assert.Nil(err)
+
+	log := NewInMemoryMessageLog()
+	log.Configure(config)
+
+	for _, key := range []string{"a", "b", "a", "c"} {
+		<-log.Append(&pb.Message{Key: []byte(key), Value: []byte("value")}).Done()
+	}
+
+	// Values are stored compressed but read back as they were written
+	cursor, err := log.CursorStart()
+	assert.Nil(err)
+	msg, err := cursor.Next()
+	assert.Nil(err)
+	assert.Equal([]byte("value"), msg.Message.Value)
+	assert.True(log.Size() > 70)
+
+	// The oldest messages go until the log fits
+	assert.Nil(log.Clean())
+	assert.True(log.Size() <= 70)
+	firstOffset, err := log.FirstOffset()
+	assert.Nil(err)
+	assert.True(firstOffset > 0)
+
+	config, err = ParseTopicConfig(map[string]string{
+		ConfigCleanupPolicy: CleanupCompact})
+	assert.Nil(err)
+
+	log = NewInMemoryMessageLog()
+	log.Configure(config)
+
+	for _, key := range []string{"a", "b", "a", "c"} {
+		<-log.Append(&pb.Message{Key: []byte(key), Value: []byte(key)}).Done()
+	}
+
+	// Only the latest message of each key is kept, at its old offset
+	assert.Nil(log.Clean())
+	cursor, err = log.CursorStart()
+	assert.Nil(err)
+
+	var offsets []int64
+	for cursor.HasNext() {
+		msg, err := cursor.Next()
+		assert.Nil(err)
+		offsets = append(offsets, msg.Offset)
+	}
+
+	assert.Equal([]int64{1, 2, 3}, offsets)
+
+	// Expired messages go wherever they are in the log
+	log = NewInMemoryMessageLog()
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+	for _, expiresAt := range []int64{0, now - 1, now + 60000} {
+		<-log.Append(&pb.Message{Value: []byte("value"), ExpiresAt: expiresAt}).Done()
+	}
+
+	assert.Nil(log.Clean())
+	cursor, err = log.CursorStart()
+	assert.Nil(err)
+
+	offsets = nil
+	for cursor.HasNext() {
+		msg, err := cursor.Next()
+		assert.Nil(err)
+		offsets = append(offsets, msg.Offset)
+	}
+
+	assert.Equal([]int64{0, 2}, offsets)
+
+	_, err = ParseTopicConfig(map[string]string{ConfigCleanupPolicy: "never"})
+	assert.IsType(&InvalidConfigError{}, err)
+}
@@ -1,13 +1,33 @@
 package ultrabus
 
 import (
+	"sync"
+	"time"
+
 	"github.com/emef/ultrabus/pb"
 	"golang.org/x/net/context"
+	"google.golang.org/grpc"
 	"google.golang.org/grpc/grpclog"
 )
 
+// How long a node's advertisement lives without being refreshed.
+const nodeTTL = 10 * time.Second
+
+// How often partitions pick up config changes and are cleaned.
+const partitionMaintenancePeriod = 5 * time.Second
+
 type NodeService struct {
+	serverAddr string
+	discovery  Discovery
+	peers      *nodePeers
+	lock       sync.RWMutex
 	partitions map[pb.PartitionID]*Partition
+
+	// Transactions of the transactional IDs this node coordinates
+	coordinator *coordinator
+
+	// Publishes what consumer queues give up on to dead-letter topics
+	deadLetters UltrabusClient
 }
 
 func (node *NodeService) Subscribe(
@@ -16,12 +36,12 @@ func (node *NodeService) Subscribe(
 
 	grpclog.Printf("Connection opened from: %v\n", request.ClientID)
 
-	partition, ok := node.partitions[*request.PartitionID]
-	if !ok {
-		return &PartitionNotFoundError{request.PartitionID}
+	partition, err := node.partition(request.PartitionID)
+	if err != nil {
+		return err
 	}
 
-	handle, err := partition.RegisterConsumer(request.ClientID, stream)
+	handle, err := partition.RegisterConsumer(request, stream)
 	if err != nil {
 		return err
 	}
@@ -29,13 +49,54 @@ func (node *NodeService) Subscribe(
 	return handle.Wait()
 }
 
+func (node *NodeService) Fetch(
+	context context.Context,
+	request *pb.FetchRequest) (*pb.FetchResponse, error) {
+
+	partition, err := node.partition(request.PartitionID)
+	if err != nil {
+		return nil, err
+	}
+
+	return partition.Fetch(request, context.Done())
+}
+
 func (node *NodeService) Publish(
 	context context.Context,
 	request *pb.PublishRequest) (*pb.PublishResponse, error) {
 
-	partition, ok := node.partitions[*request.PartitionID]
-	if !ok {
-		return nil, &PartitionNotFoundError{request.PartitionID}
+	partition, err := node.partition(request.PartitionID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Reject the whole request rather than append part of it
+	config := partition.Config()
+	for _, msg := range request.Messages {
+		if size := messageSize(msg); size > config.MaxMessageBytes {
+			return nil, &MessageTooLargeError{size, config.MaxMessageBytes}
+		}
+	}
+
+	if config.MinInsyncReplicas > 1 {
+		addrs, err := node.discovery.GetPartitionAddrs(request.PartitionID)
+		if err != nil {
+			return nil, err
+		}
+
+		if int32(len(addrs)) < config.MinInsyncReplicas {
+			return nil, &NotEnoughReplicasError{
+				request.PartitionID, int32(len(addrs)), config.MinInsyncReplicas}
+		}
+	}
+
+	if request.Producer != nil {
+		offsets, err := partition.AppendFrom(request.Producer, request.Messages)
+		if err != nil {
+			return nil, err
+		}
+
+		return &pb.PublishResponse{Offsets: offsets}, nil
 	}
 
 	offsets := make([]int64, len(request.Messages))
@@ -53,29 +114,616 @@ func (node *NodeService) Publish(
 	return &pb.PublishResponse{Offsets: offsets}, nil
 }
 
+func (node *NodeService) CommitOffset(
+	context context.Context,
+	request *pb.CommitOffsetRequest) (*pb.CommitOffsetResponse, error) {
+
+	partition, err := node.partition(request.PartitionID)
+	if err != nil {
+		return nil, err
+	}
+
+	if request.Offset < 0 {
+		return nil, &OffsetOutOfBoundsError{request.Offset, -1}
+	}
+
+	var producer *pb.ProducerSequence
+	if request.ProducerID != "" {
+		producer = &pb.ProducerSequence{
+			ProducerID:    request.ProducerID,
+			Epoch:         request.Epoch,
+			Transactional: true}
+	}
+
+	err = partition.CommitOffset(request.ConsumerGroup, request.Offset, producer)
+	if err != nil {
+		return nil, err
+	}
+
+	return &pb.CommitOffsetResponse{}, nil
+}
+
+func (node *NodeService) GrantCredits(
+	context context.Context,
+	request *pb.GrantCreditsRequest) (*pb.GrantCreditsResponse, error) {
+
+	partition, err := node.partition(request.PartitionID)
+	if err != nil {
+		return nil, err
+	}
+
+	if request.Credits == nil {
+		return &pb.GrantCreditsResponse{}, nil
+	}
+
+	err = partition.GrantCredits(request.ClientID, request.Credits)
+	if err != nil {
+		return nil, err
+	}
+
+	return &pb.GrantCreditsResponse{}, nil
+}
+
+func (node *NodeService) Ack(
+	context context.Context,
+	request *pb.AckRequest) (*pb.AckResponse, error) {
+
+	partition, err := node.partition(request.PartitionID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = partition.Ack(
+		request.ClientID, request.Acks, request.Nacks, request.Errors)
+	if err != nil {
+		return nil, err
+	}
+
+	return &pb.AckResponse{}, nil
+}
+
 func (node *NodeService) CreateTopic(
 	context context.Context,
 	request *pb.CreateTopicRequest) (*pb.CreateTopicResponse, error) {
 
-	// TODO:
-	//   1. check if exists: error if so
-	//   2. add entry in etcd
+	if err := node.discovery.CreateTopic(request.Meta); err != nil {
+		return nil, err
+	}
 
-	topic := request.Meta.Topic
-	partitions := request.Meta.Partitions
-	for i := int32(0); i < partitions; i++ {
-		partitionID := pb.PartitionID{Topic: topic, Partition: i}
-		node.partitions[partitionID] = NewInMemoryPartition()
+	err := node.startPartitions(request.Meta.Topic, 0, request.Meta.Partitions)
+	if err != nil {
+		return nil, err
 	}
 
 	return &pb.CreateTopicResponse{Ok: true}, nil
 }
 
-func NewNodeService() pb.UltrabusNodeServer {
-	partitions := make(map[pb.PartitionID]*Partition)
-	node := &NodeService{partitions}
-	node.CreateTopic(context.Background(), &pb.CreateTopicRequest{
-		&pb.TopicMeta{Topic: "topic", Partitions: 10}})
+func (node *NodeService) AlterTopic(
+	context context.Context,
+	request *pb.AlterTopicRequest) (*pb.AlterTopicResponse, error) {
+
+	meta, err := node.discovery.GetTopic(request.Topic)
+	if err != nil {
+		return nil, err
+	}
+
+	err = node.discovery.AddPartitions(request.Topic, request.Partitions)
+	if err != nil {
+		return nil, err
+	}
+
+	err = node.startPartitions(request.Topic, meta.Partitions, request.Partitions)
+	if err != nil {
+		return nil, err
+	}
+
+	altered, err := node.discovery.GetTopic(request.Topic)
+	if err != nil {
+		return nil, err
+	}
+
+	return &pb.AlterTopicResponse{Meta: altered}, nil
+}
+
+func (node *NodeService) GetTopicConfig(
+	context context.Context,
+	request *pb.GetTopicConfigRequest) (*pb.GetTopicConfigResponse, error) {
+
+	meta, err := node.discovery.GetTopic(request.Topic)
+	if err != nil {
+		return nil, err
+	}
+
+	config, err := ParseTopicConfig(meta.Config)
+	if err != nil {
+		return nil, err
+	}
+
+	return &pb.GetTopicConfigResponse{
+		Overrides: meta.Config, Effective: config.Map()}, nil
+}
+
+// Other nodes pick the change up within partitionMaintenancePeriod.
+func (node *NodeService) SetTopicConfig(
+	context context.Context,
+	request *pb.SetTopicConfigRequest) (*pb.SetTopicConfigResponse, error) {
+
+	err := node.discovery.UpdateTopicConfig(
+		request.Topic, request.Set, request.Unset)
+	if err != nil {
+		return nil, err
+	}
+
+	meta, err := node.discovery.GetTopic(request.Topic)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := node.configureTopic(meta); err != nil {
+		return nil, err
+	}
+
+	return &pb.SetTopicConfigResponse{Meta: meta}, nil
+}
+
+func (node *NodeService) DeleteTopic(
+	context context.Context,
+	request *pb.DeleteTopicRequest) (*pb.DeleteTopicResponse, error) {
+
+	if request.Local {
+		node.dropTopic(request.Topic)
+		return &pb.DeleteTopicResponse{Ok: true}, nil
+	}
+
+	hosts, err := node.topicHosts(request.Topic)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := node.discovery.DeleteTopic(request.Topic); err != nil {
+		return nil, err
+	}
+
+	node.dropTopic(request.Topic)
+
+	// Nodes that miss this drop their partitions once they notice the
+	// topic is gone
+	for _, addr := range hosts {
+		if addr == node.serverAddr {
+			continue
+		}
+
+		client, err := node.peers.client(addr)
+		if err == nil {
+			_, err = client.DeleteTopic(context, &pb.DeleteTopicRequest{
+				Topic: request.Topic, Local: true})
+		}
+
+		if err != nil {
+			grpclog.Printf("Error deleting %v on %v: %v", request.Topic, addr, err)
+		}
+	}
+
+	return &pb.DeleteTopicResponse{Ok: true}, nil
+}
+
+func (node *NodeService) ListTopics(
+	context context.Context,
+	request *pb.ListTopicsRequest) (*pb.ListTopicsResponse, error) {
+
+	topics, err := node.discovery.ListTopics()
+	if err != nil {
+		return nil, err
+	}
+
+	return &pb.ListTopicsResponse{Topics: topics}, nil
+}
+
+func (node *NodeService) DescribeTopic(
+	context context.Context,
+	request *pb.DescribeTopicRequest) (*pb.DescribeTopicResponse, error) {
+
+	if request.Local {
+		partitions, err := node.describeLocal(request.Topic)
+		if err != nil {
+			return nil, err
+		}
+
+		return &pb.DescribeTopicResponse{Partitions: partitions}, nil
+	}
+
+	meta, err := node.discovery.GetTopic(request.Topic)
+	if err != nil {
+		return nil, err
+	}
+
+	hosts, err := node.topicHosts(request.Topic)
+	if err != nil {
+		return nil, err
+	}
+
+	// What each reachable host has of every partition
+	hosted := make(map[string]map[int32]*pb.PartitionDescription)
+	for _, addr := range hosts {
+		var partitions []*pb.PartitionDescription
+		if addr == node.serverAddr {
+			partitions, err = node.describeLocal(request.Topic)
+		} else {
+			partitions, err = node.describeRemote(context, addr, request.Topic)
+		}
+
+		if err != nil {
+			grpclog.Printf("Error describing %v on %v: %v", request.Topic, addr, err)
+			continue
+		}
+
+		hosted[addr] = make(map[int32]*pb.PartitionDescription)
+		for _, partition := range partitions {
+			hosted[addr][partition.Partition] = partition
+		}
+	}
+
+	response := &pb.DescribeTopicResponse{Meta: meta}
+	for i := int32(0); i < meta.Partitions; i++ {
+		partitionID := &pb.PartitionID{Topic: meta.Topic, Partition: i}
+
+		leader, err := node.discovery.GetLeaderAddr(partitionID)
+		if err != nil {
+			return nil, err
+		}
+
+		replicas, err := node.discovery.GetPartitionAddrs(partitionID)
+		if err != nil {
+			return nil, err
+		}
+
+		// A host that hasn't started the partition yet has an empty log
+		description := &pb.PartitionDescription{FirstOffset: -1, LastOffset: -1}
+		if local, ok := hosted[leader][i]; ok {
+			description = local
+		}
+
+		description.Partition = i
+		description.Leader = leader
+		description.Replicas = replicas
+
+		// Nothing is known to be in sync with an unreachable leader
+		for _, replica := range replicas {
+			partitions, reachable := hosted[replica]
+			if _, ok := hosted[leader]; !ok || !reachable {
+				continue
+			}
+
+			lastOffset := int64(-1)
+			if local, ok := partitions[i]; ok {
+				lastOffset = local.LastOffset
+			}
+
+			if lastOffset == description.LastOffset {
+				description.Isr = append(description.Isr, replica)
+			}
+		}
+
+		response.Partitions = append(response.Partitions, description)
+	}
+
+	return response, nil
+}
+
+func (node *NodeService) Sync(
+	context context.Context,
+	request *pb.SyncRequest) (*pb.SyncResponse, error) {
+
+	partition, err := node.partition(request.PartitionID)
+	if err != nil {
+		return nil, err
+	}
+
+	messages, maxOffset, err := partition.Read(
+		request.FromOffset, request.MaxMessages)
+	if err != nil {
+		return nil, err
+	}
+
+	return &pb.SyncResponse{
+		Messages:  &pb.Messages{Messages: messages},
+		MaxOffset: maxOffset}, nil
+}
+
+func (node *NodeService) ApplyMetadata(
+	context context.Context,
+	request *pb.ApplyMetadataRequest) (*pb.ApplyMetadataResponse, error) {
+
+	server, ok := node.discovery.(MetadataServer)
+	if !ok {
+		return nil, &MetadataNotServedError{}
+	}
+
+	if err := server.ApplyMetadata(request.Command); err != nil {
+		return nil, err
+	}
+
+	return &pb.ApplyMetadataResponse{Ok: true}, nil
+}
+
+func (node *NodeService) GetMetadata(
+	context context.Context,
+	request *pb.GetMetadataRequest) (*pb.GetMetadataResponse, error) {
+
+	server, ok := node.discovery.(MetadataServer)
+	if !ok {
+		return nil, &MetadataNotServedError{}
+	}
+
+	metadata, err := server.GetMetadata()
+	if err != nil {
+		return nil, err
+	}
+
+	return &pb.GetMetadataResponse{Metadata: metadata}, nil
+}
+
+// Sends a node's errors to clients with the gRPC code of their type, so
+// that clients can tell which are worth retrying. Install it with
+// grpc.UnaryInterceptor when serving a NodeService.
+func NodeErrorInterceptor(
+	context context.Context,
+	request interface{},
+	info *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler) (interface{}, error) {
+
+	response, err := handler(context, request)
+	if err != nil {
+		if code := errorCode(err); code != grpc.Code(err) {
+			err = grpc.Errorf(code, "%v", err)
+		}
+	}
+
+	return response, err
+}
+
+// Creates the node reachable at serverAddr, which it keeps advertising
+// through discovery.
+func NewNodeService(
+	serverAddr string, discovery Discovery) (pb.UltrabusNodeServer, error) {
+
+	deadLetters, err := NewSingleAddrBrokeredClient("", discovery)
+	if err != nil {
+		return nil, err
+	}
+
+	node := &NodeService{
+		serverAddr:  serverAddr,
+		discovery:   discovery,
+		peers:       newNodePeers(),
+		partitions:  make(map[pb.PartitionID]*Partition),
+		coordinator: newCoordinator(),
+		deadLetters: deadLetters}
+
+	go node.advertise()
+	go node.maintain()
+	go node.forwardDeadLetters()
+
+	return node, nil
+}
+
+// The partition if discovery places a replica of it on this node,
+// which is started the first time it's needed.
+func (node *NodeService) partition(
+	partitionID *pb.PartitionID) (*Partition, error) {
+
+	node.lock.RLock()
+	partition, ok := node.partitions[*partitionID]
+	node.lock.RUnlock()
+
+	if ok {
+		return partition, nil
+	}
+
+	addrs, err := node.discovery.GetPartitionAddrs(partitionID)
+	if err != nil {
+		return nil, err
+	}
+
+	assigned := false
+	for _, addr := range addrs {
+		assigned = assigned || addr == node.serverAddr
+	}
+
+	if !assigned {
+		return nil, &PartitionNotFoundError{partitionID}
+	}
+
+	meta, err := node.discovery.GetTopic(partitionID.Topic)
+	if err != nil {
+		return nil, err
+	}
 
-	return node
+	config, err := ParseTopicConfig(meta.Config)
+	if err != nil {
+		return nil, err
+	}
+
+	node.lock.Lock()
+	defer node.lock.Unlock()
+
+	if partition, ok := node.partitions[*partitionID]; ok {
+		return partition, nil
+	}
+
+	partition = NewInMemoryPartition()
+	partition.Configure(config)
+	node.partitions[*partitionID] = partition
+
+	return partition, nil
+}
+
+// Starts the partitions of topic in [from, to) that discovery places on
+// this node. Other nodes start theirs when they're first used.
+func (node *NodeService) startPartitions(topic string, from, to int32) error {
+	for i := from; i < to; i++ {
+		partitionID := &pb.PartitionID{Topic: topic, Partition: i}
+		if _, err := node.partition(partitionID); err != nil {
+			if _, ok := err.(*PartitionNotFoundError); !ok {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// This node's partitions, by topic.
+func (node *NodeService) localPartitions() map[string][]*Partition {
+	node.lock.RLock()
+	defer node.lock.RUnlock()
+
+	partitions := make(map[string][]*Partition)
+	for partitionID, partition := range node.partitions {
+		partitions[partitionID.Topic] = append(
+			partitions[partitionID.Topic], partition)
+	}
+
+	return partitions
+}
+
+// Applies meta's config to this node's partitions of the topic.
+func (node *NodeService) configureTopic(meta *pb.TopicMeta) error {
+	config, err := ParseTopicConfig(meta.Config)
+	if err != nil {
+		return err
+	}
+
+	for _, partition := range node.localPartitions()[meta.Topic] {
+		partition.Configure(config)
+	}
+
+	return nil
+}
+
+// Keeps partitions configured as their topic says and cleans them.
+func (node *NodeService) maintain() {
+	for range time.Tick(partitionMaintenancePeriod) {
+		node.abortStaleTransactions()
+
+		for topic, partitions := range node.localPartitions() {
+			meta, err := node.discovery.GetTopic(topic)
+			if _, deleted := err.(*TopicNotFoundError); deleted {
+				node.dropTopic(topic)
+				continue
+			} else if err == nil {
+				err = node.configureTopic(meta)
+			}
+
+			if err != nil {
+				grpclog.Printf("Error configuring %v: %v", topic, err)
+			}
+
+			for _, partition := range partitions {
+				if err := partition.Clean(); err != nil {
+					grpclog.Printf("Error cleaning %v: %v", topic, err)
+				}
+			}
+		}
+	}
+}
+
+// Every node holding a replica of one of topic's partitions.
+func (node *NodeService) topicHosts(topic string) ([]string, error) {
+	meta, err := node.discovery.GetTopic(topic)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	var hosts []string
+	for i := int32(0); i < meta.Partitions; i++ {
+		addrs, err := node.discovery.GetPartitionAddrs(
+			&pb.PartitionID{Topic: topic, Partition: i})
+		if err != nil {
+			return nil, err
+		}
+
+		for _, addr := range addrs {
+			if !seen[addr] {
+				seen[addr] = true
+				hosts = append(hosts, addr)
+			}
+		}
+	}
+
+	return hosts, nil
+}
+
+// Stops and forgets this node's partitions of topic.
+func (node *NodeService) dropTopic(topic string) {
+	node.lock.Lock()
+	var dropped []*Partition
+	for partitionID, partition := range node.partitions {
+		if partitionID.Topic == topic {
+			dropped = append(dropped, partition)
+			delete(node.partitions, partitionID)
+		}
+	}
+	node.lock.Unlock()
+
+	for _, partition := range dropped {
+		partition.Stop()
+	}
+}
+
+// Descriptions of the partitions of topic started on this node.
+func (node *NodeService) describeLocal(
+	topic string) ([]*pb.PartitionDescription, error) {
+
+	node.lock.RLock()
+	defer node.lock.RUnlock()
+
+	var descriptions []*pb.PartitionDescription
+	for partitionID, partition := range node.partitions {
+		if partitionID.Topic != topic {
+			continue
+		}
+
+		description, err := partition.Describe()
+		if err != nil {
+			return nil, err
+		}
+
+		description.Partition = partitionID.Partition
+		descriptions = append(descriptions, description)
+	}
+
+	return descriptions, nil
+}
+
+func (node *NodeService) describeRemote(
+	context context.Context,
+	addr string,
+	topic string) ([]*pb.PartitionDescription, error) {
+
+	client, err := node.peers.client(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := client.DescribeTopic(
+		context, &pb.DescribeTopicRequest{Topic: topic, Local: true})
+	if err != nil {
+		return nil, err
+	}
+
+	return response.Partitions, nil
+}
+
+// Keeps the node's advertisement alive for as long as it runs.
+func (node *NodeService) advertise() {
+	for {
+		err := node.discovery.AdvertiseNodeAddr(node.serverAddr, nodeTTL)
+		if err != nil {
+			grpclog.Printf("Error advertising node: %v", err)
+		}
+
+		time.Sleep(nodeTTL / 3)
+	}
 }
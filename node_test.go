@@ -0,0 +1,301 @@
+package ultrabus
+
+import (
+	"net"
+	"testing"
+
+	"github.com/emef/ultrabus/pb"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+)
+
+// Serves a node on a local port for clients to reach through the
+// returned discovery, until stop is called.
+func serveTestNode(t *testing.T) (*NodeService, Discovery, func()) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+
+	discovery, err := NewLocalDiscovery()
+	if err != nil {
+		t.Fatalf("Failed to create discovery: %v", err)
+	}
+
+	node, err := NewNodeService(listener.Addr().String(), discovery)
+	if err != nil {
+		t.Fatalf("Failed to create node: %v", err)
+	}
+
+	server := grpc.NewServer(grpc.UnaryInterceptor(NodeErrorInterceptor))
+	pb.RegisterUltrabusNodeServer(server, node)
+	go server.Serve(listener)
+
+	return node.(*NodeService), discovery, server.Stop
+}
+
+func TestNodeTopicLifecycle(t *testing.T) {
+	assert := assert.New(t)
+
+	discovery, err := NewLocalDiscovery()
+	assert.Nil(err)
+
+	node, err := NewNodeService("node:10000", discovery)
+	assert.Nil(err)
+
+	meta := &pb.TopicMeta{
+		Topic:      "orders",
+		Partitions: 2,
+		Replicas:   1,
+		Config:     map[string]string{"retention.ms": "1000"}}
+
+	// The node advertises itself in the background
+	eventually(t, func() error {
+		_, err := node.CreateTopic(
+			context.Background(), &pb.CreateTopicRequest{Meta: meta})
+		return err
+	})
+
+	_, err = node.CreateTopic(
+		context.Background(), &pb.CreateTopicRequest{Meta: meta})
+	assert.IsType(&TopicExistsError{}, err)
+
+	_, err = node.CreateTopic(context.Background(), &pb.CreateTopicRequest{
+		Meta: &pb.TopicMeta{Topic: "empty"}})
+	assert.IsType(&InvalidTopicError{}, err)
+
+	stored, err := discovery.GetTopic("orders")
+	assert.Nil(err)
+	assert.Equal(meta.Config, stored.Config)
+
+	_, err = discovery.GetTopic("missing")
+	assert.IsType(&TopicNotFoundError{}, err)
+
+	response, err := node.Publish(context.Background(), &pb.PublishRequest{
+		PartitionID: &pb.PartitionID{Topic: "orders", Partition: 1},
+		Messages:    []*pb.Message{{Value: []byte("value")}}})
+	assert.Nil(err)
+	assert.Equal([]int64{0}, response.Offsets)
+
+	_, err = node.Publish(context.Background(), &pb.PublishRequest{
+		PartitionID: &pb.PartitionID{Topic: "orders", Partition: 2}})
+	assert.IsType(&PartitionNotFoundError{}, err)
+
+	_, err = node.Publish(context.Background(), &pb.PublishRequest{
+		PartitionID: &pb.PartitionID{Topic: "missing", Partition: 0}})
+	assert.IsType(&TopicNotFoundError{}, err)
+
+	altered, err := node.AlterTopic(context.Background(),
+		&pb.AlterTopicRequest{Topic: "orders", Partitions: 3})
+	assert.Nil(err)
+	assert.Equal(int32(3), altered.Meta.Partitions)
+	assert.Equal(meta.Config, altered.Meta.Config)
+
+	_, err = node.AlterTopic(context.Background(),
+		&pb.AlterTopicRequest{Topic: "orders", Partitions: 2})
+	assert.IsType(&InvalidTopicError{}, err)
+
+	_, err = node.SetTopicConfig(context.Background(),
+		&pb.SetTopicConfigRequest{
+			Topic: "orders", Set: map[string]string{ConfigKeyed: "maybe"}})
+	assert.IsType(&InvalidConfigError{}, err)
+
+	_, err = node.SetTopicConfig(context.Background(),
+		&pb.SetTopicConfigRequest{
+			Topic: "orders",
+			Set:   map[string]string{ConfigMaxMessageBytes: "4"},
+			Unset: []string{ConfigRetentionMs}})
+	assert.Nil(err)
+
+	config, err := node.GetTopicConfig(
+		context.Background(), &pb.GetTopicConfigRequest{Topic: "orders"})
+	assert.Nil(err)
+	assert.Equal(map[string]string{ConfigMaxMessageBytes: "4"}, config.Overrides)
+	assert.Equal("-1", config.Effective[ConfigRetentionMs])
+
+	_, err = node.Publish(context.Background(), &pb.PublishRequest{
+		PartitionID: &pb.PartitionID{Topic: "orders", Partition: 0},
+		Messages:    []*pb.Message{{Value: []byte("value")}}})
+	assert.IsType(&MessageTooLargeError{}, err)
+
+	listed, err := node.ListTopics(
+		context.Background(), &pb.ListTopicsRequest{})
+	assert.Nil(err)
+	assert.Equal(1, len(listed.Topics))
+
+	described, err := node.DescribeTopic(
+		context.Background(), &pb.DescribeTopicRequest{Topic: "orders"})
+	assert.Nil(err)
+	assert.Equal(3, len(described.Partitions))
+	assert.Equal("node:10000", described.Partitions[1].Leader)
+	assert.Equal([]string{"node:10000"}, described.Partitions[1].Isr)
+	assert.Equal(int64(0), described.Partitions[1].LastOffset)
+	assert.Equal(int64(5), described.Partitions[1].Size)
+
+	_, err = node.DeleteTopic(
+		context.Background(), &pb.DeleteTopicRequest{Topic: "orders"})
+	assert.Nil(err)
+
+	_, err = discovery.GetTopic("orders")
+	assert.IsType(&TopicNotFoundError{}, err)
+
+	_, err = node.DeleteTopic(
+		context.Background(), &pb.DeleteTopicRequest{Topic: "orders"})
+	assert.IsType(&TopicNotFoundError{}, err)
+}
+
+func TestNodeTransactions(t *testing.T) {
+	assert := assert.New(t)
+
+	discovery, err := NewLocalDiscovery()
+	assert.Nil(err)
+
+	service, err := NewNodeService("node:10000", discovery)
+	assert.Nil(err)
+	node := service.(*NodeService)
+
+	eventually(t, func() error {
+		_, err := node.CreateTopic(context.Background(), &pb.CreateTopicRequest{
+			Meta: &pb.TopicMeta{Topic: "orders", Partitions: 2, Replicas: 1}})
+		return err
+	})
+
+	partitionIDs := []*pb.PartitionID{
+		{Topic: "orders", Partition: 0}, {Topic: "orders", Partition: 1}}
+	partition, err := node.partition(partitionIDs[0])
+	assert.Nil(err)
+
+	committed, uncommitted := newRecordingStream(), newRecordingStream()
+	_, err = partition.RegisterConsumer(&pb.SubscribeRequest{
+		ClientID:  &pb.ClientID{ConsumerID: "committed"},
+		Isolation: pb.IsolationLevel_READ_COMMITTED}, committed)
+	assert.Nil(err)
+	_, err = partition.RegisterConsumer(&pb.SubscribeRequest{
+		ClientID: &pb.ClientID{ConsumerID: "uncommitted"}}, uncommitted)
+	assert.Nil(err)
+
+	begin := func() *pb.ProducerSequence {
+		response, err := node.BeginTransaction(context.Background(),
+			&pb.BeginTransactionRequest{TransactionalID: "job"})
+		assert.Nil(err)
+
+		_, err = node.AddPartitionsToTransaction(context.Background(),
+			&pb.AddPartitionsToTransactionRequest{
+				TransactionalID: "job",
+				ProducerID:      response.Producer.ProducerID,
+				Epoch:           response.Producer.Epoch,
+				Partitions:      partitionIDs})
+		assert.Nil(err)
+
+		return response.Producer
+	}
+
+	publish := func(producer *pb.ProducerSequence, value string) {
+		for _, partitionID := range partitionIDs {
+			_, err := node.Publish(context.Background(), &pb.PublishRequest{
+				PartitionID: partitionID,
+				Messages:    []*pb.Message{{Value: []byte(value)}},
+				Producer:    producer})
+			assert.Nil(err)
+		}
+	}
+
+	// Held back from read-committed subscribers until committed
+	producer := begin()
+	publish(producer, "committed")
+	assert.Equal([]string{"committed"}, uncommitted.received())
+	assert.Nil(committed.received())
+
+	_, err = node.EndTransaction(context.Background(),
+		&pb.EndTransactionRequest{
+			TransactionalID: "job",
+			ProducerID:      producer.ProducerID,
+			Epoch:           producer.Epoch,
+			Commit:          true})
+	assert.Nil(err)
+	assert.Equal([]string{"committed"}, committed.received())
+
+	// Beginning again aborts what the last producer left open, and fences
+	// it off
+	producer = begin()
+	publish(producer, "aborted")
+	current := begin()
+	publish(nil, "plain")
+
+	_, err = node.Publish(context.Background(), &pb.PublishRequest{
+		PartitionID: partitionIDs[0],
+		Messages:    []*pb.Message{{Value: []byte("zombie")}},
+		Producer:    producer})
+	assert.IsType(&ProducerFencedError{}, err)
+
+	_, err = node.BeginTransaction(context.Background(),
+		&pb.BeginTransactionRequest{TransactionalID: "job", Producer: producer})
+	assert.IsType(&ProducerFencedError{}, err)
+
+	_, err = node.AddPartitionsToTransaction(context.Background(),
+		&pb.AddPartitionsToTransactionRequest{
+			TransactionalID: "job",
+			ProducerID:      producer.ProducerID,
+			Epoch:           producer.Epoch,
+			Partitions:      partitionIDs})
+	assert.IsType(&ProducerFencedError{}, err)
+
+	assert.Equal(producer.ProducerID, current.ProducerID)
+	assert.True(current.Epoch > producer.Epoch)
+
+	assert.Equal([]string{"plain"}, committed.received())
+	assert.Equal([]string{"aborted", "plain"}, uncommitted.received())
+
+	// Aborted messages are recovered from the markers in the log
+	recovered := NewPartition(partition.log, partition.offsetLog)
+	defer recovered.Stop()
+
+	recoveredStream := newRecordingStream()
+	_, err = recovered.RegisterConsumer(&pb.SubscribeRequest{
+		ClientID:  &pb.ClientID{ConsumerID: "recovered"},
+		Isolation: pb.IsolationLevel_READ_COMMITTED,
+		Filter:    &pb.SubscriptionFilter{Offsets: &pb.OffsetRange{}}},
+		recoveredStream)
+	assert.Nil(err)
+	recovered.notifyAll()
+	assert.Equal([]string{"committed", "plain"}, recoveredStream.received())
+
+	_, err = node.EndTransaction(context.Background(),
+		&pb.EndTransactionRequest{
+			TransactionalID: "job",
+			ProducerID:      producer.ProducerID,
+			Epoch:           producer.Epoch})
+	assert.IsType(&ProducerFencedError{}, err)
+
+	_, err = node.EndTransaction(context.Background(),
+		&pb.EndTransactionRequest{
+			TransactionalID: "job",
+			ProducerID:      current.ProducerID,
+			Epoch:           current.Epoch})
+	assert.Nil(err)
+
+	_, err = node.EndTransaction(context.Background(),
+		&pb.EndTransactionRequest{
+			TransactionalID: "job",
+			ProducerID:      current.ProducerID,
+			Epoch:           current.Epoch})
+	assert.IsType(&InvalidTransactionError{}, err)
+}
+
+// Topic errors keep their type when the metadata is a remote node's.
+func TestRemoteMetadataErrors(t *testing.T) {
+	assert := assert.New(t)
+
+	node, _, stop := serveTestNode(t)
+	defer stop()
+
+	remote, err := NewSingleAddrDiscovery(node.serverAddr)
+	assert.Nil(err)
+
+	meta := &pb.TopicMeta{Topic: "orders", Partitions: 1, Replicas: 1}
+	assert.Nil(remote.CreateTopic(meta))
+	assert.Equal(&TopicExistsError{"orders"}, remote.CreateTopic(meta))
+	assert.Equal(
+		&TopicNotFoundError{"missing"}, remote.DeleteTopic("missing"))
+}
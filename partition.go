@@ -2,8 +2,11 @@ package ultrabus
 
 import (
 	"sync"
+	"time"
 
 	"github.com/emef/ultrabus/pb"
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/grpc/grpclog"
 )
 
 type ConnectionHandle struct {
@@ -14,6 +17,60 @@ type ConnectionHandle struct {
 	filter    MessageFilter
 	notify    chan interface{}
 	done      chan error
+	isolation pb.IsolationLevel
+	credits   *subscriptionCredits
+	batch     *subscriptionBatch
+
+	// Set in queue mode, which reads through the group's queue rather
+	// than cursor
+	queue *consumerQueue
+}
+
+// Caps on the messages, and bytes of them, sent in one Messages frame.
+const (
+	maxFrameMessages = 500
+	maxFrameBytes    = 1024 * 1024
+)
+
+// What a subscriber is ready to receive, see pb.Credits. Subscribers that
+// don't ask for flow control have unlimited credits.
+type subscriptionCredits struct {
+	lock      sync.Mutex
+	unlimited bool
+	messages  int64
+	bytes     int64
+}
+
+func newSubscriptionCredits(initial *pb.Credits) *subscriptionCredits {
+	if initial == nil {
+		return &subscriptionCredits{unlimited: true}
+	}
+
+	return &subscriptionCredits{
+		messages: initial.Messages, bytes: initial.Bytes}
+}
+
+func (credits *subscriptionCredits) available() bool {
+	credits.lock.Lock()
+	defer credits.lock.Unlock()
+
+	return credits.unlimited || (credits.messages > 0 && credits.bytes > 0)
+}
+
+func (credits *subscriptionCredits) spend(size int64) {
+	credits.lock.Lock()
+	defer credits.lock.Unlock()
+
+	credits.messages--
+	credits.bytes -= size
+}
+
+func (credits *subscriptionCredits) grant(granted *pb.Credits) {
+	credits.lock.Lock()
+	defer credits.lock.Unlock()
+
+	credits.messages += granted.Messages
+	credits.bytes += granted.Bytes
 }
 
 type Partition struct {
@@ -22,15 +79,116 @@ type Partition struct {
 	connections map[pb.ClientID]*ConnectionHandle
 	notify      chan interface{}
 	done        chan interface{}
+	config      *TopicConfig
+
+	// Idempotent producers by ID, see AppendFrom, and the transactions
+	// they have open or aborted, see EndTransaction
+	producerLock sync.Mutex
+	producers    map[string]*producerState
+	transactions map[string]*openTransaction
+	aborted      []*abortedTransaction
+
+	// The offsets consumer groups committed, see CommitOffset, and those
+	// waiting on the transaction of the producer that committed them,
+	// both kept in offsetLog apart from the messages
+	offsetLog      MessageLog
+	offsets        map[string]int64
+	pendingOffsets map[string]map[string]int64
+
+	// Closed and replaced whenever the log changes, see Fetch
+	appended chan interface{}
+
+	// The queues of consumer groups subscribed in queue mode
+	queues map[string]*consumerQueue
+
+	// Delayed messages not yet released, see releaseDue
+	scheduleLock sync.Mutex
+	scheduled    messageSchedule
+
+	// Expired messages readers skipped, and the offset past the last each
+	// consumer group's readers counted, so that a message read again isn't
+	// counted again
+	expiredLock    sync.Mutex
+	expired        int64
+	expiredThrough map[string]int64
+}
+
+// How long a transaction may stay open on a partition before the
+// partition aborts it itself, in case its coordinator is gone. Longer
+// than coordinators allow, so only abandoned transactions are aborted.
+const partitionTransactionTimeout = 2 * transactionTimeout
+
+type openTransaction struct {
+	firstOffset int64
+	started     time.Time
+}
+
+// The offsets an aborted transaction spanned, its marker's included.
+type abortedTransaction struct {
+	producerID              string
+	firstOffset, lastOffset int64
+}
+
+// How many of an idempotent producer's latest sequences a partition
+// remembers the offsets of, and so how far back it recognizes a resent
+// batch.
+const producerStateMessages = 1024
+
+// What a partition remembers of an idempotent producer: its epoch and
+// the offsets of its latest contiguous sequences.
+type producerState struct {
+	epoch         int32
+	firstSequence int64
+	offsets       []int64
 }
 
 func NewInMemoryPartition() *Partition {
+	return NewPartition(NewInMemoryMessageLog(), NewInMemoryMessageLog())
+}
+
+// A partition over log, which may already hold messages, with the offsets
+// consumer groups commit kept in offsetLog. Idempotent producers are
+// remembered from the sequences kept in the log, so a replica taking over
+// as leader dedupes like the old leader did.
+func NewPartition(log MessageLog, offsetLog MessageLog) *Partition {
+	// Only the latest of each group's offsets is kept
+	offsetConfig := DefaultTopicConfig()
+	offsetConfig.CleanupPolicy = CleanupCompact
+	offsetLog.Configure(offsetConfig)
+
 	partition := &Partition{
 		sync.RWMutex{},
-		NewInMemoryMessageLog(),
+		log,
 		make(map[pb.ClientID]*ConnectionHandle),
 		make(chan interface{}, 1),
-		make(chan interface{}, 1)}
+		make(chan interface{}, 1),
+		DefaultTopicConfig(),
+		sync.Mutex{},
+		make(map[string]*producerState),
+		make(map[string]*openTransaction),
+		nil,
+		offsetLog,
+		make(map[string]int64),
+		make(map[string]map[string]int64),
+		make(chan interface{}),
+		make(map[string]*consumerQueue),
+		sync.Mutex{},
+		nil,
+		sync.Mutex{},
+		0,
+		make(map[string]int64)}
+
+	if err := partition.recoverProducers(); err != nil {
+		grpclog.Printf("Error recovering producers: %v", err)
+	}
+
+	if err := partition.recoverOffsets(); err != nil {
+		grpclog.Printf("Error recovering committed offsets: %v", err)
+	}
+
+	if err := partition.recoverSchedule(); err != nil {
+		grpclog.Printf("Error recovering delayed messages: %v", err)
+	}
 
 	go partition.loop()
 
@@ -38,28 +196,604 @@ func NewInMemoryPartition() *Partition {
 }
 
 func (partition *Partition) Stop() {
-	partition.lock.Lock()
-	defer partition.lock.Unlock()
-
+	partition.lock.RLock()
+	var clientIDs []pb.ClientID
 	for clientID := range partition.connections {
-		partition.unregisterConsumer(&clientID, &PartitionStoppedError{})
+		clientIDs = append(clientIDs, clientID)
+	}
+	partition.lock.RUnlock()
+
+	for i := range clientIDs {
+		partition.unregisterConsumer(&clientIDs[i], &PartitionStoppedError{})
 	}
 
 	close(partition.done)
-	close(partition.notify)
+}
+
+// Applies a topic's config to the partition from now on.
+func (partition *Partition) Configure(config *TopicConfig) {
+	partition.lock.Lock()
+	partition.config = config
+	partition.lock.Unlock()
+
+	partition.log.Configure(config)
+}
+
+func (partition *Partition) Config() *TopicConfig {
+	partition.lock.RLock()
+	defer partition.lock.RUnlock()
+
+	return partition.config
+}
+
+// Drops the messages the partition's config no longer keeps, and
+// aborts transactions left open for too long.
+func (partition *Partition) Clean() error {
+	partition.abortStaleTransactions(partitionTransactionTimeout)
+
+	if err := partition.log.Clean(); err != nil {
+		return err
+	}
+
+	if err := partition.offsetLog.Clean(); err != nil {
+		return err
+	}
+
+	firstOffset, err := partition.log.FirstOffset()
+	if err != nil {
+		if _, empty := err.(*EmptyLogError); empty {
+			return nil
+		}
+
+		return err
+	}
+
+	// Forget aborted transactions no longer in the log
+	partition.producerLock.Lock()
+	defer partition.producerLock.Unlock()
+
+	kept := partition.aborted[:0]
+	for _, aborted := range partition.aborted {
+		if aborted.lastOffset >= firstOffset {
+			kept = append(kept, aborted)
+		}
+	}
+	partition.aborted = kept
+
+	return nil
 }
 
 func (partition *Partition) Append(msg *pb.Message) (int64, error) {
+	maxSize := partition.Config().MaxMessageBytes
+	if size := messageSize(msg); size > maxSize {
+		return -1, &MessageTooLargeError{size, maxSize}
+	}
+
 	receipt := partition.log.Append(msg)
 	<-receipt.Done()
 
+	offset, err := receipt.Read()
+	if err == nil {
+		partition.schedule(msg, offset, nil)
+	}
+
 	// non-blocking notify
 	select {
 	case partition.notify <- nil:
 	default:
 	}
 
-	return receipt.Read()
+	return offset, err
+}
+
+// Appends an idempotent producer's messages, the first at
+// producer.Sequence and the rest in sequence after it. A batch appended
+// before is acknowledged with the offsets it was given then.
+func (partition *Partition) AppendFrom(
+	producer *pb.ProducerSequence, msgs []*pb.Message) ([]int64, error) {
+
+	maxSize := partition.Config().MaxMessageBytes
+	for _, msg := range msgs {
+		if size := messageSize(msg); size > maxSize {
+			return nil, &MessageTooLargeError{size, maxSize}
+		}
+	}
+
+	partition.producerLock.Lock()
+	defer partition.producerLock.Unlock()
+
+	state, err := partition.producer(producer)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(state.offsets) > 0 && len(msgs) > 0 {
+		last := state.lastSequence()
+		end := producer.Sequence + int64(len(msgs)) - 1
+
+		switch {
+		case end <= last && producer.Sequence >= state.firstSequence:
+			from := producer.Sequence - state.firstSequence
+			return append([]int64(nil),
+				state.offsets[from:from+int64(len(msgs))]...), nil
+
+		case end <= last:
+			return nil, &DuplicateSequenceError{
+				producer.ProducerID, producer.Sequence}
+
+		case producer.Sequence != last+1:
+			return nil, &OutOfOrderSequenceError{
+				producer.ProducerID, last + 1, producer.Sequence}
+		}
+	}
+
+	offsets := make([]int64, len(msgs))
+	for i, msg := range msgs {
+		sequence := &pb.ProducerSequence{
+			ProducerID:    producer.ProducerID,
+			Epoch:         producer.Epoch,
+			Sequence:      producer.Sequence + int64(i),
+			Transactional: producer.Transactional}
+
+		receipt := partition.log.AppendFrom(msg, sequence)
+		<-receipt.Done()
+
+		offset, err := receipt.Read()
+		if err != nil {
+			return nil, err
+		}
+
+		state.record(sequence.Sequence, offset)
+		offsets[i] = offset
+		partition.schedule(msg, offset, sequence)
+
+		if producer.Transactional {
+			partition.openTransaction(producer.ProducerID, offset)
+		}
+	}
+
+	// non-blocking notify
+	select {
+	case partition.notify <- nil:
+	default:
+	}
+
+	return offsets, nil
+}
+
+// Ends producer's open transaction by appending marker, COMMIT or
+// ABORT, and applies or drops the offsets it committed. Producers
+// without an open transaction are ignored, so markers may be written
+// again. The producer's earlier epochs are fenced off from then on.
+func (partition *Partition) EndTransaction(
+	producer *pb.ProducerSequence, marker pb.TransactionMarker) error {
+
+	partition.producerLock.Lock()
+	defer partition.producerLock.Unlock()
+
+	if _, err := partition.producer(producer); err != nil {
+		return err
+	}
+
+	// The offsets are settled ahead of the marker, as a transaction's
+	// outcome doesn't change once it's decided
+	producerID := producer.ProducerID
+	for group, offset := range partition.pendingOffsets[producerID] {
+		if marker == pb.TransactionMarker_COMMIT {
+			if err := partition.logOffset(group, offset, nil); err != nil {
+				return err
+			}
+
+			partition.offsets[group] = offset
+		}
+
+		if err := partition.logOffset(group, -1, producer); err != nil {
+			return err
+		}
+
+		delete(partition.pendingOffsets[producerID], group)
+	}
+
+	delete(partition.pendingOffsets, producerID)
+
+	if _, ok := partition.transactions[producerID]; ok {
+		return partition.appendMarker(producer, marker)
+	}
+
+	return nil
+}
+
+// Must hold the producer lock.
+func (partition *Partition) appendMarker(
+	producer *pb.ProducerSequence, marker pb.TransactionMarker) error {
+
+	producerID := producer.ProducerID
+	receipt := partition.log.AppendMarker(&pb.ProducerSequence{
+		ProducerID:    producerID,
+		Epoch:         producer.Epoch,
+		Transactional: true}, marker)
+	<-receipt.Done()
+
+	offset, err := receipt.Read()
+	if err != nil {
+		return err
+	}
+
+	partition.closeTransaction(producerID, marker, offset)
+
+	// non-blocking notify
+	select {
+	case partition.notify <- nil:
+	default:
+	}
+
+	return nil
+}
+
+// Records that group reads the partition from offset next. Offsets
+// committed by a transactional producer, nil for none, take effect once
+// its transaction commits.
+func (partition *Partition) CommitOffset(
+	group string, offset int64, producer *pb.ProducerSequence) error {
+
+	partition.producerLock.Lock()
+	defer partition.producerLock.Unlock()
+
+	if producer == nil {
+		if committed, ok := partition.offsets[group]; ok && committed == offset {
+			return nil
+		}
+
+		if err := partition.logOffset(group, offset, nil); err != nil {
+			return err
+		}
+
+		partition.offsets[group] = offset
+		return nil
+	}
+
+	if _, err := partition.producer(producer); err != nil {
+		return err
+	}
+
+	if err := partition.logOffset(group, offset, producer); err != nil {
+		return err
+	}
+
+	pending, ok := partition.pendingOffsets[producer.ProducerID]
+	if !ok {
+		pending = make(map[string]int64)
+		partition.pendingOffsets[producer.ProducerID] = pending
+	}
+
+	pending[group] = offset
+	return nil
+}
+
+// Keeps group's offset in the offset log: committed, or pending on
+// producer's transaction, or with producer and an offset of -1 settled by
+// the transaction's end. Records are keyed so that compaction keeps only
+// the latest of each. Must hold the producer lock.
+func (partition *Partition) logOffset(
+	group string, offset int64, producer *pb.ProducerSequence) error {
+
+	value, err := proto.Marshal(
+		&pb.OffsetCommit{ConsumerGroup: group, Offset: offset})
+	if err != nil {
+		return err
+	}
+
+	key := "group/" + group
+	if producer != nil {
+		key = "transaction/" + producer.ProducerID + "/" + group
+		producer = &pb.ProducerSequence{
+			ProducerID:    producer.ProducerID,
+			Epoch:         producer.Epoch,
+			Transactional: true}
+	}
+
+	receipt := partition.offsetLog.AppendFrom(
+		&pb.Message{Key: []byte(key), Value: value}, producer)
+	<-receipt.Done()
+
+	_, err = receipt.Read()
+	return err
+}
+
+// The offset group committed last, false if it hasn't committed one.
+func (partition *Partition) CommittedOffset(group string) (int64, bool) {
+	partition.producerLock.Lock()
+	defer partition.producerLock.Unlock()
+
+	offset, ok := partition.offsets[group]
+	return offset, ok
+}
+
+// Must hold the producer lock.
+func (partition *Partition) openTransaction(producerID string, offset int64) {
+	if _, ok := partition.transactions[producerID]; !ok {
+		partition.transactions[producerID] = &openTransaction{
+			firstOffset: offset,
+			started:     time.Now()}
+	}
+}
+
+// Must hold the producer lock.
+func (partition *Partition) closeTransaction(
+	producerID string, marker pb.TransactionMarker, markerOffset int64) {
+
+	transaction, ok := partition.transactions[producerID]
+	if !ok {
+		return
+	}
+
+	if marker == pb.TransactionMarker_ABORT {
+		partition.aborted = append(partition.aborted, &abortedTransaction{
+			producerID, transaction.firstOffset, markerOffset})
+	}
+
+	delete(partition.transactions, producerID)
+}
+
+func (partition *Partition) abortStaleTransactions(timeout time.Duration) {
+	partition.producerLock.Lock()
+	var stale []*pb.ProducerSequence
+	for producerID, transaction := range partition.transactions {
+		if time.Since(transaction.started) > timeout {
+			producer := &pb.ProducerSequence{ProducerID: producerID}
+			if state, ok := partition.producers[producerID]; ok {
+				producer.Epoch = state.epoch
+			}
+
+			stale = append(stale, producer)
+		}
+	}
+	partition.producerLock.Unlock()
+
+	for _, producer := range stale {
+		err := partition.EndTransaction(producer, pb.TransactionMarker_ABORT)
+		if err != nil {
+			grpclog.Printf("Error aborting stale transaction: %v", err)
+		}
+	}
+}
+
+// The first offset read-committed subscribers may not read yet: that of
+// the earliest message of a transaction still open, or else the end of
+// the log, read under the producer lock so that transactional messages
+// past it can't be mistaken for plain ones. -1 while the log is empty.
+func (partition *Partition) stableOffset() int64 {
+	partition.producerLock.Lock()
+	defer partition.producerLock.Unlock()
+
+	last, err := partition.log.LastOffset()
+	if err != nil {
+		return -1
+	}
+
+	stable := last + 1
+	for _, transaction := range partition.transactions {
+		if transaction.firstOffset < stable {
+			stable = transaction.firstOffset
+		}
+	}
+
+	return stable
+}
+
+// Whether msg belongs to an aborted transaction.
+func (partition *Partition) isAborted(msg *pb.MessageWithOffset) bool {
+	if msg.Producer == nil || !msg.Producer.Transactional {
+		return false
+	}
+
+	partition.producerLock.Lock()
+	defer partition.producerLock.Unlock()
+
+	for _, aborted := range partition.aborted {
+		if aborted.producerID == msg.Producer.ProducerID &&
+			aborted.firstOffset <= msg.Offset &&
+			msg.Offset <= aborted.lastOffset {
+			return true
+		}
+	}
+
+	return false
+}
+
+// The state of producer, fresh if it's new or has moved to a higher
+// epoch. Must hold the producer lock.
+func (partition *Partition) producer(
+	producer *pb.ProducerSequence) (*producerState, error) {
+
+	state, ok := partition.producers[producer.ProducerID]
+	if ok && producer.Epoch < state.epoch {
+		return nil, &ProducerFencedError{
+			producer.ProducerID, producer.Epoch, state.epoch}
+	}
+
+	if !ok || producer.Epoch > state.epoch {
+		state = &producerState{epoch: producer.Epoch}
+		partition.producers[producer.ProducerID] = state
+	}
+
+	return state, nil
+}
+
+// Rebuilds what's known of idempotent producers from the log.
+func (partition *Partition) recoverProducers() error {
+	cursor, err := partition.log.CursorStart()
+	if err != nil {
+		return err
+	}
+
+	partition.producerLock.Lock()
+	defer partition.producerLock.Unlock()
+
+	for cursor.HasNext() {
+		msg, err := cursor.Next()
+		if err != nil {
+			return err
+		} else if msg.Producer == nil {
+			continue
+		} else if msg.Marker != pb.TransactionMarker_NO_MARKER {
+			partition.producer(msg.Producer)
+			partition.closeTransaction(
+				msg.Producer.ProducerID, msg.Marker, msg.Offset)
+			continue
+		}
+
+		state, err := partition.producer(msg.Producer)
+		if err == nil {
+			state.record(msg.Producer.Sequence, msg.Offset)
+		}
+
+		if msg.Producer.Transactional {
+			partition.openTransaction(msg.Producer.ProducerID, msg.Offset)
+		}
+	}
+
+	return nil
+}
+
+// Rebuilds the offsets consumer groups committed from the offset log,
+// those of transactions still open included.
+func (partition *Partition) recoverOffsets() error {
+	cursor, err := partition.offsetLog.CursorStart()
+	if err != nil {
+		return err
+	}
+
+	partition.producerLock.Lock()
+	defer partition.producerLock.Unlock()
+
+	for cursor.HasNext() {
+		msg, err := cursor.Next()
+		if err != nil {
+			return err
+		}
+
+		commit := &pb.OffsetCommit{}
+		if err := proto.Unmarshal(msg.Message.Value, commit); err != nil {
+			return err
+		}
+
+		if msg.Producer == nil {
+			partition.offsets[commit.ConsumerGroup] = commit.Offset
+			continue
+		}
+
+		producerID := msg.Producer.ProducerID
+		pending, ok := partition.pendingOffsets[producerID]
+		if !ok {
+			pending = make(map[string]int64)
+			partition.pendingOffsets[producerID] = pending
+		}
+
+		if commit.Offset < 0 {
+			delete(pending, commit.ConsumerGroup)
+		} else {
+			pending[commit.ConsumerGroup] = commit.Offset
+		}
+
+		if len(pending) == 0 {
+			delete(partition.pendingOffsets, producerID)
+		}
+	}
+
+	return nil
+}
+
+func (state *producerState) lastSequence() int64 {
+	return state.firstSequence + int64(len(state.offsets)) - 1
+}
+
+// Remembers sequence was appended at offset, starting afresh after a
+// gap, such as one left by compaction.
+func (state *producerState) record(sequence int64, offset int64) {
+	if len(state.offsets) == 0 || sequence != state.lastSequence()+1 {
+		state.firstSequence = sequence
+		state.offsets = state.offsets[:0]
+	}
+
+	state.offsets = append(state.offsets, offset)
+	if drop := len(state.offsets) - producerStateMessages; drop > 0 {
+		state.offsets = state.offsets[drop:]
+		state.firstSequence += int64(drop)
+	}
+}
+
+// Reads up to maxMessages starting at fromOffset, along with the
+// largest offset currently in the log (-1 if it is empty).
+func (partition *Partition) Read(
+	fromOffset int64,
+	maxMessages int32) ([]*pb.MessageWithOffset, int64, error) {
+
+	lastOffset, err := partition.log.LastOffset()
+	if err != nil {
+		switch err.(type) {
+		case *EmptyLogError:
+			return nil, -1, nil
+		default:
+			return nil, -1, err
+		}
+	}
+
+	if fromOffset > lastOffset {
+		return nil, lastOffset, nil
+	}
+
+	cursor, err := partition.log.CursorAt(fromOffset)
+	if err != nil {
+		return nil, lastOffset, err
+	}
+
+	var messages []*pb.MessageWithOffset
+	for cursor.HasNext() && int32(len(messages)) < maxMessages {
+		msgWithOffset, err := cursor.Next()
+		if err != nil {
+			return nil, lastOffset, err
+		}
+
+		messages = append(messages, msgWithOffset)
+	}
+
+	return messages, lastOffset, nil
+}
+
+// Describes the partition's log and consumers; topic-level fields like
+// the leader and replicas are left to the caller.
+func (partition *Partition) Describe() (*pb.PartitionDescription, error) {
+	description := &pb.PartitionDescription{
+		FirstOffset:    -1,
+		LastOffset:     -1,
+		Size:           partition.log.Size(),
+		ExpiredCleaned: partition.log.Expired()}
+
+	partition.expiredLock.Lock()
+	description.Expired = partition.expired
+	partition.expiredLock.Unlock()
+
+	lastOffset, err := partition.log.LastOffset()
+	if err == nil {
+		description.LastOffset = lastOffset
+		description.FirstOffset, err = partition.log.FirstOffset()
+		if err != nil {
+			return nil, err
+		}
+	} else if _, empty := err.(*EmptyLogError); !empty {
+		return nil, err
+	}
+
+	partition.lock.RLock()
+	defer partition.lock.RUnlock()
+
+	for clientID := range partition.connections {
+		clientID := clientID
+		description.Consumers = append(description.Consumers, &clientID)
+	}
+
+	return description, nil
 }
 
 func (partition *Partition) unregisterConsumer(
@@ -72,13 +806,31 @@ func (partition *Partition) unregisterConsumer(
 	if ok {
 		delete(partition.connections, *clientID)
 		handle.stop(err)
+
+		// Whatever the subscriber was leased goes to the rest of its group
+		if handle.queue != nil && handle.queue.release(partition, clientID) {
+			select {
+			case partition.notify <- nil:
+			default:
+			}
+		}
 	}
 }
 
 func (partition *Partition) RegisterConsumer(
-	clientID *pb.ClientID,
+	request *pb.SubscribeRequest,
 	stream pb.UltrabusNode_SubscribeServer) (*ConnectionHandle, error) {
 
+	clientID, spec := request.ClientID, request.Filter
+	if request.Queue != nil && spec != nil {
+		return nil, &InvalidFilterError{"queue subscriptions can't be filtered"}
+	}
+
+	filter, err := NewMessageFilter(spec, partition.Config().ValueSchema)
+	if err != nil {
+		return nil, err
+	}
+
 	partition.lock.RLock()
 	_, alreadyExists := partition.connections[*clientID]
 	partition.lock.RUnlock()
@@ -93,15 +845,43 @@ func (partition *Partition) RegisterConsumer(
 		return nil, err
 	}
 
+	// Consumer groups pick up from the offset they committed, and
+	// subscribers to a range from its start
+	offset, ok := partition.CommittedOffset(clientID.ConsumerGroup)
+	if spec != nil && spec.Offsets != nil {
+		offset, ok = spec.Offsets.Start, true
+	}
+
+	if ok && offset < cursor.Pos() {
+		if err := cursor.Seek(offset); err != nil {
+			return nil, err
+		}
+	}
+
+	// Queue members read through their group's queue, which starts where
+	// the first of them would have
+	var queue *consumerQueue
+	if request.Queue != nil {
+		queue = partition.queue(clientID.ConsumerGroup, cursor, request.Queue)
+		cursor = nil
+	}
+
 	handle := &ConnectionHandle{
 		partition,
 		clientID,
 		stream,
 		cursor,
-		&YesFilter{},
+		filter,
 		make(chan interface{}, 1),
-		make(chan error, 1)}
+		make(chan error, 1),
+		request.Isolation,
+		newSubscriptionCredits(request.Credits),
+		newSubscriptionBatch(request.Batch),
+		queue}
 
+	// Picking up behind the end of the log, there's already something to
+	// deliver
+	handle.notify <- nil
 	go handle.loop()
 
 	partition.lock.Lock()
@@ -111,14 +891,243 @@ func (partition *Partition) RegisterConsumer(
 	return handle, nil
 }
 
+// Adds to the credits of clientID's subscription.
+func (partition *Partition) GrantCredits(
+	clientID *pb.ClientID, credits *pb.Credits) error {
+
+	partition.lock.RLock()
+	handle, ok := partition.connections[*clientID]
+	partition.lock.RUnlock()
+
+	if !ok {
+		return &SubscriptionNotFoundError{clientID}
+	}
+
+	handle.credits.grant(credits)
+
+	// non-blocking notify
+	select {
+	case handle.notify <- nil:
+	default:
+	}
+
+	return nil
+}
+
+// Reads from cursor into batch what reader, with filter, isolation and
+// credits receives, until the batch is full or has what batch mode waits
+// for, or there's nothing more the reader may read yet.
+func (partition *Partition) readInto(
+	reader *pb.ClientID,
+	batch *subscriptionBatch,
+	cursor MessageLogCursor,
+	filter MessageFilter,
+	isolation pb.IsolationLevel,
+	credits *subscriptionCredits) error {
+
+	committed := isolation == pb.IsolationLevel_READ_COMMITTED
+	stable := partition.stableOffset()
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+
+	for cursor.HasNext() && !batch.full() && !batch.reached() {
+		if committed && cursor.Pos() >= stable {
+			break
+		}
+
+		// Out of credits the log is left alone until more are granted
+		if !credits.available() {
+			break
+		}
+
+		msgWithOffset, err := cursor.Next()
+		if err != nil {
+			return err
+		}
+
+		if committed && msgWithOffset.Offset >= stable {
+			// Skipped past the stable offset over a gap; come back to it
+			if err := cursor.Seek(msgWithOffset.Offset); err != nil {
+				return err
+			}
+
+			break
+		} else if msgWithOffset.Marker != pb.TransactionMarker_NO_MARKER {
+			continue
+		} else if isScheduled(msgWithOffset.Message) {
+			continue
+		} else if committed && partition.isAborted(msgWithOffset) {
+			continue
+		} else if isExpired(msgWithOffset.Message, now) {
+			// Counted only if the reader would have had it
+			applies, err := filter.Applies(msgWithOffset)
+			if err == nil && applies {
+				partition.countExpired(reader, msgWithOffset.Offset)
+			}
+
+			continue
+		}
+
+		// Failures go to the reader rather than end the read
+		applies, err := filter.Applies(msgWithOffset)
+		if err != nil {
+			batch.hold(nil, &pb.FilterError{
+				Offset: msgWithOffset.Offset, Error: err.Error()})
+		} else if applies {
+			size := messageSize(msgWithOffset.Message)
+			batch.hold(msgWithOffset, nil)
+			credits.spend(size)
+		}
+	}
+
+	return nil
+}
+
+// Reads what request asks for, waiting up to its maxWaitMs for anything
+// to arrive when there's nothing yet, or until cancel closes.
+func (partition *Partition) Fetch(
+	request *pb.FetchRequest,
+	cancel <-chan struct{}) (*pb.FetchResponse, error) {
+
+	cursor, err := partition.log.CursorEnd()
+	if err != nil {
+		return nil, err
+	}
+
+	// Like subscribers, consumer groups without an offset read from their
+	// committed offset, or from the end of the log
+	offset := request.Offset
+	if offset < 0 {
+		committed, ok := partition.CommittedOffset(
+			request.ClientID.GetConsumerGroup())
+		if !ok && request.Earliest {
+			start, err := partition.log.CursorStart()
+			if err != nil {
+				return nil, err
+			}
+
+			committed = start.Pos()
+		} else if !ok {
+			committed = cursor.Pos()
+		}
+
+		offset = committed
+	}
+
+	if offset > cursor.Pos() {
+		return nil, &OffsetOutOfBoundsError{offset, cursor.Pos() - 1}
+	} else if offset < cursor.Pos() {
+		if err := cursor.Seek(offset); err != nil {
+			return nil, err
+		}
+	}
+
+	limits := &pb.Credits{Messages: maxFrameMessages, Bytes: maxFrameBytes}
+	if request.MaxMessages > 0 && int64(request.MaxMessages) < limits.Messages {
+		limits.Messages = int64(request.MaxMessages)
+	}
+
+	if request.MaxBytes > 0 && request.MaxBytes < limits.Bytes {
+		limits.Bytes = request.MaxBytes
+	}
+
+	// Clients fetching without an ID count expired messages as one
+	reader := request.ClientID
+	if reader == nil {
+		reader = &pb.ClientID{}
+	}
+
+	batch := newSubscriptionBatch(nil)
+	credits := newSubscriptionCredits(limits)
+	wait := time.NewTimer(time.Duration(request.MaxWaitMs) * time.Millisecond)
+	defer wait.Stop()
+
+	for {
+		// Taken before reading so that no append in between is missed
+		appended := partition.appendedSignal()
+
+		err := partition.readInto(reader,
+			batch, cursor, &YesFilter{}, request.Isolation, credits)
+		if err != nil {
+			return nil, err
+		} else if !batch.empty() {
+			break
+		}
+
+		select {
+		case <-appended:
+			continue
+		case <-wait.C:
+		case <-cancel:
+		case <-partition.done:
+			return nil, &PartitionStoppedError{}
+		}
+
+		break
+	}
+
+	return &pb.FetchResponse{
+		Messages: batch.take(), NextOffset: cursor.Pos()}, nil
+}
+
+// Closed once the partition's log next changes.
+func (partition *Partition) appendedSignal() chan interface{} {
+	partition.lock.RLock()
+	defer partition.lock.RUnlock()
+
+	return partition.appended
+}
+
+// Wakes fetches waiting on appendedSignal.
+func (partition *Partition) wakeFetches() {
+	partition.lock.Lock()
+	defer partition.lock.Unlock()
+
+	close(partition.appended)
+	partition.appended = make(chan interface{})
+}
+
+// Counts that reader skipped the expired message at offset, unless its
+// consumer group has counted it before; readers outside any group count
+// as one group. A nil reader reads each message once.
+func (partition *Partition) countExpired(reader *pb.ClientID, offset int64) {
+	partition.expiredLock.Lock()
+	defer partition.expiredLock.Unlock()
+
+	if reader != nil {
+		group := reader.ConsumerGroup
+		if offset < partition.expiredThrough[group] {
+			return
+		}
+
+		partition.expiredThrough[group] = offset + 1
+	}
+
+	partition.expired++
+}
+
 func (partition *Partition) loop() {
+	leases := time.NewTicker(leaseCheckInterval)
+	defer leases.Stop()
+
+	due := time.NewTicker(scheduleCheckInterval)
+	defer due.Stop()
+
 	for {
 		select {
 		case <-partition.notify:
 			partition.notifyAll()
+			partition.wakeFetches()
+
+		case <-leases.C:
+			if partition.expireLeases() {
+				partition.notifyAll()
+			}
+
+		case <-due.C:
+			partition.releaseDue()
 
 		case <-partition.done:
-			break
+			return
 		}
 	}
 }
@@ -147,32 +1156,160 @@ func (handle *ConnectionHandle) stop(err error) {
 }
 
 func (handle *ConnectionHandle) loop() {
+	defer handle.batch.stopTimer()
+
 	for {
 		select {
-		case <-handle.notify:
-			var messages []*pb.MessageWithOffset
-			for handle.cursor.HasNext() {
-				msgWithOffset, err := handle.cursor.Next()
-				if err != nil {
-					handle.partition.unregisterConsumer(handle.clientID, err)
-					return
-				}
-
-				if handle.filter.Applies(msgWithOffset) {
-					messages = append(messages, msgWithOffset)
-				}
-			}
-
-			if len(messages) > 0 {
-				resp := &pb.Messages{messages}
-				if err := handle.stream.Send(resp); err != nil {
-					handle.partition.unregisterConsumer(handle.clientID, err)
-					return
-				}
+		case _, ok := <-handle.notify:
+			if !ok {
+				return
 			}
 
+		case <-handle.batch.tick:
 		case <-handle.done:
 			return
 		}
+
+		if err := handle.deliver(); err != nil {
+			handle.partition.unregisterConsumer(handle.clientID, err)
+			return
+		}
+	}
+}
+
+// Reads what the subscriber may receive into its batch and sends the
+// batch once it's ready.
+func (handle *ConnectionHandle) deliver() error {
+	batch := handle.batch
+
+	var err error
+	if handle.queue != nil {
+		err = handle.queue.leaseInto(handle.partition,
+			batch, handle.clientID, handle.isolation, handle.credits)
+	} else {
+		err = handle.partition.readInto(handle.clientID, batch,
+			handle.cursor, handle.filter, handle.isolation, handle.credits)
 	}
+
+	if err != nil {
+		return err
+	}
+
+	if batch.empty() {
+		return nil
+	}
+
+	// A batch that can't grow for want of credits goes as it is
+	if !batch.ready() && handle.credits.available() {
+		batch.startTimer()
+		return nil
+	}
+
+	full := batch.full()
+	if err := handle.stream.Send(batch.take()); err != nil {
+		return err
+	}
+
+	// Come back for the rest once the frame is sent
+	if full {
+		select {
+		case handle.notify <- nil:
+		default:
+		}
+	}
+
+	return nil
+}
+
+// The messages a subscription holds until they're sent in one frame. A
+// frame goes once it's full, or straight away unless the subscriber asked
+// for batch mode, see pb.BatchOptions.
+type subscriptionBatch struct {
+	options      *pb.BatchOptions
+	messages     []*pb.MessageWithOffset
+	filterErrors []*pb.FilterError
+	bytes        int64
+	since        time.Time
+
+	// Ticks when the batch has waited long enough
+	timer *time.Timer
+	tick  chan interface{}
+}
+
+func newSubscriptionBatch(options *pb.BatchOptions) *subscriptionBatch {
+	return &subscriptionBatch{
+		options: options, tick: make(chan interface{}, 1)}
+}
+
+func (batch *subscriptionBatch) hold(
+	message *pb.MessageWithOffset, filterError *pb.FilterError) {
+
+	if batch.empty() {
+		batch.since = time.Now()
+	}
+
+	if message != nil {
+		batch.messages = append(batch.messages, message)
+		batch.bytes += messageSize(message.Message)
+	} else {
+		batch.filterErrors = append(batch.filterErrors, filterError)
+	}
+}
+
+func (batch *subscriptionBatch) empty() bool {
+	return len(batch.messages) == 0 && len(batch.filterErrors) == 0
+}
+
+func (batch *subscriptionBatch) full() bool {
+	return len(batch.messages)+len(batch.filterErrors) >= maxFrameMessages ||
+		batch.bytes >= maxFrameBytes
+}
+
+// Whether the batch has the messages or bytes batch mode waits for.
+func (batch *subscriptionBatch) reached() bool {
+	options := batch.options
+	return options != nil &&
+		((options.MinMessages > 0 &&
+			int32(len(batch.messages)) >= options.MinMessages) ||
+			(options.MaxBytes > 0 && batch.bytes >= options.MaxBytes))
+}
+
+func (batch *subscriptionBatch) ready() bool {
+	return batch.options == nil || batch.full() || batch.reached() ||
+		(batch.options.MaxWaitMs > 0 && time.Since(batch.since) >= batch.wait())
+}
+
+func (batch *subscriptionBatch) wait() time.Duration {
+	return time.Duration(batch.options.MaxWaitMs) * time.Millisecond
+}
+
+// Ticks once the batch has waited as long as batch mode allows.
+func (batch *subscriptionBatch) startTimer() {
+	if batch.timer != nil || batch.options.MaxWaitMs <= 0 {
+		return
+	}
+
+	batch.timer = time.AfterFunc(batch.wait()-time.Since(batch.since), func() {
+		select {
+		case batch.tick <- nil:
+		default:
+		}
+	})
+}
+
+func (batch *subscriptionBatch) stopTimer() {
+	if batch.timer != nil {
+		batch.timer.Stop()
+		batch.timer = nil
+	}
+}
+
+// The held messages as a frame, emptying the batch.
+func (batch *subscriptionBatch) take() *pb.Messages {
+	frame := &pb.Messages{
+		Messages: batch.messages, FilterErrors: batch.filterErrors}
+
+	batch.stopTimer()
+	batch.messages, batch.filterErrors, batch.bytes = nil, nil, 0
+	return frame
 }
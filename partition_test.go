@@ -0,0 +1,683 @@
+package ultrabus
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/emef/ultrabus/pb"
+	"github.com/golang/protobuf/proto"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIdempotentAppend(t *testing.T) {
+	assert := assert.New(t)
+
+	partition := NewInMemoryPartition()
+	defer partition.Stop()
+
+	messages := []*pb.Message{{Value: []byte("a")}, {Value: []byte("b")}}
+	producer := func(epoch int32, sequence int64) *pb.ProducerSequence {
+		return &pb.ProducerSequence{
+			ProducerID: "producer", Epoch: epoch, Sequence: sequence}
+	}
+
+	offsets, err := partition.AppendFrom(producer(0, 0), messages)
+	assert.Nil(err)
+	assert.Equal([]int64{0, 1}, offsets)
+
+	// A resent batch gets its old offsets and isn't appended again
+	offsets, err = partition.AppendFrom(producer(0, 0), messages)
+	assert.Nil(err)
+	assert.Equal([]int64{0, 1}, offsets)
+
+	_, err = partition.AppendFrom(producer(0, 3), messages)
+	assert.IsType(&OutOfOrderSequenceError{}, err)
+
+	offsets, err = partition.AppendFrom(producer(0, 2), messages[:1])
+	assert.Nil(err)
+	assert.Equal([]int64{2}, offsets)
+
+	// A new epoch starts over and fences off the old one
+	offsets, err = partition.AppendFrom(producer(1, 0), messages[:1])
+	assert.Nil(err)
+	assert.Equal([]int64{3}, offsets)
+
+	_, err = partition.AppendFrom(producer(0, 3), messages[:1])
+	assert.IsType(&ProducerFencedError{}, err)
+
+	// A partition taking over the log remembers the producer
+	recovered := NewPartition(partition.log, partition.offsetLog)
+	defer recovered.Stop()
+
+	offsets, err = recovered.AppendFrom(producer(1, 0), messages[:1])
+	assert.Nil(err)
+	assert.Equal([]int64{3}, offsets)
+
+	lastOffset, err := recovered.log.LastOffset()
+	assert.Nil(err)
+	assert.Equal(int64(3), lastOffset)
+}
+
+func TestCommittedOffsets(t *testing.T) {
+	assert := assert.New(t)
+
+	partition := NewInMemoryPartition()
+	defer partition.Stop()
+
+	for _, value := range []string{"a", "b", "c"} {
+		_, err := partition.Append(&pb.Message{Value: []byte(value)})
+		assert.Nil(err)
+	}
+
+	_, ok := partition.CommittedOffset("group")
+	assert.False(ok)
+
+	assert.Nil(partition.CommitOffset("group", 1, nil))
+	offset, ok := partition.CommittedOffset("group")
+	assert.True(ok)
+	assert.Equal(int64(1), offset)
+
+	// The group's subscribers pick up from its offset
+	stream := newRecordingStream()
+	_, err := partition.RegisterConsumer(&pb.SubscribeRequest{
+		ClientID: &pb.ClientID{ConsumerGroup: "group", ConsumerID: "a"}},
+		stream)
+	assert.Nil(err)
+	partition.notifyAll()
+	assert.Equal([]string{"b", "c"}, stream.received())
+
+	// Offsets committed in a transaction wait on its marker
+	for _, marker := range []pb.TransactionMarker{
+		pb.TransactionMarker_ABORT, pb.TransactionMarker_COMMIT} {
+
+		producer := &pb.ProducerSequence{
+			ProducerID: marker.String(), Transactional: true}
+		_, err = partition.AppendFrom(
+			producer, []*pb.Message{{Value: []byte("d")}})
+		assert.Nil(err)
+
+		assert.Nil(partition.CommitOffset("group", 3, producer))
+		offset, _ = partition.CommittedOffset("group")
+		assert.Equal(int64(1), offset)
+
+		assert.Nil(partition.EndTransaction(producer, marker))
+	}
+
+	offset, _ = partition.CommittedOffset("group")
+	assert.Equal(int64(3), offset)
+
+	// Offsets are kept in the log, and recovered with it along with those
+	// of transactions still open
+	open := &pb.ProducerSequence{ProducerID: "open", Transactional: true}
+	_, err = partition.AppendFrom(open, []*pb.Message{{Value: []byte("e")}})
+	assert.Nil(err)
+	assert.Nil(partition.CommitOffset("group", 4, open))
+	assert.Nil(partition.CommitOffset("other", 2, nil))
+
+	config := DefaultTopicConfig()
+	config.Retention = 0
+	partition.Configure(config)
+	assert.Nil(partition.Clean())
+
+	recovered := NewPartition(partition.log, partition.offsetLog)
+	defer recovered.Stop()
+
+	offset, _ = recovered.CommittedOffset("group")
+	assert.Equal(int64(3), offset)
+	offset, _ = recovered.CommittedOffset("other")
+	assert.Equal(int64(2), offset)
+
+	assert.Nil(recovered.EndTransaction(open, pb.TransactionMarker_COMMIT))
+	offset, _ = recovered.CommittedOffset("group")
+	assert.Equal(int64(4), offset)
+}
+
+func TestSubscriptionFilters(t *testing.T) {
+	assert := assert.New(t)
+
+	partition := NewInMemoryPartition()
+	defer partition.Stop()
+
+	messages := []*pb.Message{
+		{Key: []byte("user-1"), Value: []byte("a")},
+		{Key: []byte("user-22"), Value: []byte("b"),
+			Headers: map[string][]byte{"type": []byte("click")}},
+		{Key: []byte("order-3"), Value: []byte("c"),
+			Headers: map[string][]byte{"type": []byte("click")}},
+		{Key: []byte("user-4"), Value: []byte("d"),
+			Headers: map[string][]byte{"type": []byte("view")}},
+	}
+
+	for _, message := range messages {
+		_, err := partition.Append(message)
+		assert.Nil(err)
+	}
+
+	all := &pb.OffsetRange{}
+	for i, test := range []struct {
+		filter   *pb.SubscriptionFilter
+		expected []string
+	}{
+		{&pb.SubscriptionFilter{Offsets: all}, []string{"a", "b", "c", "d"}},
+		{&pb.SubscriptionFilter{KeyPrefix: []byte("user-"), Offsets: all},
+			[]string{"a", "b", "d"}},
+		{&pb.SubscriptionFilter{KeyRegex: `-\d$`, Offsets: all},
+			[]string{"a", "c", "d"}},
+		{&pb.SubscriptionFilter{
+			Headers: map[string][]byte{"type": []byte("click")}, Offsets: all},
+			[]string{"b", "c"}},
+		{&pb.SubscriptionFilter{
+			KeyPrefix: []byte("user-"),
+			Offsets:   &pb.OffsetRange{Start: 1, End: 3}},
+			[]string{"b"}},
+	} {
+		stream := newRecordingStream()
+		_, err := partition.RegisterConsumer(&pb.SubscribeRequest{
+			ClientID: &pb.ClientID{ConsumerID: strconv.Itoa(i)},
+			Filter:   test.filter}, stream)
+		assert.Nil(err)
+
+		partition.notifyAll()
+		assert.Equal(test.expected, stream.received(), "filter %v", i)
+	}
+
+	_, err := partition.RegisterConsumer(&pb.SubscribeRequest{
+		ClientID: &pb.ClientID{ConsumerID: "bad"},
+		Filter:   &pb.SubscriptionFilter{KeyRegex: "("}}, newRecordingStream())
+	assert.IsType(&InvalidFilterError{}, err)
+}
+
+func TestExpressionFilters(t *testing.T) {
+	assert := assert.New(t)
+
+	partition := NewInMemoryPartition()
+	defer partition.Stop()
+
+	config, err := ParseTopicConfig(
+		map[string]string{ConfigValueSchema: ValueSchemaJSON})
+	assert.Nil(err)
+	partition.Configure(config)
+
+	stream := newRecordingStream()
+	_, err = partition.RegisterConsumer(&pb.SubscribeRequest{
+		ClientID: &pb.ClientID{ConsumerID: "a"},
+		Filter: &pb.SubscriptionFilter{
+			Expression: `value.status == "FAILED" && value.amount > 100`}},
+		stream)
+	assert.Nil(err)
+
+	for _, value := range []string{
+		`{"status": "FAILED", "amount": 250}`,
+		`{"status": "FAILED", "amount": 50}`,
+		`not json`,
+		`{"status": "OK"}`,
+		`{"status": "FAILED"}`,
+		`{"status": "FAILED", "amount": 101}`,
+	} {
+		_, err := partition.Append(&pb.Message{Value: []byte(value)})
+		assert.Nil(err)
+	}
+
+	// Messages the filter fails on are reported and the stream goes on
+	assert.Equal([]string{
+		`{"status": "FAILED", "amount": 250}`,
+		`{"status": "FAILED", "amount": 101}`,
+	}, stream.received())
+
+	var failed []int64
+	for len(stream.filterErrors) > 0 {
+		failed = append(failed, (<-stream.filterErrors).Offset)
+	}
+	assert.Equal([]int64{2, 4}, failed)
+
+	_, err = partition.RegisterConsumer(&pb.SubscribeRequest{
+		ClientID: &pb.ClientID{ConsumerID: "b"},
+		Filter:   &pb.SubscriptionFilter{Expression: `value.status ==`}},
+		newRecordingStream())
+	assert.IsType(&InvalidFilterError{}, err)
+
+	// Protobuf values are read by their registered type
+	config, err = ParseTopicConfig(
+		map[string]string{ConfigValueSchema: "proto:pb.PartitionID"})
+	assert.Nil(err)
+	partition.Configure(config)
+
+	filter, err := NewMessageFilter(&pb.SubscriptionFilter{
+		Expression: `value.topic == "orders" && value.partition == 3`},
+		config.ValueSchema)
+	assert.Nil(err)
+
+	value, err := proto.Marshal(&pb.PartitionID{Topic: "orders", Partition: 3})
+	assert.Nil(err)
+	applies, err := filter.Applies(&pb.MessageWithOffset{
+		Message: &pb.Message{Value: value}})
+	assert.Nil(err)
+	assert.True(applies)
+
+	_, err = NewMessageFilter(
+		&pb.SubscriptionFilter{Expression: "true"}, "proto:pb.Unknown")
+	assert.IsType(&InvalidFilterError{}, err)
+}
+
+func TestSubscriptionCredits(t *testing.T) {
+	assert := assert.New(t)
+
+	partition := NewInMemoryPartition()
+	defer partition.Stop()
+
+	clientID := &pb.ClientID{ConsumerID: "a"}
+	stream := newRecordingStream()
+	_, err := partition.RegisterConsumer(&pb.SubscribeRequest{
+		ClientID: clientID,
+		Credits:  &pb.Credits{Messages: 2, Bytes: 3}}, stream)
+	assert.Nil(err)
+
+	for _, value := range []string{"a", "b", "c", "d", "e", "f"} {
+		_, err := partition.Append(&pb.Message{Value: []byte(value)})
+		assert.Nil(err)
+	}
+
+	assert.Equal([]string{"a", "b"}, stream.received())
+
+	assert.Nil(partition.GrantCredits(clientID, &pb.Credits{Messages: 1}))
+	assert.Equal([]string{"c"}, stream.received())
+
+	// Sending stops once either credit runs out
+	assert.Nil(partition.GrantCredits(clientID, &pb.Credits{Messages: 10}))
+	assert.Nil(stream.received())
+	assert.Nil(partition.GrantCredits(clientID, &pb.Credits{Bytes: 1}))
+	assert.Equal([]string{"d"}, stream.received())
+
+	err = partition.GrantCredits(&pb.ClientID{ConsumerID: "b"}, &pb.Credits{})
+	assert.IsType(&SubscriptionNotFoundError{}, err)
+
+	// Frames are capped however far behind a subscriber is
+	unlimited := newRecordingStream()
+	_, err = partition.RegisterConsumer(&pb.SubscribeRequest{
+		ClientID: &pb.ClientID{ConsumerID: "c"},
+		Filter:   &pb.SubscriptionFilter{Offsets: &pb.OffsetRange{}}},
+		unlimited)
+	assert.Nil(err)
+
+	for i := 0; i < maxFrameMessages; i++ {
+		_, err := partition.Append(&pb.Message{Value: []byte("g")})
+		assert.Nil(err)
+	}
+
+	assert.Len(unlimited.received(), maxFrameMessages+6)
+	for len(unlimited.frames) > 0 {
+		assert.True(<-unlimited.frames <= maxFrameMessages)
+	}
+}
+
+func TestSubscriptionBatches(t *testing.T) {
+	assert := assert.New(t)
+
+	partition := NewInMemoryPartition()
+	defer partition.Stop()
+
+	subscribe := func(consumerID string, batch *pb.BatchOptions) *recordingStream {
+		stream := newRecordingStream()
+		_, err := partition.RegisterConsumer(&pb.SubscribeRequest{
+			ClientID: &pb.ClientID{ConsumerID: consumerID},
+			Batch:    batch}, stream)
+		assert.Nil(err)
+		return stream
+	}
+
+	publish := func(values ...string) {
+		for _, value := range values {
+			_, err := partition.Append(&pb.Message{Value: []byte(value)})
+			assert.Nil(err)
+		}
+	}
+
+	// Held until there are enough messages
+	counted := subscribe("counted", &pb.BatchOptions{MinMessages: 3})
+	sized := subscribe("sized", &pb.BatchOptions{MaxBytes: 4})
+	publish("a", "b")
+	assert.Nil(counted.received())
+	assert.Nil(sized.received())
+
+	publish("c", "dd")
+	assert.Equal([]string{"a", "b", "c"}, counted.received())
+	assert.Equal(3, <-counted.frames)
+	assert.Equal([]string{"a", "b", "c", "dd"}, sized.received())
+	assert.Equal(4, <-sized.frames)
+
+	// Or until the first has waited long enough
+	timed := subscribe("timed", &pb.BatchOptions{MinMessages: 10, MaxWaitMs: 200})
+	publish("e", "f")
+	assert.Nil(timed.received())
+	time.Sleep(200 * time.Millisecond)
+	assert.Equal([]string{"e", "f"}, timed.received())
+	assert.Equal(2, <-timed.frames)
+}
+
+func TestFetch(t *testing.T) {
+	assert := assert.New(t)
+
+	partition := NewInMemoryPartition()
+	defer partition.Stop()
+
+	fetch := func(request *pb.FetchRequest) ([]string, int64) {
+		response, err := partition.Fetch(request, nil)
+		if !assert.Nil(err) {
+			return nil, -1
+		}
+
+		var values []string
+		for _, msg := range response.Messages.Messages {
+			values = append(values, string(msg.Message.Value))
+		}
+
+		return values, response.NextOffset
+	}
+
+	// Waits for messages to arrive
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		for _, value := range []string{"a", "bb", "c"} {
+			partition.Append(&pb.Message{Value: []byte(value)})
+		}
+	}()
+
+	values, next := fetch(&pb.FetchRequest{MaxMessages: 1, MaxWaitMs: 5000})
+	assert.Equal([]string{"a"}, values)
+	assert.Equal(int64(1), next)
+
+	values, next = fetch(&pb.FetchRequest{Offset: 1, MaxBytes: 1})
+	assert.Equal([]string{"bb"}, values)
+	assert.Equal(int64(2), next)
+
+	// Or gives up after the wait
+	values, next = fetch(&pb.FetchRequest{Offset: 3, MaxWaitMs: 50})
+	assert.Nil(values)
+	assert.Equal(int64(3), next)
+
+	_, err := partition.Fetch(&pb.FetchRequest{Offset: 4}, nil)
+	assert.IsType(&OffsetOutOfBoundsError{}, err)
+
+	// Consumer groups start from their committed offset
+	assert.Nil(partition.CommitOffset("group", 2, nil))
+	values, next = fetch(&pb.FetchRequest{
+		ClientID: &pb.ClientID{ConsumerGroup: "group"}, Offset: -1})
+	assert.Equal([]string{"c"}, values)
+	assert.Equal(int64(3), next)
+
+	cancel := make(chan struct{})
+	close(cancel)
+	response, err := partition.Fetch(
+		&pb.FetchRequest{Offset: 3, MaxWaitMs: 5000}, cancel)
+	assert.Nil(err)
+	assert.Empty(response.Messages.Messages)
+}
+
+func TestQueueSubscriptions(t *testing.T) {
+	assert := assert.New(t)
+
+	partition := NewInMemoryPartition()
+	defer partition.Stop()
+
+	a := &pb.ClientID{ConsumerGroup: "group", ConsumerID: "a"}
+	b := &pb.ClientID{ConsumerGroup: "group", ConsumerID: "b"}
+	options := &pb.QueueOptions{VisibilityTimeoutMs: 500, MaxDeliveries: 3}
+
+	subscribe := func(clientID *pb.ClientID) *recordingStream {
+		stream := newRecordingStream()
+		_, err := partition.RegisterConsumer(&pb.SubscribeRequest{
+			ClientID: clientID, Queue: options}, stream)
+		assert.Nil(err)
+		return stream
+	}
+
+	// Values leased to stream with their delivery counts
+	leased := func(stream *recordingStream) []string {
+		var deliveries []string
+		for {
+			select {
+			case msg := <-stream.messages:
+				deliveries = append(deliveries,
+					string(msg.Message.Value)+strconv.Itoa(int(msg.Delivery)))
+			case <-time.After(50 * time.Millisecond):
+				return deliveries
+			}
+		}
+	}
+
+	committed := func() int64 {
+		offset, _ := partition.CommittedOffset("group")
+		return offset
+	}
+
+	streamA := subscribe(a)
+	for _, value := range []string{"a", "b", "c"} {
+		_, err := partition.Append(&pb.Message{Value: []byte(value)})
+		assert.Nil(err)
+	}
+
+	assert.Equal([]string{"a1", "b1", "c1"}, leased(streamA))
+
+	// Nacked messages come back until they've been delivered too often
+	assert.Nil(partition.Ack(a, []int64{0}, []int64{1}, nil))
+	assert.Equal([]string{"b2"}, leased(streamA))
+	assert.Equal(int64(1), committed())
+
+	assert.Nil(partition.Ack(a, nil, []int64{1}, nil))
+	assert.Equal([]string{"b3"}, leased(streamA))
+	assert.Nil(partition.Ack(a, nil, []int64{1}, nil))
+	assert.Nil(leased(streamA))
+	assert.Equal(int64(2), committed())
+
+	// As do those not acked in time
+	time.Sleep(500 * time.Millisecond)
+	assert.Equal([]string{"c2"}, leased(streamA))
+
+	// And those leased to a member that leaves
+	streamB := subscribe(b)
+	partition.unregisterConsumer(a, nil)
+	assert.Equal([]string{"c3"}, leased(streamB))
+
+	assert.Nil(partition.Ack(a, []int64{2}, nil, nil))
+	assert.Equal(int64(2), committed())
+	assert.Nil(partition.Ack(b, []int64{2}, nil, nil))
+	assert.Equal(int64(3), committed())
+
+	// The group's offsets are kept apart from the messages
+	lastOffset, err := partition.log.LastOffset()
+	assert.Nil(err)
+	assert.Equal(int64(2), lastOffset)
+
+	_, err = partition.RegisterConsumer(&pb.SubscribeRequest{
+		ClientID: a,
+		Queue:    options,
+		Filter:   &pb.SubscriptionFilter{KeyPrefix: []byte("k")}},
+		newRecordingStream())
+	assert.IsType(&InvalidFilterError{}, err)
+
+	err = partition.Ack(
+		&pb.ClientID{ConsumerGroup: "other"}, []int64{0}, nil, nil)
+	assert.IsType(&SubscriptionNotFoundError{}, err)
+}
+
+func TestDelayedDelivery(t *testing.T) {
+	assert := assert.New(t)
+
+	in := func(delay time.Duration) int64 {
+		return time.Now().Add(delay).UnixNano() / int64(time.Millisecond)
+	}
+
+	log := NewInMemoryMessageLog()
+	partition := NewPartition(log, NewInMemoryMessageLog())
+
+	stream := newRecordingStream()
+	_, err := partition.RegisterConsumer(&pb.SubscribeRequest{
+		ClientID: &pb.ClientID{ConsumerID: "a"}}, stream)
+	assert.Nil(err)
+
+	for _, msg := range []*pb.Message{
+		{Value: []byte("later"), DeliverAt: in(200 * time.Millisecond)},
+		{Value: []byte("first")},
+		{Value: []byte("much later"), DeliverAt: in(time.Hour)},
+		{Value: []byte("second")},
+	} {
+		_, err := partition.Append(msg)
+		assert.Nil(err)
+	}
+
+	// Immediate messages keep their order
+	assert.Equal([]string{"first", "second"}, stream.received())
+
+	time.Sleep(300 * time.Millisecond)
+	assert.Equal([]string{"later"}, stream.received())
+
+	// Delayed messages in the log are scheduled again, unless released
+	partition.Stop()
+	recovered := NewPartition(log, NewInMemoryMessageLog())
+	defer recovered.Stop()
+
+	recovered.scheduleLock.Lock()
+	assert.Len(recovered.scheduled, 1)
+	assert.Equal(int64(2), recovered.scheduled[0].Offset)
+	recovered.scheduleLock.Unlock()
+
+	recoveredStream := newRecordingStream()
+	_, err = recovered.RegisterConsumer(&pb.SubscribeRequest{
+		ClientID: &pb.ClientID{ConsumerID: "b"},
+		Filter:   &pb.SubscriptionFilter{Offsets: &pb.OffsetRange{}}},
+		recoveredStream)
+	assert.Nil(err)
+	recovered.notifyAll()
+
+	assert.Equal([]string{"first", "second", "later"},
+		recoveredStream.received())
+
+	cursor, err := log.CursorAt(4)
+	assert.Nil(err)
+	msg, err := cursor.Next()
+	assert.Nil(err)
+	assert.Equal(int64(0), msg.Message.DeliverAt)
+	assert.Equal([]byte("0"), msg.Message.Headers[HeaderScheduledOffset])
+
+	// Aborted ones never are
+	producer := &pb.ProducerSequence{ProducerID: "p", Transactional: true}
+	_, err = recovered.AppendFrom(producer, []*pb.Message{
+		{Value: []byte("aborted"), DeliverAt: in(0)}})
+	assert.Nil(err)
+	assert.Nil(recovered.EndTransaction(producer, pb.TransactionMarker_ABORT))
+
+	time.Sleep(2 * scheduleCheckInterval)
+	assert.Nil(recoveredStream.received())
+}
+
+func TestExpiredMessages(t *testing.T) {
+	assert := assert.New(t)
+
+	in := func(delay time.Duration) int64 {
+		return time.Now().Add(delay).UnixNano() / int64(time.Millisecond)
+	}
+
+	partition := NewInMemoryPartition()
+	defer partition.Stop()
+
+	for _, msg := range []*pb.Message{
+		{Value: []byte("forever")},
+		{Value: []byte("expired"), ExpiresAt: in(-time.Second)},
+		{Value: []byte("fresh"), ExpiresAt: in(time.Hour)},
+	} {
+		_, err := partition.Append(msg)
+		assert.Nil(err)
+	}
+
+	// Each consumer group skips and counts the expired message, once
+	// however often its members read it
+	for _, clientID := range []*pb.ClientID{
+		{ConsumerGroup: "a", ConsumerID: "1"},
+		{ConsumerGroup: "b", ConsumerID: "1"},
+		{ConsumerGroup: "a", ConsumerID: "2"},
+	} {
+		stream := newRecordingStream()
+		_, err := partition.RegisterConsumer(&pb.SubscribeRequest{
+			ClientID: clientID,
+			Filter:   &pb.SubscriptionFilter{Offsets: &pb.OffsetRange{}}}, stream)
+		assert.Nil(err)
+		partition.notifyAll()
+
+		assert.Equal([]string{"forever", "fresh"}, stream.received())
+	}
+
+	for i := 0; i < 2; i++ {
+		response, err := partition.Fetch(&pb.FetchRequest{
+			ClientID: &pb.ClientID{ConsumerGroup: "c"}}, nil)
+		assert.Nil(err)
+		assert.Len(response.Messages.Messages, 2)
+	}
+
+	// Subscribers whose filter leaves it out don't count it
+	stream := newRecordingStream()
+	_, err := partition.RegisterConsumer(&pb.SubscribeRequest{
+		ClientID: &pb.ClientID{ConsumerGroup: "d"},
+		Filter: &pb.SubscriptionFilter{
+			Offsets: &pb.OffsetRange{}, KeyPrefix: []byte("k")}}, stream)
+	assert.Nil(err)
+	partition.notifyAll()
+	assert.Nil(stream.received())
+
+	description, err := partition.Describe()
+	assert.Nil(err)
+	assert.Equal(int64(3), description.Expired)
+	assert.Equal(int64(0), description.ExpiredCleaned)
+
+	// Those cleanup drops are counted apart
+	config := DefaultTopicConfig()
+	config.RetentionExpired = true
+	partition.Configure(config)
+	assert.Nil(partition.Clean())
+
+	description, err = partition.Describe()
+	assert.Nil(err)
+	assert.Equal(int64(3), description.Expired)
+	assert.Equal(int64(1), description.ExpiredCleaned)
+}
+
+// Passes on what a partition sends a subscriber.
+type recordingStream struct {
+	pb.UltrabusNode_SubscribeServer
+	messages     chan *pb.MessageWithOffset
+	filterErrors chan *pb.FilterError
+	frames       chan int
+}
+
+func newRecordingStream() *recordingStream {
+	return &recordingStream{
+		messages:     make(chan *pb.MessageWithOffset, 1000),
+		filterErrors: make(chan *pb.FilterError, 100),
+		frames:       make(chan int, 100)}
+}
+
+func (stream *recordingStream) Send(messages *pb.Messages) error {
+	stream.frames <- len(messages.Messages)
+	for _, filterError := range messages.FilterErrors {
+		stream.filterErrors <- filterError
+	}
+
+	for _, msg := range messages.Messages {
+		stream.messages <- msg
+	}
+
+	return nil
+}
+
+// The values of what's sent until nothing more arrives.
+func (stream *recordingStream) received() []string {
+	var values []string
+	for {
+		select {
+		case msg := <-stream.messages:
+			values = append(values, string(msg.Message.Value))
+		case <-time.After(50 * time.Millisecond):
+			return values
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package ultrabus
+
+import (
+	"fmt"
+
+	"github.com/emef/ultrabus/pb"
+)
+
+// Chooses the partition a keyed message is published to, in place of
+// the topic's key.hash. Keyless messages are spread by the topic's
+// keyless.partitioning instead.
+type Partitioner interface {
+	// A partition in [0, partitions) for message
+	Partition(topic string, message *pb.Message, partitions int32) (int32, error)
+}
+
+// Adapts a function to a Partitioner, for custom schemes.
+type PartitionerFunc func(
+	topic string, message *pb.Message, partitions int32) (int32, error)
+
+func (partition PartitionerFunc) Partition(
+	topic string, message *pb.Message, partitions int32) (int32, error) {
+
+	return partition(topic, message, partitions)
+}
+
+// Partitions by one of the KeyHash schemes regardless of the topic's
+// key.hash, md5 when Scheme is empty.
+type MD5Partitioner struct {
+	Scheme string
+}
+
+func (md5 *MD5Partitioner) Partition(
+	topic string, message *pb.Message, partitions int32) (int32, error) {
+
+	scheme := md5.Scheme
+	if scheme == "" {
+		scheme = KeyHashMD5
+	}
+
+	return HashToPartitionScheme(message, partitions, scheme)
+}
+
+// Partitions like Kafka's default partitioner does keyed records: the
+// murmur2 hash of the key with its sign bit cleared, modulo the
+// partition count. Producers sharing a topic's partition count with a
+// Kafka topic put each key in the same partition number.
+type Murmur2Partitioner struct{}
+
+func (*Murmur2Partitioner) Partition(
+	topic string, message *pb.Message, partitions int32) (int32, error) {
+
+	hash := murmur2(message.Key) & 0x7fffffff
+	return int32(hash % uint32(partitions)), nil
+}
+
+// Sends keys to the partitions another system assigned them. Keys
+// missing from Partitions go to Fallback, or fail if it's nil.
+type ExplicitPartitioner struct {
+	Partitions map[string]int32
+	Fallback   Partitioner
+}
+
+func (explicit *ExplicitPartitioner) Partition(
+	topic string, message *pb.Message, partitions int32) (int32, error) {
+
+	if partition, ok := explicit.Partitions[string(message.Key)]; ok {
+		return partition, nil
+	}
+
+	if explicit.Fallback == nil {
+		return -1, fmt.Errorf("No partition assigned to key %q", message.Key)
+	}
+
+	return explicit.Fallback.Partition(topic, message, partitions)
+}
+
+// Kafka's murmur2 (org.apache.kafka.common.utils.Utils.murmur2).
+func murmur2(data []byte) uint32 {
+	const (
+		seed = 0x9747b28c
+		m    = 0x5bd1e995
+		r    = 24
+	)
+
+	length := len(data)
+	h := uint32(seed) ^ uint32(length)
+
+	for i := 0; i+4 <= length; i += 4 {
+		k := uint32(data[i]) | uint32(data[i+1])<<8 |
+			uint32(data[i+2])<<16 | uint32(data[i+3])<<24
+		k *= m
+		k ^= k >> r
+		k *= m
+		h *= m
+		h ^= k
+	}
+
+	tail := data[length&^3:]
+	switch len(tail) {
+	case 3:
+		h ^= uint32(tail[2]) << 16
+		fallthrough
+	case 2:
+		h ^= uint32(tail[1]) << 8
+		fallthrough
+	case 1:
+		h ^= uint32(tail[0])
+		h *= m
+	}
+
+	h ^= h >> 13
+	h *= m
+	h ^= h >> 15
+
+	return h
+}
@@ -10,16 +10,59 @@ It is generated from these files:
 
 It has these top-level messages:
 	SubscribeRequest
+	QueueOptions
+	AckRequest
+	AckResponse
+	BatchOptions
+	FetchRequest
+	FetchResponse
+	Credits
+	GrantCreditsRequest
+	GrantCreditsResponse
+	SubscriptionFilter
+	OffsetRange
 	PublishRequest
+	ProducerSequence
+	BeginTransactionRequest
+	BeginTransactionResponse
+	AddPartitionsToTransactionRequest
+	AddPartitionsToTransactionResponse
+	EndTransactionRequest
+	EndTransactionResponse
+	WriteTransactionMarkerRequest
+	WriteTransactionMarkerResponse
+	CommitOffsetRequest
+	CommitOffsetResponse
 	PublishResponse
 	CreateTopicRequest
 	CreateTopicResponse
+	AlterTopicRequest
+	AlterTopicResponse
+	GetTopicConfigRequest
+	GetTopicConfigResponse
+	SetTopicConfigRequest
+	SetTopicConfigResponse
+	DeleteTopicRequest
+	DeleteTopicResponse
+	ListTopicsRequest
+	ListTopicsResponse
+	DescribeTopicRequest
+	DescribeTopicResponse
+	PartitionDescription
+	SyncRequest
+	SyncResponse
+	ApplyMetadataRequest
+	ApplyMetadataResponse
+	GetMetadataRequest
+	GetMetadataResponse
 	ClientID
 	PartitionID
 	TopicMeta
 	Messages
+	FilterError
 	Message
 	MessageWithOffset
+	OffsetCommit
 */
 package pb
 
@@ -43,9 +86,67 @@ var _ = math.Inf
 // proto package needs to be updated.
 const _ = proto.ProtoPackageIsVersion2 // please upgrade the proto package
 
+type IsolationLevel int32
+
+const (
+	// Every message, including those of open and aborted transactions
+	IsolationLevel_READ_UNCOMMITTED IsolationLevel = 0
+	// Messages up to the first still in an open transaction, leaving out
+	// those of aborted transactions
+	IsolationLevel_READ_COMMITTED IsolationLevel = 1
+)
+
+var IsolationLevel_name = map[int32]string{
+	0: "READ_UNCOMMITTED",
+	1: "READ_COMMITTED",
+}
+var IsolationLevel_value = map[string]int32{
+	"READ_UNCOMMITTED": 0,
+	"READ_COMMITTED":   1,
+}
+
+func (x IsolationLevel) String() string {
+	return proto.EnumName(IsolationLevel_name, int32(x))
+}
+func (IsolationLevel) EnumDescriptor() ([]byte, []int) { return fileDescriptor0, []int{0} }
+
+type TransactionMarker int32
+
+const (
+	TransactionMarker_NO_MARKER TransactionMarker = 0
+	TransactionMarker_COMMIT    TransactionMarker = 1
+	TransactionMarker_ABORT     TransactionMarker = 2
+)
+
+var TransactionMarker_name = map[int32]string{
+	0: "NO_MARKER",
+	1: "COMMIT",
+	2: "ABORT",
+}
+var TransactionMarker_value = map[string]int32{
+	"NO_MARKER": 0,
+	"COMMIT":    1,
+	"ABORT":     2,
+}
+
+func (x TransactionMarker) String() string {
+	return proto.EnumName(TransactionMarker_name, int32(x))
+}
+func (TransactionMarker) EnumDescriptor() ([]byte, []int) { return fileDescriptor0, []int{1} }
+
 type SubscribeRequest struct {
-	ClientID    *ClientID    `protobuf:"bytes,1,opt,name=clientID" json:"clientID,omitempty"`
-	PartitionID *PartitionID `protobuf:"bytes,2,opt,name=partitionID" json:"partitionID,omitempty"`
+	ClientID    *ClientID      `protobuf:"bytes,1,opt,name=clientID" json:"clientID,omitempty"`
+	PartitionID *PartitionID   `protobuf:"bytes,2,opt,name=partitionID" json:"partitionID,omitempty"`
+	Isolation   IsolationLevel `protobuf:"varint,3,opt,name=isolation,enum=pb.IsolationLevel" json:"isolation,omitempty"`
+	// Only the messages it lets through are sent, see NewMessageFilter
+	Filter *SubscriptionFilter `protobuf:"bytes,4,opt,name=filter" json:"filter,omitempty"`
+	// What the subscriber is ready for to begin with, topped up by
+	// GrantCredits. Unset for no flow control.
+	Credits *Credits `protobuf:"bytes,5,opt,name=credits" json:"credits,omitempty"`
+	// Set for batch mode, unset to be sent messages as they arrive
+	Batch *BatchOptions `protobuf:"bytes,6,opt,name=batch" json:"batch,omitempty"`
+	// Set to share the consumer group's messages out among its members
+	Queue *QueueOptions `protobuf:"bytes,7,opt,name=queue" json:"queue,omitempty"`
 }
 
 func (m *SubscribeRequest) Reset()                    { *m = SubscribeRequest{} }
@@ -67,316 +168,1894 @@ func (m *SubscribeRequest) GetPartitionID() *PartitionID {
 	return nil
 }
 
-type PublishRequest struct {
-	PartitionID *PartitionID `protobuf:"bytes,1,opt,name=partitionID" json:"partitionID,omitempty"`
-	Messages    []*Message   `protobuf:"bytes,3,rep,name=messages" json:"messages,omitempty"`
+func (m *SubscribeRequest) GetIsolation() IsolationLevel {
+	if m != nil {
+		return m.Isolation
+	}
+	return IsolationLevel_READ_UNCOMMITTED
 }
 
-func (m *PublishRequest) Reset()                    { *m = PublishRequest{} }
-func (m *PublishRequest) String() string            { return proto.CompactTextString(m) }
-func (*PublishRequest) ProtoMessage()               {}
-func (*PublishRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{1} }
-
-func (m *PublishRequest) GetPartitionID() *PartitionID {
+func (m *SubscribeRequest) GetFilter() *SubscriptionFilter {
 	if m != nil {
-		return m.PartitionID
+		return m.Filter
 	}
 	return nil
 }
 
-func (m *PublishRequest) GetMessages() []*Message {
+func (m *SubscribeRequest) GetCredits() *Credits {
 	if m != nil {
-		return m.Messages
+		return m.Credits
 	}
 	return nil
 }
 
-type PublishResponse struct {
-	Offsets []int64 `protobuf:"varint,1,rep,packed,name=offsets" json:"offsets,omitempty"`
+func (m *SubscribeRequest) GetBatch() *BatchOptions {
+	if m != nil {
+		return m.Batch
+	}
+	return nil
 }
 
-func (m *PublishResponse) Reset()                    { *m = PublishResponse{} }
-func (m *PublishResponse) String() string            { return proto.CompactTextString(m) }
-func (*PublishResponse) ProtoMessage()               {}
-func (*PublishResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{2} }
-
-func (m *PublishResponse) GetOffsets() []int64 {
+func (m *SubscribeRequest) GetQueue() *QueueOptions {
 	if m != nil {
-		return m.Offsets
+		return m.Queue
 	}
 	return nil
 }
 
-type CreateTopicRequest struct {
-	Meta *TopicMeta `protobuf:"bytes,1,opt,name=meta" json:"meta,omitempty"`
+// Queue mode: each message goes to one member of the consumer group at a
+// time, leased until the member acks it. Messages nacked, not acked within
+// visibilityTimeoutMs or leased to a member that leaves are delivered
+// again, up to maxDeliveries times. The group's committed offset follows
+// the first message it hasn't finished with. Queue subscriptions can't be
+// filtered.
+type QueueOptions struct {
+	// 0 for 30s
+	VisibilityTimeoutMs int64 `protobuf:"varint,1,opt,name=visibilityTimeoutMs" json:"visibilityTimeoutMs,omitempty"`
+	// 0 for no limit
+	MaxDeliveries int32 `protobuf:"varint,2,opt,name=maxDeliveries" json:"maxDeliveries,omitempty"`
+	// Where messages delivered maxDeliveries times go, with headers saying
+	// where from and why, see HeaderDeadLetterTopic. Unset to drop them.
+	DeadLetterTopic string `protobuf:"bytes,3,opt,name=deadLetterTopic" json:"deadLetterTopic,omitempty"`
 }
 
-func (m *CreateTopicRequest) Reset()                    { *m = CreateTopicRequest{} }
-func (m *CreateTopicRequest) String() string            { return proto.CompactTextString(m) }
-func (*CreateTopicRequest) ProtoMessage()               {}
-func (*CreateTopicRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{3} }
+func (m *QueueOptions) Reset()                    { *m = QueueOptions{} }
+func (m *QueueOptions) String() string            { return proto.CompactTextString(m) }
+func (*QueueOptions) ProtoMessage()               {}
+func (*QueueOptions) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{1} }
 
-func (m *CreateTopicRequest) GetMeta() *TopicMeta {
+func (m *QueueOptions) GetVisibilityTimeoutMs() int64 {
 	if m != nil {
-		return m.Meta
+		return m.VisibilityTimeoutMs
 	}
-	return nil
+	return 0
 }
 
-type CreateTopicResponse struct {
-	Ok bool `protobuf:"varint,1,opt,name=ok" json:"ok,omitempty"`
+func (m *QueueOptions) GetMaxDeliveries() int32 {
+	if m != nil {
+		return m.MaxDeliveries
+	}
+	return 0
 }
 
-func (m *CreateTopicResponse) Reset()                    { *m = CreateTopicResponse{} }
-func (m *CreateTopicResponse) String() string            { return proto.CompactTextString(m) }
-func (*CreateTopicResponse) ProtoMessage()               {}
-func (*CreateTopicResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{4} }
-
-func (m *CreateTopicResponse) GetOk() bool {
+func (m *QueueOptions) GetDeadLetterTopic() string {
 	if m != nil {
-		return m.Ok
+		return m.DeadLetterTopic
 	}
-	return false
+	return ""
 }
 
-type ClientID struct {
-	ConsumerGroup string `protobuf:"bytes,1,opt,name=consumerGroup" json:"consumerGroup,omitempty"`
-	ConsumerID    string `protobuf:"bytes,2,opt,name=consumerID" json:"consumerID,omitempty"`
+// Settles messages a queue subscriber was leased. Offsets it no longer
+// holds the lease of, such as ones it took too long over, are ignored.
+type AckRequest struct {
+	ClientID    *ClientID    `protobuf:"bytes,1,opt,name=clientID" json:"clientID,omitempty"`
+	PartitionID *PartitionID `protobuf:"bytes,2,opt,name=partitionID" json:"partitionID,omitempty"`
+	// Offsets of messages the subscriber is done with
+	Acks []int64 `protobuf:"varint,3,rep,packed,name=acks" json:"acks,omitempty"`
+	// Offsets of messages to deliver again
+	Nacks []int64 `protobuf:"varint,4,rep,packed,name=nacks" json:"nacks,omitempty"`
+	// Why nacked messages failed, by offset
+	Errors map[int64]string `protobuf:"bytes,5,rep,name=errors" json:"errors,omitempty" protobuf_key:"varint,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
 }
 
-func (m *ClientID) Reset()                    { *m = ClientID{} }
-func (m *ClientID) String() string            { return proto.CompactTextString(m) }
-func (*ClientID) ProtoMessage()               {}
-func (*ClientID) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{5} }
+func (m *AckRequest) Reset()                    { *m = AckRequest{} }
+func (m *AckRequest) String() string            { return proto.CompactTextString(m) }
+func (*AckRequest) ProtoMessage()               {}
+func (*AckRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{2} }
 
-func (m *ClientID) GetConsumerGroup() string {
+func (m *AckRequest) GetClientID() *ClientID {
 	if m != nil {
-		return m.ConsumerGroup
+		return m.ClientID
 	}
-	return ""
+	return nil
 }
 
-func (m *ClientID) GetConsumerID() string {
+func (m *AckRequest) GetPartitionID() *PartitionID {
 	if m != nil {
-		return m.ConsumerID
+		return m.PartitionID
 	}
-	return ""
+	return nil
 }
 
-type PartitionID struct {
-	Topic     string `protobuf:"bytes,1,opt,name=topic" json:"topic,omitempty"`
-	Partition int32  `protobuf:"varint,2,opt,name=partition" json:"partition,omitempty"`
+func (m *AckRequest) GetAcks() []int64 {
+	if m != nil {
+		return m.Acks
+	}
+	return nil
 }
 
-func (m *PartitionID) Reset()                    { *m = PartitionID{} }
-func (m *PartitionID) String() string            { return proto.CompactTextString(m) }
-func (*PartitionID) ProtoMessage()               {}
-func (*PartitionID) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{6} }
-
-func (m *PartitionID) GetTopic() string {
+func (m *AckRequest) GetNacks() []int64 {
 	if m != nil {
-		return m.Topic
+		return m.Nacks
 	}
-	return ""
+	return nil
 }
 
-func (m *PartitionID) GetPartition() int32 {
+func (m *AckRequest) GetErrors() map[int64]string {
 	if m != nil {
-		return m.Partition
+		return m.Errors
 	}
-	return 0
+	return nil
 }
 
-type TopicMeta struct {
-	Topic      string `protobuf:"bytes,1,opt,name=topic" json:"topic,omitempty"`
-	Partitions int32  `protobuf:"varint,2,opt,name=partitions" json:"partitions,omitempty"`
-	Replicas   int32  `protobuf:"varint,3,opt,name=replicas" json:"replicas,omitempty"`
+type AckResponse struct {
 }
 
-func (m *TopicMeta) Reset()                    { *m = TopicMeta{} }
-func (m *TopicMeta) String() string            { return proto.CompactTextString(m) }
-func (*TopicMeta) ProtoMessage()               {}
-func (*TopicMeta) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{7} }
+func (m *AckResponse) Reset()                    { *m = AckResponse{} }
+func (m *AckResponse) String() string            { return proto.CompactTextString(m) }
+func (*AckResponse) ProtoMessage()               {}
+func (*AckResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{3} }
+
+// Batch mode: a node holds messages for the subscriber until it has
+// minMessages of them, maxBytes of them or the first has waited maxWaitMs,
+// then sends them in one frame. Unset (0) thresholds don't apply; with
+// none set messages are held until a frame is full.
+type BatchOptions struct {
+	MinMessages int32 `protobuf:"varint,1,opt,name=minMessages" json:"minMessages,omitempty"`
+	MaxWaitMs   int64 `protobuf:"varint,2,opt,name=maxWaitMs" json:"maxWaitMs,omitempty"`
+	MaxBytes    int64 `protobuf:"varint,3,opt,name=maxBytes" json:"maxBytes,omitempty"`
+}
 
-func (m *TopicMeta) GetTopic() string {
+func (m *BatchOptions) Reset()                    { *m = BatchOptions{} }
+func (m *BatchOptions) String() string            { return proto.CompactTextString(m) }
+func (*BatchOptions) ProtoMessage()               {}
+func (*BatchOptions) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{4} }
+
+func (m *BatchOptions) GetMinMessages() int32 {
 	if m != nil {
-		return m.Topic
+		return m.MinMessages
 	}
-	return ""
+	return 0
 }
 
-func (m *TopicMeta) GetPartitions() int32 {
+func (m *BatchOptions) GetMaxWaitMs() int64 {
 	if m != nil {
-		return m.Partitions
+		return m.MaxWaitMs
 	}
 	return 0
 }
 
-func (m *TopicMeta) GetReplicas() int32 {
+func (m *BatchOptions) GetMaxBytes() int64 {
 	if m != nil {
-		return m.Replicas
+		return m.MaxBytes
 	}
 	return 0
 }
 
-type Messages struct {
-	Messages []*MessageWithOffset `protobuf:"bytes,1,rep,name=messages" json:"messages,omitempty"`
+// Reads a partition without holding a stream open. A node answers as soon
+// as it has anything to send, or after maxWaitMs with nothing.
+type FetchRequest struct {
+	// Only the consumer group is used, for where a negative offset reads from
+	ClientID    *ClientID    `protobuf:"bytes,1,opt,name=clientID" json:"clientID,omitempty"`
+	PartitionID *PartitionID `protobuf:"bytes,2,opt,name=partitionID" json:"partitionID,omitempty"`
+	// Negative for the consumer group's committed offset, or the end of the
+	// log when it has none
+	Offset int64 `protobuf:"varint,3,opt,name=offset" json:"offset,omitempty"`
+	// Caps on the messages and their bytes, 0 for the most a frame holds.
+	// The last message may overdraw maxBytes.
+	MaxMessages int32          `protobuf:"varint,4,opt,name=maxMessages" json:"maxMessages,omitempty"`
+	MaxBytes    int64          `protobuf:"varint,5,opt,name=maxBytes" json:"maxBytes,omitempty"`
+	MaxWaitMs   int64          `protobuf:"varint,6,opt,name=maxWaitMs" json:"maxWaitMs,omitempty"`
+	Isolation   IsolationLevel `protobuf:"varint,7,opt,name=isolation,enum=pb.IsolationLevel" json:"isolation,omitempty"`
+	// With a negative offset and no committed one, read from the start of
+	// the log rather than its end
+	Earliest bool `protobuf:"varint,8,opt,name=earliest" json:"earliest,omitempty"`
 }
 
-func (m *Messages) Reset()                    { *m = Messages{} }
-func (m *Messages) String() string            { return proto.CompactTextString(m) }
-func (*Messages) ProtoMessage()               {}
-func (*Messages) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{8} }
+func (m *FetchRequest) Reset()                    { *m = FetchRequest{} }
+func (m *FetchRequest) String() string            { return proto.CompactTextString(m) }
+func (*FetchRequest) ProtoMessage()               {}
+func (*FetchRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{5} }
 
-func (m *Messages) GetMessages() []*MessageWithOffset {
+func (m *FetchRequest) GetClientID() *ClientID {
 	if m != nil {
-		return m.Messages
+		return m.ClientID
 	}
 	return nil
 }
 
-type Message struct {
-	Key   []byte `protobuf:"bytes,1,opt,name=key,proto3" json:"key,omitempty"`
-	Value []byte `protobuf:"bytes,2,opt,name=value,proto3" json:"value,omitempty"`
-}
-
-func (m *Message) Reset()                    { *m = Message{} }
-func (m *Message) String() string            { return proto.CompactTextString(m) }
-func (*Message) ProtoMessage()               {}
-func (*Message) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{9} }
-
-func (m *Message) GetKey() []byte {
+func (m *FetchRequest) GetPartitionID() *PartitionID {
 	if m != nil {
-		return m.Key
+		return m.PartitionID
 	}
 	return nil
 }
 
-func (m *Message) GetValue() []byte {
+func (m *FetchRequest) GetOffset() int64 {
 	if m != nil {
-		return m.Value
+		return m.Offset
 	}
-	return nil
+	return 0
 }
 
-type MessageWithOffset struct {
-	Offset  int64    `protobuf:"varint,1,opt,name=offset" json:"offset,omitempty"`
-	Message *Message `protobuf:"bytes,2,opt,name=message" json:"message,omitempty"`
+func (m *FetchRequest) GetMaxMessages() int32 {
+	if m != nil {
+		return m.MaxMessages
+	}
+	return 0
 }
 
-func (m *MessageWithOffset) Reset()                    { *m = MessageWithOffset{} }
-func (m *MessageWithOffset) String() string            { return proto.CompactTextString(m) }
-func (*MessageWithOffset) ProtoMessage()               {}
-func (*MessageWithOffset) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{10} }
-
-func (m *MessageWithOffset) GetOffset() int64 {
+func (m *FetchRequest) GetMaxBytes() int64 {
 	if m != nil {
-		return m.Offset
+		return m.MaxBytes
 	}
 	return 0
 }
 
-func (m *MessageWithOffset) GetMessage() *Message {
+func (m *FetchRequest) GetMaxWaitMs() int64 {
 	if m != nil {
-		return m.Message
+		return m.MaxWaitMs
 	}
-	return nil
+	return 0
 }
 
-func init() {
-	proto.RegisterType((*SubscribeRequest)(nil), "pb.SubscribeRequest")
-	proto.RegisterType((*PublishRequest)(nil), "pb.PublishRequest")
-	proto.RegisterType((*PublishResponse)(nil), "pb.PublishResponse")
-	proto.RegisterType((*CreateTopicRequest)(nil), "pb.CreateTopicRequest")
-	proto.RegisterType((*CreateTopicResponse)(nil), "pb.CreateTopicResponse")
-	proto.RegisterType((*ClientID)(nil), "pb.ClientID")
-	proto.RegisterType((*PartitionID)(nil), "pb.PartitionID")
-	proto.RegisterType((*TopicMeta)(nil), "pb.TopicMeta")
-	proto.RegisterType((*Messages)(nil), "pb.Messages")
-	proto.RegisterType((*Message)(nil), "pb.Message")
-	proto.RegisterType((*MessageWithOffset)(nil), "pb.MessageWithOffset")
+func (m *FetchRequest) GetIsolation() IsolationLevel {
+	if m != nil {
+		return m.Isolation
+	}
+	return IsolationLevel_READ_UNCOMMITTED
 }
 
-// Reference imports to suppress errors if they are not otherwise used.
-var _ context.Context
-var _ grpc.ClientConn
+func (m *FetchRequest) GetEarliest() bool {
+	if m != nil {
+		return m.Earliest
+	}
+	return false
+}
 
-// This is a compile-time assertion to ensure that this generated file
-// is compatible with the grpc package it is being compiled against.
-const _ = grpc.SupportPackageIsVersion4
+type FetchResponse struct {
+	Messages *Messages `protobuf:"bytes,1,opt,name=messages" json:"messages,omitempty"`
+	// Where to fetch from next, past any messages left out
+	NextOffset int64 `protobuf:"varint,2,opt,name=nextOffset" json:"nextOffset,omitempty"`
+}
 
-// Client API for UltrabusNode service
+func (m *FetchResponse) Reset()                    { *m = FetchResponse{} }
+func (m *FetchResponse) String() string            { return proto.CompactTextString(m) }
+func (*FetchResponse) ProtoMessage()               {}
+func (*FetchResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{6} }
 
-type UltrabusNodeClient interface {
-	Subscribe(ctx context.Context, in *SubscribeRequest, opts ...grpc.CallOption) (UltrabusNode_SubscribeClient, error)
-	Publish(ctx context.Context, in *PublishRequest, opts ...grpc.CallOption) (*PublishResponse, error)
-	CreateTopic(ctx context.Context, in *CreateTopicRequest, opts ...grpc.CallOption) (*CreateTopicResponse, error)
+func (m *FetchResponse) GetMessages() *Messages {
+	if m != nil {
+		return m.Messages
+	}
+	return nil
 }
 
-type ultrabusNodeClient struct {
-	cc *grpc.ClientConn
+func (m *FetchResponse) GetNextOffset() int64 {
+	if m != nil {
+		return m.NextOffset
+	}
+	return 0
 }
 
-func NewUltrabusNodeClient(cc *grpc.ClientConn) UltrabusNodeClient {
-	return &ultrabusNodeClient{cc}
+// Messages, and bytes of their keys, values and headers, a subscriber is
+// ready to receive. A node sends while both are positive, so the last
+// message sent may overdraw bytes.
+type Credits struct {
+	Messages int64 `protobuf:"varint,1,opt,name=messages" json:"messages,omitempty"`
+	Bytes    int64 `protobuf:"varint,2,opt,name=bytes" json:"bytes,omitempty"`
 }
 
-func (c *ultrabusNodeClient) Subscribe(ctx context.Context, in *SubscribeRequest, opts ...grpc.CallOption) (UltrabusNode_SubscribeClient, error) {
-	stream, err := grpc.NewClientStream(ctx, &_UltrabusNode_serviceDesc.Streams[0], c.cc, "/pb.UltrabusNode/Subscribe", opts...)
-	if err != nil {
-		return nil, err
-	}
-	x := &ultrabusNodeSubscribeClient{stream}
-	if err := x.ClientStream.SendMsg(in); err != nil {
-		return nil, err
-	}
-	if err := x.ClientStream.CloseSend(); err != nil {
-		return nil, err
+func (m *Credits) Reset()                    { *m = Credits{} }
+func (m *Credits) String() string            { return proto.CompactTextString(m) }
+func (*Credits) ProtoMessage()               {}
+func (*Credits) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{7} }
+
+func (m *Credits) GetMessages() int64 {
+	if m != nil {
+		return m.Messages
 	}
-	return x, nil
+	return 0
 }
 
-type UltrabusNode_SubscribeClient interface {
-	Recv() (*Messages, error)
-	grpc.ClientStream
+func (m *Credits) GetBytes() int64 {
+	if m != nil {
+		return m.Bytes
+	}
+	return 0
 }
 
-type ultrabusNodeSubscribeClient struct {
-	grpc.ClientStream
+// Adds to a subscription's credits, sent to the node it subscribed to.
+type GrantCreditsRequest struct {
+	ClientID    *ClientID    `protobuf:"bytes,1,opt,name=clientID" json:"clientID,omitempty"`
+	PartitionID *PartitionID `protobuf:"bytes,2,opt,name=partitionID" json:"partitionID,omitempty"`
+	Credits     *Credits     `protobuf:"bytes,3,opt,name=credits" json:"credits,omitempty"`
 }
 
-func (x *ultrabusNodeSubscribeClient) Recv() (*Messages, error) {
-	m := new(Messages)
-	if err := x.ClientStream.RecvMsg(m); err != nil {
-		return nil, err
+func (m *GrantCreditsRequest) Reset()                    { *m = GrantCreditsRequest{} }
+func (m *GrantCreditsRequest) String() string            { return proto.CompactTextString(m) }
+func (*GrantCreditsRequest) ProtoMessage()               {}
+func (*GrantCreditsRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{8} }
+
+func (m *GrantCreditsRequest) GetClientID() *ClientID {
+	if m != nil {
+		return m.ClientID
 	}
-	return m, nil
+	return nil
 }
 
-func (c *ultrabusNodeClient) Publish(ctx context.Context, in *PublishRequest, opts ...grpc.CallOption) (*PublishResponse, error) {
-	out := new(PublishResponse)
-	err := grpc.Invoke(ctx, "/pb.UltrabusNode/Publish", in, out, c.cc, opts...)
-	if err != nil {
-		return nil, err
+func (m *GrantCreditsRequest) GetPartitionID() *PartitionID {
+	if m != nil {
+		return m.PartitionID
 	}
-	return out, nil
+	return nil
 }
 
-func (c *ultrabusNodeClient) CreateTopic(ctx context.Context, in *CreateTopicRequest, opts ...grpc.CallOption) (*CreateTopicResponse, error) {
-	out := new(CreateTopicResponse)
-	err := grpc.Invoke(ctx, "/pb.UltrabusNode/CreateTopic", in, out, c.cc, opts...)
-	if err != nil {
-		return nil, err
+func (m *GrantCreditsRequest) GetCredits() *Credits {
+	if m != nil {
+		return m.Credits
 	}
-	return out, nil
+	return nil
 }
 
-// Server API for UltrabusNode service
+type GrantCreditsResponse struct {
+}
 
-type UltrabusNodeServer interface {
-	Subscribe(*SubscribeRequest, UltrabusNode_SubscribeServer) error
-	Publish(context.Context, *PublishRequest) (*PublishResponse, error)
-	CreateTopic(context.Context, *CreateTopicRequest) (*CreateTopicResponse, error)
+func (m *GrantCreditsResponse) Reset()                    { *m = GrantCreditsResponse{} }
+func (m *GrantCreditsResponse) String() string            { return proto.CompactTextString(m) }
+func (*GrantCreditsResponse) ProtoMessage()               {}
+func (*GrantCreditsResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{9} }
+
+// Conditions every message sent to a subscriber meets. Unset ones
+// let every message through.
+type SubscriptionFilter struct {
+	KeyPrefix []byte `protobuf:"bytes,1,opt,name=keyPrefix,proto3" json:"keyPrefix,omitempty"`
+	// RE2 syntax, matched anywhere in the key
+	KeyRegex string `protobuf:"bytes,2,opt,name=keyRegex" json:"keyRegex,omitempty"`
+	// Headers a message must have, with exactly these values
+	Headers map[string][]byte `protobuf:"bytes,3,rep,name=headers" json:"headers,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value,proto3"`
+	Offsets *OffsetRange      `protobuf:"bytes,4,opt,name=offsets" json:"offsets,omitempty"`
+	// A predicate on the message, see Expression, with its value decoded
+	// by the topic's value.schema
+	Expression string `protobuf:"bytes,5,opt,name=expression" json:"expression,omitempty"`
+}
+
+func (m *SubscriptionFilter) Reset()                    { *m = SubscriptionFilter{} }
+func (m *SubscriptionFilter) String() string            { return proto.CompactTextString(m) }
+func (*SubscriptionFilter) ProtoMessage()               {}
+func (*SubscriptionFilter) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{10} }
+
+func (m *SubscriptionFilter) GetKeyPrefix() []byte {
+	if m != nil {
+		return m.KeyPrefix
+	}
+	return nil
+}
+
+func (m *SubscriptionFilter) GetKeyRegex() string {
+	if m != nil {
+		return m.KeyRegex
+	}
+	return ""
+}
+
+func (m *SubscriptionFilter) GetHeaders() map[string][]byte {
+	if m != nil {
+		return m.Headers
+	}
+	return nil
+}
+
+func (m *SubscriptionFilter) GetOffsets() *OffsetRange {
+	if m != nil {
+		return m.Offsets
+	}
+	return nil
+}
+
+func (m *SubscriptionFilter) GetExpression() string {
+	if m != nil {
+		return m.Expression
+	}
+	return ""
+}
+
+// Offsets from start up to but not including end, or with no end when 0.
+// Subscribers reading a range start at its start.
+type OffsetRange struct {
+	Start int64 `protobuf:"varint,1,opt,name=start" json:"start,omitempty"`
+	End   int64 `protobuf:"varint,2,opt,name=end" json:"end,omitempty"`
+}
+
+func (m *OffsetRange) Reset()                    { *m = OffsetRange{} }
+func (m *OffsetRange) String() string            { return proto.CompactTextString(m) }
+func (*OffsetRange) ProtoMessage()               {}
+func (*OffsetRange) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{11} }
+
+func (m *OffsetRange) GetStart() int64 {
+	if m != nil {
+		return m.Start
+	}
+	return 0
+}
+
+func (m *OffsetRange) GetEnd() int64 {
+	if m != nil {
+		return m.End
+	}
+	return 0
+}
+
+type PublishRequest struct {
+	PartitionID *PartitionID `protobuf:"bytes,1,opt,name=partitionID" json:"partitionID,omitempty"`
+	Messages    []*Message   `protobuf:"bytes,2,rep,name=messages" json:"messages,omitempty"`
+	// Set by idempotent producers, with the sequence of the first message;
+	// the rest follow on from it
+	Producer *ProducerSequence `protobuf:"bytes,3,opt,name=producer" json:"producer,omitempty"`
+}
+
+func (m *PublishRequest) Reset()                    { *m = PublishRequest{} }
+func (m *PublishRequest) String() string            { return proto.CompactTextString(m) }
+func (*PublishRequest) ProtoMessage()               {}
+func (*PublishRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{12} }
+
+func (m *PublishRequest) GetPartitionID() *PartitionID {
+	if m != nil {
+		return m.PartitionID
+	}
+	return nil
+}
+
+func (m *PublishRequest) GetMessages() []*Message {
+	if m != nil {
+		return m.Messages
+	}
+	return nil
+}
+
+func (m *PublishRequest) GetProducer() *ProducerSequence {
+	if m != nil {
+		return m.Producer
+	}
+	return nil
+}
+
+// Identifies an idempotent producer's write to a partition. A partition
+// appends a producer's messages only in sequence order, acknowledging
+// resent ones without appending them again. A higher epoch starts the
+// producer's sequence afresh and fences off writes from lower ones.
+type ProducerSequence struct {
+	ProducerID string `protobuf:"bytes,1,opt,name=producerID" json:"producerID,omitempty"`
+	Epoch      int32  `protobuf:"varint,2,opt,name=epoch" json:"epoch,omitempty"`
+	Sequence   int64  `protobuf:"varint,3,opt,name=sequence" json:"sequence,omitempty"`
+	// Whether the messages belong to the producer's open transaction
+	Transactional bool `protobuf:"varint,4,opt,name=transactional" json:"transactional,omitempty"`
+}
+
+func (m *ProducerSequence) Reset()                    { *m = ProducerSequence{} }
+func (m *ProducerSequence) String() string            { return proto.CompactTextString(m) }
+func (*ProducerSequence) ProtoMessage()               {}
+func (*ProducerSequence) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{13} }
+
+func (m *ProducerSequence) GetProducerID() string {
+	if m != nil {
+		return m.ProducerID
+	}
+	return ""
+}
+
+func (m *ProducerSequence) GetEpoch() int32 {
+	if m != nil {
+		return m.Epoch
+	}
+	return 0
+}
+
+func (m *ProducerSequence) GetSequence() int64 {
+	if m != nil {
+		return m.Sequence
+	}
+	return 0
+}
+
+func (m *ProducerSequence) GetTransactional() bool {
+	if m != nil {
+		return m.Transactional
+	}
+	return false
+}
+
+// Starts a transaction, aborting any the transactional ID left open. The
+// producer the transaction publishes as is returned, its epoch one past
+// the last transaction's.
+type BeginTransactionRequest struct {
+	TransactionalID string `protobuf:"bytes,1,opt,name=transactionalID" json:"transactionalID,omitempty"`
+	// The producer the client's last transaction was given. Unset when the
+	// client starts, which fences off any earlier client of the ID; set,
+	// it's fenced itself if a later client has begun since.
+	Producer *ProducerSequence `protobuf:"bytes,2,opt,name=producer" json:"producer,omitempty"`
+}
+
+func (m *BeginTransactionRequest) Reset()                    { *m = BeginTransactionRequest{} }
+func (m *BeginTransactionRequest) String() string            { return proto.CompactTextString(m) }
+func (*BeginTransactionRequest) ProtoMessage()               {}
+func (*BeginTransactionRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{14} }
+
+func (m *BeginTransactionRequest) GetTransactionalID() string {
+	if m != nil {
+		return m.TransactionalID
+	}
+	return ""
+}
+
+func (m *BeginTransactionRequest) GetProducer() *ProducerSequence {
+	if m != nil {
+		return m.Producer
+	}
+	return nil
+}
+
+type BeginTransactionResponse struct {
+	Producer *ProducerSequence `protobuf:"bytes,1,opt,name=producer" json:"producer,omitempty"`
+}
+
+func (m *BeginTransactionResponse) Reset()                    { *m = BeginTransactionResponse{} }
+func (m *BeginTransactionResponse) String() string            { return proto.CompactTextString(m) }
+func (*BeginTransactionResponse) ProtoMessage()               {}
+func (*BeginTransactionResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{15} }
+
+func (m *BeginTransactionResponse) GetProducer() *ProducerSequence {
+	if m != nil {
+		return m.Producer
+	}
+	return nil
+}
+
+// Registers partitions a transaction is about to publish to.
+type AddPartitionsToTransactionRequest struct {
+	TransactionalID string         `protobuf:"bytes,1,opt,name=transactionalID" json:"transactionalID,omitempty"`
+	ProducerID      string         `protobuf:"bytes,2,opt,name=producerID" json:"producerID,omitempty"`
+	Partitions      []*PartitionID `protobuf:"bytes,3,rep,name=partitions" json:"partitions,omitempty"`
+	Epoch           int32          `protobuf:"varint,4,opt,name=epoch" json:"epoch,omitempty"`
+}
+
+func (m *AddPartitionsToTransactionRequest) Reset()         { *m = AddPartitionsToTransactionRequest{} }
+func (m *AddPartitionsToTransactionRequest) String() string { return proto.CompactTextString(m) }
+func (*AddPartitionsToTransactionRequest) ProtoMessage()    {}
+func (*AddPartitionsToTransactionRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor0, []int{16}
+}
+
+func (m *AddPartitionsToTransactionRequest) GetTransactionalID() string {
+	if m != nil {
+		return m.TransactionalID
+	}
+	return ""
+}
+
+func (m *AddPartitionsToTransactionRequest) GetProducerID() string {
+	if m != nil {
+		return m.ProducerID
+	}
+	return ""
+}
+
+func (m *AddPartitionsToTransactionRequest) GetPartitions() []*PartitionID {
+	if m != nil {
+		return m.Partitions
+	}
+	return nil
+}
+
+func (m *AddPartitionsToTransactionRequest) GetEpoch() int32 {
+	if m != nil {
+		return m.Epoch
+	}
+	return 0
+}
+
+type AddPartitionsToTransactionResponse struct {
+}
+
+func (m *AddPartitionsToTransactionResponse) Reset()         { *m = AddPartitionsToTransactionResponse{} }
+func (m *AddPartitionsToTransactionResponse) String() string { return proto.CompactTextString(m) }
+func (*AddPartitionsToTransactionResponse) ProtoMessage()    {}
+func (*AddPartitionsToTransactionResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor0, []int{17}
+}
+
+// Commits or aborts a transaction by writing a marker to each of its
+// partitions.
+type EndTransactionRequest struct {
+	TransactionalID string `protobuf:"bytes,1,opt,name=transactionalID" json:"transactionalID,omitempty"`
+	ProducerID      string `protobuf:"bytes,2,opt,name=producerID" json:"producerID,omitempty"`
+	Commit          bool   `protobuf:"varint,3,opt,name=commit" json:"commit,omitempty"`
+	Epoch           int32  `protobuf:"varint,4,opt,name=epoch" json:"epoch,omitempty"`
+}
+
+func (m *EndTransactionRequest) Reset()                    { *m = EndTransactionRequest{} }
+func (m *EndTransactionRequest) String() string            { return proto.CompactTextString(m) }
+func (*EndTransactionRequest) ProtoMessage()               {}
+func (*EndTransactionRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{18} }
+
+func (m *EndTransactionRequest) GetTransactionalID() string {
+	if m != nil {
+		return m.TransactionalID
+	}
+	return ""
+}
+
+func (m *EndTransactionRequest) GetProducerID() string {
+	if m != nil {
+		return m.ProducerID
+	}
+	return ""
+}
+
+func (m *EndTransactionRequest) GetCommit() bool {
+	if m != nil {
+		return m.Commit
+	}
+	return false
+}
+
+func (m *EndTransactionRequest) GetEpoch() int32 {
+	if m != nil {
+		return m.Epoch
+	}
+	return 0
+}
+
+type EndTransactionResponse struct {
+}
+
+func (m *EndTransactionResponse) Reset()                    { *m = EndTransactionResponse{} }
+func (m *EndTransactionResponse) String() string            { return proto.CompactTextString(m) }
+func (*EndTransactionResponse) ProtoMessage()               {}
+func (*EndTransactionResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{19} }
+
+type WriteTransactionMarkerRequest struct {
+	PartitionID *PartitionID      `protobuf:"bytes,1,opt,name=partitionID" json:"partitionID,omitempty"`
+	ProducerID  string            `protobuf:"bytes,2,opt,name=producerID" json:"producerID,omitempty"`
+	Marker      TransactionMarker `protobuf:"varint,3,opt,name=marker,enum=pb.TransactionMarker" json:"marker,omitempty"`
+	// Fences off the producer's earlier epochs on the partition
+	Epoch int32 `protobuf:"varint,4,opt,name=epoch" json:"epoch,omitempty"`
+}
+
+func (m *WriteTransactionMarkerRequest) Reset()                    { *m = WriteTransactionMarkerRequest{} }
+func (m *WriteTransactionMarkerRequest) String() string            { return proto.CompactTextString(m) }
+func (*WriteTransactionMarkerRequest) ProtoMessage()               {}
+func (*WriteTransactionMarkerRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{20} }
+
+func (m *WriteTransactionMarkerRequest) GetPartitionID() *PartitionID {
+	if m != nil {
+		return m.PartitionID
+	}
+	return nil
+}
+
+func (m *WriteTransactionMarkerRequest) GetProducerID() string {
+	if m != nil {
+		return m.ProducerID
+	}
+	return ""
+}
+
+func (m *WriteTransactionMarkerRequest) GetMarker() TransactionMarker {
+	if m != nil {
+		return m.Marker
+	}
+	return TransactionMarker_NO_MARKER
+}
+
+func (m *WriteTransactionMarkerRequest) GetEpoch() int32 {
+	if m != nil {
+		return m.Epoch
+	}
+	return 0
+}
+
+type WriteTransactionMarkerResponse struct {
+}
+
+func (m *WriteTransactionMarkerResponse) Reset()         { *m = WriteTransactionMarkerResponse{} }
+func (m *WriteTransactionMarkerResponse) String() string { return proto.CompactTextString(m) }
+func (*WriteTransactionMarkerResponse) ProtoMessage()    {}
+func (*WriteTransactionMarkerResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor0, []int{21}
+}
+
+// Records the offset a consumer group reads a partition from next, which
+// its subscribers start at. Sent to the partition's leader.
+type CommitOffsetRequest struct {
+	ConsumerGroup string       `protobuf:"bytes,1,opt,name=consumerGroup" json:"consumerGroup,omitempty"`
+	PartitionID   *PartitionID `protobuf:"bytes,2,opt,name=partitionID" json:"partitionID,omitempty"`
+	Offset        int64        `protobuf:"varint,3,opt,name=offset" json:"offset,omitempty"`
+	// When set the commit is part of the producer's open transaction, and
+	// only takes effect if it commits
+	ProducerID string `protobuf:"bytes,4,opt,name=producerID" json:"producerID,omitempty"`
+	Epoch      int32  `protobuf:"varint,5,opt,name=epoch" json:"epoch,omitempty"`
+}
+
+func (m *CommitOffsetRequest) Reset()                    { *m = CommitOffsetRequest{} }
+func (m *CommitOffsetRequest) String() string            { return proto.CompactTextString(m) }
+func (*CommitOffsetRequest) ProtoMessage()               {}
+func (*CommitOffsetRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{22} }
+
+func (m *CommitOffsetRequest) GetConsumerGroup() string {
+	if m != nil {
+		return m.ConsumerGroup
+	}
+	return ""
+}
+
+func (m *CommitOffsetRequest) GetPartitionID() *PartitionID {
+	if m != nil {
+		return m.PartitionID
+	}
+	return nil
+}
+
+func (m *CommitOffsetRequest) GetOffset() int64 {
+	if m != nil {
+		return m.Offset
+	}
+	return 0
+}
+
+func (m *CommitOffsetRequest) GetProducerID() string {
+	if m != nil {
+		return m.ProducerID
+	}
+	return ""
+}
+
+func (m *CommitOffsetRequest) GetEpoch() int32 {
+	if m != nil {
+		return m.Epoch
+	}
+	return 0
+}
+
+type CommitOffsetResponse struct {
+}
+
+func (m *CommitOffsetResponse) Reset()                    { *m = CommitOffsetResponse{} }
+func (m *CommitOffsetResponse) String() string            { return proto.CompactTextString(m) }
+func (*CommitOffsetResponse) ProtoMessage()               {}
+func (*CommitOffsetResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{23} }
+
+type PublishResponse struct {
+	Offsets []int64 `protobuf:"varint,1,rep,packed,name=offsets" json:"offsets,omitempty"`
+}
+
+func (m *PublishResponse) Reset()                    { *m = PublishResponse{} }
+func (m *PublishResponse) String() string            { return proto.CompactTextString(m) }
+func (*PublishResponse) ProtoMessage()               {}
+func (*PublishResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{24} }
+
+func (m *PublishResponse) GetOffsets() []int64 {
+	if m != nil {
+		return m.Offsets
+	}
+	return nil
+}
+
+type CreateTopicRequest struct {
+	Meta *TopicMeta `protobuf:"bytes,1,opt,name=meta" json:"meta,omitempty"`
+}
+
+func (m *CreateTopicRequest) Reset()                    { *m = CreateTopicRequest{} }
+func (m *CreateTopicRequest) String() string            { return proto.CompactTextString(m) }
+func (*CreateTopicRequest) ProtoMessage()               {}
+func (*CreateTopicRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{25} }
+
+func (m *CreateTopicRequest) GetMeta() *TopicMeta {
+	if m != nil {
+		return m.Meta
+	}
+	return nil
+}
+
+type CreateTopicResponse struct {
+	Ok bool `protobuf:"varint,1,opt,name=ok" json:"ok,omitempty"`
+}
+
+func (m *CreateTopicResponse) Reset()                    { *m = CreateTopicResponse{} }
+func (m *CreateTopicResponse) String() string            { return proto.CompactTextString(m) }
+func (*CreateTopicResponse) ProtoMessage()               {}
+func (*CreateTopicResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{26} }
+
+func (m *CreateTopicResponse) GetOk() bool {
+	if m != nil {
+		return m.Ok
+	}
+	return false
+}
+
+// Grows a topic to the given number of partitions. Keyed messages are
+// spread over the new count from then on, see HashToPartition.
+type AlterTopicRequest struct {
+	Topic      string `protobuf:"bytes,1,opt,name=topic" json:"topic,omitempty"`
+	Partitions int32  `protobuf:"varint,2,opt,name=partitions" json:"partitions,omitempty"`
+}
+
+func (m *AlterTopicRequest) Reset()                    { *m = AlterTopicRequest{} }
+func (m *AlterTopicRequest) String() string            { return proto.CompactTextString(m) }
+func (*AlterTopicRequest) ProtoMessage()               {}
+func (*AlterTopicRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{27} }
+
+func (m *AlterTopicRequest) GetTopic() string {
+	if m != nil {
+		return m.Topic
+	}
+	return ""
+}
+
+func (m *AlterTopicRequest) GetPartitions() int32 {
+	if m != nil {
+		return m.Partitions
+	}
+	return 0
+}
+
+type AlterTopicResponse struct {
+	Meta *TopicMeta `protobuf:"bytes,1,opt,name=meta" json:"meta,omitempty"`
+}
+
+func (m *AlterTopicResponse) Reset()                    { *m = AlterTopicResponse{} }
+func (m *AlterTopicResponse) String() string            { return proto.CompactTextString(m) }
+func (*AlterTopicResponse) ProtoMessage()               {}
+func (*AlterTopicResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{28} }
+
+func (m *AlterTopicResponse) GetMeta() *TopicMeta {
+	if m != nil {
+		return m.Meta
+	}
+	return nil
+}
+
+type GetTopicConfigRequest struct {
+	Topic string `protobuf:"bytes,1,opt,name=topic" json:"topic,omitempty"`
+}
+
+func (m *GetTopicConfigRequest) Reset()                    { *m = GetTopicConfigRequest{} }
+func (m *GetTopicConfigRequest) String() string            { return proto.CompactTextString(m) }
+func (*GetTopicConfigRequest) ProtoMessage()               {}
+func (*GetTopicConfigRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{29} }
+
+func (m *GetTopicConfigRequest) GetTopic() string {
+	if m != nil {
+		return m.Topic
+	}
+	return ""
+}
+
+type GetTopicConfigResponse struct {
+	// Keys the topic sets
+	Overrides map[string]string `protobuf:"bytes,1,rep,name=overrides" json:"overrides,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	// Every key, with defaults for those the topic doesn't set
+	Effective map[string]string `protobuf:"bytes,2,rep,name=effective" json:"effective,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+}
+
+func (m *GetTopicConfigResponse) Reset()                    { *m = GetTopicConfigResponse{} }
+func (m *GetTopicConfigResponse) String() string            { return proto.CompactTextString(m) }
+func (*GetTopicConfigResponse) ProtoMessage()               {}
+func (*GetTopicConfigResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{30} }
+
+func (m *GetTopicConfigResponse) GetOverrides() map[string]string {
+	if m != nil {
+		return m.Overrides
+	}
+	return nil
+}
+
+func (m *GetTopicConfigResponse) GetEffective() map[string]string {
+	if m != nil {
+		return m.Effective
+	}
+	return nil
+}
+
+// Sets and removes overrides of a topic's config, see TopicConfig.
+// Nodes apply the change to their partitions at runtime.
+type SetTopicConfigRequest struct {
+	Topic string            `protobuf:"bytes,1,opt,name=topic" json:"topic,omitempty"`
+	Set   map[string]string `protobuf:"bytes,2,rep,name=set" json:"set,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	Unset []string          `protobuf:"bytes,3,rep,name=unset" json:"unset,omitempty"`
+}
+
+func (m *SetTopicConfigRequest) Reset()                    { *m = SetTopicConfigRequest{} }
+func (m *SetTopicConfigRequest) String() string            { return proto.CompactTextString(m) }
+func (*SetTopicConfigRequest) ProtoMessage()               {}
+func (*SetTopicConfigRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{31} }
+
+func (m *SetTopicConfigRequest) GetTopic() string {
+	if m != nil {
+		return m.Topic
+	}
+	return ""
+}
+
+func (m *SetTopicConfigRequest) GetSet() map[string]string {
+	if m != nil {
+		return m.Set
+	}
+	return nil
+}
+
+func (m *SetTopicConfigRequest) GetUnset() []string {
+	if m != nil {
+		return m.Unset
+	}
+	return nil
+}
+
+type SetTopicConfigResponse struct {
+	Meta *TopicMeta `protobuf:"bytes,1,opt,name=meta" json:"meta,omitempty"`
+}
+
+func (m *SetTopicConfigResponse) Reset()                    { *m = SetTopicConfigResponse{} }
+func (m *SetTopicConfigResponse) String() string            { return proto.CompactTextString(m) }
+func (*SetTopicConfigResponse) ProtoMessage()               {}
+func (*SetTopicConfigResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{32} }
+
+func (m *SetTopicConfigResponse) GetMeta() *TopicMeta {
+	if m != nil {
+		return m.Meta
+	}
+	return nil
+}
+
+type DeleteTopicRequest struct {
+	Topic string `protobuf:"bytes,1,opt,name=topic" json:"topic,omitempty"`
+	// private: only drop the partitions hosted by the receiving node
+	Local bool `protobuf:"varint,2,opt,name=local" json:"local,omitempty"`
+}
+
+func (m *DeleteTopicRequest) Reset()                    { *m = DeleteTopicRequest{} }
+func (m *DeleteTopicRequest) String() string            { return proto.CompactTextString(m) }
+func (*DeleteTopicRequest) ProtoMessage()               {}
+func (*DeleteTopicRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{33} }
+
+func (m *DeleteTopicRequest) GetTopic() string {
+	if m != nil {
+		return m.Topic
+	}
+	return ""
+}
+
+func (m *DeleteTopicRequest) GetLocal() bool {
+	if m != nil {
+		return m.Local
+	}
+	return false
+}
+
+type DeleteTopicResponse struct {
+	Ok bool `protobuf:"varint,1,opt,name=ok" json:"ok,omitempty"`
+}
+
+func (m *DeleteTopicResponse) Reset()                    { *m = DeleteTopicResponse{} }
+func (m *DeleteTopicResponse) String() string            { return proto.CompactTextString(m) }
+func (*DeleteTopicResponse) ProtoMessage()               {}
+func (*DeleteTopicResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{34} }
+
+func (m *DeleteTopicResponse) GetOk() bool {
+	if m != nil {
+		return m.Ok
+	}
+	return false
+}
+
+type ListTopicsRequest struct {
+}
+
+func (m *ListTopicsRequest) Reset()                    { *m = ListTopicsRequest{} }
+func (m *ListTopicsRequest) String() string            { return proto.CompactTextString(m) }
+func (*ListTopicsRequest) ProtoMessage()               {}
+func (*ListTopicsRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{35} }
+
+type ListTopicsResponse struct {
+	Topics []*TopicMeta `protobuf:"bytes,1,rep,name=topics" json:"topics,omitempty"`
+}
+
+func (m *ListTopicsResponse) Reset()                    { *m = ListTopicsResponse{} }
+func (m *ListTopicsResponse) String() string            { return proto.CompactTextString(m) }
+func (*ListTopicsResponse) ProtoMessage()               {}
+func (*ListTopicsResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{36} }
+
+func (m *ListTopicsResponse) GetTopics() []*TopicMeta {
+	if m != nil {
+		return m.Topics
+	}
+	return nil
+}
+
+type DescribeTopicRequest struct {
+	Topic string `protobuf:"bytes,1,opt,name=topic" json:"topic,omitempty"`
+	// private: only describe the partitions hosted by the receiving node
+	Local bool `protobuf:"varint,2,opt,name=local" json:"local,omitempty"`
+}
+
+func (m *DescribeTopicRequest) Reset()                    { *m = DescribeTopicRequest{} }
+func (m *DescribeTopicRequest) String() string            { return proto.CompactTextString(m) }
+func (*DescribeTopicRequest) ProtoMessage()               {}
+func (*DescribeTopicRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{37} }
+
+func (m *DescribeTopicRequest) GetTopic() string {
+	if m != nil {
+		return m.Topic
+	}
+	return ""
+}
+
+func (m *DescribeTopicRequest) GetLocal() bool {
+	if m != nil {
+		return m.Local
+	}
+	return false
+}
+
+type DescribeTopicResponse struct {
+	Meta       *TopicMeta              `protobuf:"bytes,1,opt,name=meta" json:"meta,omitempty"`
+	Partitions []*PartitionDescription `protobuf:"bytes,2,rep,name=partitions" json:"partitions,omitempty"`
+}
+
+func (m *DescribeTopicResponse) Reset()                    { *m = DescribeTopicResponse{} }
+func (m *DescribeTopicResponse) String() string            { return proto.CompactTextString(m) }
+func (*DescribeTopicResponse) ProtoMessage()               {}
+func (*DescribeTopicResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{38} }
+
+func (m *DescribeTopicResponse) GetMeta() *TopicMeta {
+	if m != nil {
+		return m.Meta
+	}
+	return nil
+}
+
+func (m *DescribeTopicResponse) GetPartitions() []*PartitionDescription {
+	if m != nil {
+		return m.Partitions
+	}
+	return nil
+}
+
+type PartitionDescription struct {
+	Partition int32    `protobuf:"varint,1,opt,name=partition" json:"partition,omitempty"`
+	Leader    string   `protobuf:"bytes,2,opt,name=leader" json:"leader,omitempty"`
+	Replicas  []string `protobuf:"bytes,3,rep,name=replicas" json:"replicas,omitempty"`
+	// Replicas whose log has every message the leader's has
+	Isr []string `protobuf:"bytes,4,rep,name=isr" json:"isr,omitempty"`
+	// Offsets and size (in message bytes) of the leader's log, -1 when empty
+	FirstOffset int64 `protobuf:"varint,5,opt,name=firstOffset" json:"firstOffset,omitempty"`
+	LastOffset  int64 `protobuf:"varint,6,opt,name=lastOffset" json:"lastOffset,omitempty"`
+	Size        int64 `protobuf:"varint,7,opt,name=size" json:"size,omitempty"`
+	// Consumers subscribed to the leader
+	Consumers []*ClientID `protobuf:"bytes,8,rep,name=consumers" json:"consumers,omitempty"`
+	// Messages readers skipped because they'd expired and they'd otherwise
+	// have read, counted once per message for each consumer group, readers
+	// outside any group counting as one
+	Expired int64 `protobuf:"varint,9,opt,name=expired" json:"expired,omitempty"`
+	// Messages cleanup dropped because they'd expired, see
+	// retention.expired, whether or not readers had skipped them
+	ExpiredCleaned int64 `protobuf:"varint,10,opt,name=expiredCleaned" json:"expiredCleaned,omitempty"`
+}
+
+func (m *PartitionDescription) Reset()                    { *m = PartitionDescription{} }
+func (m *PartitionDescription) String() string            { return proto.CompactTextString(m) }
+func (*PartitionDescription) ProtoMessage()               {}
+func (*PartitionDescription) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{39} }
+
+func (m *PartitionDescription) GetPartition() int32 {
+	if m != nil {
+		return m.Partition
+	}
+	return 0
+}
+
+func (m *PartitionDescription) GetLeader() string {
+	if m != nil {
+		return m.Leader
+	}
+	return ""
+}
+
+func (m *PartitionDescription) GetReplicas() []string {
+	if m != nil {
+		return m.Replicas
+	}
+	return nil
+}
+
+func (m *PartitionDescription) GetIsr() []string {
+	if m != nil {
+		return m.Isr
+	}
+	return nil
+}
+
+func (m *PartitionDescription) GetFirstOffset() int64 {
+	if m != nil {
+		return m.FirstOffset
+	}
+	return 0
+}
+
+func (m *PartitionDescription) GetLastOffset() int64 {
+	if m != nil {
+		return m.LastOffset
+	}
+	return 0
+}
+
+func (m *PartitionDescription) GetSize() int64 {
+	if m != nil {
+		return m.Size
+	}
+	return 0
+}
+
+func (m *PartitionDescription) GetConsumers() []*ClientID {
+	if m != nil {
+		return m.Consumers
+	}
+	return nil
+}
+
+func (m *PartitionDescription) GetExpired() int64 {
+	if m != nil {
+		return m.Expired
+	}
+	return 0
+}
+
+func (m *PartitionDescription) GetExpiredCleaned() int64 {
+	if m != nil {
+		return m.ExpiredCleaned
+	}
+	return 0
+}
+
+type SyncRequest struct {
+	PartitionID *PartitionID `protobuf:"bytes,1,opt,name=partitionID" json:"partitionID,omitempty"`
+	FromOffset  int64        `protobuf:"varint,2,opt,name=fromOffset" json:"fromOffset,omitempty"`
+	MaxMessages int32        `protobuf:"varint,3,opt,name=maxMessages" json:"maxMessages,omitempty"`
+}
+
+func (m *SyncRequest) Reset()                    { *m = SyncRequest{} }
+func (m *SyncRequest) String() string            { return proto.CompactTextString(m) }
+func (*SyncRequest) ProtoMessage()               {}
+func (*SyncRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{40} }
+
+func (m *SyncRequest) GetPartitionID() *PartitionID {
+	if m != nil {
+		return m.PartitionID
+	}
+	return nil
+}
+
+func (m *SyncRequest) GetFromOffset() int64 {
+	if m != nil {
+		return m.FromOffset
+	}
+	return 0
+}
+
+func (m *SyncRequest) GetMaxMessages() int32 {
+	if m != nil {
+		return m.MaxMessages
+	}
+	return 0
+}
+
+type SyncResponse struct {
+	Messages  *Messages `protobuf:"bytes,1,opt,name=messages" json:"messages,omitempty"`
+	MaxOffset int64     `protobuf:"varint,2,opt,name=maxOffset" json:"maxOffset,omitempty"`
+}
+
+func (m *SyncResponse) Reset()                    { *m = SyncResponse{} }
+func (m *SyncResponse) String() string            { return proto.CompactTextString(m) }
+func (*SyncResponse) ProtoMessage()               {}
+func (*SyncResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{41} }
+
+func (m *SyncResponse) GetMessages() *Messages {
+	if m != nil {
+		return m.Messages
+	}
+	return nil
+}
+
+func (m *SyncResponse) GetMaxOffset() int64 {
+	if m != nil {
+		return m.MaxOffset
+	}
+	return 0
+}
+
+type ApplyMetadataRequest struct {
+	Command []byte `protobuf:"bytes,1,opt,name=command,proto3" json:"command,omitempty"`
+}
+
+func (m *ApplyMetadataRequest) Reset()                    { *m = ApplyMetadataRequest{} }
+func (m *ApplyMetadataRequest) String() string            { return proto.CompactTextString(m) }
+func (*ApplyMetadataRequest) ProtoMessage()               {}
+func (*ApplyMetadataRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{42} }
+
+func (m *ApplyMetadataRequest) GetCommand() []byte {
+	if m != nil {
+		return m.Command
+	}
+	return nil
+}
+
+type ApplyMetadataResponse struct {
+	Ok bool `protobuf:"varint,1,opt,name=ok" json:"ok,omitempty"`
+}
+
+func (m *ApplyMetadataResponse) Reset()                    { *m = ApplyMetadataResponse{} }
+func (m *ApplyMetadataResponse) String() string            { return proto.CompactTextString(m) }
+func (*ApplyMetadataResponse) ProtoMessage()               {}
+func (*ApplyMetadataResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{43} }
+
+func (m *ApplyMetadataResponse) GetOk() bool {
+	if m != nil {
+		return m.Ok
+	}
+	return false
+}
+
+type GetMetadataRequest struct {
+}
+
+func (m *GetMetadataRequest) Reset()                    { *m = GetMetadataRequest{} }
+func (m *GetMetadataRequest) String() string            { return proto.CompactTextString(m) }
+func (*GetMetadataRequest) ProtoMessage()               {}
+func (*GetMetadataRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{44} }
+
+type GetMetadataResponse struct {
+	Metadata []byte `protobuf:"bytes,1,opt,name=metadata,proto3" json:"metadata,omitempty"`
+}
+
+func (m *GetMetadataResponse) Reset()                    { *m = GetMetadataResponse{} }
+func (m *GetMetadataResponse) String() string            { return proto.CompactTextString(m) }
+func (*GetMetadataResponse) ProtoMessage()               {}
+func (*GetMetadataResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{45} }
+
+func (m *GetMetadataResponse) GetMetadata() []byte {
+	if m != nil {
+		return m.Metadata
+	}
+	return nil
+}
+
+type ClientID struct {
+	ConsumerGroup string `protobuf:"bytes,1,opt,name=consumerGroup" json:"consumerGroup,omitempty"`
+	ConsumerID    string `protobuf:"bytes,2,opt,name=consumerID" json:"consumerID,omitempty"`
+}
+
+func (m *ClientID) Reset()                    { *m = ClientID{} }
+func (m *ClientID) String() string            { return proto.CompactTextString(m) }
+func (*ClientID) ProtoMessage()               {}
+func (*ClientID) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{46} }
+
+func (m *ClientID) GetConsumerGroup() string {
+	if m != nil {
+		return m.ConsumerGroup
+	}
+	return ""
+}
+
+func (m *ClientID) GetConsumerID() string {
+	if m != nil {
+		return m.ConsumerID
+	}
+	return ""
+}
+
+type PartitionID struct {
+	Topic     string `protobuf:"bytes,1,opt,name=topic" json:"topic,omitempty"`
+	Partition int32  `protobuf:"varint,2,opt,name=partition" json:"partition,omitempty"`
+}
+
+func (m *PartitionID) Reset()                    { *m = PartitionID{} }
+func (m *PartitionID) String() string            { return proto.CompactTextString(m) }
+func (*PartitionID) ProtoMessage()               {}
+func (*PartitionID) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{47} }
+
+func (m *PartitionID) GetTopic() string {
+	if m != nil {
+		return m.Topic
+	}
+	return ""
+}
+
+func (m *PartitionID) GetPartition() int32 {
+	if m != nil {
+		return m.Partition
+	}
+	return 0
+}
+
+type TopicMeta struct {
+	Topic      string `protobuf:"bytes,1,opt,name=topic" json:"topic,omitempty"`
+	Partitions int32  `protobuf:"varint,2,opt,name=partitions" json:"partitions,omitempty"`
+	Replicas   int32  `protobuf:"varint,3,opt,name=replicas" json:"replicas,omitempty"`
+	// Overrides of the topic's defaults, see TopicConfig for the keys
+	Config map[string]string `protobuf:"bytes,4,rep,name=config" json:"config,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+}
+
+func (m *TopicMeta) Reset()                    { *m = TopicMeta{} }
+func (m *TopicMeta) String() string            { return proto.CompactTextString(m) }
+func (*TopicMeta) ProtoMessage()               {}
+func (*TopicMeta) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{48} }
+
+func (m *TopicMeta) GetTopic() string {
+	if m != nil {
+		return m.Topic
+	}
+	return ""
+}
+
+func (m *TopicMeta) GetPartitions() int32 {
+	if m != nil {
+		return m.Partitions
+	}
+	return 0
+}
+
+func (m *TopicMeta) GetReplicas() int32 {
+	if m != nil {
+		return m.Replicas
+	}
+	return 0
+}
+
+func (m *TopicMeta) GetConfig() map[string]string {
+	if m != nil {
+		return m.Config
+	}
+	return nil
+}
+
+type Messages struct {
+	Messages []*MessageWithOffset `protobuf:"bytes,1,rep,name=messages" json:"messages,omitempty"`
+	// Messages a subscription's filter failed on, which are left out
+	FilterErrors []*FilterError `protobuf:"bytes,2,rep,name=filterErrors" json:"filterErrors,omitempty"`
+}
+
+func (m *Messages) Reset()                    { *m = Messages{} }
+func (m *Messages) String() string            { return proto.CompactTextString(m) }
+func (*Messages) ProtoMessage()               {}
+func (*Messages) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{49} }
+
+func (m *Messages) GetMessages() []*MessageWithOffset {
+	if m != nil {
+		return m.Messages
+	}
+	return nil
+}
+
+func (m *Messages) GetFilterErrors() []*FilterError {
+	if m != nil {
+		return m.FilterErrors
+	}
+	return nil
+}
+
+type FilterError struct {
+	Offset int64  `protobuf:"varint,1,opt,name=offset" json:"offset,omitempty"`
+	Error  string `protobuf:"bytes,2,opt,name=error" json:"error,omitempty"`
+}
+
+func (m *FilterError) Reset()                    { *m = FilterError{} }
+func (m *FilterError) String() string            { return proto.CompactTextString(m) }
+func (*FilterError) ProtoMessage()               {}
+func (*FilterError) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{50} }
+
+func (m *FilterError) GetOffset() int64 {
+	if m != nil {
+		return m.Offset
+	}
+	return 0
+}
+
+func (m *FilterError) GetError() string {
+	if m != nil {
+		return m.Error
+	}
+	return ""
+}
+
+type Message struct {
+	Key     []byte            `protobuf:"bytes,1,opt,name=key,proto3" json:"key,omitempty"`
+	Value   []byte            `protobuf:"bytes,2,opt,name=value,proto3" json:"value,omitempty"`
+	Headers map[string][]byte `protobuf:"bytes,3,rep,name=headers" json:"headers,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value,proto3"`
+	// When to deliver the message, in ms since the epoch, or 0 for straight
+	// away. A delayed message is stored when published but skipped by
+	// subscribers; once due it's appended again without deliverAt, with
+	// the ultrabus.scheduled.offset header. Immediate messages are read in
+	// the order they were published whatever is delayed among them.
+	DeliverAt int64 `protobuf:"varint,4,opt,name=deliverAt" json:"deliverAt,omitempty"`
+	// When the message stops being worth delivering, in ms since the
+	// epoch, or 0 for never. Subscribers skip expired messages, and
+	// cleanup drops them by the topic's retention.expired.
+	ExpiresAt int64 `protobuf:"varint,5,opt,name=expiresAt" json:"expiresAt,omitempty"`
+}
+
+func (m *Message) Reset()                    { *m = Message{} }
+func (m *Message) String() string            { return proto.CompactTextString(m) }
+func (*Message) ProtoMessage()               {}
+func (*Message) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{51} }
+
+func (m *Message) GetKey() []byte {
+	if m != nil {
+		return m.Key
+	}
+	return nil
+}
+
+func (m *Message) GetValue() []byte {
+	if m != nil {
+		return m.Value
+	}
+	return nil
+}
+
+func (m *Message) GetHeaders() map[string][]byte {
+	if m != nil {
+		return m.Headers
+	}
+	return nil
+}
+
+func (m *Message) GetDeliverAt() int64 {
+	if m != nil {
+		return m.DeliverAt
+	}
+	return 0
+}
+
+func (m *Message) GetExpiresAt() int64 {
+	if m != nil {
+		return m.ExpiresAt
+	}
+	return 0
+}
+
+type MessageWithOffset struct {
+	Offset  int64    `protobuf:"varint,1,opt,name=offset" json:"offset,omitempty"`
+	Message *Message `protobuf:"bytes,2,opt,name=message" json:"message,omitempty"`
+	// When the partition appended the message, in ms since the epoch
+	Timestamp int64 `protobuf:"varint,3,opt,name=timestamp" json:"timestamp,omitempty"`
+	// The idempotent producer that wrote the message and its sequence
+	Producer *ProducerSequence `protobuf:"bytes,4,opt,name=producer" json:"producer,omitempty"`
+	// Set on the control records ending a producer's transaction, which
+	// carry no message and aren't delivered to subscribers
+	Marker TransactionMarker `protobuf:"varint,5,opt,name=marker,enum=pb.TransactionMarker" json:"marker,omitempty"`
+	// The partition the message was read from, set by subscriptions
+	Partition int32 `protobuf:"varint,6,opt,name=partition" json:"partition,omitempty"`
+	// How many times queue mode has delivered the message, from 1
+	Delivery int32 `protobuf:"varint,7,opt,name=delivery" json:"delivery,omitempty"`
+}
+
+func (m *MessageWithOffset) Reset()                    { *m = MessageWithOffset{} }
+func (m *MessageWithOffset) String() string            { return proto.CompactTextString(m) }
+func (*MessageWithOffset) ProtoMessage()               {}
+func (*MessageWithOffset) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{52} }
+
+func (m *MessageWithOffset) GetOffset() int64 {
+	if m != nil {
+		return m.Offset
+	}
+	return 0
+}
+
+func (m *MessageWithOffset) GetMessage() *Message {
+	if m != nil {
+		return m.Message
+	}
+	return nil
+}
+
+func (m *MessageWithOffset) GetTimestamp() int64 {
+	if m != nil {
+		return m.Timestamp
+	}
+	return 0
+}
+
+func (m *MessageWithOffset) GetProducer() *ProducerSequence {
+	if m != nil {
+		return m.Producer
+	}
+	return nil
+}
+
+func (m *MessageWithOffset) GetMarker() TransactionMarker {
+	if m != nil {
+		return m.Marker
+	}
+	return TransactionMarker_NO_MARKER
+}
+
+func (m *MessageWithOffset) GetPartition() int32 {
+	if m != nil {
+		return m.Partition
+	}
+	return 0
+}
+
+func (m *MessageWithOffset) GetDelivery() int32 {
+	if m != nil {
+		return m.Delivery
+	}
+	return 0
+}
+
+// A consumer group's offset as partitions keep it, apart from their
+// messages. Transactional producers' commits take effect once their
+// transaction commits.
+type OffsetCommit struct {
+	ConsumerGroup string `protobuf:"bytes,1,opt,name=consumerGroup" json:"consumerGroup,omitempty"`
+	Offset        int64  `protobuf:"varint,2,opt,name=offset" json:"offset,omitempty"`
+}
+
+func (m *OffsetCommit) Reset()                    { *m = OffsetCommit{} }
+func (m *OffsetCommit) String() string            { return proto.CompactTextString(m) }
+func (*OffsetCommit) ProtoMessage()               {}
+func (*OffsetCommit) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{53} }
+
+func (m *OffsetCommit) GetConsumerGroup() string {
+	if m != nil {
+		return m.ConsumerGroup
+	}
+	return ""
+}
+
+func (m *OffsetCommit) GetOffset() int64 {
+	if m != nil {
+		return m.Offset
+	}
+	return 0
+}
+
+func init() {
+	proto.RegisterType((*SubscribeRequest)(nil), "pb.SubscribeRequest")
+	proto.RegisterType((*QueueOptions)(nil), "pb.QueueOptions")
+	proto.RegisterType((*AckRequest)(nil), "pb.AckRequest")
+	proto.RegisterType((*AckResponse)(nil), "pb.AckResponse")
+	proto.RegisterType((*BatchOptions)(nil), "pb.BatchOptions")
+	proto.RegisterType((*FetchRequest)(nil), "pb.FetchRequest")
+	proto.RegisterType((*FetchResponse)(nil), "pb.FetchResponse")
+	proto.RegisterType((*Credits)(nil), "pb.Credits")
+	proto.RegisterType((*GrantCreditsRequest)(nil), "pb.GrantCreditsRequest")
+	proto.RegisterType((*GrantCreditsResponse)(nil), "pb.GrantCreditsResponse")
+	proto.RegisterType((*SubscriptionFilter)(nil), "pb.SubscriptionFilter")
+	proto.RegisterType((*OffsetRange)(nil), "pb.OffsetRange")
+	proto.RegisterType((*PublishRequest)(nil), "pb.PublishRequest")
+	proto.RegisterType((*ProducerSequence)(nil), "pb.ProducerSequence")
+	proto.RegisterType((*BeginTransactionRequest)(nil), "pb.BeginTransactionRequest")
+	proto.RegisterType((*BeginTransactionResponse)(nil), "pb.BeginTransactionResponse")
+	proto.RegisterType((*AddPartitionsToTransactionRequest)(nil), "pb.AddPartitionsToTransactionRequest")
+	proto.RegisterType((*AddPartitionsToTransactionResponse)(nil), "pb.AddPartitionsToTransactionResponse")
+	proto.RegisterType((*EndTransactionRequest)(nil), "pb.EndTransactionRequest")
+	proto.RegisterType((*EndTransactionResponse)(nil), "pb.EndTransactionResponse")
+	proto.RegisterType((*WriteTransactionMarkerRequest)(nil), "pb.WriteTransactionMarkerRequest")
+	proto.RegisterType((*WriteTransactionMarkerResponse)(nil), "pb.WriteTransactionMarkerResponse")
+	proto.RegisterType((*CommitOffsetRequest)(nil), "pb.CommitOffsetRequest")
+	proto.RegisterType((*CommitOffsetResponse)(nil), "pb.CommitOffsetResponse")
+	proto.RegisterType((*PublishResponse)(nil), "pb.PublishResponse")
+	proto.RegisterType((*CreateTopicRequest)(nil), "pb.CreateTopicRequest")
+	proto.RegisterType((*CreateTopicResponse)(nil), "pb.CreateTopicResponse")
+	proto.RegisterType((*AlterTopicRequest)(nil), "pb.AlterTopicRequest")
+	proto.RegisterType((*AlterTopicResponse)(nil), "pb.AlterTopicResponse")
+	proto.RegisterType((*GetTopicConfigRequest)(nil), "pb.GetTopicConfigRequest")
+	proto.RegisterType((*GetTopicConfigResponse)(nil), "pb.GetTopicConfigResponse")
+	proto.RegisterType((*SetTopicConfigRequest)(nil), "pb.SetTopicConfigRequest")
+	proto.RegisterType((*SetTopicConfigResponse)(nil), "pb.SetTopicConfigResponse")
+	proto.RegisterType((*DeleteTopicRequest)(nil), "pb.DeleteTopicRequest")
+	proto.RegisterType((*DeleteTopicResponse)(nil), "pb.DeleteTopicResponse")
+	proto.RegisterType((*ListTopicsRequest)(nil), "pb.ListTopicsRequest")
+	proto.RegisterType((*ListTopicsResponse)(nil), "pb.ListTopicsResponse")
+	proto.RegisterType((*DescribeTopicRequest)(nil), "pb.DescribeTopicRequest")
+	proto.RegisterType((*DescribeTopicResponse)(nil), "pb.DescribeTopicResponse")
+	proto.RegisterType((*PartitionDescription)(nil), "pb.PartitionDescription")
+	proto.RegisterType((*SyncRequest)(nil), "pb.SyncRequest")
+	proto.RegisterType((*SyncResponse)(nil), "pb.SyncResponse")
+	proto.RegisterType((*ApplyMetadataRequest)(nil), "pb.ApplyMetadataRequest")
+	proto.RegisterType((*ApplyMetadataResponse)(nil), "pb.ApplyMetadataResponse")
+	proto.RegisterType((*GetMetadataRequest)(nil), "pb.GetMetadataRequest")
+	proto.RegisterType((*GetMetadataResponse)(nil), "pb.GetMetadataResponse")
+	proto.RegisterType((*ClientID)(nil), "pb.ClientID")
+	proto.RegisterType((*PartitionID)(nil), "pb.PartitionID")
+	proto.RegisterType((*TopicMeta)(nil), "pb.TopicMeta")
+	proto.RegisterType((*Messages)(nil), "pb.Messages")
+	proto.RegisterType((*FilterError)(nil), "pb.FilterError")
+	proto.RegisterType((*Message)(nil), "pb.Message")
+	proto.RegisterType((*MessageWithOffset)(nil), "pb.MessageWithOffset")
+	proto.RegisterType((*OffsetCommit)(nil), "pb.OffsetCommit")
+	proto.RegisterEnum("pb.IsolationLevel", IsolationLevel_name, IsolationLevel_value)
+	proto.RegisterEnum("pb.TransactionMarker", TransactionMarker_name, TransactionMarker_value)
+}
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ context.Context
+var _ grpc.ClientConn
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+const _ = grpc.SupportPackageIsVersion4
+
+// Client API for UltrabusNode service
+
+type UltrabusNodeClient interface {
+	Subscribe(ctx context.Context, in *SubscribeRequest, opts ...grpc.CallOption) (UltrabusNode_SubscribeClient, error)
+	Fetch(ctx context.Context, in *FetchRequest, opts ...grpc.CallOption) (*FetchResponse, error)
+	Publish(ctx context.Context, in *PublishRequest, opts ...grpc.CallOption) (*PublishResponse, error)
+	CreateTopic(ctx context.Context, in *CreateTopicRequest, opts ...grpc.CallOption) (*CreateTopicResponse, error)
+	AlterTopic(ctx context.Context, in *AlterTopicRequest, opts ...grpc.CallOption) (*AlterTopicResponse, error)
+	DeleteTopic(ctx context.Context, in *DeleteTopicRequest, opts ...grpc.CallOption) (*DeleteTopicResponse, error)
+	GetTopicConfig(ctx context.Context, in *GetTopicConfigRequest, opts ...grpc.CallOption) (*GetTopicConfigResponse, error)
+	SetTopicConfig(ctx context.Context, in *SetTopicConfigRequest, opts ...grpc.CallOption) (*SetTopicConfigResponse, error)
+	ListTopics(ctx context.Context, in *ListTopicsRequest, opts ...grpc.CallOption) (*ListTopicsResponse, error)
+	DescribeTopic(ctx context.Context, in *DescribeTopicRequest, opts ...grpc.CallOption) (*DescribeTopicResponse, error)
+	CommitOffset(ctx context.Context, in *CommitOffsetRequest, opts ...grpc.CallOption) (*CommitOffsetResponse, error)
+	GrantCredits(ctx context.Context, in *GrantCreditsRequest, opts ...grpc.CallOption) (*GrantCreditsResponse, error)
+	Ack(ctx context.Context, in *AckRequest, opts ...grpc.CallOption) (*AckResponse, error)
+	BeginTransaction(ctx context.Context, in *BeginTransactionRequest, opts ...grpc.CallOption) (*BeginTransactionResponse, error)
+	AddPartitionsToTransaction(ctx context.Context, in *AddPartitionsToTransactionRequest, opts ...grpc.CallOption) (*AddPartitionsToTransactionResponse, error)
+	EndTransaction(ctx context.Context, in *EndTransactionRequest, opts ...grpc.CallOption) (*EndTransactionResponse, error)
+	Sync(ctx context.Context, in *SyncRequest, opts ...grpc.CallOption) (*SyncResponse, error)
+	ApplyMetadata(ctx context.Context, in *ApplyMetadataRequest, opts ...grpc.CallOption) (*ApplyMetadataResponse, error)
+	GetMetadata(ctx context.Context, in *GetMetadataRequest, opts ...grpc.CallOption) (*GetMetadataResponse, error)
+	WriteTransactionMarker(ctx context.Context, in *WriteTransactionMarkerRequest, opts ...grpc.CallOption) (*WriteTransactionMarkerResponse, error)
+}
+
+type ultrabusNodeClient struct {
+	cc *grpc.ClientConn
+}
+
+func NewUltrabusNodeClient(cc *grpc.ClientConn) UltrabusNodeClient {
+	return &ultrabusNodeClient{cc}
+}
+
+func (c *ultrabusNodeClient) Subscribe(ctx context.Context, in *SubscribeRequest, opts ...grpc.CallOption) (UltrabusNode_SubscribeClient, error) {
+	stream, err := grpc.NewClientStream(ctx, &_UltrabusNode_serviceDesc.Streams[0], c.cc, "/pb.UltrabusNode/Subscribe", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &ultrabusNodeSubscribeClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type UltrabusNode_SubscribeClient interface {
+	Recv() (*Messages, error)
+	grpc.ClientStream
+}
+
+type ultrabusNodeSubscribeClient struct {
+	grpc.ClientStream
+}
+
+func (x *ultrabusNodeSubscribeClient) Recv() (*Messages, error) {
+	m := new(Messages)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *ultrabusNodeClient) Fetch(ctx context.Context, in *FetchRequest, opts ...grpc.CallOption) (*FetchResponse, error) {
+	out := new(FetchResponse)
+	err := grpc.Invoke(ctx, "/pb.UltrabusNode/Fetch", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *ultrabusNodeClient) Publish(ctx context.Context, in *PublishRequest, opts ...grpc.CallOption) (*PublishResponse, error) {
+	out := new(PublishResponse)
+	err := grpc.Invoke(ctx, "/pb.UltrabusNode/Publish", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *ultrabusNodeClient) CreateTopic(ctx context.Context, in *CreateTopicRequest, opts ...grpc.CallOption) (*CreateTopicResponse, error) {
+	out := new(CreateTopicResponse)
+	err := grpc.Invoke(ctx, "/pb.UltrabusNode/CreateTopic", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *ultrabusNodeClient) AlterTopic(ctx context.Context, in *AlterTopicRequest, opts ...grpc.CallOption) (*AlterTopicResponse, error) {
+	out := new(AlterTopicResponse)
+	err := grpc.Invoke(ctx, "/pb.UltrabusNode/AlterTopic", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *ultrabusNodeClient) DeleteTopic(ctx context.Context, in *DeleteTopicRequest, opts ...grpc.CallOption) (*DeleteTopicResponse, error) {
+	out := new(DeleteTopicResponse)
+	err := grpc.Invoke(ctx, "/pb.UltrabusNode/DeleteTopic", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *ultrabusNodeClient) GetTopicConfig(ctx context.Context, in *GetTopicConfigRequest, opts ...grpc.CallOption) (*GetTopicConfigResponse, error) {
+	out := new(GetTopicConfigResponse)
+	err := grpc.Invoke(ctx, "/pb.UltrabusNode/GetTopicConfig", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *ultrabusNodeClient) SetTopicConfig(ctx context.Context, in *SetTopicConfigRequest, opts ...grpc.CallOption) (*SetTopicConfigResponse, error) {
+	out := new(SetTopicConfigResponse)
+	err := grpc.Invoke(ctx, "/pb.UltrabusNode/SetTopicConfig", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *ultrabusNodeClient) ListTopics(ctx context.Context, in *ListTopicsRequest, opts ...grpc.CallOption) (*ListTopicsResponse, error) {
+	out := new(ListTopicsResponse)
+	err := grpc.Invoke(ctx, "/pb.UltrabusNode/ListTopics", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *ultrabusNodeClient) DescribeTopic(ctx context.Context, in *DescribeTopicRequest, opts ...grpc.CallOption) (*DescribeTopicResponse, error) {
+	out := new(DescribeTopicResponse)
+	err := grpc.Invoke(ctx, "/pb.UltrabusNode/DescribeTopic", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *ultrabusNodeClient) CommitOffset(ctx context.Context, in *CommitOffsetRequest, opts ...grpc.CallOption) (*CommitOffsetResponse, error) {
+	out := new(CommitOffsetResponse)
+	err := grpc.Invoke(ctx, "/pb.UltrabusNode/CommitOffset", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *ultrabusNodeClient) GrantCredits(ctx context.Context, in *GrantCreditsRequest, opts ...grpc.CallOption) (*GrantCreditsResponse, error) {
+	out := new(GrantCreditsResponse)
+	err := grpc.Invoke(ctx, "/pb.UltrabusNode/GrantCredits", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *ultrabusNodeClient) Ack(ctx context.Context, in *AckRequest, opts ...grpc.CallOption) (*AckResponse, error) {
+	out := new(AckResponse)
+	err := grpc.Invoke(ctx, "/pb.UltrabusNode/Ack", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *ultrabusNodeClient) BeginTransaction(ctx context.Context, in *BeginTransactionRequest, opts ...grpc.CallOption) (*BeginTransactionResponse, error) {
+	out := new(BeginTransactionResponse)
+	err := grpc.Invoke(ctx, "/pb.UltrabusNode/BeginTransaction", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *ultrabusNodeClient) AddPartitionsToTransaction(ctx context.Context, in *AddPartitionsToTransactionRequest, opts ...grpc.CallOption) (*AddPartitionsToTransactionResponse, error) {
+	out := new(AddPartitionsToTransactionResponse)
+	err := grpc.Invoke(ctx, "/pb.UltrabusNode/AddPartitionsToTransaction", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *ultrabusNodeClient) EndTransaction(ctx context.Context, in *EndTransactionRequest, opts ...grpc.CallOption) (*EndTransactionResponse, error) {
+	out := new(EndTransactionResponse)
+	err := grpc.Invoke(ctx, "/pb.UltrabusNode/EndTransaction", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *ultrabusNodeClient) Sync(ctx context.Context, in *SyncRequest, opts ...grpc.CallOption) (*SyncResponse, error) {
+	out := new(SyncResponse)
+	err := grpc.Invoke(ctx, "/pb.UltrabusNode/Sync", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *ultrabusNodeClient) ApplyMetadata(ctx context.Context, in *ApplyMetadataRequest, opts ...grpc.CallOption) (*ApplyMetadataResponse, error) {
+	out := new(ApplyMetadataResponse)
+	err := grpc.Invoke(ctx, "/pb.UltrabusNode/ApplyMetadata", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *ultrabusNodeClient) GetMetadata(ctx context.Context, in *GetMetadataRequest, opts ...grpc.CallOption) (*GetMetadataResponse, error) {
+	out := new(GetMetadataResponse)
+	err := grpc.Invoke(ctx, "/pb.UltrabusNode/GetMetadata", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *ultrabusNodeClient) WriteTransactionMarker(ctx context.Context, in *WriteTransactionMarkerRequest, opts ...grpc.CallOption) (*WriteTransactionMarkerResponse, error) {
+	out := new(WriteTransactionMarkerResponse)
+	err := grpc.Invoke(ctx, "/pb.UltrabusNode/WriteTransactionMarker", in, out, c.cc, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// Server API for UltrabusNode service
+
+type UltrabusNodeServer interface {
+	Subscribe(*SubscribeRequest, UltrabusNode_SubscribeServer) error
+	Fetch(context.Context, *FetchRequest) (*FetchResponse, error)
+	Publish(context.Context, *PublishRequest) (*PublishResponse, error)
+	CreateTopic(context.Context, *CreateTopicRequest) (*CreateTopicResponse, error)
+	AlterTopic(context.Context, *AlterTopicRequest) (*AlterTopicResponse, error)
+	DeleteTopic(context.Context, *DeleteTopicRequest) (*DeleteTopicResponse, error)
+	GetTopicConfig(context.Context, *GetTopicConfigRequest) (*GetTopicConfigResponse, error)
+	SetTopicConfig(context.Context, *SetTopicConfigRequest) (*SetTopicConfigResponse, error)
+	ListTopics(context.Context, *ListTopicsRequest) (*ListTopicsResponse, error)
+	DescribeTopic(context.Context, *DescribeTopicRequest) (*DescribeTopicResponse, error)
+	CommitOffset(context.Context, *CommitOffsetRequest) (*CommitOffsetResponse, error)
+	GrantCredits(context.Context, *GrantCreditsRequest) (*GrantCreditsResponse, error)
+	Ack(context.Context, *AckRequest) (*AckResponse, error)
+	BeginTransaction(context.Context, *BeginTransactionRequest) (*BeginTransactionResponse, error)
+	AddPartitionsToTransaction(context.Context, *AddPartitionsToTransactionRequest) (*AddPartitionsToTransactionResponse, error)
+	EndTransaction(context.Context, *EndTransactionRequest) (*EndTransactionResponse, error)
+	Sync(context.Context, *SyncRequest) (*SyncResponse, error)
+	ApplyMetadata(context.Context, *ApplyMetadataRequest) (*ApplyMetadataResponse, error)
+	GetMetadata(context.Context, *GetMetadataRequest) (*GetMetadataResponse, error)
+	WriteTransactionMarker(context.Context, *WriteTransactionMarkerRequest) (*WriteTransactionMarkerResponse, error)
 }
 
 func RegisterUltrabusNodeServer(s *grpc.Server, srv UltrabusNodeServer) {
@@ -404,6 +2083,24 @@ func (x *ultrabusNodeSubscribeServer) Send(m *Messages) error {
 	return x.ServerStream.SendMsg(m)
 }
 
+func _UltrabusNode_Fetch_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(FetchRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UltrabusNodeServer).Fetch(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pb.UltrabusNode/Fetch",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UltrabusNodeServer).Fetch(ctx, req.(*FetchRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 func _UltrabusNode_Publish_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
 	in := new(PublishRequest)
 	if err := dec(in); err != nil {
@@ -440,10 +2137,302 @@ func _UltrabusNode_CreateTopic_Handler(srv interface{}, ctx context.Context, dec
 	return interceptor(ctx, in, info, handler)
 }
 
+func _UltrabusNode_AlterTopic_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AlterTopicRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UltrabusNodeServer).AlterTopic(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pb.UltrabusNode/AlterTopic",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UltrabusNodeServer).AlterTopic(ctx, req.(*AlterTopicRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _UltrabusNode_DeleteTopic_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeleteTopicRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UltrabusNodeServer).DeleteTopic(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pb.UltrabusNode/DeleteTopic",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UltrabusNodeServer).DeleteTopic(ctx, req.(*DeleteTopicRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _UltrabusNode_GetTopicConfig_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetTopicConfigRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UltrabusNodeServer).GetTopicConfig(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pb.UltrabusNode/GetTopicConfig",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UltrabusNodeServer).GetTopicConfig(ctx, req.(*GetTopicConfigRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _UltrabusNode_SetTopicConfig_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SetTopicConfigRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UltrabusNodeServer).SetTopicConfig(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pb.UltrabusNode/SetTopicConfig",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UltrabusNodeServer).SetTopicConfig(ctx, req.(*SetTopicConfigRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _UltrabusNode_ListTopics_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListTopicsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UltrabusNodeServer).ListTopics(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pb.UltrabusNode/ListTopics",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UltrabusNodeServer).ListTopics(ctx, req.(*ListTopicsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _UltrabusNode_DescribeTopic_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DescribeTopicRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UltrabusNodeServer).DescribeTopic(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pb.UltrabusNode/DescribeTopic",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UltrabusNodeServer).DescribeTopic(ctx, req.(*DescribeTopicRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _UltrabusNode_CommitOffset_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CommitOffsetRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UltrabusNodeServer).CommitOffset(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pb.UltrabusNode/CommitOffset",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UltrabusNodeServer).CommitOffset(ctx, req.(*CommitOffsetRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _UltrabusNode_GrantCredits_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GrantCreditsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UltrabusNodeServer).GrantCredits(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pb.UltrabusNode/GrantCredits",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UltrabusNodeServer).GrantCredits(ctx, req.(*GrantCreditsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _UltrabusNode_Ack_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AckRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UltrabusNodeServer).Ack(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pb.UltrabusNode/Ack",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UltrabusNodeServer).Ack(ctx, req.(*AckRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _UltrabusNode_BeginTransaction_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(BeginTransactionRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UltrabusNodeServer).BeginTransaction(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pb.UltrabusNode/BeginTransaction",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UltrabusNodeServer).BeginTransaction(ctx, req.(*BeginTransactionRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _UltrabusNode_AddPartitionsToTransaction_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AddPartitionsToTransactionRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UltrabusNodeServer).AddPartitionsToTransaction(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pb.UltrabusNode/AddPartitionsToTransaction",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UltrabusNodeServer).AddPartitionsToTransaction(ctx, req.(*AddPartitionsToTransactionRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _UltrabusNode_EndTransaction_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(EndTransactionRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UltrabusNodeServer).EndTransaction(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pb.UltrabusNode/EndTransaction",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UltrabusNodeServer).EndTransaction(ctx, req.(*EndTransactionRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _UltrabusNode_Sync_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SyncRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UltrabusNodeServer).Sync(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pb.UltrabusNode/Sync",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UltrabusNodeServer).Sync(ctx, req.(*SyncRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _UltrabusNode_ApplyMetadata_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ApplyMetadataRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UltrabusNodeServer).ApplyMetadata(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pb.UltrabusNode/ApplyMetadata",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UltrabusNodeServer).ApplyMetadata(ctx, req.(*ApplyMetadataRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _UltrabusNode_GetMetadata_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetMetadataRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UltrabusNodeServer).GetMetadata(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pb.UltrabusNode/GetMetadata",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UltrabusNodeServer).GetMetadata(ctx, req.(*GetMetadataRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _UltrabusNode_WriteTransactionMarker_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(WriteTransactionMarkerRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UltrabusNodeServer).WriteTransactionMarker(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pb.UltrabusNode/WriteTransactionMarker",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UltrabusNodeServer).WriteTransactionMarker(ctx, req.(*WriteTransactionMarkerRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 var _UltrabusNode_serviceDesc = grpc.ServiceDesc{
 	ServiceName: "pb.UltrabusNode",
 	HandlerType: (*UltrabusNodeServer)(nil),
 	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Fetch",
+			Handler:    _UltrabusNode_Fetch_Handler,
+		},
 		{
 			MethodName: "Publish",
 			Handler:    _UltrabusNode_Publish_Handler,
@@ -452,6 +2441,70 @@ var _UltrabusNode_serviceDesc = grpc.ServiceDesc{
 			MethodName: "CreateTopic",
 			Handler:    _UltrabusNode_CreateTopic_Handler,
 		},
+		{
+			MethodName: "AlterTopic",
+			Handler:    _UltrabusNode_AlterTopic_Handler,
+		},
+		{
+			MethodName: "DeleteTopic",
+			Handler:    _UltrabusNode_DeleteTopic_Handler,
+		},
+		{
+			MethodName: "GetTopicConfig",
+			Handler:    _UltrabusNode_GetTopicConfig_Handler,
+		},
+		{
+			MethodName: "SetTopicConfig",
+			Handler:    _UltrabusNode_SetTopicConfig_Handler,
+		},
+		{
+			MethodName: "ListTopics",
+			Handler:    _UltrabusNode_ListTopics_Handler,
+		},
+		{
+			MethodName: "DescribeTopic",
+			Handler:    _UltrabusNode_DescribeTopic_Handler,
+		},
+		{
+			MethodName: "CommitOffset",
+			Handler:    _UltrabusNode_CommitOffset_Handler,
+		},
+		{
+			MethodName: "GrantCredits",
+			Handler:    _UltrabusNode_GrantCredits_Handler,
+		},
+		{
+			MethodName: "Ack",
+			Handler:    _UltrabusNode_Ack_Handler,
+		},
+		{
+			MethodName: "BeginTransaction",
+			Handler:    _UltrabusNode_BeginTransaction_Handler,
+		},
+		{
+			MethodName: "AddPartitionsToTransaction",
+			Handler:    _UltrabusNode_AddPartitionsToTransaction_Handler,
+		},
+		{
+			MethodName: "EndTransaction",
+			Handler:    _UltrabusNode_EndTransaction_Handler,
+		},
+		{
+			MethodName: "Sync",
+			Handler:    _UltrabusNode_Sync_Handler,
+		},
+		{
+			MethodName: "ApplyMetadata",
+			Handler:    _UltrabusNode_ApplyMetadata_Handler,
+		},
+		{
+			MethodName: "GetMetadata",
+			Handler:    _UltrabusNode_GetMetadata_Handler,
+		},
+		{
+			MethodName: "WriteTransactionMarker",
+			Handler:    _UltrabusNode_WriteTransactionMarker_Handler,
+		},
 	},
 	Streams: []grpc.StreamDesc{
 		{
@@ -466,33 +2519,133 @@ var _UltrabusNode_serviceDesc = grpc.ServiceDesc{
 func init() { proto.RegisterFile("public.proto", fileDescriptor0) }
 
 var fileDescriptor0 = []byte{
-	// 443 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x6c, 0x53, 0x4d, 0x6f, 0xd3, 0x40,
-	0x14, 0xac, 0x63, 0xda, 0xd8, 0x63, 0xa7, 0x69, 0x5f, 0x29, 0x44, 0xe1, 0x43, 0x61, 0x05, 0x22,
-	0xa7, 0x00, 0x09, 0xdc, 0x8b, 0x5a, 0x09, 0xf5, 0x50, 0xa8, 0x80, 0x0a, 0xae, 0xb6, 0x79, 0xa5,
-	0x56, 0x9d, 0xac, 0xf1, 0xae, 0x91, 0xf8, 0x6d, 0xfc, 0x39, 0xb4, 0x9b, 0x8d, 0x71, 0x92, 0x5e,
-	0x27, 0xf3, 0xb1, 0x99, 0x37, 0x46, 0x5c, 0xd6, 0x69, 0x91, 0x67, 0x93, 0xb2, 0x92, 0x5a, 0x52,
-	0xa7, 0x4c, 0xc5, 0x77, 0x1c, 0x7c, 0xa9, 0x53, 0x95, 0x55, 0x79, 0xca, 0x9f, 0xf9, 0x57, 0xcd,
-	0x4a, 0xd3, 0x53, 0x04, 0x59, 0x91, 0xf3, 0x42, 0x9f, 0x9f, 0x0d, 0xbc, 0x91, 0x37, 0x8e, 0xa6,
-	0xf1, 0xa4, 0x4c, 0x27, 0xa7, 0x0e, 0xa3, 0xe7, 0x88, 0xca, 0xa4, 0xd2, 0xb9, 0xce, 0xe5, 0xe2,
-	0xfc, 0x6c, 0xd0, 0xb1, 0x94, 0xbe, 0xa1, 0x5c, 0xfe, 0x87, 0xc5, 0x15, 0xf6, 0x2f, 0x4d, 0x9a,
-	0xba, 0x59, 0xf9, 0x6e, 0xe8, 0xbc, 0x3b, 0x75, 0xf4, 0x04, 0xc1, 0x9c, 0x95, 0x4a, 0x7e, 0xb2,
-	0x1a, 0xf8, 0x23, 0x7f, 0x1c, 0x4d, 0x23, 0x43, 0xb9, 0x58, 0x62, 0x42, 0xa0, 0xdf, 0xd8, 0xaa,
-	0x52, 0x2e, 0x14, 0x53, 0x1f, 0x5d, 0x79, 0x7d, 0xad, 0x58, 0xab, 0x81, 0x37, 0xf2, 0xc7, 0xbe,
-	0x78, 0x03, 0x3a, 0xad, 0x38, 0xd1, 0xfc, 0x55, 0x96, 0x79, 0xb6, 0x8a, 0x7f, 0x84, 0x7b, 0x73,
-	0xd6, 0x89, 0xcb, 0xed, 0x19, 0x53, 0xfb, 0xfb, 0x05, 0xeb, 0x44, 0x3c, 0xc3, 0xd1, 0x9a, 0xc4,
-	0x59, 0x03, 0x1d, 0x79, 0x6b, 0x15, 0x81, 0x78, 0x87, 0xa0, 0xa9, 0xe0, 0x18, 0xbd, 0x4c, 0x2e,
-	0x54, 0x3d, 0xe7, 0xea, 0x43, 0x25, 0xeb, 0xd2, 0x52, 0x42, 0x22, 0x60, 0x05, 0xbb, 0x62, 0x42,
-	0xf1, 0x0a, 0x51, 0xfb, 0xef, 0xf5, 0xb0, 0xab, 0x4d, 0x84, 0x53, 0x1c, 0x22, 0x6c, 0x3a, 0xb1,
-	0x82, 0x5d, 0x71, 0x82, 0xb0, 0x79, 0xd7, 0x26, 0x9d, 0x80, 0x86, 0xae, 0x96, 0x7c, 0x3a, 0x40,
-	0x50, 0x71, 0x59, 0xe4, 0x59, 0x62, 0x0a, 0x33, 0x0e, 0x33, 0x04, 0xae, 0x2e, 0x45, 0x2f, 0x5b,
-	0x75, 0x7a, 0xb6, 0xce, 0xe3, 0x56, 0x9d, 0xdf, 0x72, 0x7d, 0xf3, 0xc9, 0x76, 0x27, 0x5e, 0xa0,
-	0xeb, 0x40, 0x8a, 0xe0, 0xdf, 0xf2, 0x1f, 0x1b, 0x19, 0x9b, 0x17, 0xfc, 0x4e, 0x8a, 0x9a, 0x6d,
-	0x5a, 0x2c, 0xde, 0xe3, 0x70, 0x4b, 0x4b, 0xfb, 0xd8, 0x5b, 0x5e, 0xc0, 0x6a, 0x7c, 0x7a, 0x8c,
-	0xae, 0x0b, 0x75, 0xeb, 0x68, 0x9f, 0x70, 0xfa, 0xd7, 0x43, 0x7c, 0x55, 0xe8, 0x2a, 0x49, 0x6b,
-	0xf5, 0x51, 0xfe, 0x60, 0x9a, 0x21, 0x6c, 0x46, 0x48, 0xf7, 0x0d, 0x75, 0x73, 0x93, 0xc3, 0xb8,
-	0x65, 0xa0, 0xc4, 0xce, 0x6b, 0x8f, 0xde, 0xa2, 0xeb, 0x86, 0x40, 0x64, 0x37, 0xb4, 0x36, 0xb6,
-	0xe1, 0xd1, 0x1a, 0xb6, 0x3c, 0xa7, 0xd8, 0xa1, 0x13, 0x44, 0xad, 0x3b, 0xd3, 0x03, 0x3b, 0xec,
-	0xad, 0xad, 0x0c, 0x1f, 0x6e, 0xe1, 0x2b, 0x87, 0x74, 0xcf, 0x7e, 0x3c, 0xb3, 0x7f, 0x01, 0x00,
-	0x00, 0xff, 0xff, 0xb8, 0xb8, 0x1e, 0xc0, 0x4c, 0x03, 0x00, 0x00,
+	// 2046 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xac, 0x59, 0xdd, 0x6f, 0xdb, 0xc8,
+	0x11, 0x0f, 0xf5, 0xcd, 0xd1, 0x87, 0xa5, 0xb5, 0x24, 0x33, 0x4c, 0x72, 0xe7, 0x30, 0x97, 0xc4,
+	0x71, 0xaf, 0xba, 0xab, 0x73, 0x29, 0xda, 0xc3, 0x1d, 0x50, 0xd9, 0x72, 0x7c, 0x46, 0xe3, 0x38,
+	0xb5, 0x7d, 0x08, 0x70, 0x2d, 0x7a, 0xa0, 0xa8, 0x95, 0x4d, 0x98, 0x22, 0x15, 0x72, 0x65, 0x48,
+	0x05, 0xfa, 0xd6, 0xa2, 0x8f, 0x05, 0xfa, 0xd8, 0x3f, 0xa0, 0x7f, 0x4b, 0xd1, 0xf7, 0xa2, 0xe8,
+	0x6b, 0xff, 0x8a, 0x3e, 0x16, 0xfb, 0x41, 0x6a, 0xf9, 0x21, 0x3b, 0xee, 0xe5, 0x91, 0xb3, 0x33,
+	0xb3, 0xbf, 0x9d, 0x9d, 0xd9, 0xfd, 0xcd, 0x12, 0x6a, 0xd3, 0xd9, 0xd0, 0xb1, 0xad, 0xde, 0xd4,
+	0xf7, 0x88, 0x87, 0x72, 0xd3, 0xa1, 0xf1, 0xa7, 0x1c, 0x34, 0x4f, 0x67, 0xc3, 0xc0, 0xf2, 0xed,
+	0x21, 0x3e, 0xc1, 0xef, 0x66, 0x38, 0x20, 0xe8, 0x23, 0xa8, 0x58, 0x8e, 0x8d, 0x5d, 0x72, 0x38,
+	0xd0, 0x94, 0x4d, 0x65, 0xab, 0xba, 0x53, 0xeb, 0x4d, 0x87, 0xbd, 0x3d, 0x21, 0x43, 0x9f, 0x40,
+	0x75, 0x6a, 0xfa, 0xc4, 0x26, 0xb6, 0xe7, 0x1e, 0x0e, 0xb4, 0x1c, 0x53, 0x59, 0xa3, 0x2a, 0x6f,
+	0x96, 0x62, 0xf4, 0x18, 0x54, 0x3b, 0xf0, 0x1c, 0x93, 0x7e, 0x6a, 0xf9, 0x4d, 0x65, 0xab, 0xb1,
+	0x83, 0xa8, 0xce, 0x61, 0x28, 0x7c, 0x85, 0xaf, 0xb0, 0x83, 0x9e, 0x40, 0x69, 0x6c, 0x3b, 0x04,
+	0xfb, 0x5a, 0x81, 0xf9, 0xe9, 0x52, 0x1d, 0x01, 0x69, 0x4a, 0xd5, 0x5e, 0xb2, 0x51, 0x74, 0x1f,
+	0xca, 0x96, 0x8f, 0x47, 0x36, 0x09, 0xb4, 0x22, 0x53, 0xac, 0x32, 0x4c, 0x5c, 0x84, 0x3e, 0x86,
+	0xe2, 0xd0, 0x24, 0xd6, 0x85, 0x56, 0x62, 0x63, 0x4d, 0x3a, 0xb6, 0x4b, 0x05, 0xc7, 0xcc, 0x07,
+	0x53, 0x78, 0x37, 0xc3, 0x33, 0xac, 0x95, 0x97, 0x0a, 0xbf, 0xa2, 0x02, 0xa1, 0x60, 0xfc, 0x1a,
+	0x6a, 0xf2, 0x37, 0xba, 0x07, 0xeb, 0x57, 0x76, 0x60, 0x0f, 0x6d, 0xc7, 0x26, 0x8b, 0x33, 0x7b,
+	0x82, 0xbd, 0x19, 0x39, 0x0a, 0x58, 0x3c, 0xf2, 0xa8, 0x03, 0xf5, 0x89, 0x39, 0x1f, 0x60, 0xc7,
+	0xbe, 0xc2, 0xbe, 0x8d, 0x03, 0x16, 0x83, 0x22, 0xda, 0x80, 0xb5, 0x11, 0x36, 0x47, 0xaf, 0x30,
+	0x21, 0xd8, 0x3f, 0xf3, 0xa6, 0xb6, 0xc5, 0x16, 0xae, 0x1a, 0xff, 0x50, 0x00, 0xfa, 0xd6, 0xe5,
+	0x87, 0x0d, 0x70, 0x0d, 0x0a, 0xa6, 0x75, 0x19, 0x68, 0xf9, 0xcd, 0xfc, 0x56, 0x1e, 0xd5, 0xa1,
+	0xe8, 0xb2, 0xcf, 0x02, 0xfb, 0xdc, 0x86, 0x12, 0xf6, 0x7d, 0xcf, 0xa7, 0xd1, 0xca, 0x6f, 0x55,
+	0x77, 0x74, 0x6a, 0xbd, 0x84, 0xd0, 0xdb, 0x67, 0x83, 0xfb, 0x2e, 0xf1, 0x17, 0xfa, 0x8f, 0xa1,
+	0x2a, 0x7d, 0xa2, 0x2a, 0xe4, 0x2f, 0xf1, 0x42, 0xac, 0xb4, 0x0e, 0xc5, 0x2b, 0xd3, 0x99, 0x61,
+	0x06, 0x42, 0xfd, 0x32, 0xf7, 0x33, 0xc5, 0xa8, 0x43, 0x95, 0x39, 0x0a, 0xa6, 0x9e, 0x1b, 0x60,
+	0xe3, 0x1b, 0xa8, 0xc5, 0x22, 0xbd, 0x0e, 0xd5, 0x89, 0xed, 0x1e, 0xe1, 0x20, 0x30, 0xcf, 0x31,
+	0x0f, 0x58, 0x11, 0xb5, 0x40, 0x9d, 0x98, 0xf3, 0xb7, 0xa6, 0x4d, 0x63, 0x98, 0x63, 0x9e, 0x9b,
+	0x50, 0x99, 0x98, 0xf3, 0xdd, 0x05, 0xc1, 0x01, 0x8b, 0x52, 0xde, 0xf8, 0x97, 0x02, 0xb5, 0x97,
+	0x98, 0x58, 0x17, 0x1f, 0x36, 0x4e, 0x0d, 0x28, 0x79, 0xe3, 0x71, 0x80, 0x09, 0x9f, 0x86, 0x01,
+	0x34, 0xe7, 0x11, 0xc0, 0x02, 0x03, 0x28, 0xa3, 0x29, 0x32, 0xb5, 0x18, 0xe4, 0x12, 0x13, 0xc5,
+	0x52, 0xba, 0xbc, 0x32, 0xa5, 0x9b, 0x50, 0xc1, 0xa6, 0xef, 0xd8, 0x38, 0x20, 0x5a, 0x65, 0x53,
+	0xd9, 0xaa, 0x18, 0x7b, 0x50, 0x17, 0x0b, 0xe3, 0x41, 0xa3, 0x2b, 0x9b, 0xc8, 0x11, 0x12, 0x2b,
+	0x0b, 0x41, 0x21, 0x04, 0xe0, 0xe2, 0x39, 0x39, 0xe6, 0xb8, 0x59, 0xc0, 0x8c, 0x6d, 0x28, 0x87,
+	0xe9, 0xde, 0x4c, 0x98, 0xb3, 0x7d, 0x1a, 0x32, 0xf0, 0x5c, 0x77, 0x01, 0xeb, 0x07, 0xbe, 0xe9,
+	0x12, 0x61, 0xf0, 0x61, 0x03, 0x2a, 0x95, 0x62, 0x3e, 0x55, 0x8a, 0x46, 0x17, 0xda, 0xf1, 0xa9,
+	0x45, 0x9e, 0xfc, 0x53, 0x01, 0x94, 0x51, 0xd7, 0x2d, 0x50, 0x2f, 0xf1, 0xe2, 0x8d, 0x8f, 0xc7,
+	0xf6, 0x9c, 0x61, 0xaa, 0xd1, 0xd5, 0x5d, 0xe2, 0xc5, 0x09, 0x3e, 0xc7, 0x73, 0x9e, 0x76, 0xe8,
+	0x0b, 0x28, 0x5f, 0x60, 0x73, 0x84, 0x7d, 0x9e, 0xed, 0xd5, 0x9d, 0x47, 0xd9, 0xa7, 0x44, 0xef,
+	0x1b, 0xae, 0xc5, 0x13, 0x79, 0x13, 0xca, 0x7c, 0xe3, 0x03, 0xad, 0xb0, 0x5c, 0x09, 0x8f, 0xe9,
+	0x89, 0xe9, 0x9e, 0x63, 0x1a, 0x66, 0x3c, 0x9f, 0xfa, 0x38, 0x08, 0xe8, 0x8e, 0xd2, 0x7d, 0x57,
+	0xf5, 0x1e, 0xd4, 0x62, 0x5e, 0xa4, 0x72, 0x50, 0xe3, 0xe5, 0x50, 0x63, 0xe5, 0xf0, 0x0c, 0xaa,
+	0xb2, 0xcb, 0x3a, 0x14, 0x03, 0x62, 0xfa, 0x44, 0xec, 0x4b, 0x15, 0xf2, 0xd8, 0x1d, 0x89, 0x5d,
+	0xf9, 0x3d, 0x34, 0xde, 0xd0, 0x13, 0x38, 0x88, 0x32, 0x3c, 0x11, 0x70, 0x25, 0x3b, 0xe0, 0x0f,
+	0xa4, 0xed, 0xce, 0x6d, 0xe6, 0xc3, 0x88, 0x8b, 0x6c, 0x41, 0x4f, 0xa0, 0x32, 0xf5, 0xbd, 0xd1,
+	0xcc, 0xc2, 0xbe, 0xd8, 0x90, 0x36, 0xf3, 0x20, 0x64, 0xa7, 0x74, 0x2e, 0xd7, 0xc2, 0xc6, 0x6f,
+	0xa1, 0x99, 0x94, 0xd1, 0x08, 0x84, 0xb6, 0x87, 0x83, 0xe5, 0x22, 0xf1, 0xd4, 0xb3, 0x2e, 0xc4,
+	0xa9, 0xd6, 0x84, 0x4a, 0x20, 0xd4, 0x45, 0x05, 0x75, 0xa0, 0x4e, 0x7c, 0xd3, 0x0d, 0x4c, 0x8b,
+	0x02, 0x34, 0x1d, 0x16, 0xde, 0x8a, 0xf1, 0x1d, 0x6c, 0xec, 0xe2, 0x73, 0xdb, 0x3d, 0x5b, 0x8e,
+	0x85, 0xeb, 0xdc, 0x80, 0xb5, 0x98, 0x45, 0x34, 0x97, 0x8c, 0x3d, 0x77, 0x0d, 0xf6, 0x5d, 0xd0,
+	0xd2, 0xbe, 0x45, 0x31, 0xc9, 0x3e, 0x94, 0x6b, 0x7c, 0xfc, 0x41, 0x81, 0x87, 0xfd, 0xd1, 0x28,
+	0x8a, 0x6c, 0x70, 0xe6, 0xdd, 0x06, 0x6a, 0x3c, 0x54, 0x3c, 0x31, 0x1f, 0x01, 0x44, 0xfb, 0x17,
+	0xe6, 0x66, 0x6a, 0xfb, 0xa2, 0x78, 0xb2, 0xa3, 0xc6, 0xf8, 0x04, 0x8c, 0xeb, 0x50, 0x88, 0x72,
+	0xb1, 0xa0, 0xb3, 0xef, 0x8e, 0x7e, 0x28, 0xbe, 0x06, 0x94, 0x2c, 0x6f, 0x32, 0xb1, 0xf9, 0xd9,
+	0x57, 0x49, 0x42, 0xd1, 0xa0, 0x9b, 0x9c, 0x44, 0x4c, 0xff, 0x67, 0x05, 0x1e, 0xbc, 0xf5, 0x6d,
+	0x82, 0xa5, 0xc1, 0x23, 0xd3, 0xbf, 0xc4, 0xfe, 0xed, 0x52, 0x37, 0x0b, 0xd4, 0x63, 0x28, 0x4d,
+	0x98, 0x2b, 0x41, 0x0b, 0x3a, 0xd4, 0x28, 0x35, 0x4f, 0x12, 0xeb, 0x26, 0x7c, 0xb4, 0x0a, 0x90,
+	0xc0, 0xfc, 0x47, 0x05, 0xd6, 0xf7, 0xd8, 0x6a, 0x45, 0x41, 0x0a, 0xa4, 0x1d, 0xa8, 0x5b, 0x9e,
+	0x1b, 0xcc, 0x26, 0xd8, 0x3f, 0xf0, 0xbd, 0xd9, 0x54, 0xc4, 0xeb, 0xff, 0xbb, 0x3d, 0xe2, 0x0b,
+	0x2a, 0xc4, 0x0b, 0xa6, 0xc8, 0x90, 0x76, 0xa1, 0x1d, 0x87, 0x21, 0xf0, 0x19, 0xb0, 0x16, 0x95,
+	0x3f, 0x17, 0xa1, 0xb5, 0xe5, 0x11, 0xa5, 0xd0, 0x7b, 0xdb, 0xf8, 0x09, 0xa0, 0x3d, 0x1f, 0x9b,
+	0x04, 0x33, 0xfa, 0x10, 0xae, 0xe0, 0x1e, 0x14, 0x26, 0x98, 0x98, 0x22, 0xc8, 0x75, 0x16, 0x2f,
+	0x3a, 0x7e, 0x84, 0x89, 0x69, 0x3c, 0x84, 0xf5, 0x98, 0x89, 0x70, 0x0d, 0x90, 0xf3, 0x2e, 0x99,
+	0x45, 0xc5, 0xf8, 0x29, 0xb4, 0xfa, 0x4e, 0xc8, 0x49, 0x42, 0xa7, 0x75, 0x28, 0x12, 0xfa, 0x2d,
+	0xa5, 0xcf, 0x32, 0x95, 0x59, 0xe9, 0x53, 0x34, 0xb2, 0x9d, 0xf0, 0x7c, 0x2d, 0x9a, 0x27, 0xd0,
+	0x39, 0xc0, 0x84, 0x7d, 0xef, 0x79, 0xee, 0xd8, 0x3e, 0xcf, 0x9e, 0xce, 0xf8, 0xaf, 0x02, 0xdd,
+	0xa4, 0xa2, 0xf0, 0xff, 0x15, 0xa8, 0xde, 0x15, 0xf6, 0x7d, 0x7b, 0x84, 0x79, 0x58, 0xaa, 0x3b,
+	0xcf, 0xe8, 0x24, 0xd9, 0xea, 0xbd, 0xe3, 0x50, 0x97, 0x9f, 0xd7, 0x5f, 0x81, 0x8a, 0xc7, 0x63,
+	0x6c, 0x11, 0xfb, 0x0a, 0x6b, 0xb9, 0x1b, 0xad, 0xf7, 0x43, 0x5d, 0xce, 0x85, 0x3e, 0x87, 0x46,
+	0xc2, 0xdf, 0xea, 0xf3, 0x9f, 0xd1, 0x21, 0x6a, 0x11, 0xf7, 0x71, 0x93, 0x85, 0xf1, 0x17, 0x05,
+	0x3a, 0xa7, 0xef, 0x11, 0x23, 0xf4, 0x19, 0xe4, 0xf9, 0xf5, 0x4f, 0x17, 0x61, 0xb0, 0x2b, 0x2f,
+	0xcb, 0x8c, 0x4a, 0xf9, 0xcc, 0x75, 0x28, 0xce, 0x5c, 0x9e, 0xab, 0xf9, 0x2d, 0x55, 0xdf, 0x86,
+	0x4a, 0x34, 0x74, 0x13, 0xa8, 0x17, 0xd0, 0x3d, 0xcd, 0xde, 0x8e, 0x6b, 0xb7, 0x7b, 0x07, 0xd0,
+	0x00, 0x3b, 0x38, 0x91, 0xaf, 0x89, 0x75, 0xd4, 0xa1, 0xe8, 0x78, 0x96, 0xe9, 0xb0, 0xe9, 0x2a,
+	0x34, 0x61, 0x63, 0x36, 0x19, 0x09, 0xbb, 0x0e, 0xad, 0x57, 0x76, 0xc0, 0xe1, 0x84, 0xec, 0xc5,
+	0x78, 0x0e, 0x48, 0x16, 0x0a, 0xb3, 0x07, 0x50, 0x62, 0x73, 0x85, 0xa9, 0x92, 0x00, 0xf8, 0x05,
+	0xb4, 0x07, 0x98, 0xf7, 0x37, 0xb7, 0x80, 0x38, 0x84, 0x4e, 0xc2, 0xea, 0x3d, 0x82, 0x81, 0x3e,
+	0x4d, 0x94, 0x10, 0x85, 0xa3, 0xc5, 0x0e, 0x94, 0x01, 0x8e, 0x18, 0x8b, 0xf1, 0x6f, 0x05, 0xda,
+	0x59, 0x03, 0x94, 0x12, 0x45, 0x6e, 0x04, 0x7f, 0x6e, 0x40, 0xc9, 0x61, 0xa4, 0x44, 0x1c, 0xa1,
+	0x4d, 0xa8, 0xf8, 0x78, 0xea, 0xd8, 0x96, 0xc9, 0x6f, 0x1d, 0x95, 0xee, 0xaf, 0x1d, 0xf8, 0x8c,
+	0xfd, 0xab, 0x94, 0xe2, 0x8e, 0x6d, 0x3f, 0x08, 0xf9, 0x63, 0x31, 0x3c, 0xb9, 0x1c, 0x33, 0x92,
+	0x71, 0x46, 0x5b, 0x83, 0x42, 0x60, 0xff, 0x8e, 0x77, 0x45, 0x79, 0xf4, 0x31, 0xa8, 0xe1, 0x41,
+	0x19, 0x68, 0x95, 0xcd, 0x7c, 0x8a, 0x1f, 0xae, 0x41, 0x19, 0xcf, 0xa7, 0xb6, 0x8f, 0x47, 0x9a,
+	0xca, 0x2c, 0xba, 0xd0, 0x10, 0x82, 0x3d, 0x07, 0x9b, 0x2e, 0x1e, 0x69, 0x40, 0xe5, 0xc6, 0x6f,
+	0xa0, 0x7a, 0xba, 0x70, 0xad, 0x5b, 0xdf, 0x15, 0x63, 0xdf, 0x9b, 0xc8, 0xa4, 0x37, 0x49, 0xd6,
+	0xf3, 0xec, 0x58, 0xea, 0x43, 0x8d, 0x7b, 0x7f, 0x4f, 0x36, 0xcd, 0xa9, 0x7c, 0x8c, 0x4c, 0x3f,
+	0x85, 0x76, 0x7f, 0x3a, 0x75, 0x16, 0x74, 0xdf, 0x46, 0x26, 0x31, 0x43, 0xa4, 0x6b, 0x50, 0xa6,
+	0x17, 0xa6, 0xe9, 0x8e, 0x38, 0x19, 0x35, 0x1e, 0x41, 0x27, 0xa1, 0x98, 0x91, 0xae, 0x6d, 0x40,
+	0x07, 0x98, 0x24, 0x7c, 0x19, 0x4f, 0x61, 0x3d, 0x26, 0x15, 0x86, 0x8c, 0xbc, 0x73, 0x99, 0x98,
+	0xe3, 0x05, 0x54, 0xa2, 0x10, 0xaf, 0xb8, 0xac, 0x10, 0x40, 0x28, 0x0e, 0xef, 0x51, 0xe3, 0x33,
+	0xa8, 0x26, 0x68, 0x86, 0x9c, 0xd1, 0xb1, 0x2c, 0xe2, 0xc7, 0xf9, 0x5f, 0x15, 0x50, 0x97, 0xd9,
+	0x7a, 0xf3, 0xf9, 0x9f, 0x48, 0x33, 0x2a, 0x79, 0x46, 0x09, 0x05, 0x3d, 0x1e, 0x58, 0xa6, 0x55,
+	0x77, 0xee, 0xc6, 0x2a, 0xa0, 0xc7, 0x8f, 0x8e, 0xa8, 0xad, 0x94, 0x3e, 0x6f, 0x3c, 0x80, 0xbe,
+	0x83, 0x4a, 0xb4, 0x61, 0x4f, 0x63, 0x1b, 0x4a, 0xe7, 0xe9, 0x48, 0x1b, 0xfa, 0xd6, 0x26, 0x17,
+	0x7c, 0x33, 0xd1, 0x63, 0xa8, 0xf1, 0xd7, 0x03, 0xde, 0xc0, 0x8a, 0x9a, 0x63, 0x99, 0xf5, 0x72,
+	0x29, 0x37, 0x3e, 0x85, 0xaa, 0xf4, 0x29, 0xdd, 0xe9, 0x51, 0xf3, 0xc4, 0x9a, 0x65, 0x11, 0xd7,
+	0xbf, 0x29, 0x50, 0x16, 0x53, 0xc9, 0xa8, 0x6b, 0x09, 0xf6, 0x8f, 0x9e, 0x25, 0xbb, 0x12, 0x4d,
+	0x02, 0x19, 0x6f, 0x45, 0x5a, 0xa0, 0x8e, 0xf8, 0x6b, 0x41, 0x9f, 0x68, 0x85, 0xb0, 0xbf, 0xe4,
+	0xa5, 0x13, 0xf4, 0x45, 0x85, 0xde, 0xba, 0xf5, 0xf8, 0xbb, 0x02, 0xad, 0x74, 0x4c, 0x92, 0xab,
+	0xbb, 0x0f, 0x65, 0x11, 0x4c, 0xc1, 0x71, 0x62, 0xcd, 0x43, 0x0b, 0x54, 0x62, 0x4f, 0x70, 0x40,
+	0xcc, 0xc9, 0x54, 0x50, 0x1c, 0x99, 0x4f, 0x17, 0x56, 0xf3, 0x69, 0x89, 0xc7, 0x15, 0xaf, 0xe3,
+	0x71, 0xb1, 0x44, 0x2c, 0x85, 0x79, 0x25, 0xc2, 0xb1, 0x60, 0x47, 0x4f, 0xd1, 0x78, 0x01, 0x35,
+	0x0e, 0x9f, 0x33, 0xa7, 0x55, 0x65, 0xb0, 0x5c, 0x1b, 0x2b, 0xe3, 0xed, 0x2f, 0xa1, 0x91, 0x68,
+	0xbe, 0xdb, 0xd0, 0x3c, 0xd9, 0xef, 0x0f, 0xbe, 0xff, 0xf6, 0xf5, 0xde, 0xf1, 0xd1, 0xd1, 0xe1,
+	0xd9, 0xd9, 0xfe, 0xa0, 0x79, 0x07, 0x21, 0x68, 0x30, 0xe9, 0x52, 0xa6, 0x6c, 0xff, 0x1c, 0x5a,
+	0x59, 0xa4, 0x53, 0x7d, 0x7d, 0xfc, 0xfd, 0x51, 0xff, 0xe4, 0x97, 0xfb, 0x27, 0xcd, 0x3b, 0x08,
+	0xa0, 0xc4, 0x4d, 0x9a, 0x0a, 0x52, 0xa1, 0xd8, 0xdf, 0x3d, 0x3e, 0x39, 0x6b, 0xe6, 0x76, 0xfe,
+	0x03, 0x50, 0xfb, 0xd6, 0x21, 0xbe, 0x39, 0x9c, 0x05, 0xaf, 0xbd, 0x11, 0x46, 0xcf, 0x41, 0x8d,
+	0x9e, 0xd1, 0x50, 0x5b, 0x6a, 0x4e, 0xa3, 0x57, 0x35, 0x3d, 0x76, 0x24, 0x19, 0x77, 0x3e, 0x57,
+	0x50, 0x0f, 0x8a, 0xec, 0x55, 0x00, 0xb1, 0xd7, 0x28, 0xf9, 0xe5, 0x43, 0x6f, 0x49, 0x12, 0xc1,
+	0x1e, 0xef, 0xd0, 0x2e, 0x58, 0xf0, 0x47, 0xc4, 0x9e, 0x1d, 0xe2, 0xbd, 0xa4, 0xbe, 0x1e, 0x93,
+	0x45, 0x56, 0xbf, 0x80, 0xaa, 0x44, 0x0f, 0x51, 0x57, 0xf4, 0xea, 0x09, 0x8a, 0xa9, 0x6f, 0xa4,
+	0xe4, 0x91, 0x87, 0xaf, 0x01, 0x96, 0x2c, 0x10, 0xb1, 0x5d, 0x4e, 0xb1, 0x49, 0xbd, 0x9b, 0x14,
+	0xcb, 0x00, 0xa4, 0xeb, 0x9e, 0x03, 0x48, 0x73, 0x06, 0x7d, 0x23, 0x25, 0x8f, 0x3c, 0x1c, 0x42,
+	0x23, 0xce, 0xde, 0xd0, 0xdd, 0x2c, 0x46, 0xc7, 0xfd, 0xe8, 0xab, 0xc9, 0x1e, 0x77, 0x75, 0x9a,
+	0xe1, 0xea, 0x74, 0xb5, 0xab, 0xd3, 0x55, 0xae, 0xbe, 0x06, 0x58, 0xd2, 0x11, 0x1e, 0x96, 0x14,
+	0x67, 0xd1, 0xbb, 0x49, 0x71, 0x64, 0xfe, 0x12, 0xea, 0x31, 0x8a, 0x81, 0x34, 0x1e, 0x80, 0x34,
+	0x57, 0xd1, 0xef, 0x66, 0x8c, 0x44, 0x7e, 0xf6, 0xa0, 0x26, 0x77, 0x1b, 0x88, 0x6f, 0x64, 0xba,
+	0x0d, 0xd2, 0xb5, 0xf4, 0x80, 0xec, 0x44, 0x7e, 0xb4, 0xe1, 0x4e, 0x32, 0x5e, 0x90, 0x74, 0x2d,
+	0x3d, 0x10, 0x39, 0xd9, 0x82, 0x7c, 0xdf, 0xba, 0x44, 0x8d, 0xf8, 0x53, 0xa3, 0xbe, 0x16, 0x7d,
+	0x47, 0x9a, 0xc7, 0xd0, 0x4c, 0x76, 0xf3, 0xe8, 0x1e, 0x7b, 0xb3, 0xcd, 0x7e, 0x3f, 0xd0, 0xef,
+	0x67, 0x0f, 0x46, 0x0e, 0x27, 0xa0, 0xaf, 0xee, 0xa9, 0xd1, 0x63, 0x86, 0xe0, 0xa6, 0xce, 0x5f,
+	0x7f, 0x72, 0x93, 0x9a, 0x9c, 0x45, 0xf1, 0xbe, 0x99, 0x67, 0x51, 0x66, 0xc3, 0xae, 0xeb, 0x59,
+	0x43, 0x91, 0xab, 0x1f, 0x41, 0x81, 0x72, 0x19, 0xc4, 0xa2, 0x24, 0x71, 0x26, 0xbd, 0xb9, 0x14,
+	0xc8, 0x39, 0x13, 0x23, 0x23, 0x3c, 0x67, 0xb2, 0x88, 0x8c, 0x7e, 0x37, 0x63, 0x44, 0x2e, 0x49,
+	0x89, 0x99, 0xf0, 0x92, 0x4c, 0x13, 0x18, 0x7d, 0x23, 0x25, 0x8f, 0x3c, 0x98, 0xd0, 0xcd, 0xee,
+	0xc6, 0xd1, 0x43, 0x6a, 0x74, 0xed, 0xd3, 0x81, 0x6e, 0x5c, 0xa7, 0x12, 0x4e, 0x31, 0x2c, 0xb1,
+	0xdf, 0x14, 0xcf, 0xff, 0x37, 0x00, 0x81, 0xfb, 0xb9, 0xbf, 0xb6, 0x18, 0x00, 0x00,
 }
@@ -0,0 +1,378 @@
+package ultrabus
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/emef/ultrabus/pb"
+)
+
+// Buffers messages per partition and publishes each partition's
+// messages in batches, in the order they were sent.
+type Producer interface {
+	// Queues message for topic, blocking while the producer's buffer is
+	// full. The receipt resolves once the message's batch is written.
+	Send(
+		topic string,
+		message *pb.Message,
+		callback PublishCallback) (WriteReceipt, error)
+
+	// Publishes every buffered message and waits for all messages sent
+	// so far, returning the first error any message failed with since
+	// Flush last returned one.
+	Flush() error
+
+	// Flushes and stops the producer. Sends after Close fail.
+	Close() error
+}
+
+// How a Producer batches, see DefaultProducerConfig.
+type ProducerConfig struct {
+	// How long a partition's batch waits for more messages
+	Linger time.Duration
+
+	// Messages, and bytes of keys, values and headers, that have a batch
+	// sent as soon as it reaches either
+	BatchMessages int
+	BatchBytes    int64
+
+	// Bytes of buffered and unacknowledged messages Send blocks beyond
+	BufferBytes int64
+}
+
+func DefaultProducerConfig() *ProducerConfig {
+	return &ProducerConfig{
+		Linger:        5 * time.Millisecond,
+		BatchMessages: 500,
+		BatchBytes:    16384,
+		BufferBytes:   32 * 1024 * 1024}
+}
+
+func (config *ProducerConfig) validate() error {
+	switch {
+	case config.Linger < 0:
+		return fmt.Errorf("Producer linger must not be negative")
+	case config.BatchMessages < 1:
+		return fmt.Errorf("Producer batches need at least one message")
+	case config.BatchBytes < 1:
+		return fmt.Errorf("Producer batches need at least one byte")
+	case config.BufferBytes < 1:
+		return fmt.Errorf("Producer buffer needs at least one byte")
+	}
+
+	return nil
+}
+
+type batchingProducer struct {
+	brokers func(topic string) (*TopicBroker, error)
+	config  *ProducerConfig
+	pool    *bufferPool
+
+	lock    sync.Mutex
+	closed  bool
+	batches map[pb.PartitionID]*producerBatch
+
+	// Batches dispatched to each partition and not yet sent, bounded by
+	// the buffer, and the partitions with a sender publishing them
+	queued  map[pb.PartitionID][]*producerBatch
+	sending map[pb.PartitionID]bool
+
+	// Partition keyless messages of sticky topics go to until its batch
+	// is sent
+	sticky map[string]int32
+
+	// Batches dispatched and not yet sent, and the first error a message
+	// failed with since Flush last returned one
+	inflightLock sync.Mutex
+	inflight     map[*producerBatch]struct{}
+	failed       error
+}
+
+// Messages buffered for one partition, sent as one PublishRequest.
+type producerBatch struct {
+	broker      *TopicBroker
+	partitionID *pb.PartitionID
+	messages    []*pb.Message
+	receipts    []*receiptImpl
+	callbacks   []PublishCallback
+	bytes       int64
+	timer       *time.Timer
+	created     time.Time
+	done        chan interface{}
+}
+
+func newBatchingProducer(
+	brokers func(topic string) (*TopicBroker, error),
+	config *ProducerConfig) (*batchingProducer, error) {
+
+	if config == nil {
+		config = DefaultProducerConfig()
+	} else if err := config.validate(); err != nil {
+		return nil, err
+	}
+
+	return &batchingProducer{
+		brokers:  brokers,
+		config:   config,
+		pool:     newBufferPool(config.BufferBytes),
+		batches:  make(map[pb.PartitionID]*producerBatch),
+		queued:   make(map[pb.PartitionID][]*producerBatch),
+		sending:  make(map[pb.PartitionID]bool),
+		sticky:   make(map[string]int32),
+		inflight: make(map[*producerBatch]struct{})}, nil
+}
+
+func (producer *batchingProducer) Send(
+	topic string,
+	message *pb.Message,
+	callback PublishCallback) (WriteReceipt, error) {
+
+	broker, err := producer.brokers(topic)
+	if err != nil {
+		return nil, err
+	}
+
+	size := messageSize(message)
+	if size > producer.config.BufferBytes {
+		return nil, &MessageTooLargeError{size, producer.config.BufferBytes}
+	}
+
+	if err := producer.pool.acquire(size); err != nil {
+		return nil, err
+	}
+
+	producer.lock.Lock()
+	defer producer.lock.Unlock()
+
+	partitionID, err := producer.place(broker, message)
+	if err != nil {
+		producer.pool.release(size)
+		return nil, err
+	}
+
+	batch, ok := producer.batches[partitionID]
+	if !ok {
+		batch = &producerBatch{
+			broker: broker,
+			partitionID: &pb.PartitionID{
+				Topic:     partitionID.Topic,
+				Partition: partitionID.Partition},
+			created: time.Now(),
+			done:    make(chan interface{})}
+		batch.timer = time.AfterFunc(producer.config.Linger, func() {
+			producer.lock.Lock()
+			defer producer.lock.Unlock()
+
+			if producer.batches[partitionID] == batch {
+				producer.dispatch(partitionID)
+			}
+		})
+
+		producer.batches[partitionID] = batch
+	}
+
+	receipt := newReceipt()
+	batch.messages = append(batch.messages, message)
+	batch.receipts = append(batch.receipts, receipt)
+	batch.callbacks = append(batch.callbacks, callback)
+	batch.bytes += size
+
+	if len(batch.messages) >= producer.config.BatchMessages ||
+		batch.bytes >= producer.config.BatchBytes {
+		producer.dispatch(partitionID)
+	}
+
+	return receipt, nil
+}
+
+// Chooses message's partition. Must hold the lock.
+func (producer *batchingProducer) place(
+	broker *TopicBroker, message *pb.Message) (pb.PartitionID, error) {
+
+	if producer.closed {
+		return pb.PartitionID{}, &ProducerClosedError{}
+	}
+
+	topic, config, strategy, err := broker.placement()
+	if err != nil {
+		return pb.PartitionID{}, err
+	}
+
+	sticky, ok := producer.sticky[topic.Topic]
+	if !ok && strategy == KeylessSticky {
+		sticky = broker.nextKeylessPartition(topic.Partitions)
+		producer.sticky[topic.Topic] = sticky
+	}
+
+	partition, err := broker.partition(topic, config, strategy, sticky, message)
+	if err != nil {
+		return pb.PartitionID{}, err
+	}
+
+	return pb.PartitionID{Topic: topic.Topic, Partition: partition}, nil
+}
+
+// Queues a partition's batch for its sender, starting one if there's
+// none. Must hold the lock, so never waits.
+func (producer *batchingProducer) dispatch(partitionID pb.PartitionID) {
+	batch := producer.batches[partitionID]
+	delete(producer.batches, partitionID)
+	batch.timer.Stop()
+
+	if sticky, ok := producer.sticky[partitionID.Topic]; ok &&
+		sticky == partitionID.Partition {
+		delete(producer.sticky, partitionID.Topic)
+	}
+
+	producer.inflightLock.Lock()
+	producer.inflight[batch] = struct{}{}
+	producer.inflightLock.Unlock()
+
+	producer.queued[partitionID] = append(producer.queued[partitionID], batch)
+	if !producer.sending[partitionID] {
+		producer.sending[partitionID] = true
+		go producer.send(partitionID)
+	}
+}
+
+// The next batch queued for partitionID, or nil once there's none and
+// the partition's sender is done.
+func (producer *batchingProducer) next(partitionID pb.PartitionID) *producerBatch {
+	producer.lock.Lock()
+	defer producer.lock.Unlock()
+
+	queued := producer.queued[partitionID]
+	if len(queued) == 0 {
+		delete(producer.queued, partitionID)
+		delete(producer.sending, partitionID)
+		return nil
+	}
+
+	producer.queued[partitionID] = queued[1:]
+	return queued[0]
+}
+
+// Publishes a partition's batches one at a time, keeping them in order,
+// until none are left.
+func (producer *batchingProducer) send(partitionID pb.PartitionID) {
+	for {
+		batch := producer.next(partitionID)
+		if batch == nil {
+			return
+		}
+
+		request := &pb.PublishRequest{
+			PartitionID: batch.partitionID,
+			Messages:    batch.messages}
+		batch.broker.send(request, batch.receipts, nil, batch.created)
+
+		producer.pool.release(batch.bytes)
+
+		for i, receipt := range batch.receipts {
+			if batch.callbacks[i] != nil {
+				offset, err := receipt.Read()
+				batch.callbacks[i](batch.messages[i], offset, err)
+			}
+		}
+
+		producer.inflightLock.Lock()
+		delete(producer.inflight, batch)
+		for _, receipt := range batch.receipts {
+			if _, err := receipt.Read(); err != nil && producer.failed == nil {
+				producer.failed = err
+			}
+		}
+		producer.inflightLock.Unlock()
+
+		close(batch.done)
+	}
+}
+
+func (producer *batchingProducer) Flush() error {
+	producer.lock.Lock()
+	for partitionID := range producer.batches {
+		producer.dispatch(partitionID)
+	}
+	producer.lock.Unlock()
+
+	producer.inflightLock.Lock()
+	batches := make([]*producerBatch, 0, len(producer.inflight))
+	for batch := range producer.inflight {
+		batches = append(batches, batch)
+	}
+	producer.inflightLock.Unlock()
+
+	for _, batch := range batches {
+		<-batch.done
+	}
+
+	producer.inflightLock.Lock()
+	defer producer.inflightLock.Unlock()
+
+	err := producer.failed
+	producer.failed = nil
+	return err
+}
+
+func (producer *batchingProducer) Close() error {
+	producer.lock.Lock()
+	if producer.closed {
+		producer.lock.Unlock()
+		return nil
+	}
+
+	producer.closed = true
+	producer.lock.Unlock()
+
+	producer.pool.close()
+	return producer.Flush()
+}
+
+// Bytes a producer may hold, handed out to messages until they're sent.
+type bufferPool struct {
+	lock   sync.Mutex
+	cond   *sync.Cond
+	free   int64
+	closed bool
+}
+
+func newBufferPool(size int64) *bufferPool {
+	pool := &bufferPool{free: size}
+	pool.cond = sync.NewCond(&pool.lock)
+	return pool
+}
+
+// Takes size bytes, waiting until they're free.
+func (pool *bufferPool) acquire(size int64) error {
+	pool.lock.Lock()
+	defer pool.lock.Unlock()
+
+	for pool.free < size && !pool.closed {
+		pool.cond.Wait()
+	}
+
+	if pool.closed {
+		return &ProducerClosedError{}
+	}
+
+	pool.free -= size
+	return nil
+}
+
+func (pool *bufferPool) release(size int64) {
+	pool.lock.Lock()
+	defer pool.lock.Unlock()
+
+	pool.free += size
+	pool.cond.Broadcast()
+}
+
+// Fails every acquire from now on.
+func (pool *bufferPool) close() {
+	pool.lock.Lock()
+	defer pool.lock.Unlock()
+
+	pool.closed = true
+	pool.cond.Broadcast()
+}
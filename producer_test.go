@@ -0,0 +1,136 @@
+package ultrabus
+
+import (
+	"testing"
+	"time"
+
+	"github.com/emef/ultrabus/pb"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+func TestBatchingProducer(t *testing.T) {
+	assert := assert.New(t)
+
+	client := newRecordingNodeClient()
+	broker := NewTopicBroker(
+		&pb.TopicMeta{Topic: "orders", Partitions: 1}, nil, client)
+	brokers := func(topic string) (*TopicBroker, error) {
+		if topic != "orders" {
+			return nil, &TopicNotFoundError{topic}
+		}
+
+		return broker, nil
+	}
+
+	producer, err := newBatchingProducer(brokers, &ProducerConfig{
+		Linger:        time.Hour,
+		BatchMessages: 2,
+		BatchBytes:    1000,
+		BufferBytes:   1000})
+	assert.Nil(err)
+
+	send := func() WriteReceipt {
+		receipt, err := producer.Send(
+			"orders", &pb.Message{Value: []byte("value")}, nil)
+		assert.Nil(err)
+		return receipt
+	}
+
+	// Full batches are sent right away, the rest waits for a flush
+	first, second, third := send(), send(), send()
+	<-second.Done()
+	offset, err := first.Read()
+	assert.Nil(err)
+	assert.Equal(int64(0), offset)
+	_, err = third.Read()
+	assert.IsType(&ReceiptNotWrittenError{}, err)
+
+	assert.Nil(producer.Flush())
+	offset, _ = third.Read()
+	assert.Equal(int64(2), offset)
+	assert.Equal(2, client.requests)
+
+	_, err = producer.Send("missing", &pb.Message{}, nil)
+	assert.IsType(&TopicNotFoundError{}, err)
+
+	// Lingering batches are sent by themselves
+	lingering, err := newBatchingProducer(brokers, &ProducerConfig{
+		Linger:        time.Millisecond,
+		BatchMessages: 2,
+		BatchBytes:    1000,
+		BufferBytes:   1000})
+	assert.Nil(err)
+
+	receipt, err := lingering.Send("orders", &pb.Message{}, nil)
+	assert.Nil(err)
+	select {
+	case <-receipt.Done():
+	case <-time.After(time.Second):
+		t.Fatal("Lingering batch was never sent")
+	}
+
+	assert.Nil(producer.Close())
+	_, err = producer.Send("orders", &pb.Message{}, nil)
+	assert.IsType(&ProducerClosedError{}, err)
+
+	_, err = newBatchingProducer(brokers, &ProducerConfig{})
+	assert.NotNil(err)
+
+	// Failures are kept for the next flush however early they happen, and
+	// callbacks may send more
+	flaky := NewTopicBroker(&pb.TopicMeta{Topic: "orders", Partitions: 1},
+		nil, &flakyNodeClient{newRecordingNodeClient(), 1,
+			grpc.Errorf(codes.InvalidArgument, "rejected")})
+	failing, err := newBatchingProducer(
+		func(topic string) (*TopicBroker, error) { return flaky, nil },
+		&ProducerConfig{
+			Linger:        time.Hour,
+			BatchMessages: 1,
+			BatchBytes:    1000,
+			BufferBytes:   1000})
+	assert.Nil(err)
+
+	resent := make(chan WriteReceipt, 1)
+	receipt, err = failing.Send("orders", &pb.Message{},
+		func(message *pb.Message, offset int64, err error) {
+			receipt, _ := failing.Send("orders", &pb.Message{}, nil)
+			resent <- receipt
+		})
+	assert.Nil(err)
+	<-receipt.Done()
+
+	select {
+	case receipt = <-resent:
+		<-receipt.Done()
+	case <-time.After(time.Second):
+		t.Fatal("Sending from a callback blocked")
+	}
+
+	assert.NotNil(failing.Flush())
+	assert.Nil(failing.Flush())
+	assert.Nil(failing.Close())
+}
+
+func TestBufferPool(t *testing.T) {
+	pool := newBufferPool(10)
+	assert.Nil(t, pool.acquire(8))
+
+	acquired := make(chan error)
+	go func() {
+		acquired <- pool.acquire(5)
+	}()
+
+	select {
+	case <-acquired:
+		t.Fatal("Acquired more than the pool holds")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	pool.release(8)
+	assert.Nil(t, <-acquired)
+
+	pool.close()
+	assert.IsType(t, &ProducerClosedError{}, pool.acquire(1))
+}
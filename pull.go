@@ -0,0 +1,236 @@
+package ultrabus
+
+import (
+	"sync"
+	"time"
+
+	"github.com/emef/ultrabus/pb"
+	"golang.org/x/net/context"
+)
+
+// Reads a topic by fetching rather than over streams, for consumers such
+// as batch jobs that can't hold a stream open. Each partition is read from
+// the consumer group's committed offset, or when the group hasn't
+// committed one from its end, or its beginning WithEarliestOffsets.
+type PullConsumer interface {
+	// The messages following those earlier polls returned, waiting up to
+	// maxWait for any to arrive. At most maxMessages, and maxBytes of them,
+	// are fetched from each partition, 0 for the most a node sends at once.
+	Poll(
+		maxMessages int32,
+		maxBytes int64,
+		maxWait time.Duration) ([]*pb.MessageWithOffset, error)
+
+	// Reads partition from offset on
+	Seek(partition int32, offset int64)
+
+	// Commits the offsets polls have read up to, which the consumer group
+	// starts from next
+	Commit() error
+}
+
+type pullConsumer struct {
+	client *singleAddrBrokeredClient
+	broker *TopicBroker
+
+	lock sync.Mutex
+
+	// Where each partition is read from next, once known
+	offsets map[int32]int64
+}
+
+type fetchResult struct {
+	partition int32
+	response  *pb.FetchResponse
+	err       error
+}
+
+func (client *singleAddrBrokeredClient) NewPullConsumer(
+	topic string) (PullConsumer, error) {
+
+	broker, err := client.broker(topic)
+	if err != nil {
+		return nil, err
+	}
+
+	return &pullConsumer{
+		client:  client,
+		broker:  broker,
+		offsets: make(map[int32]int64)}, nil
+}
+
+func (client *singleAddrBrokeredClient) Fetch(
+	topic string,
+	partition int32,
+	offset int64,
+	maxMessages int32,
+	maxBytes int64,
+	maxWait time.Duration) (*pb.FetchResponse, error) {
+
+	return client.fetch(context.Background(),
+		topic, partition, offset, maxMessages, maxBytes, maxWait)
+}
+
+func (client *singleAddrBrokeredClient) fetch(
+	ctx context.Context,
+	topic string,
+	partition int32,
+	offset int64,
+	maxMessages int32,
+	maxBytes int64,
+	maxWait time.Duration) (*pb.FetchResponse, error) {
+
+	partitionID := &pb.PartitionID{Topic: topic, Partition: partition}
+	node, err := client.connectionManager.GetReadClient(partitionID)
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := node.Fetch(ctx, &pb.FetchRequest{
+		ClientID:    client.clientID,
+		PartitionID: partitionID,
+		Offset:      offset,
+		MaxMessages: maxMessages,
+		MaxBytes:    maxBytes,
+		MaxWaitMs:   int64(maxWait / time.Millisecond),
+		Isolation:   client.options.isolation,
+		Earliest:    client.options.earliest})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, msg := range response.Messages.GetMessages() {
+		msg.Partition = partition
+	}
+
+	return response, nil
+}
+
+// Fetches every partition at once, taking what's arrived as soon as any
+// partition has messages. An error fetching a partition is returned only
+// when no other partition had messages.
+func (consumer *pullConsumer) Poll(
+	maxMessages int32,
+	maxBytes int64,
+	maxWait time.Duration) ([]*pb.MessageWithOffset, error) {
+
+	consumer.lock.Lock()
+	defer consumer.lock.Unlock()
+
+	// Partitions not yet read are pinned to where they start without
+	// waiting first. Left to a long poll, one cut short by another
+	// partition's messages would start later on, past what arrived since.
+	var unknown, known []int32
+	meta := consumer.broker.meta()
+	for partition := int32(0); partition < meta.Partitions; partition++ {
+		if _, ok := consumer.offsets[partition]; ok {
+			known = append(known, partition)
+		} else {
+			unknown = append(unknown, partition)
+		}
+	}
+
+	messages, pinErr := consumer.fetch(unknown, maxMessages, maxBytes, 0)
+	if len(messages) > 0 {
+		return messages, nil
+	}
+
+	messages, err := consumer.fetch(known, maxMessages, maxBytes, maxWait)
+	if len(messages) > 0 {
+		return messages, nil
+	} else if err == nil {
+		err = pinErr
+	}
+
+	return nil, err
+}
+
+// Fetches partitions at once, from where each is read next or else from
+// where it starts, and moves them past what's fetched, along with the
+// first error fetching one. Must hold the consumer's lock.
+func (consumer *pullConsumer) fetch(
+	partitions []int32,
+	maxMessages int32,
+	maxBytes int64,
+	maxWait time.Duration) ([]*pb.MessageWithOffset, error) {
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	topic := consumer.broker.meta().Topic
+	results := make(chan *fetchResult, len(partitions))
+	for _, partition := range partitions {
+		offset, ok := consumer.offsets[partition]
+		if !ok {
+			offset = -1
+		}
+
+		go func(partition int32, offset int64) {
+			response, err := consumer.client.fetch(ctx, topic,
+				partition, offset, maxMessages, maxBytes, maxWait)
+			results <- &fetchResult{partition, response, err}
+		}(partition, offset)
+	}
+
+	var messages []*pb.MessageWithOffset
+	var firstErr error
+	var cut []int32
+	for range partitions {
+		result := <-results
+		if result.err != nil && ctx.Err() != nil {
+			cut = append(cut, result.partition)
+			continue
+		} else if result.err != nil {
+			if firstErr == nil {
+				firstErr = result.err
+			}
+
+			continue
+		}
+
+		consumer.offsets[result.partition] = result.response.NextOffset
+		if fetched := result.response.Messages.GetMessages(); len(fetched) > 0 {
+			messages = append(messages, fetched...)
+
+			// Only fetches that wait are cut short, as those that don't
+			// are pinning where partitions start
+			if maxWait > 0 {
+				cancel()
+			}
+		}
+	}
+
+	// Fetches cut short once others had messages aren't errors, and take
+	// what their partitions have without waiting, so that no partition is
+	// left out by others answering first
+	if len(cut) > 0 {
+		fetched, err := consumer.fetch(cut, maxMessages, maxBytes, 0)
+		messages = append(messages, fetched...)
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	return messages, firstErr
+}
+
+func (consumer *pullConsumer) Seek(partition int32, offset int64) {
+	consumer.lock.Lock()
+	defer consumer.lock.Unlock()
+
+	consumer.offsets[partition] = offset
+}
+
+func (consumer *pullConsumer) Commit() error {
+	consumer.lock.Lock()
+	defer consumer.lock.Unlock()
+
+	topic := consumer.broker.meta().Topic
+	for partition, offset := range consumer.offsets {
+		if err := consumer.client.CommitOffset(topic, partition, offset); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
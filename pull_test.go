@@ -0,0 +1,68 @@
+package ultrabus
+
+import (
+	"testing"
+	"time"
+
+	"github.com/emef/ultrabus/pb"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPullConsumer(t *testing.T) {
+	assert := assert.New(t)
+
+	node, discovery, stop := serveTestNode(t)
+	defer stop()
+
+	client, err := NewSingleAddrBrokeredClient("group", discovery)
+	assert.Nil(err)
+	defer client.Close()
+
+	eventually(t, func() error {
+		return client.Create("orders", 2, 1)
+	})
+
+	appendTo := func(partition int32, value string) {
+		p, err := node.partition(
+			&pb.PartitionID{Topic: "orders", Partition: partition})
+		assert.Nil(err)
+		_, err = p.Append(&pb.Message{Value: []byte(value)})
+		assert.Nil(err)
+	}
+
+	consumer, err := client.NewPullConsumer("orders")
+	assert.Nil(err)
+
+	received := make(map[int32][]string)
+	poll := func(maxWait time.Duration) {
+		messages, err := consumer.Poll(0, 0, maxWait)
+		assert.Nil(err)
+		for _, msg := range messages {
+			received[msg.Partition] = append(
+				received[msg.Partition], string(msg.Message.Value))
+		}
+	}
+
+	// A partition with messages waiting doesn't cut short another finding
+	// where it starts, which would skip what arrives at it next
+	consumer.Seek(0, 0)
+	for _, value := range []string{"a", "b", "c", "d", "e"} {
+		appendTo(0, value)
+		poll(time.Second)
+		appendTo(1, value)
+	}
+
+	poll(time.Second)
+	assert.Equal([]string{"a", "b", "c", "d", "e"}, received[0])
+	assert.Equal([]string{"a", "b", "c", "d", "e"}, received[1])
+
+	// Committed offsets are where the group's consumers start
+	assert.Nil(consumer.Commit())
+	appendTo(1, "f")
+
+	consumer, err = client.NewPullConsumer("orders")
+	assert.Nil(err)
+	received = make(map[int32][]string)
+	poll(time.Second)
+	assert.Equal(map[int32][]string{1: {"f"}}, received)
+}
@@ -0,0 +1,323 @@
+package ultrabus
+
+import (
+	"sync"
+	"time"
+
+	"github.com/emef/ultrabus/pb"
+	"google.golang.org/grpc/grpclog"
+)
+
+// How long a queue subscriber has to ack a message before it's delivered
+// again, unless its group asks for another timeout.
+const defaultVisibilityTimeout = 30 * time.Second
+
+// How often partitions look for leases that have run out.
+const leaseCheckInterval = 100 * time.Millisecond
+
+// A consumer group's work queue over a partition, see pb.QueueOptions.
+// Members share the queue's cursor, each leasing the messages it reads.
+type consumerQueue struct {
+	lock    sync.Mutex
+	group   string
+	options *pb.QueueOptions
+
+	// The first offset not yet leased to any member
+	cursor MessageLogCursor
+
+	leases map[int64]*queueLease
+
+	// Messages to lease again, ahead of new ones
+	redeliveries []*pb.MessageWithOffset
+
+	// How many times each message not yet settled has been delivered
+	deliveries map[int64]int32
+
+	// Messages given up on, waiting to go to the dead-letter topic
+	deadLetters []*deadLetter
+}
+
+type queueLease struct {
+	clientID pb.ClientID
+	message  *pb.MessageWithOffset
+	expires  time.Time
+}
+
+func newConsumerQueue(group string, cursor MessageLogCursor) *consumerQueue {
+	return &consumerQueue{
+		group:      group,
+		options:    &pb.QueueOptions{},
+		cursor:     cursor,
+		leases:     make(map[int64]*queueLease),
+		deliveries: make(map[int64]int32)}
+}
+
+// Applies the options of the group's latest member, which members are
+// expected to share.
+func (queue *consumerQueue) configure(options *pb.QueueOptions) {
+	queue.lock.Lock()
+	defer queue.lock.Unlock()
+
+	queue.options = options
+}
+
+func (queue *consumerQueue) visibilityTimeout() time.Duration {
+	if queue.options.VisibilityTimeoutMs <= 0 {
+		return defaultVisibilityTimeout
+	}
+
+	return time.Duration(queue.options.VisibilityTimeoutMs) * time.Millisecond
+}
+
+// Leases what clientID may receive into batch: messages due to be
+// delivered again first, then ones not yet delivered.
+func (queue *consumerQueue) leaseInto(
+	partition *Partition,
+	batch *subscriptionBatch,
+	clientID *pb.ClientID,
+	isolation pb.IsolationLevel,
+	credits *subscriptionCredits) error {
+
+	queue.lock.Lock()
+	defer queue.lock.Unlock()
+
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+	for len(queue.redeliveries) > 0 &&
+		!batch.full() && !batch.reached() && credits.available() {
+
+		msg := queue.redeliveries[0]
+		queue.redeliveries = queue.redeliveries[1:]
+
+		// Expired while it was failing, so done with
+		if isExpired(msg.Message, now) {
+			partition.countExpired(nil, msg.Offset)
+			delete(queue.deliveries, msg.Offset)
+			continue
+		}
+
+		batch.hold(queue.lease(clientID, msg), nil)
+		credits.spend(messageSize(msg.Message))
+	}
+
+	held := len(batch.messages)
+	reader := &pb.ClientID{ConsumerGroup: queue.group}
+	err := partition.readInto(reader,
+		batch, queue.cursor, &YesFilter{}, isolation, credits)
+	if err != nil {
+		return err
+	}
+
+	for i := held; i < len(batch.messages); i++ {
+		batch.messages[i] = queue.lease(clientID, batch.messages[i])
+	}
+
+	return nil
+}
+
+// A copy of msg leased to clientID, with its delivery count. Must hold
+// the queue's lock.
+func (queue *consumerQueue) lease(
+	clientID *pb.ClientID, msg *pb.MessageWithOffset) *pb.MessageWithOffset {
+
+	queue.deliveries[msg.Offset]++
+	queue.leases[msg.Offset] = &queueLease{
+		*clientID, msg, time.Now().Add(queue.visibilityTimeout())}
+
+	leased := *msg
+	leased.Delivery = queue.deliveries[msg.Offset]
+	return &leased
+}
+
+// Settles the leases clientID holds of acked and nacked offsets, with
+// errors saying why nacked ones failed, true if any message is due to be
+// delivered again.
+func (queue *consumerQueue) settle(
+	partition *Partition,
+	clientID *pb.ClientID,
+	acks, nacks []int64,
+	errors map[int64]string) bool {
+
+	queue.lock.Lock()
+	defer queue.lock.Unlock()
+	defer queue.commit(partition)
+
+	for _, offset := range acks {
+		if lease, ok := queue.leases[offset]; ok && lease.clientID == *clientID {
+			delete(queue.leases, offset)
+			delete(queue.deliveries, offset)
+		}
+	}
+
+	for _, offset := range nacks {
+		if lease, ok := queue.leases[offset]; ok && lease.clientID == *clientID {
+			reason, ok := errors[offset]
+			if !ok {
+				reason = "nacked"
+			}
+
+			delete(queue.leases, offset)
+			queue.retry(lease.message, reason)
+		}
+	}
+
+	return len(queue.redeliveries) > 0
+}
+
+// Takes back the leases of clientID, a member that's left, true if any
+// message is due to be delivered again.
+func (queue *consumerQueue) release(
+	partition *Partition, clientID *pb.ClientID) bool {
+
+	queue.lock.Lock()
+	defer queue.lock.Unlock()
+
+	done := false
+	for offset, lease := range queue.leases {
+		if lease.clientID == *clientID {
+			delete(queue.leases, offset)
+			done = queue.retry(lease.message, "subscriber left") || done
+		}
+	}
+
+	if done {
+		queue.commit(partition)
+	}
+
+	return len(queue.redeliveries) > 0
+}
+
+// Takes back leases that have run out, true if any message is due to be
+// delivered again.
+func (queue *consumerQueue) expire(partition *Partition, now time.Time) bool {
+	queue.lock.Lock()
+	defer queue.lock.Unlock()
+
+	done := false
+	for offset, lease := range queue.leases {
+		if now.After(lease.expires) {
+			delete(queue.leases, offset)
+			done = queue.retry(lease.message, "visibility timeout expired") || done
+		}
+	}
+
+	if done {
+		queue.commit(partition)
+	}
+
+	return len(queue.redeliveries) > 0
+}
+
+// Queues msg, which failed for reason, to be delivered again, or gives up
+// on it once it's been delivered as many times as the group allows, true
+// if the group is then done with it. Must hold the queue's lock.
+func (queue *consumerQueue) retry(msg *pb.MessageWithOffset, reason string) bool {
+	deliveries := queue.deliveries[msg.Offset]
+	max := queue.options.MaxDeliveries
+	if max <= 0 || deliveries < max {
+		queue.redeliveries = append(queue.redeliveries, msg)
+		return false
+	}
+
+	delete(queue.deliveries, msg.Offset)
+
+	if topic := queue.options.DeadLetterTopic; topic != "" {
+		queue.deadLetters = append(queue.deadLetters,
+			&deadLetter{topic, msg, deliveries, reason})
+		return false
+	}
+
+	grpclog.Printf("Giving up on offset %v for %v after %v deliveries",
+		msg.Offset, queue.group, deliveries)
+	return true
+}
+
+// Commits the first offset the group hasn't finished with. Must hold the
+// queue's lock.
+func (queue *consumerQueue) commit(partition *Partition) {
+	offset := queue.cursor.Pos()
+	for leased := range queue.leases {
+		if leased < offset {
+			offset = leased
+		}
+	}
+
+	for _, msg := range queue.redeliveries {
+		if msg.Offset < offset {
+			offset = msg.Offset
+		}
+	}
+
+	for _, letter := range queue.deadLetters {
+		if letter.message.Offset < offset {
+			offset = letter.message.Offset
+		}
+	}
+
+	partition.CommitOffset(queue.group, offset, nil)
+}
+
+func (partition *Partition) consumerQueues() []*consumerQueue {
+	partition.lock.RLock()
+	defer partition.lock.RUnlock()
+
+	queues := make([]*consumerQueue, 0, len(partition.queues))
+	for _, queue := range partition.queues {
+		queues = append(queues, queue)
+	}
+
+	return queues
+}
+
+// The group's queue, made reading from cursor if it's new.
+func (partition *Partition) queue(
+	group string,
+	cursor MessageLogCursor,
+	options *pb.QueueOptions) *consumerQueue {
+
+	partition.lock.Lock()
+	defer partition.lock.Unlock()
+
+	queue, ok := partition.queues[group]
+	if !ok {
+		queue = newConsumerQueue(group, cursor)
+		partition.queues[group] = queue
+	}
+
+	queue.configure(options)
+	return queue
+}
+
+// Settles messages of clientID's queue subscription, see pb.AckRequest.
+func (partition *Partition) Ack(
+	clientID *pb.ClientID,
+	acks, nacks []int64,
+	errors map[int64]string) error {
+
+	partition.lock.RLock()
+	queue, ok := partition.queues[clientID.ConsumerGroup]
+	partition.lock.RUnlock()
+
+	if !ok {
+		return &SubscriptionNotFoundError{clientID}
+	}
+
+	if queue.settle(partition, clientID, acks, nacks, errors) {
+		partition.notifyAll()
+	}
+
+	return nil
+}
+
+// Takes back leases that have run out, true if any message is due to be
+// delivered again.
+func (partition *Partition) expireLeases() bool {
+	redeliver := false
+	now := time.Now()
+	for _, queue := range partition.consumerQueues() {
+		if queue.expire(partition, now) {
+			redeliver = true
+		}
+	}
+
+	return redeliver
+}
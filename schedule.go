@@ -0,0 +1,157 @@
+package ultrabus
+
+import (
+	"container/heap"
+	"strconv"
+	"time"
+
+	"github.com/emef/ultrabus/pb"
+	"google.golang.org/grpc/grpclog"
+)
+
+// Header of a delayed message released to subscribers: the offset it was
+// scheduled at when published, see pb.Message.
+const HeaderScheduledOffset = "ultrabus.scheduled.offset"
+
+// How often partitions release the delayed messages that are due.
+const scheduleCheckInterval = 100 * time.Millisecond
+
+// Delayed messages waiting to be released, soonest first.
+type messageSchedule []*pb.MessageWithOffset
+
+func (schedule messageSchedule) Len() int {
+	return len(schedule)
+}
+
+func (schedule messageSchedule) Less(i, j int) bool {
+	if schedule[i].Message.DeliverAt != schedule[j].Message.DeliverAt {
+		return schedule[i].Message.DeliverAt < schedule[j].Message.DeliverAt
+	}
+
+	return schedule[i].Offset < schedule[j].Offset
+}
+
+func (schedule messageSchedule) Swap(i, j int) {
+	schedule[i], schedule[j] = schedule[j], schedule[i]
+}
+
+func (schedule *messageSchedule) Push(msg interface{}) {
+	*schedule = append(*schedule, msg.(*pb.MessageWithOffset))
+}
+
+func (schedule *messageSchedule) Pop() interface{} {
+	old := *schedule
+	msg := old[len(old)-1]
+	*schedule = old[:len(old)-1]
+	return msg
+}
+
+// Whether msg was published for later, so that subscribers skip it until
+// it's released.
+func isScheduled(msg *pb.Message) bool {
+	return msg.DeliverAt > 0
+}
+
+// Schedules msg, appended at offset, if it's delayed.
+func (partition *Partition) schedule(
+	msg *pb.Message, offset int64, producer *pb.ProducerSequence) {
+
+	if !isScheduled(msg) {
+		return
+	}
+
+	partition.scheduleLock.Lock()
+	defer partition.scheduleLock.Unlock()
+
+	heap.Push(&partition.scheduled, &pb.MessageWithOffset{
+		Offset: offset, Message: msg, Producer: producer})
+}
+
+// Appends the delayed messages that are due for subscribers to read. Those
+// of transactions not yet committed wait, and those of aborted ones, or
+// that expired while waiting, are dropped.
+func (partition *Partition) releaseDue() {
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+	stable := partition.stableOffset()
+
+	for {
+		partition.scheduleLock.Lock()
+		if len(partition.scheduled) == 0 ||
+			partition.scheduled[0].Message.DeliverAt > now ||
+			partition.scheduled[0].Offset >= stable {
+			partition.scheduleLock.Unlock()
+			return
+		}
+
+		msg := heap.Pop(&partition.scheduled).(*pb.MessageWithOffset)
+		partition.scheduleLock.Unlock()
+
+		if partition.isAborted(msg) || isExpired(msg.Message, now) {
+			continue
+		}
+
+		receipt := partition.log.Append(released(msg))
+		<-receipt.Done()
+
+		if _, err := receipt.Read(); err != nil {
+			grpclog.Printf("Error releasing offset %v: %v", msg.Offset, err)
+
+			partition.scheduleLock.Lock()
+			heap.Push(&partition.scheduled, msg)
+			partition.scheduleLock.Unlock()
+			return
+		}
+
+		// non-blocking notify
+		select {
+		case partition.notify <- nil:
+		default:
+		}
+	}
+}
+
+// The message subscribers receive once scheduled is due.
+func released(scheduled *pb.MessageWithOffset) *pb.Message {
+	msg := scheduled.Message
+	headers := make(map[string][]byte, len(msg.Headers)+1)
+	for name, value := range msg.Headers {
+		headers[name] = value
+	}
+
+	headers[HeaderScheduledOffset] =
+		[]byte(strconv.FormatInt(scheduled.Offset, 10))
+
+	return &pb.Message{
+		Key: msg.Key, Value: msg.Value, Headers: headers, ExpiresAt: msg.ExpiresAt}
+}
+
+// Schedules the delayed messages in the log that haven't been released.
+func (partition *Partition) recoverSchedule() error {
+	cursor, err := partition.log.CursorStart()
+	if err != nil {
+		return err
+	}
+
+	pending := make(map[int64]*pb.MessageWithOffset)
+	for cursor.HasNext() {
+		msg, err := cursor.Next()
+		if err != nil {
+			return err
+		}
+
+		if isScheduled(msg.Message) {
+			pending[msg.Offset] = msg
+		} else if header, ok := msg.Message.Headers[HeaderScheduledOffset]; ok {
+			offset, err := strconv.ParseInt(string(header), 10, 64)
+			if err == nil {
+				delete(pending, offset)
+			}
+		}
+	}
+
+	for _, msg := range pending {
+		partition.schedule(msg.Message, msg.Offset, msg.Producer)
+	}
+
+	return nil
+}
@@ -0,0 +1,247 @@
+package ultrabus
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Keys of the per-topic config map stored in TopicMeta.config, with
+// the default used when a topic doesn't set them.
+const (
+	// Milliseconds messages are kept for, -1 for ever. Default -1.
+	ConfigRetentionMs = "retention.ms"
+
+	// Bytes a partition keeps before dropping its oldest messages, -1
+	// for no limit. Default -1.
+	ConfigRetentionBytes = "retention.bytes"
+
+	// "delete" drops messages by retention, "compact" only keeps the
+	// latest message of each key. Default delete.
+	ConfigCleanupPolicy = "cleanup.policy"
+
+	// Largest key, value and headers a partition accepts. Default 1048576.
+	ConfigMaxMessageBytes = "max.message.bytes"
+
+	// Available replicas a partition needs to accept writes. Default 1.
+	ConfigMinInsyncReplicas = "min.insync.replicas"
+
+	// How partitions store message values, "none" or "gzip". Default
+	// none.
+	ConfigCompressionType = "compression.type"
+
+	// Whether publishers partition messages by key. Messages of topics
+	// that aren't, and keyless messages of those that are, are spread by
+	// keyless.partitioning. Default true.
+	ConfigKeyed = "keyed"
+
+	// How publishers spread keyless messages: "round-robin" gives each
+	// message the next partition, "sticky" sends all of a publish's
+	// messages to one partition and moves on for the next. Clients may
+	// override it. Default round-robin.
+	ConfigKeylessPartitioning = "keyless.partitioning"
+
+	// How publishers map keys to partitions, "md5", "ring" or "legacy";
+	// see HashToPartitionScheme. Clients with a Partitioner ignore it.
+	// Default legacy, so that topics from before key.hash keep their
+	// keys' partitions; new topics should set md5 or ring.
+	ConfigKeyHash = "key.hash"
+
+	// How subscription filter expressions decode message values: "none"
+	// leaves them as strings, "json" parses them and "proto:<type>"
+	// unmarshals them as the protobuf message type registered under
+	// that name, which nodes must have linked in. Default none.
+	ConfigValueSchema = "value.schema"
+
+	// Whether cleanup drops messages past their expiresAt, wherever they
+	// are in the log, before retention.ms would. Default true.
+	ConfigRetentionExpired = "retention.expired"
+)
+
+const (
+	CleanupDelete  = "delete"
+	CleanupCompact = "compact"
+
+	CompressionNone = "none"
+	CompressionGzip = "gzip"
+
+	KeylessRoundRobin = "round-robin"
+	KeylessSticky     = "sticky"
+
+	ValueSchemaNone  = "none"
+	ValueSchemaJSON  = "json"
+	ValueSchemaProto = "proto:"
+)
+
+// A topic's config map with defaults filled in.
+type TopicConfig struct {
+	Retention           time.Duration
+	RetentionBytes      int64
+	CleanupPolicy       string
+	MaxMessageBytes     int64
+	MinInsyncReplicas   int32
+	CompressionType     string
+	Keyed               bool
+	KeylessPartitioning string
+	KeyHash             string
+	ValueSchema         string
+	RetentionExpired    bool
+}
+
+func DefaultTopicConfig() *TopicConfig {
+	return &TopicConfig{
+		Retention:           -1,
+		RetentionBytes:      -1,
+		CleanupPolicy:       CleanupDelete,
+		MaxMessageBytes:     1048576,
+		MinInsyncReplicas:   1,
+		CompressionType:     CompressionNone,
+		Keyed:               true,
+		KeylessPartitioning: KeylessRoundRobin,
+		KeyHash:             KeyHashLegacy,
+		ValueSchema:         ValueSchemaNone,
+		RetentionExpired:    true}
+}
+
+// Validates config, rejecting unknown keys and malformed values.
+func ParseTopicConfig(config map[string]string) (*TopicConfig, error) {
+	parsed := DefaultTopicConfig()
+
+	for key, value := range config {
+		var err error
+		switch key {
+		case ConfigRetentionMs:
+			var ms int64
+			ms, err = parseConfigInt(value, -1)
+			parsed.Retention = time.Duration(ms) * time.Millisecond
+
+		case ConfigRetentionBytes:
+			parsed.RetentionBytes, err = parseConfigInt(value, -1)
+
+		case ConfigCleanupPolicy:
+			parsed.CleanupPolicy, err = parseConfigEnum(
+				value, CleanupDelete, CleanupCompact)
+
+		case ConfigMaxMessageBytes:
+			parsed.MaxMessageBytes, err = parseConfigInt(value, 1)
+
+		case ConfigMinInsyncReplicas:
+			var replicas int64
+			replicas, err = parseConfigInt(value, 1)
+			parsed.MinInsyncReplicas = int32(replicas)
+
+		case ConfigCompressionType:
+			parsed.CompressionType, err = parseConfigEnum(
+				value, CompressionNone, CompressionGzip)
+
+		case ConfigKeyed:
+			parsed.Keyed, err = strconv.ParseBool(value)
+
+		case ConfigKeylessPartitioning:
+			parsed.KeylessPartitioning, err = parseKeylessPartitioning(value)
+
+		case ConfigKeyHash:
+			parsed.KeyHash, err = parseConfigEnum(
+				value, KeyHashMD5, KeyHashRing, KeyHashLegacy)
+
+		case ConfigValueSchema:
+			parsed.ValueSchema, err = parseValueSchema(value)
+
+		case ConfigRetentionExpired:
+			parsed.RetentionExpired, err = strconv.ParseBool(value)
+
+		default:
+			err = fmt.Errorf("unknown key")
+		}
+
+		if err != nil {
+			return nil, &InvalidConfigError{key, value, err.Error()}
+		}
+	}
+
+	return parsed, nil
+}
+
+// The config as a map holding every key.
+func (config *TopicConfig) Map() map[string]string {
+	retentionMs := int64(config.Retention / time.Millisecond)
+	if config.Retention < 0 {
+		retentionMs = -1
+	}
+
+	return map[string]string{
+		ConfigRetentionMs:         strconv.FormatInt(retentionMs, 10),
+		ConfigRetentionBytes:      strconv.FormatInt(config.RetentionBytes, 10),
+		ConfigCleanupPolicy:       config.CleanupPolicy,
+		ConfigMaxMessageBytes:     strconv.FormatInt(config.MaxMessageBytes, 10),
+		ConfigMinInsyncReplicas:   fmt.Sprint(config.MinInsyncReplicas),
+		ConfigCompressionType:     config.CompressionType,
+		ConfigKeyed:               strconv.FormatBool(config.Keyed),
+		ConfigKeylessPartitioning: config.KeylessPartitioning,
+		ConfigKeyHash:             config.KeyHash,
+		ConfigValueSchema:         config.ValueSchema,
+		ConfigRetentionExpired:    strconv.FormatBool(config.RetentionExpired)}
+}
+
+// Applies set and unset to a copy of config.
+func mergeConfig(
+	config map[string]string,
+	set map[string]string,
+	unset []string) map[string]string {
+
+	merged := copyConfig(config)
+	if merged == nil {
+		merged = make(map[string]string)
+	}
+
+	for _, key := range unset {
+		delete(merged, key)
+	}
+
+	for key, value := range set {
+		merged[key] = value
+	}
+
+	return merged
+}
+
+func parseKeylessPartitioning(value string) (string, error) {
+	return parseConfigEnum(value, KeylessRoundRobin, KeylessSticky)
+}
+
+func parseValueSchema(value string) (string, error) {
+	if strings.HasPrefix(value, ValueSchemaProto) {
+		if value == ValueSchemaProto {
+			return "", fmt.Errorf("missing protobuf type")
+		}
+
+		return value, nil
+	}
+
+	return parseConfigEnum(
+		value, ValueSchemaNone, ValueSchemaJSON, ValueSchemaProto+"<type>")
+}
+
+func parseConfigInt(value string, min int64) (int64, error) {
+	parsed, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, err
+	} else if parsed < min {
+		return 0, fmt.Errorf("must be at least %v", min)
+	}
+
+	return parsed, nil
+}
+
+func parseConfigEnum(value string, allowed ...string) (string, error) {
+	for _, option := range allowed {
+		if value == option {
+			return value, nil
+		}
+	}
+
+	sort.Strings(allowed)
+	return "", fmt.Errorf("must be one of %v", allowed)
+}
@@ -0,0 +1,666 @@
+package ultrabus
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"code.google.com/p/go-uuid/uuid"
+	"github.com/emef/ultrabus/pb"
+	"golang.org/x/net/context"
+)
+
+// How long a transaction may stay open before its coordinator aborts it.
+const transactionTimeout = time.Minute
+
+// How long a coordinator waits on a partition's leader to write a
+// transaction marker before leaving it for a later attempt.
+const transactionMarkerTimeout = 10 * time.Second
+
+// The node coordinating transactionalID's transactions: one of addrs
+// picked by the ID's KeyHash, so every client agrees on it while the
+// nodes stay the same.
+func transactionCoordinator(addrs []string, transactionalID string) string {
+	sorted := append([]string(nil), addrs...)
+	sort.Strings(sorted)
+
+	return sorted[KeyHash([]byte(transactionalID))%uint64(len(sorted))]
+}
+
+// The producer and open transaction of each transactional ID a node
+// coordinates. A transactional ID keeps its producer ID, each transaction
+// publishing under the next epoch so that partitions fence off the
+// earlier ones.
+type coordinator struct {
+	lock         sync.Mutex
+	producers    map[string]*pb.ProducerSequence
+	transactions map[string]*coordinatedTransaction
+}
+
+type coordinatedTransaction struct {
+	producerID string
+	epoch      int32
+	partitions map[pb.PartitionID]bool
+	started    time.Time
+
+	// COMMIT or ABORT once the transaction is ending, after which it only
+	// waits for the marker to be written to its partitions
+	marker pb.TransactionMarker
+}
+
+func newCoordinator() *coordinator {
+	return &coordinator{
+		producers:    make(map[string]*pb.ProducerSequence),
+		transactions: make(map[string]*coordinatedTransaction)}
+}
+
+func (node *NodeService) BeginTransaction(
+	context context.Context,
+	request *pb.BeginTransactionRequest) (*pb.BeginTransactionResponse, error) {
+
+	if err := node.checkCoordinator(request.TransactionalID); err != nil {
+		return nil, err
+	}
+
+	for {
+		node.coordinator.lock.Lock()
+
+		current, ok := node.coordinator.producers[request.TransactionalID]
+		if ok && request.Producer != nil &&
+			(request.Producer.ProducerID != current.ProducerID ||
+				request.Producer.Epoch < current.Epoch) {
+			node.coordinator.lock.Unlock()
+			return nil, &ProducerFencedError{
+				request.Producer.ProducerID, request.Producer.Epoch, current.Epoch}
+		}
+
+		// The transaction left open, whether by this client or one it
+		// replaces, is ended under the next epoch so that whoever left it
+		// can't publish to it any more
+		previous, open := node.coordinator.transactions[request.TransactionalID]
+		if open {
+			if previous.marker == pb.TransactionMarker_NO_MARKER {
+				previous.marker = pb.TransactionMarker_ABORT
+			}
+
+			node.coordinator.lock.Unlock()
+
+			err := node.finishTransaction(
+				request.TransactionalID, previous, previous.epoch+1)
+			if err != nil {
+				return nil, err
+			}
+
+			continue
+		}
+
+		producer := &pb.ProducerSequence{ProducerID: uuid.New(), Transactional: true}
+		if ok {
+			producer.ProducerID = current.ProducerID
+			producer.Epoch = current.Epoch + 1
+		}
+
+		node.coordinator.producers[request.TransactionalID] = producer
+		node.coordinator.transactions[request.TransactionalID] =
+			&coordinatedTransaction{
+				producerID: producer.ProducerID,
+				epoch:      producer.Epoch,
+				partitions: make(map[pb.PartitionID]bool),
+				started:    time.Now()}
+
+		node.coordinator.lock.Unlock()
+
+		return &pb.BeginTransactionResponse{
+			Producer: &pb.ProducerSequence{
+				ProducerID:    producer.ProducerID,
+				Epoch:         producer.Epoch,
+				Transactional: true}}, nil
+	}
+}
+
+func (node *NodeService) AddPartitionsToTransaction(
+	context context.Context,
+	request *pb.AddPartitionsToTransactionRequest) (
+	*pb.AddPartitionsToTransactionResponse, error) {
+
+	node.coordinator.lock.Lock()
+	defer node.coordinator.lock.Unlock()
+
+	transaction, err := node.openTransaction(
+		request.TransactionalID, request.ProducerID, request.Epoch)
+	if err != nil {
+		return nil, err
+	} else if transaction.marker != pb.TransactionMarker_NO_MARKER {
+		return nil, &InvalidTransactionError{
+			request.TransactionalID, "already ending"}
+	} else if time.Since(transaction.started) > transactionTimeout {
+		return nil, &InvalidTransactionError{request.TransactionalID, "timed out"}
+	}
+
+	for _, partitionID := range request.Partitions {
+		transaction.partitions[*partitionID] = true
+	}
+
+	return &pb.AddPartitionsToTransactionResponse{}, nil
+}
+
+// Commits or aborts the transaction. A transaction that has run out of
+// time is aborted whatever the request asks. Ending it again with the
+// same outcome, as a client does when retrying, finishes writing its
+// markers.
+func (node *NodeService) EndTransaction(
+	context context.Context,
+	request *pb.EndTransactionRequest) (*pb.EndTransactionResponse, error) {
+
+	marker := pb.TransactionMarker_ABORT
+	if request.Commit {
+		marker = pb.TransactionMarker_COMMIT
+	}
+
+	node.coordinator.lock.Lock()
+	transaction, err := node.openTransaction(
+		request.TransactionalID, request.ProducerID, request.Epoch)
+	if err != nil {
+		node.coordinator.lock.Unlock()
+		return nil, err
+	}
+
+	var timedOut error
+	if transaction.marker == pb.TransactionMarker_NO_MARKER {
+		if time.Since(transaction.started) > transactionTimeout {
+			marker = pb.TransactionMarker_ABORT
+			timedOut = &InvalidTransactionError{
+				request.TransactionalID, "timed out"}
+		}
+
+		transaction.marker = marker
+	} else if transaction.marker != marker {
+		node.coordinator.lock.Unlock()
+		return nil, &InvalidTransactionError{
+			request.TransactionalID, "already ending otherwise"}
+	}
+	node.coordinator.lock.Unlock()
+
+	err = node.finishTransaction(
+		request.TransactionalID, transaction, transaction.epoch)
+	if err != nil {
+		return nil, err
+	} else if timedOut != nil {
+		return nil, timedOut
+	}
+
+	return &pb.EndTransactionResponse{}, nil
+}
+
+func (node *NodeService) WriteTransactionMarker(
+	context context.Context,
+	request *pb.WriteTransactionMarkerRequest) (
+	*pb.WriteTransactionMarkerResponse, error) {
+
+	partition, err := node.partition(request.PartitionID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = partition.EndTransaction(&pb.ProducerSequence{
+		ProducerID:    request.ProducerID,
+		Epoch:         request.Epoch,
+		Transactional: true}, request.Marker)
+	if err != nil {
+		return nil, err
+	}
+
+	return &pb.WriteTransactionMarkerResponse{}, nil
+}
+
+func (node *NodeService) checkCoordinator(transactionalID string) error {
+	addrs, err := node.discovery.GetAllNodeAddrs()
+	if err != nil {
+		return err
+	} else if len(addrs) == 0 {
+		return &NoNodesError{}
+	}
+
+	coordinator := transactionCoordinator(addrs, transactionalID)
+	if coordinator != node.serverAddr {
+		return &NotCoordinatorError{transactionalID, coordinator}
+	}
+
+	return nil
+}
+
+// transactionalID's transaction if producerID is publishing it at epoch,
+// failing with ProducerFencedError if a later epoch has begun since. Must
+// hold the coordinator lock.
+func (node *NodeService) openTransaction(
+	transactionalID, producerID string,
+	epoch int32) (*coordinatedTransaction, error) {
+
+	current, ok := node.coordinator.producers[transactionalID]
+	if ok && current.ProducerID == producerID && epoch < current.Epoch {
+		return nil, &ProducerFencedError{producerID, epoch, current.Epoch}
+	}
+
+	transaction, ok := node.coordinator.transactions[transactionalID]
+	if !ok || transaction.producerID != producerID || transaction.epoch != epoch {
+		return nil, &InvalidTransactionError{
+			transactionalID, "no open transaction for this producer"}
+	}
+
+	return transaction, nil
+}
+
+// Writes the marker transaction is ending with to each of its partitions
+// through their leaders, at epoch. The coordinator forgets the
+// transaction once every marker is written; those that fail are written
+// again by the next attempt to end it.
+func (node *NodeService) finishTransaction(
+	transactionalID string,
+	transaction *coordinatedTransaction,
+	epoch int32) error {
+
+	node.coordinator.lock.Lock()
+	marker := transaction.marker
+	partitions := make([]pb.PartitionID, 0, len(transaction.partitions))
+	for partitionID := range transaction.partitions {
+		partitions = append(partitions, partitionID)
+	}
+	node.coordinator.lock.Unlock()
+
+	ctx, cancel := context.WithTimeout(
+		context.Background(), transactionMarkerTimeout)
+	defer cancel()
+
+	var firstErr error
+	for _, partitionID := range partitions {
+		partitionID := partitionID
+		err := node.writeTransactionMarker(ctx, &pb.WriteTransactionMarkerRequest{
+			PartitionID: &partitionID,
+			ProducerID:  transaction.producerID,
+			Marker:      marker,
+			Epoch:       epoch})
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+
+			continue
+		}
+
+		node.coordinator.lock.Lock()
+		delete(transaction.partitions, partitionID)
+		node.coordinator.lock.Unlock()
+	}
+
+	if firstErr != nil {
+		return firstErr
+	}
+
+	node.coordinator.lock.Lock()
+	defer node.coordinator.lock.Unlock()
+
+	if node.coordinator.transactions[transactionalID] == transaction {
+		delete(node.coordinator.transactions, transactionalID)
+	}
+
+	return nil
+}
+
+// Writes request's marker through the partition's leader.
+func (node *NodeService) writeTransactionMarker(
+	ctx context.Context, request *pb.WriteTransactionMarkerRequest) error {
+
+	leaderAddr, err := node.discovery.GetLeaderAddr(request.PartitionID)
+	if err != nil {
+		return err
+	}
+
+	if leaderAddr == node.serverAddr {
+		_, err = node.WriteTransactionMarker(ctx, request)
+		return err
+	}
+
+	client, err := node.peers.client(leaderAddr)
+	if err != nil {
+		return err
+	}
+
+	_, err = client.WriteTransactionMarker(ctx, request)
+	return err
+}
+
+// Aborts the transactions that have been open too long, and finishes
+// those whose markers weren't all written.
+func (node *NodeService) abortStaleTransactions() {
+	node.coordinator.lock.Lock()
+	stale := make(map[string]*coordinatedTransaction)
+	for transactionalID, transaction := range node.coordinator.transactions {
+		if transaction.marker == pb.TransactionMarker_NO_MARKER {
+			if time.Since(transaction.started) <= transactionTimeout {
+				continue
+			}
+
+			transaction.marker = pb.TransactionMarker_ABORT
+		}
+
+		stale[transactionalID] = transaction
+	}
+	node.coordinator.lock.Unlock()
+
+	for transactionalID, transaction := range stale {
+		node.finishTransaction(transactionalID, transaction, transaction.epoch)
+	}
+}
+
+// Publishes to any partitions of any topics atomically: subscribers
+// reading committed messages see all of a transaction's messages once it
+// commits, and none if it aborts.
+type Transaction interface {
+	// Publishes messages to topic as part of the transaction
+	Publish(topic string, messages []*pb.Message) error
+
+	// Commits the client's consumer group offsets of topic's partitions,
+	// see UltrabusClient.CommitOffset, as part of the transaction
+	CommitOffsets(topic string, offsets map[int32]int64) error
+
+	// Makes the transaction's messages visible. A transaction one of
+	// whose publishes failed is aborted instead, and the failure returned.
+	Commit() error
+
+	Abort() error
+}
+
+type clientTransaction struct {
+	client          *singleAddrBrokeredClient
+	transactionalID string
+	producerID      string
+	epoch           int32
+
+	lock  sync.Mutex
+	ended bool
+
+	// The error a publish failed with, which dooms the transaction
+	failed error
+
+	// The next sequence of each partition added to the transaction
+	sequences map[pb.PartitionID]int64
+}
+
+func (transaction *clientTransaction) coordinatorClient() (
+	pb.UltrabusNodeClient, error) {
+
+	return transaction.client.connectionManager.GetCoordinatorClient(
+		transaction.transactionalID)
+}
+
+func (transaction *clientTransaction) Publish(
+	topic string, messages []*pb.Message) error {
+
+	transaction.lock.Lock()
+	defer transaction.lock.Unlock()
+
+	if transaction.ended {
+		return &InvalidTransactionError{
+			transaction.transactionalID, "already ended"}
+	}
+
+	broker, err := transaction.client.broker(topic)
+	if err != nil {
+		return err
+	}
+
+	sent := time.Now()
+	batches, receipts, partitions := broker.batch(messages, nil)
+
+	partitionIDs := make([]pb.PartitionID, 0, len(batches))
+	for partitionID := range batches {
+		partitionIDs = append(partitionIDs, partitionID)
+	}
+
+	if err := transaction.add(partitionIDs); err != nil {
+		return err
+	}
+
+	for partitionID, batch := range batches {
+		request := batch.request(partitionID)
+		request.Producer = &pb.ProducerSequence{
+			ProducerID:    transaction.producerID,
+			Epoch:         transaction.epoch,
+			Sequence:      transaction.sequences[partitionID],
+			Transactional: true}
+
+		go broker.send(request, batch.receipts, nil, sent)
+	}
+
+	err = publishReport(topic, receipts, partitions)
+	if err != nil {
+		transaction.failed = err
+		return err
+	}
+
+	for partitionID, batch := range batches {
+		transaction.sequences[partitionID] += int64(len(batch.messages))
+	}
+
+	return nil
+}
+
+func (transaction *clientTransaction) CommitOffsets(
+	topic string, offsets map[int32]int64) error {
+
+	transaction.lock.Lock()
+	defer transaction.lock.Unlock()
+
+	if transaction.ended {
+		return &InvalidTransactionError{
+			transaction.transactionalID, "already ended"}
+	}
+
+	// Offsets are committed by the marker ending the transaction, so
+	// their partitions join it
+	partitionIDs := make([]pb.PartitionID, 0, len(offsets))
+	for partition := range offsets {
+		partitionIDs = append(partitionIDs,
+			pb.PartitionID{Topic: topic, Partition: partition})
+	}
+
+	if err := transaction.add(partitionIDs); err != nil {
+		return err
+	}
+
+	for partition, offset := range offsets {
+		err := transaction.client.commitOffset(topic, partition, offset,
+			&pb.ProducerSequence{
+				ProducerID: transaction.producerID, Epoch: transaction.epoch})
+		if err != nil {
+			transaction.failed = err
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Registers the partitions not yet in the transaction with its
+// coordinator. Must hold the lock.
+func (transaction *clientTransaction) add(
+	partitionIDs []pb.PartitionID) error {
+
+	var added []*pb.PartitionID
+	for _, partitionID := range partitionIDs {
+		if _, ok := transaction.sequences[partitionID]; !ok {
+			partitionID := partitionID
+			added = append(added, &partitionID)
+		}
+	}
+
+	if len(added) == 0 {
+		return nil
+	}
+
+	coordinator, err := transaction.coordinatorClient()
+	if err == nil {
+		_, err = coordinator.AddPartitionsToTransaction(
+			context.Background(), &pb.AddPartitionsToTransactionRequest{
+				TransactionalID: transaction.transactionalID,
+				ProducerID:      transaction.producerID,
+				Epoch:           transaction.epoch,
+				Partitions:      added})
+	}
+
+	if err != nil {
+		return err
+	}
+
+	for _, partitionID := range added {
+		transaction.sequences[*partitionID] = 0
+	}
+
+	return nil
+}
+
+func (transaction *clientTransaction) Commit() error {
+	transaction.lock.Lock()
+	defer transaction.lock.Unlock()
+
+	if transaction.failed != nil {
+		if err := transaction.end(false); err != nil {
+			return err
+		}
+
+		return transaction.failed
+	}
+
+	return transaction.end(true)
+}
+
+func (transaction *clientTransaction) Abort() error {
+	transaction.lock.Lock()
+	defer transaction.lock.Unlock()
+
+	return transaction.end(false)
+}
+
+// Must hold the lock.
+func (transaction *clientTransaction) end(commit bool) error {
+	if transaction.ended {
+		return &InvalidTransactionError{
+			transaction.transactionalID, "already ended"}
+	}
+
+	coordinator, err := transaction.coordinatorClient()
+	if err != nil {
+		return err
+	}
+
+	_, err = coordinator.EndTransaction(
+		context.Background(), &pb.EndTransactionRequest{
+			TransactionalID: transaction.transactionalID,
+			ProducerID:      transaction.producerID,
+			Epoch:           transaction.epoch,
+			Commit:          commit})
+	if err != nil {
+		return err
+	}
+
+	transaction.ended = true
+	return nil
+}
+
+// How often Transform commits the messages it has transformed since its
+// last commit.
+const transformCommitInterval = 100 * time.Millisecond
+
+// Makes the messages Transform publishes to its sink out of one read from
+// its source, or fails the transform.
+//
+// Transform publishes in transactions that also commit the client's
+// consumer group offsets past the messages transformed, so its output
+// and its progress through the source are committed together. A job
+// restarted with the same transactional ID picks up from the last commit,
+// the transaction it left open aborted along with the output it holds, so
+// every message is transformed into the sink once. Readers of the sink
+// only see this with READ_COMMITTED isolation, see WithIsolation.
+type TransformFunc func(message *pb.MessageWithOffset) ([]*pb.Message, error)
+
+// Transforms source into sink until done is closed, committing what it
+// has transformed, or until it fails, aborting what it has transformed
+// since its last commit.
+func (client *singleAddrBrokeredClient) Transform(
+	transactionalID, source, sink string,
+	transform TransformFunc,
+	done chan interface{}) error {
+
+	subscription, err := client.Subscribe(source)
+	if err != nil {
+		return err
+	}
+
+	defer subscription.Stop()
+
+	// The next offset to transform of each partition, skipping what a
+	// partition resubscribed to sends again from the last commit
+	next := make(map[int32]int64)
+
+	// The open transaction and the offsets it commits
+	var transaction Transaction
+	offsets := make(map[int32]int64)
+
+	commit := func() error {
+		if transaction == nil {
+			return nil
+		}
+
+		err := transaction.CommitOffsets(source, offsets)
+		if err == nil {
+			err = transaction.Commit()
+		} else {
+			transaction.Abort()
+		}
+
+		transaction = nil
+		offsets = make(map[int32]int64)
+		return err
+	}
+
+	ticker := time.NewTicker(transformCommitInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case message := <-subscription.Messages():
+			if offset, ok := next[message.Partition]; ok && message.Offset < offset {
+				continue
+			}
+
+			if transaction == nil {
+				transaction, err = client.BeginTransaction(transactionalID)
+				if err != nil {
+					return err
+				}
+			}
+
+			messages, err := transform(message)
+			if err == nil && len(messages) > 0 {
+				err = transaction.Publish(sink, messages)
+			}
+
+			if err != nil {
+				// Left open on failure, the coordinator aborts it in time
+				transaction.Abort()
+				return err
+			}
+
+			next[message.Partition] = message.Offset + 1
+			offsets[message.Partition] = message.Offset + 1
+
+		case <-ticker.C:
+			if err := commit(); err != nil {
+				return err
+			}
+
+		case <-done:
+			return commit()
+		}
+	}
+}
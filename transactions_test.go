@@ -0,0 +1,265 @@
+package ultrabus
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/emef/ultrabus/pb"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+func TestClientTransactions(t *testing.T) {
+	assert := assert.New(t)
+
+	node, discovery, stop := serveTestNode(t)
+	defer stop()
+
+	client, err := NewSingleAddrBrokeredClient("group", discovery,
+		WithIsolation(pb.IsolationLevel_READ_COMMITTED))
+	assert.Nil(err)
+	defer client.Close()
+
+	eventually(t, func() error {
+		return client.Create("orders", 1, 1)
+	})
+	assert.Nil(client.Create("input", 1, 1))
+
+	// What read-committed subscribers would see of orders
+	committed := func() []string {
+		response, err := client.Fetch("orders", 0, 0, 0, 0, 0)
+		assert.Nil(err)
+
+		var values []string
+		for _, msg := range response.Messages.GetMessages() {
+			values = append(values, string(msg.Message.Value))
+		}
+
+		return values
+	}
+
+	transaction, err := client.BeginTransaction("job")
+	assert.Nil(err)
+	assert.Nil(transaction.Publish("orders", []*pb.Message{
+		{Value: []byte("a")}, {Value: []byte("b")}}))
+	assert.Nil(transaction.CommitOffsets("input", map[int32]int64{0: 5}))
+	assert.Nil(committed())
+
+	input, err := node.partition(&pb.PartitionID{Topic: "input"})
+	assert.Nil(err)
+	_, ok := input.CommittedOffset("group")
+	assert.False(ok)
+
+	// Messages and offsets are committed together
+	assert.Nil(transaction.Commit())
+	assert.Equal([]string{"a", "b"}, committed())
+	offset, _ := input.CommittedOffset("group")
+	assert.Equal(int64(5), offset)
+
+	_, ok = transaction.Publish("orders", nil).(*InvalidTransactionError)
+	assert.True(ok)
+
+	// And aborted together
+	transaction, err = client.BeginTransaction("job")
+	assert.Nil(err)
+	assert.Nil(transaction.Publish("orders", []*pb.Message{{Value: []byte("c")}}))
+	assert.Nil(transaction.CommitOffsets("input", map[int32]int64{0: 6}))
+	assert.Nil(transaction.Abort())
+	assert.Equal([]string{"a", "b"}, committed())
+	offset, _ = input.CommittedOffset("group")
+	assert.Equal(int64(5), offset)
+
+	// Another client of the ID, such as a restarted job, fences this one
+	// off rather than have the two abort each other
+	open, err := client.BeginTransaction("job")
+	assert.Nil(err)
+	assert.Nil(open.Publish("orders", []*pb.Message{{Value: []byte("d")}}))
+
+	restarted, err := NewSingleAddrBrokeredClient("group", discovery)
+	assert.Nil(err)
+	defer restarted.Close()
+
+	replacement, err := restarted.BeginTransaction("job")
+	assert.Nil(err)
+
+	err = open.Publish("orders", []*pb.Message{{Value: []byte("e")}})
+	if assert.IsType(&PublishError{}, err) {
+		assert.Equal(codes.FailedPrecondition,
+			grpc.Code(err.(*PublishError).Partitions[0]))
+	}
+	_, err = client.BeginTransaction("job")
+	assert.Equal(codes.FailedPrecondition, grpc.Code(err))
+
+	assert.Nil(replacement.Publish("orders", []*pb.Message{{Value: []byte("f")}}))
+	assert.Nil(replacement.Commit())
+	assert.Equal([]string{"a", "b", "f"}, committed())
+}
+
+// A coordinator doesn't hold up other transactions while a partition's
+// leader is slow to take a marker.
+func TestTransactionMarkersOutsideLock(t *testing.T) {
+	node, discovery, stop := serveTestNode(t)
+	defer stop()
+
+	client, err := NewSingleAddrBrokeredClient("", discovery)
+	assert.Nil(t, err)
+	defer client.Close()
+
+	eventually(t, func() error {
+		return client.Create("orders", 1, 1)
+	})
+
+	transaction, err := client.BeginTransaction("slow")
+	assert.Nil(t, err)
+	assert.Nil(t, transaction.Publish(
+		"orders", []*pb.Message{{Value: []byte("a")}}))
+
+	partition, err := node.partition(&pb.PartitionID{Topic: "orders"})
+	assert.Nil(t, err)
+
+	// Markers wait on the producer lock
+	partition.producerLock.Lock()
+	committed := make(chan error)
+	go func() {
+		committed <- transaction.Commit()
+	}()
+
+	began := make(chan error)
+	go func() {
+		_, err := client.BeginTransaction("other")
+		began <- err
+	}()
+
+	select {
+	case err := <-began:
+		assert.Nil(t, err)
+	case <-time.After(time.Second):
+		t.Error("Beginning a transaction waited on another's markers")
+	}
+
+	partition.producerLock.Unlock()
+	assert.Nil(t, <-committed)
+}
+
+// Stops node's partitions and starts them again over the same logs, as a
+// node restarting with its logs on disk would.
+func restartPartitions(node *NodeService) {
+	node.lock.Lock()
+	defer node.lock.Unlock()
+
+	for partitionID, partition := range node.partitions {
+		partition.Stop()
+
+		restarted := NewPartition(partition.log, partition.offsetLog)
+		restarted.Configure(partition.Config())
+		node.partitions[partitionID] = restarted
+	}
+}
+
+// A transform that crashes part way through and is restarted, along with
+// the node, transforms every message into its sink once.
+func TestTransformRestart(t *testing.T) {
+	assert := assert.New(t)
+
+	node, discovery, stop := serveTestNode(t)
+	defer stop()
+
+	producer, err := NewSingleAddrBrokeredClient("", discovery,
+		WithIsolation(pb.IsolationLevel_READ_COMMITTED))
+	assert.Nil(err)
+	defer producer.Close()
+
+	eventually(t, func() error {
+		return producer.Create("input", 1, 1)
+	})
+	assert.Nil(producer.Create("output", 1, 1))
+
+	publish := func(from, to int) {
+		for i := from; i < to; i++ {
+			assert.Nil(producer.Publish("input", []*pb.Message{
+				{Value: []byte(strconv.Itoa(i))}}))
+		}
+	}
+
+	// What read-committed readers of output see
+	output := func() []string {
+		response, err := producer.Fetch("output", 0, 0, 0, 0, 0)
+		if err != nil {
+			return nil
+		}
+
+		var values []string
+		for _, msg := range response.Messages.GetMessages() {
+			values = append(values, string(msg.Message.Value))
+		}
+
+		return values
+	}
+
+	// Waits for output to hold values, failing on what the transform
+	// returns first
+	await := func(values []string, failed chan error) {
+		deadline := time.After(5 * time.Second)
+		for !reflect.DeepEqual(values, output()) {
+			select {
+			case err := <-failed:
+				t.Fatalf("Transform ended: %v", err)
+			case <-deadline:
+				t.Fatalf("Output %v, expected %v", output(), values)
+			case <-time.After(20 * time.Millisecond):
+			}
+		}
+	}
+
+	var expected []string
+	for i := 0; i < 10; i++ {
+		expected = append(expected, "t"+strconv.Itoa(i))
+	}
+
+	run := func(crashAt int) (chan interface{}, chan error) {
+		client, err := NewSingleAddrBrokeredClient("transform", discovery,
+			WithEarliestOffsets())
+		assert.Nil(err)
+
+		done := make(chan interface{})
+		failed := make(chan error, 1)
+		go func() {
+			defer client.Close()
+			failed <- client.Transform("job", "input", "output",
+				func(msg *pb.MessageWithOffset) ([]*pb.Message, error) {
+					value := string(msg.Message.Value)
+					if value == strconv.Itoa(crashAt) {
+						return nil, fmt.Errorf("crashed at %v", value)
+					}
+
+					return []*pb.Message{{Value: []byte("t" + value)}}, nil
+				}, done)
+		}()
+
+		return done, failed
+	}
+
+	// The job's group reads input from the start
+	input, err := node.partition(&pb.PartitionID{Topic: "input"})
+	assert.Nil(err)
+	assert.Nil(input.CommitOffset("transform", 0, nil))
+
+	_, failed := run(7)
+	publish(0, 5)
+	await(expected[:5], failed)
+
+	publish(5, 10)
+	assert.NotNil(<-failed)
+
+	restartPartitions(node)
+
+	done, failed := run(-1)
+	await(expected, failed)
+	close(done)
+	assert.Nil(<-failed)
+	assert.Equal(expected, output())
+}